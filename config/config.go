@@ -28,6 +28,7 @@ import (
 	"github.com/libp2p/go-libp2p/p2p/host/peerstore/pstoremem"
 	rcmgr "github.com/libp2p/go-libp2p/p2p/host/resource-manager"
 	routed "github.com/libp2p/go-libp2p/p2p/host/routed"
+	"github.com/libp2p/go-libp2p/p2p/metricshelper"
 	"github.com/libp2p/go-libp2p/p2p/net/swarm"
 	tptu "github.com/libp2p/go-libp2p/p2p/net/upgrader"
 	circuitv2 "github.com/libp2p/go-libp2p/p2p/protocol/circuitv2/client"
@@ -119,6 +120,8 @@ type Config struct {
 	AutoRelayOpts   []autorelay.Option
 	AutoNATConfig
 
+	EnableAutoNATv2 bool
+
 	EnableHolePunching  bool
 	HolePunchingOptions []holepunch.Option
 
@@ -185,6 +188,9 @@ func (cfg *Config) makeSwarm(eventBus event.Bus, enableMetrics bool) (*swarm.Swa
 	if enableMetrics {
 		opts = append(opts,
 			swarm.WithMetricsTracer(swarm.NewMetricsTracer(swarm.WithRegisterer(cfg.PrometheusRegisterer))))
+		if r, ok := cfg.Reporter.(metricshelper.TransportBandwidthReporter); ok {
+			metricshelper.RegisterCollectors(cfg.PrometheusRegisterer, metricshelper.NewBandwidthCollector(r))
+		}
 	}
 	// TODO: Make the swarm implementation configurable.
 	return swarm.NewSwarm(pid, cfg.Peerstore, eventBus, opts...)
@@ -204,6 +210,7 @@ func (cfg *Config) addTransports(h host.Host) error {
 		fx.Supply(h.ID()),
 		fx.Provide(func() host.Host { return h }),
 		fx.Provide(func() crypto.PrivKey { return h.Peerstore().PrivKey(h.ID()) }),
+		fx.Provide(func() peerstore.Peerstore { return h.Peerstore() }),
 		fx.Provide(func() connmgr.ConnectionGater { return cfg.ConnectionGater }),
 		fx.Provide(func() pnet.PSK { return cfg.PSK }),
 		fx.Provide(func() network.ResourceManager { return cfg.ResourceManager }),
@@ -292,6 +299,51 @@ func (cfg *Config) addTransports(h host.Host) error {
 	return nil
 }
 
+// makeAutoNATDialerHost creates a host with a fresh identity and peerstore
+// that AutoNAT servers use to dial back peers.
+func (cfg *Config) makeAutoNATDialerHost() (host.Host, error) {
+	autonatPrivKey, _, err := crypto.GenerateEd25519Key(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	ps, err := pstoremem.NewPeerstore()
+	if err != nil {
+		return nil, err
+	}
+
+	// Pull out the pieces of the config that we _actually_ care about.
+	// Specifically, don't set up things like autorelay, listeners,
+	// identify, etc.
+	autoNatCfg := Config{
+		Transports:         cfg.Transports,
+		Muxers:             cfg.Muxers,
+		SecurityTransports: cfg.SecurityTransports,
+		Insecure:           cfg.Insecure,
+		PSK:                cfg.PSK,
+		ConnectionGater:    cfg.ConnectionGater,
+		Reporter:           cfg.Reporter,
+		PeerKey:            autonatPrivKey,
+		Peerstore:          ps,
+		DialRanker:         swarm.NoDelayDialRanker,
+		SwarmOpts: []swarm.Option{
+			// It is better to disable black hole detection and just attempt a dial for autonat
+			swarm.WithUDPBlackHoleConfig(false, 0, 0),
+			swarm.WithIPv6BlackHoleConfig(false, 0, 0),
+		},
+	}
+
+	dialer, err := autoNatCfg.makeSwarm(eventbus.NewBus(), false)
+	if err != nil {
+		return nil, err
+	}
+	dialerHost := blankhost.NewBlankHost(dialer)
+	if err := autoNatCfg.addTransports(dialerHost); err != nil {
+		dialerHost.Close()
+		return nil, err
+	}
+	return dialerHost, nil
+}
+
 // NewNode constructs a new libp2p Host from the Config.
 //
 // This function consumes the config. Do not reuse it (really!).
@@ -306,6 +358,11 @@ func (cfg *Config) NewNode() (host.Host, error) {
 	}
 
 	eventBus := eventbus.NewBus(eventbus.WithMetricsTracer(eventbus.NewMetricsTracer(eventbus.WithRegisterer(cfg.PrometheusRegisterer))))
+	if r, ok := cfg.ResourceManager.(rcmgr.LimitChangeReporter); ok {
+		if err := r.EmitLimitChanges(eventBus); err != nil {
+			return nil, err
+		}
+	}
 	swrm, err := cfg.makeSwarm(eventBus, !cfg.DisableMetrics)
 	if err != nil {
 		return nil, err
@@ -315,6 +372,15 @@ func (cfg *Config) NewNode() (host.Host, error) {
 		rcmgr.MustRegisterWith(cfg.PrometheusRegisterer)
 	}
 
+	var autonatv2Dialer host.Host
+	if cfg.EnableAutoNATv2 {
+		autonatv2Dialer, err = cfg.makeAutoNATDialerHost()
+		if err != nil {
+			swrm.Close()
+			return nil, err
+		}
+	}
+
 	h, err := bhost.NewHost(swrm, &bhost.HostOpts{
 		EventBus:             eventBus,
 		ConnManager:          cfg.ConnManager,
@@ -329,9 +395,14 @@ func (cfg *Config) NewNode() (host.Host, error) {
 		RelayServiceOpts:     cfg.RelayServiceOpts,
 		EnableMetrics:        !cfg.DisableMetrics,
 		PrometheusRegisterer: cfg.PrometheusRegisterer,
+		EnableAutoNATv2:      cfg.EnableAutoNATv2,
+		AutoNATv2Dialer:      autonatv2Dialer,
 	})
 	if err != nil {
 		swrm.Close()
+		if autonatv2Dialer != nil {
+			autonatv2Dialer.Close()
+		}
 		return nil, err
 	}
 
@@ -406,44 +477,8 @@ func (cfg *Config) NewNode() (host.Host, error) {
 			autonat.WithPeerThrottling(cfg.AutoNATConfig.ThrottlePeerLimit))
 	}
 	if cfg.AutoNATConfig.EnableService {
-		autonatPrivKey, _, err := crypto.GenerateEd25519Key(rand.Reader)
+		dialerHost, err := cfg.makeAutoNATDialerHost()
 		if err != nil {
-			return nil, err
-		}
-		ps, err := pstoremem.NewPeerstore()
-		if err != nil {
-			return nil, err
-		}
-
-		// Pull out the pieces of the config that we _actually_ care about.
-		// Specifically, don't set up things like autorelay, listeners,
-		// identify, etc.
-		autoNatCfg := Config{
-			Transports:         cfg.Transports,
-			Muxers:             cfg.Muxers,
-			SecurityTransports: cfg.SecurityTransports,
-			Insecure:           cfg.Insecure,
-			PSK:                cfg.PSK,
-			ConnectionGater:    cfg.ConnectionGater,
-			Reporter:           cfg.Reporter,
-			PeerKey:            autonatPrivKey,
-			Peerstore:          ps,
-			DialRanker:         swarm.NoDelayDialRanker,
-			SwarmOpts: []swarm.Option{
-				// It is better to disable black hole detection and just attempt a dial for autonat
-				swarm.WithUDPBlackHoleConfig(false, 0, 0),
-				swarm.WithIPv6BlackHoleConfig(false, 0, 0),
-			},
-		}
-
-		dialer, err := autoNatCfg.makeSwarm(eventbus.NewBus(), false)
-		if err != nil {
-			h.Close()
-			return nil, err
-		}
-		dialerHost := blankhost.NewBlankHost(dialer)
-		if err := autoNatCfg.addTransports(dialerHost); err != nil {
-			dialerHost.Close()
 			h.Close()
 			return nil, err
 		}
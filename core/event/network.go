@@ -1,6 +1,8 @@
 package event
 
 import (
+	"time"
+
 	"github.com/libp2p/go-libp2p/core/network"
 	"github.com/libp2p/go-libp2p/core/peer"
 )
@@ -53,3 +55,41 @@ type EvtPeerConnectednessChanged struct {
 	// Connectedness is the new connectedness state.
 	Connectedness network.Connectedness
 }
+
+// EvtBlackHoleStateChanged is emitted by the swarm when the state of one of
+// its black hole filters changes.
+//
+// Filters that track the state per outbound route have one state per network
+// interface, and emit one event per interface.
+type EvtBlackHoleStateChanged struct {
+	// Name is the name of the filter, e.g. "UDP" or "IPv6".
+	Name string
+	// Route is the name of the network interface the state applies to. It
+	// is empty if the filter doesn't track the state per route.
+	Route string
+	// State is the new state of the filter.
+	State network.BlackHoleState
+	// SuccessFraction is the fraction of successful dials among the most
+	// recent dials.
+	SuccessFraction float64
+}
+
+// EvtConnSuperseded is emitted by the swarm when a limited or relayed
+// connection to a peer is superseded by a direct connection, e.g. after a
+// successful hole punch.
+//
+// New streams are opened on the direct connection. Streams that are already
+// open on the superseded connection are not moved, applications that keep
+// long-lived streams to the peer should reopen them when they receive this
+// event. The superseded connection is closed once all its streams are closed,
+// or at Deadline, whichever comes first.
+type EvtConnSuperseded struct {
+	// Peer is the remote peer of the connections.
+	Peer peer.ID
+	// Superseded is the limited or relayed connection.
+	Superseded network.Conn
+	// By is the direct connection.
+	By network.Conn
+	// Deadline is the time at which the superseded connection is closed.
+	Deadline time.Time
+}
@@ -2,6 +2,8 @@ package event
 
 import (
 	"github.com/libp2p/go-libp2p/core/network"
+
+	ma "github.com/multiformats/go-multiaddr"
 )
 
 // EvtLocalReachabilityChanged is an event struct to be emitted when the local's
@@ -11,3 +13,21 @@ import (
 type EvtLocalReachabilityChanged struct {
 	Reachability network.Reachability
 }
+
+// EvtHostReachableAddrsChanged is an event struct to be emitted when the
+// reachability of one or more of the local node's addresses changes.
+//
+// Unlike EvtLocalReachabilityChanged, which carries a single verdict for the
+// whole host, this event reports reachability per address, so consumers can
+// tell e.g. that QUIC is reachable while TCP is not. Each address appears in
+// exactly one of the lists.
+//
+// This event is usually emitted by the AutoNAT v2 subsystem.
+type EvtHostReachableAddrsChanged struct {
+	// Reachable contains the addresses that were confirmed to be dialable.
+	Reachable []ma.Multiaddr
+	// Unreachable contains the addresses that could not be dialed.
+	Unreachable []ma.Multiaddr
+	// Unknown contains the addresses whose reachability couldn't be determined yet.
+	Unknown []ma.Multiaddr
+}
@@ -13,6 +13,9 @@ import (
 // BandwidthCounter tracks incoming and outgoing data transferred by the local peer.
 // Metrics are available for total bandwidth across all peers / protocols, as well
 // as segmented by remote peer ID and protocol ID.
+//
+// ShardedBandwidthCounter should be preferred: it has a lower overhead, and
+// also segments bandwidth by connection and transport.
 type BandwidthCounter struct {
 	totalIn  flow.Meter
 	totalOut flow.Meter
@@ -0,0 +1,489 @@
+package metrics
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/libp2p/go-flow-metrics"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/protocol"
+
+	"golang.org/x/exp/slices"
+)
+
+// numShards is the number of shards of the meter maps. Each shard has its own
+// lock, so that registering meters for different keys rarely contends.
+const numShards = 32
+
+// ConnReporter is a Reporter that can record traffic per connection.
+//
+// Recording traffic through a ConnMeter or a StreamMeter doesn't require any
+// lookup or lock, which makes it cheaper than the LogSentMessageStream and
+// LogRecvMessageStream methods of the Reporter.
+type ConnReporter interface {
+	Reporter
+
+	// OpenConn returns the meter recording the traffic of a new connection
+	// with id to peer p, over transport.
+	OpenConn(id string, p peer.ID, transport string) *ConnMeter
+}
+
+// PeerStats are the bandwidth metrics of a peer.
+type PeerStats struct {
+	Peer peer.ID
+	Stats
+}
+
+// ProtocolStats are the bandwidth metrics of a protocol.
+type ProtocolStats struct {
+	Protocol protocol.ID
+	Stats
+}
+
+// ConnStats are the bandwidth metrics of a connection.
+type ConnStats struct {
+	ID        string
+	Peer      peer.ID
+	Transport string
+	Stats
+}
+
+// meterPair meters the traffic in both directions.
+type meterPair struct {
+	in, out *flow.Meter
+	// removed is set once the meter is removed from its map. Whoever
+	// caches the meter then has to look it up again.
+	removed atomic.Bool
+}
+
+func newMeterPair() *meterPair {
+	return &meterPair{in: flow.NewMeter(), out: flow.NewMeter()}
+}
+
+func (m *meterPair) stats() Stats {
+	inSnap := m.in.Snapshot()
+	outSnap := m.out.Snapshot()
+	return Stats{
+		TotalIn:  int64(inSnap.Total),
+		TotalOut: int64(outSnap.Total),
+		RateIn:   inSnap.Rate,
+		RateOut:  outSnap.Rate,
+	}
+}
+
+func (m *meterPair) lastUpdate() time.Time {
+	in, out := m.in.Snapshot().LastUpdate, m.out.Snapshot().LastUpdate
+	if in.After(out) {
+		return in
+	}
+	return out
+}
+
+func (m *meterPair) reset() {
+	m.in.Reset()
+	m.out.Reset()
+}
+
+// shardedMap is a map with string keys, split in shards that are locked
+// independently.
+type shardedMap[V any] struct {
+	shards [numShards]struct {
+		sync.Mutex
+		m map[string]V
+	}
+}
+
+func (sm *shardedMap[V]) shard(key string) int {
+	// FNV-1a
+	h := uint32(2166136261)
+	for i := 0; i < len(key); i++ {
+		h ^= uint32(key[i])
+		h *= 16777619
+	}
+	return int(h % numShards)
+}
+
+// getOrCreate returns the value for key, creating it with newV if there's
+// none.
+func (sm *shardedMap[V]) getOrCreate(key string, newV func() V) V {
+	s := &sm.shards[sm.shard(key)]
+	s.Lock()
+	defer s.Unlock()
+	v, ok := s.m[key]
+	if !ok {
+		if s.m == nil {
+			s.m = make(map[string]V)
+		}
+		v = newV()
+		s.m[key] = v
+	}
+	return v
+}
+
+func (sm *shardedMap[V]) load(key string) (V, bool) {
+	s := &sm.shards[sm.shard(key)]
+	s.Lock()
+	defer s.Unlock()
+	v, ok := s.m[key]
+	return v, ok
+}
+
+func (sm *shardedMap[V]) delete(key string) {
+	s := &sm.shards[sm.shard(key)]
+	s.Lock()
+	delete(s.m, key)
+	s.Unlock()
+}
+
+// deleteFunc deletes the values for which del returns true.
+func (sm *shardedMap[V]) deleteFunc(del func(key string, v V) bool) {
+	for i := range sm.shards {
+		s := &sm.shards[i]
+		s.Lock()
+		for k, v := range s.m {
+			if del(k, v) {
+				delete(s.m, k)
+			}
+		}
+		s.Unlock()
+	}
+}
+
+// forEach calls f for every value in the map. The shards are not locked while
+// f is called.
+func (sm *shardedMap[V]) forEach(f func(key string, v V)) {
+	type entry struct {
+		k string
+		v V
+	}
+	var entries []entry
+	for i := range sm.shards {
+		s := &sm.shards[i]
+		s.Lock()
+		entries = slices.Grow(entries[:0], len(s.m))
+		for k, v := range s.m {
+			entries = append(entries, entry{k, v})
+		}
+		s.Unlock()
+		for _, e := range entries {
+			f(e.k, e.v)
+		}
+	}
+}
+
+// ShardedBandwidthCounter tracks incoming and outgoing data transferred by the
+// local peer. Metrics are available for total bandwidth, and segmented by
+// remote peer ID, protocol ID, connection and transport.
+//
+// Unlike BandwidthCounter, its meters are kept in sharded maps, and traffic
+// recorded through a ConnMeter doesn't take any lock.
+type ShardedBandwidthCounter struct {
+	total *meterPair
+
+	peers      shardedMap[*meterPair]
+	protocols  shardedMap[*meterPair]
+	transports shardedMap[*meterPair]
+	conns      shardedMap[*ConnMeter]
+}
+
+var _ ConnReporter = (*ShardedBandwidthCounter)(nil)
+
+// NewShardedBandwidthCounter creates a new ShardedBandwidthCounter.
+func NewShardedBandwidthCounter() *ShardedBandwidthCounter {
+	return &ShardedBandwidthCounter{total: newMeterPair()}
+}
+
+func (bwc *ShardedBandwidthCounter) peerMeter(p peer.ID) *meterPair {
+	return bwc.peers.getOrCreate(string(p), newMeterPair)
+}
+
+func (bwc *ShardedBandwidthCounter) protocolMeter(proto protocol.ID) *meterPair {
+	return bwc.protocols.getOrCreate(string(proto), newMeterPair)
+}
+
+// removeMeters removes the meters for which del returns true from sm.
+func removeMeters(sm *shardedMap[*meterPair], del func(m *meterPair) bool) {
+	sm.deleteFunc(func(_ string, m *meterPair) bool {
+		if del(m) {
+			m.removed.Store(true)
+			return true
+		}
+		return false
+	})
+}
+
+// LogSentMessage records the size of an outgoing message
+// without associating the bandwidth to a specific peer or protocol.
+func (bwc *ShardedBandwidthCounter) LogSentMessage(size int64) {
+	bwc.total.out.Mark(uint64(size))
+}
+
+// LogRecvMessage records the size of an incoming message
+// without associating the bandwidth to a specific peer or protocol.
+func (bwc *ShardedBandwidthCounter) LogRecvMessage(size int64) {
+	bwc.total.in.Mark(uint64(size))
+}
+
+// LogSentMessageStream records the size of an outgoing message over a single logical stream.
+// Bandwidth is associated with the given protocol.ID and peer.ID.
+func (bwc *ShardedBandwidthCounter) LogSentMessageStream(size int64, proto protocol.ID, p peer.ID) {
+	bwc.protocolMeter(proto).out.Mark(uint64(size))
+	bwc.peerMeter(p).out.Mark(uint64(size))
+}
+
+// LogRecvMessageStream records the size of an incoming message over a single logical stream.
+// Bandwidth is associated with the given protocol.ID and peer.ID.
+func (bwc *ShardedBandwidthCounter) LogRecvMessageStream(size int64, proto protocol.ID, p peer.ID) {
+	bwc.protocolMeter(proto).in.Mark(uint64(size))
+	bwc.peerMeter(p).in.Mark(uint64(size))
+}
+
+// OpenConn returns the meter recording the traffic of a new connection with id
+// to peer p, over transport. The connection is tracked until its meter is
+// closed.
+func (bwc *ShardedBandwidthCounter) OpenConn(id string, p peer.ID, transport string) *ConnMeter {
+	return bwc.conns.getOrCreate(id, func() *ConnMeter {
+		c := &ConnMeter{
+			bwc:       bwc,
+			id:        id,
+			p:         p,
+			transport: transport,
+			conn:      newMeterPair(),
+			tpt:       bwc.transports.getOrCreate(transport, newMeterPair),
+		}
+		c.peer.Store(bwc.peerMeter(p))
+		return c
+	})
+}
+
+// GetBandwidthForPeer returns a Stats struct with bandwidth metrics associated with the given peer.ID.
+// The metrics returned include all traffic sent / received for the peer, regardless of protocol.
+func (bwc *ShardedBandwidthCounter) GetBandwidthForPeer(p peer.ID) Stats {
+	m, ok := bwc.peers.load(string(p))
+	if !ok {
+		return Stats{}
+	}
+	return m.stats()
+}
+
+// GetBandwidthForProtocol returns a Stats struct with bandwidth metrics associated with the given protocol.ID.
+// The metrics returned include all traffic sent / received for the protocol, regardless of which peers were
+// involved.
+func (bwc *ShardedBandwidthCounter) GetBandwidthForProtocol(proto protocol.ID) Stats {
+	m, ok := bwc.protocols.load(string(proto))
+	if !ok {
+		return Stats{}
+	}
+	return m.stats()
+}
+
+// GetBandwidthForConn returns the bandwidth metrics of the open connection
+// with id.
+func (bwc *ShardedBandwidthCounter) GetBandwidthForConn(id string) Stats {
+	c, ok := bwc.conns.load(id)
+	if !ok {
+		return Stats{}
+	}
+	return c.conn.stats()
+}
+
+// GetBandwidthTotals returns a Stats struct with bandwidth metrics for all data sent / received by the
+// local peer, regardless of protocol or remote peer IDs.
+func (bwc *ShardedBandwidthCounter) GetBandwidthTotals() Stats {
+	return bwc.total.stats()
+}
+
+// GetBandwidthByPeer returns a map of all remembered peers and the bandwidth
+// metrics with respect to each.
+func (bwc *ShardedBandwidthCounter) GetBandwidthByPeer() map[peer.ID]Stats {
+	peers := make(map[peer.ID]Stats)
+	bwc.peers.forEach(func(p string, m *meterPair) {
+		peers[peer.ID(p)] = m.stats()
+	})
+	return peers
+}
+
+// GetBandwidthByProtocol returns a map of all remembered protocols and
+// the bandwidth metrics with respect to each.
+func (bwc *ShardedBandwidthCounter) GetBandwidthByProtocol() map[protocol.ID]Stats {
+	protocols := make(map[protocol.ID]Stats)
+	bwc.protocols.forEach(func(proto string, m *meterPair) {
+		protocols[protocol.ID(proto)] = m.stats()
+	})
+	return protocols
+}
+
+// GetBandwidthByTransport returns a map of all the transports connections
+// were opened on, and the bandwidth metrics with respect to each.
+func (bwc *ShardedBandwidthCounter) GetBandwidthByTransport() map[string]Stats {
+	transports := make(map[string]Stats)
+	bwc.transports.forEach(func(tpt string, m *meterPair) {
+		transports[tpt] = m.stats()
+	})
+	return transports
+}
+
+// TopPeers returns the n peers with the highest bandwidth use, in decreasing
+// order of total rate.
+func (bwc *ShardedBandwidthCounter) TopPeers(n int) []PeerStats {
+	var res []PeerStats
+	bwc.peers.forEach(func(p string, m *meterPair) {
+		res = append(res, PeerStats{Peer: peer.ID(p), Stats: m.stats()})
+	})
+	return topN(res, n, func(s PeerStats) Stats { return s.Stats })
+}
+
+// TopProtocols returns the n protocols with the highest bandwidth use, in
+// decreasing order of total rate.
+func (bwc *ShardedBandwidthCounter) TopProtocols(n int) []ProtocolStats {
+	var res []ProtocolStats
+	bwc.protocols.forEach(func(proto string, m *meterPair) {
+		res = append(res, ProtocolStats{Protocol: protocol.ID(proto), Stats: m.stats()})
+	})
+	return topN(res, n, func(s ProtocolStats) Stats { return s.Stats })
+}
+
+// TopConns returns the n open connections with the highest bandwidth use, in
+// decreasing order of total rate.
+func (bwc *ShardedBandwidthCounter) TopConns(n int) []ConnStats {
+	var res []ConnStats
+	bwc.conns.forEach(func(_ string, c *ConnMeter) {
+		res = append(res, ConnStats{ID: c.id, Peer: c.p, Transport: c.transport, Stats: c.conn.stats()})
+	})
+	return topN(res, n, func(s ConnStats) Stats { return s.Stats })
+}
+
+// topN sorts s in decreasing order of total rate, and returns the first n
+// elements.
+func topN[T any](s []T, n int, stats func(T) Stats) []T {
+	slices.SortFunc(s, func(a, b T) int {
+		sa, sb := stats(a), stats(b)
+		ra, rb := sa.RateIn+sa.RateOut, sb.RateIn+sb.RateOut
+		switch {
+		case ra > rb:
+			return -1
+		case ra < rb:
+			return 1
+		}
+		ta, tb := sa.TotalIn+sa.TotalOut, sb.TotalIn+sb.TotalOut
+		switch {
+		case ta > tb:
+			return -1
+		case ta < tb:
+			return 1
+		}
+		return 0
+	})
+	if len(s) > n {
+		s = s[:max(n, 0)]
+	}
+	return s
+}
+
+// Reset clears all stats.
+func (bwc *ShardedBandwidthCounter) Reset() {
+	bwc.total.reset()
+	all := func(*meterPair) bool { return true }
+	removeMeters(&bwc.peers, all)
+	removeMeters(&bwc.protocols, all)
+	// The meters of the open connections are still in use, so they are
+	// reset instead of being removed.
+	bwc.transports.forEach(func(_ string, m *meterPair) { m.reset() })
+	bwc.conns.forEach(func(_ string, c *ConnMeter) { c.conn.reset() })
+}
+
+// TrimIdle trims all peer and protocol meters idle since the given time.
+func (bwc *ShardedBandwidthCounter) TrimIdle(since time.Time) {
+	idle := func(m *meterPair) bool { return m.lastUpdate().Before(since) }
+	removeMeters(&bwc.peers, idle)
+	removeMeters(&bwc.protocols, idle)
+}
+
+// ConnMeter records the traffic of a connection, in the totals of the
+// ShardedBandwidthCounter, and in the meters of the connection, its peer and
+// its transport.
+type ConnMeter struct {
+	bwc       *ShardedBandwidthCounter
+	id        string
+	p         peer.ID
+	transport string
+
+	conn, tpt *meterPair
+	peer      atomic.Pointer[meterPair]
+}
+
+// Close stops tracking the connection. The traffic of the connection is still
+// accounted for in the meters of its peer and its transport.
+func (c *ConnMeter) Close() {
+	c.bwc.conns.delete(c.id)
+}
+
+// peerMeter returns the meter of the peer of the connection.
+func (c *ConnMeter) peerMeter() *meterPair {
+	m := c.peer.Load()
+	if m.removed.Load() {
+		m = c.bwc.peerMeter(c.p)
+		c.peer.Store(m)
+	}
+	return m
+}
+
+// NewStream returns the meter recording the traffic of a new stream on the
+// connection.
+func (c *ConnMeter) NewStream() *StreamMeter {
+	return &StreamMeter{conn: c}
+}
+
+// StreamMeter records the traffic of a stream, in the meters of its
+// connection, and of its protocol once it's set.
+type StreamMeter struct {
+	conn  *ConnMeter
+	proto atomic.Pointer[protocol.ID]
+	meter atomic.Pointer[meterPair]
+}
+
+// SetProtocol sets the protocol of the stream. The traffic recorded before
+// the protocol is set isn't associated with any protocol.
+func (s *StreamMeter) SetProtocol(proto protocol.ID) {
+	s.proto.Store(&proto)
+	s.meter.Store(s.conn.bwc.protocolMeter(proto))
+}
+
+// protocolMeter returns the meter of the protocol of the stream, or nil if
+// it's not set.
+func (s *StreamMeter) protocolMeter() *meterPair {
+	m := s.meter.Load()
+	if m != nil && m.removed.Load() {
+		m = s.conn.bwc.protocolMeter(*s.proto.Load())
+		s.meter.Store(m)
+	}
+	return m
+}
+
+// LogSent records the size of an outgoing message on the stream.
+func (s *StreamMeter) LogSent(size int64) {
+	n := uint64(size)
+	c := s.conn
+	c.bwc.total.out.Mark(n)
+	c.conn.out.Mark(n)
+	c.tpt.out.Mark(n)
+	c.peerMeter().out.Mark(n)
+	if p := s.protocolMeter(); p != nil {
+		p.out.Mark(n)
+	}
+}
+
+// LogRecv records the size of an incoming message on the stream.
+func (s *StreamMeter) LogRecv(size int64) {
+	n := uint64(size)
+	c := s.conn
+	c.bwc.total.in.Mark(n)
+	c.conn.in.Mark(n)
+	c.tpt.in.Mark(n)
+	c.peerMeter().in.Mark(n)
+	if p := s.protocolMeter(); p != nil {
+		p.in.Mark(n)
+	}
+}
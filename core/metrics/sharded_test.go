@@ -0,0 +1,141 @@
+package metrics
+
+import (
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/protocol"
+
+	"github.com/libp2p/go-flow-metrics"
+	"github.com/stretchr/testify/require"
+)
+
+func BenchmarkShardedBandwidthCounter(b *testing.B) {
+	bwc := NewShardedBandwidthCounter()
+	var n atomic.Int64
+	b.RunParallel(func(pb *testing.PB) {
+		i := n.Add(1)
+		s := bwc.OpenConn(fmt.Sprintf("conn-%d", i), peer.ID(fmt.Sprintf("peer-%d", i)), "tcp").NewStream()
+		s.SetProtocol("bitswap")
+		for pb.Next() {
+			s.LogSent(100)
+		}
+	})
+}
+
+// sweep makes the sweeper update the snapshots of the meters with the traffic
+// recorded so far. Meters are registered with the sweeper asynchronously, in
+// order, so the mock clock is advanced until a meter registered after all
+// others shows up in a snapshot.
+func sweep(t *testing.T) {
+	t.Helper()
+	fence := flow.NewMeter()
+	fence.Mark(1)
+	require.Eventually(t, func() bool {
+		cl.Add(time.Second)
+		return fence.Snapshot().Total == 1
+	}, 5*time.Second, time.Millisecond)
+}
+
+func TestShardedBandwidthCounter(t *testing.T) {
+	bwc := NewShardedBandwidthCounter()
+	for i := 0; i < 10; i++ {
+		p := peer.ID(fmt.Sprintf("peer-%d", i))
+		tpt := "tcp"
+		if i%2 == 1 {
+			tpt = "quic-v1"
+		}
+		c := bwc.OpenConn(fmt.Sprintf("conn-%d", i), p, tpt)
+		for j := 0; j < 2; j++ {
+			s := c.NewStream()
+			// traffic before the protocol is set isn't associated with any protocol
+			s.LogSent(1)
+			s.SetProtocol(protocol.ID(fmt.Sprintf("proto-%d", j)))
+			s.LogSent(int64(100 * (i + 1)))
+			s.LogRecv(int64(50 * (i + 1)))
+		}
+	}
+	// traffic not recorded on a connection
+	bwc.LogSentMessage(1000)
+	bwc.LogSentMessageStream(1000, "proto-0", "peer-0")
+	sweep(t)
+
+	totals := bwc.GetBandwidthTotals()
+	require.Equal(t, int64(12020), totals.TotalOut)
+	require.Equal(t, int64(5500), totals.TotalIn)
+
+	byPeer := bwc.GetBandwidthByPeer()
+	require.Len(t, byPeer, 10)
+	require.Equal(t, int64(1202), byPeer["peer-0"].TotalOut)
+	require.Equal(t, int64(2002), byPeer["peer-9"].TotalOut)
+	require.Equal(t, byPeer["peer-9"], bwc.GetBandwidthForPeer("peer-9"))
+
+	byProtocol := bwc.GetBandwidthByProtocol()
+	require.Len(t, byProtocol, 2)
+	require.Equal(t, int64(6500), byProtocol["proto-0"].TotalOut)
+	require.Equal(t, int64(5500), byProtocol["proto-1"].TotalOut)
+	require.Equal(t, int64(2750), bwc.GetBandwidthForProtocol("proto-1").TotalIn)
+
+	byTransport := bwc.GetBandwidthByTransport()
+	require.Len(t, byTransport, 2)
+	require.Equal(t, int64(5010), byTransport["tcp"].TotalOut)
+	require.Equal(t, int64(6010), byTransport["quic-v1"].TotalOut)
+
+	require.Equal(t, int64(500), bwc.GetBandwidthForConn("conn-4").TotalIn)
+
+	top := bwc.TopConns(3)
+	require.Len(t, top, 3)
+	for i, c := range top {
+		require.Equal(t, fmt.Sprintf("conn-%d", 9-i), c.ID)
+		require.Equal(t, peer.ID(fmt.Sprintf("peer-%d", 9-i)), c.Peer)
+	}
+	require.Equal(t, "quic-v1", top[0].Transport)
+	require.Equal(t, peer.ID("peer-9"), bwc.TopPeers(1)[0].Peer)
+	require.Len(t, bwc.TopPeers(20), 10)
+	require.Equal(t, protocol.ID("proto-0"), bwc.TopProtocols(2)[0].Protocol)
+
+	// closed connections are forgotten, but still accounted for in the
+	// transport metrics
+	bwc.conns.forEach(func(_ string, c *ConnMeter) { c.Close() })
+	require.Empty(t, bwc.TopConns(10))
+	require.Zero(t, bwc.GetBandwidthForConn("conn-4"))
+	require.Equal(t, byTransport, bwc.GetBandwidthByTransport())
+}
+
+func TestShardedBandwidthCounterTrimIdle(t *testing.T) {
+	bwc := NewShardedBandwidthCounter()
+	s := bwc.OpenConn("conn", "peer", "tcp").NewStream()
+	s.SetProtocol("proto")
+	s.LogSent(100)
+	s.LogRecv(100)
+	sweep(t)
+
+	bwc.TrimIdle(cl.Now().Add(time.Second))
+	require.Empty(t, bwc.GetBandwidthByPeer())
+	require.Empty(t, bwc.GetBandwidthByProtocol())
+
+	// the meters are recreated for connections and streams still in use
+	s.LogSent(10)
+	sweep(t)
+	require.Equal(t, int64(10), bwc.GetBandwidthForPeer("peer").TotalOut)
+	require.Equal(t, int64(10), bwc.GetBandwidthForProtocol("proto").TotalOut)
+	require.Equal(t, int64(110), bwc.GetBandwidthForConn("conn").TotalOut)
+}
+
+func TestShardedBandwidthCounterReset(t *testing.T) {
+	bwc := NewShardedBandwidthCounter()
+	s := bwc.OpenConn("conn", "peer", "tcp").NewStream()
+	s.SetProtocol("proto")
+	s.LogSent(100)
+	sweep(t)
+
+	bwc.Reset()
+	require.Zero(t, bwc.GetBandwidthTotals())
+	require.Empty(t, bwc.GetBandwidthByPeer())
+	require.Empty(t, bwc.GetBandwidthByProtocol())
+	require.Zero(t, bwc.GetBandwidthByTransport()["tcp"])
+	require.Zero(t, bwc.GetBandwidthForConn("conn"))
+}
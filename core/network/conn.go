@@ -19,6 +19,10 @@ import (
 type Conn interface {
 	io.Closer
 
+	// CloseWithError closes the connection, sending the error code to the
+	// remote peer if the transport supports it.
+	CloseWithError(errCode ConnErrorCode) error
+
 	ConnSecurity
 	ConnMultiaddrs
 	ConnStat
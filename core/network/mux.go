@@ -3,6 +3,7 @@ package network
 import (
 	"context"
 	"errors"
+	"fmt"
 	"io"
 	"net"
 	"time"
@@ -11,6 +12,119 @@ import (
 // ErrReset is returned when reading or writing on a reset stream.
 var ErrReset = errors.New("stream reset")
 
+// StreamErrorCode is an application defined error code that is sent to the
+// remote peer when resetting a stream.
+type StreamErrorCode uint32
+
+// StreamError is returned when reading or writing on a stream that was reset
+// with an error code, either locally or by the remote peer.
+type StreamError struct {
+	ErrorCode StreamErrorCode
+	// Remote is true if the stream was reset by the remote peer.
+	Remote bool
+	// TransportError is the underlying error reported by the transport, if any.
+	TransportError error
+}
+
+func (s *StreamError) Error() string {
+	side := "local"
+	if s.Remote {
+		side = "remote"
+	}
+	if s.TransportError != nil {
+		return fmt.Sprintf("stream reset (%s): code: 0x%x: transport error: %s", side, s.ErrorCode, s.TransportError)
+	}
+	return fmt.Sprintf("stream reset (%s): code: 0x%x", side, s.ErrorCode)
+}
+
+// Is reports whether target is a *StreamError with the same code and side.
+func (s *StreamError) Is(target error) bool {
+	if tse, ok := target.(*StreamError); ok {
+		return tse.ErrorCode == s.ErrorCode && tse.Remote == s.Remote
+	}
+	return false
+}
+
+// Unwrap allows errors.Is(err, ErrReset) to keep working for streams reset
+// with an error code.
+func (s *StreamError) Unwrap() []error {
+	return []error{ErrReset, s.TransportError}
+}
+
+// ConnErrorCode is an application defined error code that is sent to the
+// remote peer when closing a connection.
+type ConnErrorCode uint32
+
+// ConnError is returned when using a connection that was closed with an error
+// code, either locally or by the remote peer.
+type ConnError struct {
+	ErrorCode ConnErrorCode
+	// Remote is true if the connection was closed by the remote peer.
+	Remote bool
+	// TransportError is the underlying error reported by the transport, if any.
+	TransportError error
+}
+
+func (c *ConnError) Error() string {
+	side := "local"
+	if c.Remote {
+		side = "remote"
+	}
+	if c.TransportError != nil {
+		return fmt.Sprintf("connection closed (%s): code: 0x%x: transport error: %s", side, c.ErrorCode, c.TransportError)
+	}
+	return fmt.Sprintf("connection closed (%s): code: 0x%x", side, c.ErrorCode)
+}
+
+// Is reports whether target is a *ConnError with the same code and side.
+func (c *ConnError) Is(target error) bool {
+	if tce, ok := target.(*ConnError); ok {
+		return tce.ErrorCode == c.ErrorCode && tce.Remote == c.Remote
+	}
+	return false
+}
+
+// Unwrap allows errors.Is(err, ErrReset) to keep working for streams that
+// were reset because the connection was closed with an error code.
+func (c *ConnError) Unwrap() []error {
+	return []error{ErrReset, c.TransportError}
+}
+
+// Connection error codes used by libp2p. Applications should use codes
+// outside of the 0x1000 - 0x1fff range, which is reserved for libp2p.
+const (
+	ConnNoError                   ConnErrorCode = 0
+	ConnProtocolNegotiationFailed ConnErrorCode = 0x1000
+	ConnResourceLimitExceeded     ConnErrorCode = 0x1001
+	ConnRateLimited               ConnErrorCode = 0x1002
+	ConnProtocolViolation         ConnErrorCode = 0x1003
+	ConnSupplanted                ConnErrorCode = 0x1004
+	ConnGarbageCollected          ConnErrorCode = 0x1005
+	ConnShutdown                  ConnErrorCode = 0x1006
+	ConnGated                     ConnErrorCode = 0x1007
+	// ConnCodeOutOfRange is used when the transport reported a code that
+	// doesn't fit into a ConnErrorCode.
+	ConnCodeOutOfRange ConnErrorCode = 0x1008
+)
+
+// Stream error codes used by libp2p. They use the same values as the
+// connection error codes for the same conditions. Applications should use
+// codes outside of the 0x1000 - 0x1fff range, which is reserved for libp2p.
+const (
+	StreamNoError                   StreamErrorCode = 0
+	StreamProtocolNegotiationFailed StreamErrorCode = 0x1000
+	StreamResourceLimitExceeded     StreamErrorCode = 0x1001
+	StreamRateLimited               StreamErrorCode = 0x1002
+	StreamProtocolViolation         StreamErrorCode = 0x1003
+	StreamSupplanted                StreamErrorCode = 0x1004
+	StreamGarbageCollected          StreamErrorCode = 0x1005
+	StreamShutdown                  StreamErrorCode = 0x1006
+	StreamGated                     StreamErrorCode = 0x1007
+	// StreamCodeOutOfRange is used when the transport reported a code that
+	// doesn't fit into a StreamErrorCode.
+	StreamCodeOutOfRange StreamErrorCode = 0x1008
+)
+
 // MuxedStream is a bidirectional io pipe within a connection.
 type MuxedStream interface {
 	io.Reader
@@ -56,6 +170,11 @@ type MuxedStream interface {
 	// side to hang up and go away.
 	Reset() error
 
+	// ResetWithError is like Reset, but it also sends the error code to the
+	// remote peer. Reads and writes on the remote side fail with a
+	// *StreamError holding the code.
+	ResetWithError(errCode StreamErrorCode) error
+
 	SetDeadline(time.Time) error
 	SetReadDeadline(time.Time) error
 	SetWriteDeadline(time.Time) error
@@ -75,6 +194,10 @@ type MuxedConn interface {
 	// Close closes the stream muxer and the the underlying net.Conn.
 	io.Closer
 
+	// CloseWithError closes the connection, sending the error code to the
+	// remote peer if the transport supports it.
+	CloseWithError(errCode ConnErrorCode) error
+
 	// IsClosed returns whether a connection is fully closed, so it can
 	// be garbage collected.
 	IsClosed() bool
@@ -0,0 +1,33 @@
+package network
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestStreamErrorIs(t *testing.T) {
+	var err error = &StreamError{ErrorCode: 1, Remote: true}
+	require.ErrorIs(t, err, ErrReset)
+	require.ErrorIs(t, err, &StreamError{ErrorCode: 1, Remote: true})
+	require.NotErrorIs(t, err, &StreamError{ErrorCode: 1, Remote: false})
+	require.NotErrorIs(t, err, &StreamError{ErrorCode: 2, Remote: true})
+
+	terr := errors.New("transport error")
+	err = fmt.Errorf("wrapped: %w", &StreamError{ErrorCode: 1, TransportError: terr})
+	require.ErrorIs(t, err, terr)
+	require.ErrorIs(t, err, ErrReset)
+	var serr *StreamError
+	require.ErrorAs(t, err, &serr)
+	require.Equal(t, StreamErrorCode(1), serr.ErrorCode)
+}
+
+func TestConnErrorIs(t *testing.T) {
+	var err error = &ConnError{ErrorCode: ConnGarbageCollected, Remote: true}
+	require.ErrorIs(t, err, ErrReset)
+	require.ErrorIs(t, err, &ConnError{ErrorCode: ConnGarbageCollected, Remote: true})
+	require.NotErrorIs(t, err, &ConnError{ErrorCode: ConnGarbageCollected})
+	require.NotErrorIs(t, err, &StreamError{ErrorCode: StreamErrorCode(ConnGarbageCollected), Remote: true})
+}
@@ -98,6 +98,34 @@ func (r Reachability) String() string {
 	return str[r]
 }
 
+// BlackHoleState is the state of a black hole filter of the swarm, which
+// blocks dials to addresses that are unreachable from the local network, e.g.
+// UDP addresses on networks that block UDP.
+type BlackHoleState int
+
+const (
+	// BlackHoleStateProbing indicates that there aren't enough dial results
+	// to determine whether the addresses are black holed. All dials are
+	// allowed.
+	BlackHoleStateProbing BlackHoleState = iota
+
+	// BlackHoleStateAllowed indicates that enough dials succeeded, the
+	// addresses are not black holed.
+	BlackHoleStateAllowed
+
+	// BlackHoleStateBlocked indicates that the addresses are black holed.
+	// Only occasional probes are dialed.
+	BlackHoleStateBlocked
+)
+
+func (s BlackHoleState) String() string {
+	str := [...]string{"Probing", "Allowed", "Blocked"}
+	if s < 0 || int(s) >= len(str) {
+		return unrecognized
+	}
+	return str[s]
+}
+
 // ConnStats stores metadata pertaining to a given Conn.
 type ConnStats struct {
 	Stats
@@ -113,6 +141,12 @@ type Stats struct {
 	Opened time.Time
 	// Transient indicates that this connection is transient and may be closed soon.
 	Transient bool
+	// Parallel indicates that this connection is one of several parallel
+	// connections kept to the same peer to spread streams over them. Connection
+	// managers should not count it as a separate connection. The flag doesn't
+	// change when the first connection to the peer is closed, so a peer with
+	// only parallel connections left should still be counted once.
+	Parallel bool
 	// Extra stores additional metadata about this connection.
 	Extra map[interface{}]interface{}
 }
@@ -1,6 +1,8 @@
 package network
 
 import (
+	"context"
+
 	"github.com/libp2p/go-libp2p/core/peer"
 	"github.com/libp2p/go-libp2p/core/protocol"
 	"github.com/multiformats/go-multiaddr"
@@ -258,6 +260,15 @@ type StreamScope interface {
 	SetService(srv string) error
 }
 
+// BandwidthScope is an optional interface implemented by stream scopes that
+// limit the bandwidth of the stream, and of the scopes it belongs to.
+type BandwidthScope interface {
+	// WaitBandwidth accounts for n bytes transferred on the stream, inbound
+	// for bytes read and outbound for bytes written. It blocks until the
+	// transfer fits in the bandwidth limits of the scopes, or ctx is done.
+	WaitBandwidth(ctx context.Context, dir Direction, n int) error
+}
+
 // ScopeStat is a struct containing resource accounting information.
 type ScopeStat struct {
 	NumStreamsInbound  int
@@ -267,6 +278,12 @@ type ScopeStat struct {
 	NumFD              int
 
 	Memory int64
+
+	// BytesInbound and BytesOutbound are the number of bytes read from and
+	// written to the streams of the scope. They're only accounted for by
+	// resource managers that implement BandwidthScope.
+	BytesInbound  int64
+	BytesOutbound int64
 }
 
 // NullResourceManager is a stub for tests and initialization of default values
@@ -26,6 +26,10 @@ import (
 //
 // Useful when you want to extend, but not replace, the supported transport
 // security protocols.
+//
+// The default Noise transport doesn't cache the static keys of remote peers.
+// To make reconnections use the IK handshake, replace it with
+// Security(noise.ID, noise.NewWithPeerstore).
 var DefaultSecurity = ChainOptions(
 	Security(tls.ID, tls.New),
 	Security(noise.ID, noise.New),
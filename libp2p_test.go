@@ -3,16 +3,24 @@ package libp2p
 import (
 	"context"
 	"fmt"
+	"net"
 	"regexp"
 	"strings"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/libp2p/go-libp2p/core/connmgr"
 	"github.com/libp2p/go-libp2p/core/crypto"
 	"github.com/libp2p/go-libp2p/core/host"
 	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/peerstore"
+	"github.com/libp2p/go-libp2p/core/protocol"
+	"github.com/libp2p/go-libp2p/core/sec"
 	"github.com/libp2p/go-libp2p/core/transport"
+	rcmgr "github.com/libp2p/go-libp2p/p2p/host/resource-manager"
 	"github.com/libp2p/go-libp2p/p2p/net/swarm"
+	tptu "github.com/libp2p/go-libp2p/p2p/net/upgrader"
 	"github.com/libp2p/go-libp2p/p2p/security/noise"
 	tls "github.com/libp2p/go-libp2p/p2p/security/tls"
 	quic "github.com/libp2p/go-libp2p/p2p/transport/quic"
@@ -97,6 +105,12 @@ func TestAutoNATService(t *testing.T) {
 	h.Close()
 }
 
+func TestAutoNATv2(t *testing.T) {
+	h, err := New(EnableAutoNATv2())
+	require.NoError(t, err)
+	h.Close()
+}
+
 func TestDefaultListenAddrs(t *testing.T) {
 	reTCP := regexp.MustCompile("/(ip)[4|6]/((0.0.0.0)|(::))/tcp/")
 	reQUIC := regexp.MustCompile("/(ip)[4|6]/((0.0.0.0)|(::))/udp/([0-9]*)/quic-v1")
@@ -291,6 +305,110 @@ func TestSecurityConstructor(t *testing.T) {
 	require.NoError(t, h2.Connect(context.Background(), ai))
 }
 
+func TestHybridSecurityNegotiation(t *testing.T) {
+	h, err := New(
+		Transport(tcp.NewTCPTransport),
+		Security(noise.PQID, noise.NewPQ),
+		Security(tls.PQID, tls.NewPQ),
+		Security(noise.ID, noise.New),
+		Security(tls.ID, tls.New),
+		ListenAddrStrings("/ip4/127.0.0.1/tcp/0"),
+		DisableRelay(),
+	)
+	require.NoError(t, err)
+	defer h.Close()
+
+	for _, tc := range []struct {
+		name     string
+		security Option
+		expected string
+	}{
+		{"classical noise", Security(noise.ID, noise.New), noise.ID},
+		{"classical tls", Security(tls.ID, tls.New), tls.ID},
+		{"hybrid noise", ChainOptions(Security(noise.PQID, noise.NewPQ), Security(noise.ID, noise.New)), noise.PQID},
+		{"hybrid tls", ChainOptions(Security(tls.PQID, tls.NewPQ), Security(tls.ID, tls.New)), tls.PQID},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			h1, err := New(
+				NoListenAddrs,
+				Transport(tcp.NewTCPTransport),
+				tc.security,
+				DisableRelay(),
+			)
+			require.NoError(t, err)
+			defer h1.Close()
+
+			require.NoError(t, h1.Connect(context.Background(), peer.AddrInfo{ID: h.ID(), Addrs: h.Addrs()}))
+			conns := h1.Network().ConnsToPeer(h.ID())
+			require.Len(t, conns, 1)
+			require.Equal(t, tc.expected, string(conns[0].ConnState().Security))
+		})
+	}
+}
+
+// handshakeCountingTransport counts the handshake messages sent by the
+// initiator of a security handshake.
+type handshakeCountingTransport struct {
+	sec.SecureTransport
+	messages chan int
+}
+
+type writeCountingConn struct {
+	net.Conn
+	writes atomic.Int32
+}
+
+func (c *writeCountingConn) Write(b []byte) (int, error) {
+	c.writes.Add(1)
+	return c.Conn.Write(b)
+}
+
+func (t *handshakeCountingTransport) SecureOutbound(ctx context.Context, insecure net.Conn, p peer.ID) (sec.SecureConn, error) {
+	c := &writeCountingConn{Conn: insecure}
+	conn, err := t.SecureTransport.SecureOutbound(ctx, c, p)
+	t.messages <- int(c.writes.Load())
+	return conn, err
+}
+
+func TestNoiseStaticKeyCache(t *testing.T) {
+	// The static key cache is opt-in, both hosts enable it explicitly.
+	h, err := New(
+		Transport(tcp.NewTCPTransport),
+		Security(noise.ID, noise.NewWithPeerstore),
+		ListenAddrStrings("/ip4/127.0.0.1/tcp/0"),
+		DisableRelay(),
+	)
+	require.NoError(t, err)
+	defer h.Close()
+
+	messages := make(chan int, 2)
+	h1, err := New(
+		NoListenAddrs,
+		Transport(tcp.NewTCPTransport),
+		Security(noise.ID, func(id protocol.ID, priv crypto.PrivKey, muxers []tptu.StreamMuxer, ps peerstore.Peerstore) (*handshakeCountingTransport, error) {
+			tpt, err := noise.NewWithPeerstore(id, priv, muxers, ps)
+			if err != nil {
+				return nil, err
+			}
+			return &handshakeCountingTransport{SecureTransport: tpt, messages: messages}, nil
+		}),
+		DisableRelay(),
+	)
+	require.NoError(t, err)
+	defer h1.Close()
+
+	ai := peer.AddrInfo{ID: h.ID(), Addrs: h.Addrs()}
+	// The first handshake uses XX, where the initiator sends two messages.
+	require.NoError(t, h1.Connect(context.Background(), ai))
+	require.Equal(t, 2, <-messages)
+	require.NoError(t, h1.Network().ClosePeer(h.ID()))
+
+	// The second one uses IK with the cached static key, where the initiator
+	// sends a single message.
+	require.NoError(t, h1.Connect(context.Background(), ai))
+	require.Equal(t, 1, <-messages)
+}
+
 func TestTransportConstructorWebTransport(t *testing.T) {
 	h, err := New(
 		Transport(webtransport.New),
@@ -356,3 +474,36 @@ func TestTransportCustomAddressWebTransportDoesNotStall(t *testing.T) {
 	// We did not add the certhash to the multiaddr
 	require.Equal(t, addrs[0], customAddr)
 }
+
+type testResourceSource struct {
+	memory atomic.Int64
+}
+
+func (s *testResourceSource) Memory() (int64, error) { return s.memory.Load(), nil }
+func (s *testResourceSource) FDs() (int, error)      { return 1024, nil }
+
+func TestLimitChangeEvents(t *testing.T) {
+	src := &testResourceSource{}
+	src.memory.Store(1 << 30)
+	l, err := rcmgr.NewDynamicLimiter(rcmgr.DefaultLimits, rcmgr.WithResourceSource(src))
+	require.NoError(t, err)
+	defer l.Close()
+	rm, err := rcmgr.NewResourceManager(l)
+	require.NoError(t, err)
+	h, err := New(NoListenAddrs, ResourceManager(rm))
+	require.NoError(t, err)
+	defer h.Close()
+
+	sub, err := h.EventBus().Subscribe(new(rcmgr.LimitChangeEvent))
+	require.NoError(t, err)
+	defer sub.Close()
+
+	src.memory.Store(2 << 30)
+	require.NoError(t, l.Update())
+	select {
+	case e := <-sub.Out():
+		require.Equal(t, int64(2<<30), e.(rcmgr.LimitChangeEvent).NewMemory)
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected a limit change event")
+	}
+}
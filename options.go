@@ -220,6 +220,10 @@ func PrivateNetwork(psk pnet.PSK) Option {
 }
 
 // BandwidthReporter configures libp2p to use the given bandwidth reporter.
+//
+// If the reporter breaks bandwidth down by transport, like
+// metrics.ShardedBandwidthCounter, and metrics are enabled, the bandwidth by
+// transport is exported to Prometheus.
 func BandwidthReporter(rep metrics.Reporter) Option {
 	return func(cfg *Config) error {
 		if cfg.Reporter != nil {
@@ -388,6 +392,17 @@ func AutoNATServiceRateLimit(global, perPeer int, interval time.Duration) Option
 	}
 }
 
+// EnableAutoNATv2 enables the AutoNAT v2 client and server. The client checks
+// the reachability of each of the host's addresses individually, and reports
+// the results via event.EvtHostReachableAddrsChanged. The server helps other
+// peers verify the reachability of their addresses.
+func EnableAutoNATv2() Option {
+	return func(cfg *Config) error {
+		cfg.EnableAutoNATv2 = true
+		return nil
+	}
+}
+
 // ConnectionGater configures libp2p to use the given ConnectionGater
 // to actively reject inbound/outbound connections based on the lifecycle stage
 // of the connection.
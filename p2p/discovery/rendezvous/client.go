@@ -0,0 +1,209 @@
+package rendezvous
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/discovery"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/record"
+	"github.com/libp2p/go-libp2p/p2p/discovery/rendezvous/pb"
+
+	"github.com/libp2p/go-msgio/pbio"
+)
+
+// Client registers the host at, and discovers peers from, a rendezvous server.
+type Client struct {
+	host   host.Host
+	server peer.ID
+}
+
+var _ discovery.Discovery = &Client{}
+
+// NewClient creates a client for the rendezvous server with the given peer
+// ID. The host must be able to connect to the server.
+func NewClient(h host.Host, server peer.ID) *Client {
+	return &Client{host: h, server: server}
+}
+
+// roundTrip sends req to the server and returns the response. If req doesn't
+// have a response, it returns nil.
+func (c *Client) roundTrip(ctx context.Context, req *pb.Message) (*pb.Message, error) {
+	s, err := c.host.NewStream(ctx, c.server, Protocol)
+	if err != nil {
+		return nil, err
+	}
+	defer s.Close()
+	if err := s.Scope().SetService(ServiceName); err != nil {
+		s.Reset()
+		return nil, fmt.Errorf("failed to attach stream to service %s: %w", ServiceName, err)
+	}
+	if err := s.Scope().ReserveMemory(maxResponseSize, network.ReservationPriorityAlways); err != nil {
+		s.Reset()
+		return nil, fmt.Errorf("failed to reserve memory for stream: %w", err)
+	}
+	defer s.Scope().ReleaseMemory(maxResponseSize)
+
+	deadline := time.Now().Add(streamTimeout)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	s.SetDeadline(deadline)
+
+	if err := pbio.NewDelimitedWriter(s).WriteMsg(req); err != nil {
+		s.Reset()
+		return nil, err
+	}
+	if req.GetType() == pb.Message_UNREGISTER {
+		return nil, nil
+	}
+	var resp pb.Message
+	if err := pbio.NewDelimitedReader(s, maxResponseSize).ReadMsg(&resp); err != nil {
+		s.Reset()
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// Advertise registers the host in the namespace. The TTL defaults to
+// DefaultTTL, and the TTL granted by the server is returned.
+func (c *Client) Advertise(ctx context.Context, ns string, opts ...discovery.Option) (time.Duration, error) {
+	var options discovery.Options
+	if err := options.Apply(opts...); err != nil {
+		return 0, err
+	}
+	ttl := options.Ttl
+	if ttl == 0 {
+		ttl = DefaultTTL
+	}
+
+	privKey := c.host.Peerstore().PrivKey(c.host.ID())
+	if privKey == nil {
+		return 0, errors.New("unable to access host key")
+	}
+	env, err := record.Seal(peer.PeerRecordFromAddrInfo(peer.AddrInfo{ID: c.host.ID(), Addrs: c.host.Addrs()}), privKey)
+	if err != nil {
+		return 0, fmt.Errorf("failed to create signed peer record: %w", err)
+	}
+	spr, err := env.Marshal()
+	if err != nil {
+		return 0, err
+	}
+
+	resp, err := c.roundTrip(ctx, &pb.Message{
+		Type: pb.Message_REGISTER.Enum(),
+		Register: &pb.Message_Register{
+			Ns:               &ns,
+			SignedPeerRecord: spr,
+			Ttl:              uint64Ptr(uint64(ttl / time.Second)),
+		},
+	})
+	if err != nil {
+		return 0, err
+	}
+	if resp.GetType() != pb.Message_REGISTER_RESPONSE {
+		return 0, fmt.Errorf("unexpected response: %s", resp.GetType())
+	}
+	r := resp.GetRegisterResponse()
+	if r.GetStatus() != pb.Message_OK {
+		return 0, Error{Status: r.GetStatus(), Text: r.GetStatusText()}
+	}
+	return time.Duration(r.GetTtl()) * time.Second, nil
+}
+
+// Unregister removes the registration of the host in the namespace.
+func (c *Client) Unregister(ctx context.Context, ns string) error {
+	_, err := c.roundTrip(ctx, &pb.Message{
+		Type:       pb.Message_UNREGISTER.Enum(),
+		Unregister: &pb.Message_Unregister{Ns: &ns},
+	})
+	return err
+}
+
+// Discover returns up to limit peers registered in the namespace, and the
+// cookie to pass to the next call to only get the peers registered since.
+// The server may return fewer peers than requested even if more peers are
+// registered. An empty namespace discovers peers of all namespaces.
+func (c *Client) Discover(ctx context.Context, ns string, limit int, cookie []byte) ([]peer.AddrInfo, []byte, error) {
+	resp, err := c.roundTrip(ctx, &pb.Message{
+		Type: pb.Message_DISCOVER.Enum(),
+		Discover: &pb.Message_Discover{
+			Ns:     &ns,
+			Limit:  uint64Ptr(uint64(limit)),
+			Cookie: cookie,
+		},
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	if resp.GetType() != pb.Message_DISCOVER_RESPONSE {
+		return nil, nil, fmt.Errorf("unexpected response: %s", resp.GetType())
+	}
+	r := resp.GetDiscoverResponse()
+	if r.GetStatus() != pb.Message_OK {
+		return nil, nil, Error{Status: r.GetStatus(), Text: r.GetStatusText()}
+	}
+
+	ais := make([]peer.AddrInfo, 0, len(r.GetRegistrations()))
+	for _, reg := range r.GetRegistrations() {
+		// Don't trust the server, verify the records.
+		env, rec, err := record.ConsumeEnvelope(reg.GetSignedPeerRecord(), peer.PeerRecordEnvelopeDomain)
+		if err != nil {
+			log.Debugw("invalid signed peer record", "namespace", reg.GetNs(), "error", err)
+			continue
+		}
+		prec, ok := rec.(*peer.PeerRecord)
+		if !ok {
+			continue
+		}
+		signer, err := peer.IDFromPublicKey(env.PublicKey)
+		if err != nil || signer != prec.PeerID {
+			log.Debugw("peer record not signed by the peer", "namespace", reg.GetNs(), "peer", prec.PeerID)
+			continue
+		}
+		ais = append(ais, peer.AddrInfo{ID: prec.PeerID, Addrs: prec.Addrs})
+	}
+	return ais, r.GetCookie(), nil
+}
+
+// FindPeers returns the peers registered in the namespace, paging through all
+// registrations until the limit is reached.
+func (c *Client) FindPeers(ctx context.Context, ns string, opts ...discovery.Option) (<-chan peer.AddrInfo, error) {
+	var options discovery.Options
+	if err := options.Apply(opts...); err != nil {
+		return nil, err
+	}
+
+	var res []peer.AddrInfo
+	var cookie []byte
+	for options.Limit == 0 || len(res) < options.Limit {
+		limit := maxDiscoverLimit
+		if options.Limit != 0 && options.Limit-len(res) < limit {
+			limit = options.Limit - len(res)
+		}
+		ais, next, err := c.Discover(ctx, ns, limit, cookie)
+		if err != nil {
+			return nil, err
+		}
+		for _, ai := range ais {
+			if ai.ID != c.host.ID() {
+				res = append(res, ai)
+			}
+		}
+		if len(ais) == 0 {
+			break
+		}
+		cookie = next
+	}
+
+	ch := make(chan peer.AddrInfo, len(res))
+	for _, ai := range res {
+		ch <- ai
+	}
+	close(ch)
+	return ch, nil
+}
@@ -0,0 +1,110 @@
+package rendezvous
+
+import (
+	"errors"
+	"time"
+)
+
+// NamespaceLimits are the limits the server enforces for the registrations in
+// a namespace.
+type NamespaceLimits struct {
+	// MinTTL and MaxTTL bound the TTL a client can request.
+	MinTTL time.Duration
+	MaxTTL time.Duration
+	// MaxRegistrations is the maximum number of peers registered in the
+	// namespace.
+	MaxRegistrations int
+}
+
+// DefaultNamespaceLimits are the limits used for namespaces that weren't
+// configured using WithNamespaceLimits.
+var DefaultNamespaceLimits = NamespaceLimits{
+	MinTTL:           2 * time.Minute,
+	MaxTTL:           MaxTTL,
+	MaxRegistrations: 1000,
+}
+
+type config struct {
+	defaultLimits   NamespaceLimits
+	namespaceLimits map[string]NamespaceLimits
+	// maxRegistrationsPerPeer is the maximum number of namespaces a peer can
+	// be registered in.
+	maxRegistrationsPerPeer int
+	gcInterval              time.Duration
+}
+
+func defaultConfig() *config {
+	return &config{
+		defaultLimits:           DefaultNamespaceLimits,
+		namespaceLimits:         make(map[string]NamespaceLimits),
+		maxRegistrationsPerPeer: 100,
+		gcInterval:              time.Minute,
+	}
+}
+
+func (c *config) limits(ns string) NamespaceLimits {
+	if l, ok := c.namespaceLimits[ns]; ok {
+		return l
+	}
+	return c.defaultLimits
+}
+
+// Option configures the rendezvous server.
+type Option func(*config) error
+
+func validateLimits(l NamespaceLimits) error {
+	if l.MinTTL <= 0 || l.MaxTTL < l.MinTTL {
+		return errors.New("invalid TTL limits")
+	}
+	if l.MaxRegistrations <= 0 {
+		return errors.New("max registrations must be positive")
+	}
+	return nil
+}
+
+// WithDefaultNamespaceLimits sets the limits for all namespaces that don't
+// have specific limits configured.
+func WithDefaultNamespaceLimits(l NamespaceLimits) Option {
+	return func(c *config) error {
+		if err := validateLimits(l); err != nil {
+			return err
+		}
+		c.defaultLimits = l
+		return nil
+	}
+}
+
+// WithNamespaceLimits sets the limits of a namespace.
+func WithNamespaceLimits(ns string, l NamespaceLimits) Option {
+	return func(c *config) error {
+		if err := validateLimits(l); err != nil {
+			return err
+		}
+		c.namespaceLimits[ns] = l
+		return nil
+	}
+}
+
+// WithMaxRegistrationsPerPeer sets the maximum number of namespaces a single
+// peer can be registered in.
+func WithMaxRegistrationsPerPeer(n int) Option {
+	return func(c *config) error {
+		if n <= 0 {
+			return errors.New("max registrations per peer must be positive")
+		}
+		c.maxRegistrationsPerPeer = n
+		return nil
+	}
+}
+
+// WithGCInterval sets how often expired registrations are removed from the
+// store.
+func WithGCInterval(d time.Duration) Option {
+	return func(c *config) error {
+		if d <= 0 {
+			return errors.New("gc interval must be positive")
+		}
+		c.gcInterval = d
+		return nil
+	}
+}
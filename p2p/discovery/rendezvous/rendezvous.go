@@ -0,0 +1,63 @@
+// Package rendezvous implements the rendezvous protocol, see
+// https://github.com/libp2p/specs/blob/master/rendezvous/README.md.
+//
+// Peers register themselves in namespaces at a rendezvous server, using a
+// signed peer record, and discover other peers registered in the same
+// namespace. The Client implements discovery.Discovery, so it can be used with
+// the backoff discovery and discovery/util.
+package rendezvous
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/protocol"
+	"github.com/libp2p/go-libp2p/p2p/discovery/rendezvous/pb"
+
+	logging "github.com/ipfs/go-log/v2"
+)
+
+//go:generate protoc --go_out=. --go_opt=Mpb/rendezvous.proto=./pb pb/rendezvous.proto
+
+const (
+	Protocol    protocol.ID = "/rendezvous/1.0.0"
+	ServiceName             = "libp2p.rendezvous"
+
+	// DefaultTTL is the TTL of a registration if the client doesn't request one.
+	DefaultTTL = 2 * time.Hour
+	// MaxTTL is the maximum TTL of a registration allowed by default.
+	MaxTTL = 72 * time.Hour
+
+	// maxNamespaceLength is the maximum length of a namespace in bytes.
+	maxNamespaceLength = 255
+	// maxSignedPeerRecordSize is the maximum size of a signed peer record
+	// accepted by the server.
+	maxSignedPeerRecordSize = 4 << 10
+	// maxRequestSize is the maximum size of a request.
+	maxRequestSize = 8 << 10
+	// maxResponseSize is the maximum size of a response. The server never
+	// returns more than maxDiscoverLimit registrations per response, so this
+	// fits maxDiscoverLimit signed peer records.
+	maxResponseSize = 1 << 20
+	// maxDiscoverLimit is the maximum number of registrations returned in a
+	// single discover response. Clients page through the rest using the
+	// cookie.
+	maxDiscoverLimit = 100
+
+	streamTimeout = time.Minute
+)
+
+var log = logging.Logger("rendezvous")
+
+// Error is returned by the client if the server responds with an error status.
+type Error struct {
+	Status pb.Message_ResponseStatus
+	Text   string
+}
+
+func (e Error) Error() string {
+	if e.Text == "" {
+		return fmt.Sprintf("rendezvous error: %s", e.Status)
+	}
+	return fmt.Sprintf("rendezvous error: %s: %s", e.Status, e.Text)
+}
@@ -0,0 +1,191 @@
+package rendezvous
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/discovery"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/record"
+	"github.com/libp2p/go-libp2p/p2p/discovery/backoff"
+	"github.com/libp2p/go-libp2p/p2p/discovery/rendezvous/pb"
+	"github.com/libp2p/go-libp2p/p2p/discovery/util"
+	mocknet "github.com/libp2p/go-libp2p/p2p/net/mock"
+
+	"github.com/stretchr/testify/require"
+)
+
+func newHosts(t *testing.T, n int) []host.Host {
+	t.Helper()
+	mn, err := mocknet.FullMeshConnected(n)
+	require.NoError(t, err)
+	t.Cleanup(func() { mn.Close() })
+	return mn.Hosts()
+}
+
+func newServer(t *testing.T, h host.Host, opts ...Option) *Server {
+	t.Helper()
+	s, err := NewServer(h, NewMemoryStore(), opts...)
+	require.NoError(t, err)
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+func TestAdvertiseAndFindPeers(t *testing.T) {
+	hosts := newHosts(t, 5)
+	newServer(t, hosts[0])
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	for _, h := range hosts[1:] {
+		ttl, err := NewClient(h, hosts[0].ID()).Advertise(ctx, "foo")
+		require.NoError(t, err)
+		require.Equal(t, DefaultTTL, ttl)
+	}
+
+	c := NewClient(hosts[1], hosts[0].ID())
+	peers, err := util.FindPeers(ctx, c, "foo")
+	require.NoError(t, err)
+	// the client doesn't return itself
+	require.Len(t, peers, 3)
+	for _, ai := range peers {
+		require.NotEqual(t, hosts[1].ID(), ai.ID)
+		require.ElementsMatch(t, hosts[0].Peerstore().Addrs(ai.ID), ai.Addrs)
+	}
+
+	peers, err = util.FindPeers(ctx, c, "foo", discovery.Limit(2))
+	require.NoError(t, err)
+	require.Len(t, peers, 2)
+
+	require.NoError(t, NewClient(hosts[2], hosts[0].ID()).Unregister(ctx, "foo"))
+	require.Eventually(t, func() bool {
+		peers, err := util.FindPeers(ctx, c, "foo")
+		return err == nil && len(peers) == 2
+	}, 5*time.Second, 10*time.Millisecond)
+}
+
+func TestDiscoverCookie(t *testing.T) {
+	hosts := newHosts(t, 4)
+	newServer(t, hosts[0])
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	_, err := NewClient(hosts[1], hosts[0].ID()).Advertise(ctx, "foo")
+	require.NoError(t, err)
+
+	c := NewClient(hosts[3], hosts[0].ID())
+	peers, cookie, err := c.Discover(ctx, "foo", 10, nil)
+	require.NoError(t, err)
+	require.Equal(t, []peer.ID{hosts[1].ID()}, peerIDs(peers))
+
+	_, err = NewClient(hosts[2], hosts[0].ID()).Advertise(ctx, "foo")
+	require.NoError(t, err)
+	peers, _, err = c.Discover(ctx, "foo", 10, cookie)
+	require.NoError(t, err)
+	require.Equal(t, []peer.ID{hosts[2].ID()}, peerIDs(peers))
+
+	_, _, err = c.Discover(ctx, "bar", 10, cookie)
+	var rerr Error
+	require.ErrorAs(t, err, &rerr)
+	require.Equal(t, pb.Message_E_INVALID_COOKIE, rerr.Status)
+}
+
+func TestDiscoverRejectsForgedRecords(t *testing.T) {
+	hosts := newHosts(t, 3)
+	store := NewMemoryStore()
+	s, err := NewServer(hosts[0], store)
+	require.NoError(t, err)
+	t.Cleanup(func() { s.Close() })
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	// A malicious server hands out a record for hosts[1], signed by another key.
+	priv, _, err := crypto.GenerateEd25519Key(nil)
+	require.NoError(t, err)
+	rec := peer.PeerRecordFromAddrInfo(peer.AddrInfo{ID: hosts[1].ID(), Addrs: hosts[1].Addrs()})
+	env, err := record.Seal(rec, priv)
+	require.NoError(t, err)
+	spr, err := env.Marshal()
+	require.NoError(t, err)
+	require.NoError(t, store.Register(ctx, Registration{
+		Namespace:        "foo",
+		Peer:             hosts[1].ID(),
+		SignedPeerRecord: spr,
+		Expiry:           time.Now().Add(time.Hour),
+	}, 100, 100))
+
+	c := NewClient(hosts[2], hosts[0].ID())
+	peers, _, err := c.Discover(ctx, "foo", 10, nil)
+	require.NoError(t, err)
+	require.Empty(t, peers)
+
+	// Records signed by the peer itself are returned.
+	_, err = NewClient(hosts[1], hosts[0].ID()).Advertise(ctx, "foo")
+	require.NoError(t, err)
+	peers, _, err = c.Discover(ctx, "foo", 10, nil)
+	require.NoError(t, err)
+	require.Equal(t, []peer.ID{hosts[1].ID()}, peerIDs(peers))
+}
+
+func peerIDs(ais []peer.AddrInfo) []peer.ID {
+	ids := make([]peer.ID, 0, len(ais))
+	for _, ai := range ais {
+		ids = append(ids, ai.ID)
+	}
+	return ids
+}
+
+func TestServerLimits(t *testing.T) {
+	hosts := newHosts(t, 3)
+	newServer(t, hosts[0],
+		WithNamespaceLimits("small", NamespaceLimits{MinTTL: time.Hour, MaxTTL: 2 * time.Hour, MaxRegistrations: 1}),
+	)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	c1 := NewClient(hosts[1], hosts[0].ID())
+	c2 := NewClient(hosts[2], hosts[0].ID())
+
+	checkStatus := func(err error, status pb.Message_ResponseStatus) {
+		t.Helper()
+		var rerr Error
+		require.ErrorAs(t, err, &rerr)
+		require.Equal(t, status, rerr.Status)
+	}
+
+	_, err := c1.Advertise(ctx, "small", discovery.TTL(time.Minute))
+	checkStatus(err, pb.Message_E_INVALID_TTL)
+	_, err = c1.Advertise(ctx, "small", discovery.TTL(3*time.Hour))
+	checkStatus(err, pb.Message_E_INVALID_TTL)
+	_, err = c1.Advertise(ctx, "default", discovery.TTL(100*time.Hour))
+	checkStatus(err, pb.Message_E_INVALID_TTL)
+
+	ttl, err := c1.Advertise(ctx, "small", discovery.TTL(90*time.Minute))
+	require.NoError(t, err)
+	require.Equal(t, 90*time.Minute, ttl)
+	_, err = c2.Advertise(ctx, "small")
+	checkStatus(err, pb.Message_E_UNAVAILABLE)
+
+	_, err = c2.Advertise(ctx, "")
+	checkStatus(err, pb.Message_E_INVALID_NAMESPACE)
+	_, err = c2.Advertise(ctx, string(make([]byte, maxNamespaceLength+1)))
+	checkStatus(err, pb.Message_E_INVALID_NAMESPACE)
+}
+
+func TestBackoffDiscovery(t *testing.T) {
+	hosts := newHosts(t, 3)
+	newServer(t, hosts[0])
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	_, err := NewClient(hosts[1], hosts[0].ID()).Advertise(ctx, "foo")
+	require.NoError(t, err)
+
+	d, err := backoff.NewBackoffDiscovery(NewClient(hosts[2], hosts[0].ID()), backoff.NewFixedBackoff(time.Minute))
+	require.NoError(t, err)
+	peers, err := util.FindPeers(ctx, d, "foo", discovery.Limit(10))
+	require.NoError(t, err)
+	require.Equal(t, []peer.ID{hosts[1].ID()}, peerIDs(peers))
+}
@@ -0,0 +1,284 @@
+package rendezvous
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/record"
+	"github.com/libp2p/go-libp2p/p2p/discovery/rendezvous/pb"
+
+	"github.com/libp2p/go-msgio/pbio"
+)
+
+// Server is a rendezvous server. It stores the registrations in a Store.
+type Server struct {
+	host  host.Host
+	store Store
+	conf  *config
+
+	ctx       context.Context
+	ctxCancel context.CancelFunc
+	refCount  sync.WaitGroup
+
+	now func() time.Time
+}
+
+// NewServer creates a rendezvous server and attaches the stream handler to
+// the host.
+func NewServer(h host.Host, store Store, opts ...Option) (*Server, error) {
+	conf := defaultConfig()
+	for _, o := range opts {
+		if err := o(conf); err != nil {
+			return nil, err
+		}
+	}
+
+	s := &Server{
+		host:  h,
+		store: store,
+		conf:  conf,
+		now:   time.Now,
+	}
+	s.ctx, s.ctxCancel = context.WithCancel(context.Background())
+
+	s.refCount.Add(1)
+	go s.background()
+
+	h.SetStreamHandler(Protocol, s.handleStream)
+	return s, nil
+}
+
+// Close removes the stream handler and stops the garbage collection of expired
+// registrations. It doesn't close the store.
+func (s *Server) Close() error {
+	s.host.RemoveStreamHandler(Protocol)
+	s.ctxCancel()
+	s.refCount.Wait()
+	return nil
+}
+
+func (s *Server) background() {
+	defer s.refCount.Done()
+
+	t := time.NewTicker(s.conf.gcInterval)
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C:
+			if err := s.store.GC(s.ctx); err != nil {
+				log.Warnw("failed to remove expired registrations", "error", err)
+			}
+		case <-s.ctx.Done():
+			return
+		}
+	}
+}
+
+func (s *Server) handleStream(str network.Stream) {
+	if err := str.Scope().SetService(ServiceName); err != nil {
+		log.Debugf("failed to attach stream to service %s: %s", ServiceName, err)
+		str.Reset()
+		return
+	}
+	if err := str.Scope().ReserveMemory(maxRequestSize, network.ReservationPriorityAlways); err != nil {
+		log.Debugf("failed to reserve memory for stream %s: %s", Protocol, err)
+		str.Reset()
+		return
+	}
+	defer str.Scope().ReleaseMemory(maxRequestSize)
+
+	str.SetDeadline(time.Now().Add(streamTimeout))
+	defer str.Close()
+
+	p := str.Conn().RemotePeer()
+	r := pbio.NewDelimitedReader(str, maxRequestSize)
+	w := pbio.NewDelimitedWriter(str)
+	// A client can send multiple requests on the same stream.
+	for {
+		var req pb.Message
+		if err := r.ReadMsg(&req); err != nil {
+			if err != io.EOF {
+				log.Debugf("failed to read request from %s: %s", p, err)
+				str.Reset()
+			}
+			return
+		}
+
+		var resp *pb.Message
+		switch req.GetType() {
+		case pb.Message_REGISTER:
+			resp = s.handleRegister(p, req.GetRegister())
+		case pb.Message_UNREGISTER:
+			s.handleUnregister(p, req.GetUnregister())
+			continue
+		case pb.Message_DISCOVER:
+			resp = s.handleDiscover(req.GetDiscover())
+		default:
+			log.Debugf("unexpected message type from %s: %s", p, req.GetType())
+			str.Reset()
+			return
+		}
+		if err := w.WriteMsg(resp); err != nil {
+			log.Debugf("failed to write response to %s: %s", p, err)
+			str.Reset()
+			return
+		}
+	}
+}
+
+func validNamespace(ns string) bool {
+	return len(ns) <= maxNamespaceLength
+}
+
+func newRegisterResponse(status pb.Message_ResponseStatus, text string, ttl time.Duration) *pb.Message {
+	return &pb.Message{
+		Type: pb.Message_REGISTER_RESPONSE.Enum(),
+		RegisterResponse: &pb.Message_RegisterResponse{
+			Status:     status.Enum(),
+			StatusText: &text,
+			Ttl:        uint64Ptr(uint64(ttl / time.Second)),
+		},
+	}
+}
+
+func newDiscoverErrorResponse(status pb.Message_ResponseStatus, text string) *pb.Message {
+	return &pb.Message{
+		Type: pb.Message_DISCOVER_RESPONSE.Enum(),
+		DiscoverResponse: &pb.Message_DiscoverResponse{
+			Status:     status.Enum(),
+			StatusText: &text,
+		},
+	}
+}
+
+func uint64Ptr(n uint64) *uint64 { return &n }
+
+func (s *Server) handleRegister(p peer.ID, req *pb.Message_Register) *pb.Message {
+	ns := req.GetNs()
+	if ns == "" || !validNamespace(ns) {
+		return newRegisterResponse(pb.Message_E_INVALID_NAMESPACE, "invalid namespace", 0)
+	}
+
+	limits := s.conf.limits(ns)
+	ttl := time.Duration(req.GetTtl()) * time.Second
+	if req.GetTtl() == 0 {
+		ttl = DefaultTTL
+		if ttl > limits.MaxTTL {
+			ttl = limits.MaxTTL
+		}
+	}
+	if req.GetTtl() > uint64(limits.MaxTTL/time.Second) || ttl < limits.MinTTL {
+		return newRegisterResponse(pb.Message_E_INVALID_TTL, "invalid ttl", 0)
+	}
+
+	spr := req.GetSignedPeerRecord()
+	if len(spr) > maxSignedPeerRecordSize {
+		return newRegisterResponse(pb.Message_E_INVALID_SIGNED_PEER_RECORD, "signed peer record too large", 0)
+	}
+	if err := validateSignedPeerRecord(spr, p); err != nil {
+		log.Debugf("invalid signed peer record from %s: %s", p, err)
+		return newRegisterResponse(pb.Message_E_INVALID_SIGNED_PEER_RECORD, "invalid signed peer record", 0)
+	}
+
+	reg := Registration{
+		Namespace:        ns,
+		Peer:             p,
+		SignedPeerRecord: spr,
+		Expiry:           s.now().Add(ttl),
+	}
+	ctx, cancel := context.WithTimeout(s.ctx, streamTimeout)
+	defer cancel()
+	if err := s.store.Register(ctx, reg, limits.MaxRegistrations, s.conf.maxRegistrationsPerPeer); err != nil {
+		if errors.Is(err, ErrTooManyRegistrations) {
+			return newRegisterResponse(pb.Message_E_UNAVAILABLE, err.Error(), 0)
+		}
+		log.Errorf("failed to register %s in %s: %s", p, ns, err)
+		return newRegisterResponse(pb.Message_E_INTERNAL_ERROR, "internal error", 0)
+	}
+	log.Debugf("registered %s in %s for %s", p, ns, ttl)
+	return newRegisterResponse(pb.Message_OK, "", ttl)
+}
+
+// validateSignedPeerRecord checks that spr is a peer record signed by p.
+func validateSignedPeerRecord(spr []byte, p peer.ID) error {
+	env, rec, err := record.ConsumeEnvelope(spr, peer.PeerRecordEnvelopeDomain)
+	if err != nil {
+		return err
+	}
+	signer, err := peer.IDFromPublicKey(env.PublicKey)
+	if err != nil {
+		return err
+	}
+	if signer != p {
+		return errors.New("record not signed by the registering peer")
+	}
+	prec, ok := rec.(*peer.PeerRecord)
+	if !ok {
+		return errors.New("not a peer record")
+	}
+	if prec.PeerID != p {
+		return errors.New("record for a different peer")
+	}
+	return nil
+}
+
+func (s *Server) handleUnregister(p peer.ID, req *pb.Message_Unregister) {
+	ns := req.GetNs()
+	if ns == "" || !validNamespace(ns) {
+		return
+	}
+	ctx, cancel := context.WithTimeout(s.ctx, streamTimeout)
+	defer cancel()
+	if err := s.store.Unregister(ctx, ns, p); err != nil {
+		log.Errorf("failed to unregister %s from %s: %s", p, ns, err)
+		return
+	}
+	log.Debugf("unregistered %s from %s", p, ns)
+}
+
+func (s *Server) handleDiscover(req *pb.Message_Discover) *pb.Message {
+	ns := req.GetNs()
+	if !validNamespace(ns) {
+		return newDiscoverErrorResponse(pb.Message_E_INVALID_NAMESPACE, "invalid namespace")
+	}
+	limit := maxDiscoverLimit
+	if l := req.GetLimit(); l > 0 && l < maxDiscoverLimit {
+		limit = int(l)
+	}
+
+	ctx, cancel := context.WithTimeout(s.ctx, streamTimeout)
+	defer cancel()
+	regs, cookie, err := s.store.Discover(ctx, ns, req.GetCookie(), limit)
+	if err != nil {
+		if errors.Is(err, ErrInvalidCookie) {
+			return newDiscoverErrorResponse(pb.Message_E_INVALID_COOKIE, "invalid cookie")
+		}
+		log.Errorf("failed to discover registrations in %s: %s", ns, err)
+		return newDiscoverErrorResponse(pb.Message_E_INTERNAL_ERROR, "internal error")
+	}
+
+	now := s.now()
+	pregs := make([]*pb.Message_Register, 0, len(regs))
+	for _, r := range regs {
+		rns := r.Namespace
+		pregs = append(pregs, &pb.Message_Register{
+			Ns:               &rns,
+			SignedPeerRecord: r.SignedPeerRecord,
+			Ttl:              uint64Ptr(uint64(r.Expiry.Sub(now) / time.Second)),
+		})
+	}
+	return &pb.Message{
+		Type: pb.Message_DISCOVER_RESPONSE.Enum(),
+		DiscoverResponse: &pb.Message_DiscoverResponse{
+			Registrations: pregs,
+			Cookie:        cookie,
+			Status:        pb.Message_OK.Enum(),
+		},
+	}
+}
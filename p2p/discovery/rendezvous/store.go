@@ -0,0 +1,70 @@
+package rendezvous
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+var (
+	// ErrInvalidCookie is returned by Store.Discover if the cookie wasn't
+	// issued for the namespace.
+	ErrInvalidCookie = errors.New("invalid cookie")
+	// ErrTooManyRegistrations is returned by Store.Register if registering
+	// the peer would exceed the namespace or peer limits.
+	ErrTooManyRegistrations = errors.New("too many registrations")
+)
+
+// Registration is the registration of a peer in a namespace.
+type Registration struct {
+	Namespace string
+	Peer      peer.ID
+	// SignedPeerRecord is the marshaled envelope containing the peer record.
+	SignedPeerRecord []byte
+	Expiry           time.Time
+}
+
+// Store stores the registrations of a rendezvous server.
+//
+// Registrations are ordered by the time they were last registered. Cookies
+// point into this order, so that discovering with a cookie only returns
+// registrations that were added or refreshed since the cookie was issued.
+// Implementations must never return expired registrations.
+type Store interface {
+	// Register adds the registration, replacing any existing registration of
+	// the peer in the namespace. If the peer isn't registered in the
+	// namespace yet, it fails with ErrTooManyRegistrations if the namespace
+	// already holds maxNamespaceRegs registrations, or if the peer is already
+	// registered in maxPeerRegs namespaces.
+	Register(ctx context.Context, reg Registration, maxNamespaceRegs, maxPeerRegs int) error
+	// Unregister removes the registration of the peer in the namespace.
+	Unregister(ctx context.Context, ns string, p peer.ID) error
+	// Discover returns up to limit registrations in the namespace, following
+	// the registrations returned for the cookie. A nil cookie starts from the
+	// beginning. An empty namespace returns registrations of all namespaces.
+	// The returned cookie continues from the last returned registration.
+	Discover(ctx context.Context, ns string, cookie []byte, limit int) ([]Registration, []byte, error)
+	// GC removes all expired registrations.
+	GC(ctx context.Context) error
+}
+
+// A cookie is the counter of the last returned registration followed by the
+// namespace it was issued for.
+func encodeCookie(counter uint64, ns string) []byte {
+	b := make([]byte, 8, 8+len(ns))
+	binary.BigEndian.PutUint64(b, counter)
+	return append(b, ns...)
+}
+
+func decodeCookie(cookie []byte, ns string) (uint64, error) {
+	if cookie == nil {
+		return 0, nil
+	}
+	if len(cookie) < 8 || string(cookie[8:]) != ns {
+		return 0, ErrInvalidCookie
+	}
+	return binary.BigEndian.Uint64(cookie), nil
+}
@@ -0,0 +1,304 @@
+package rendezvous
+
+import (
+	"context"
+	"encoding/base32"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+
+	"github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-datastore/namespace"
+	"github.com/ipfs/go-datastore/query"
+	"golang.org/x/exp/slices"
+)
+
+const (
+	dsNamespace  = "/libp2p/rendezvous"
+	dsRegPrefix  = "/reg"
+	dsPeerPrefix = "/peer"
+)
+
+var dsCounterKey = datastore.NewKey("/counter")
+
+// Namespaces can contain arbitrary characters, including the datastore key
+// separator. Peer IDs are encoded the same way to keep the keys uniform.
+var keyEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// DatastoreStore is a Store that persists the registrations in a datastore.
+//
+// Registrations are stored under /libp2p/rendezvous/reg/<namespace>/<peer>,
+// with an index of the namespaces of a peer under
+// /libp2p/rendezvous/peer/<peer>/<namespace>.
+type DatastoreStore struct {
+	ds datastore.Datastore
+
+	// mx serializes writes, so that the counter and the index stay consistent
+	mx sync.Mutex
+
+	now func() time.Time
+}
+
+var _ Store = &DatastoreStore{}
+
+func NewDatastoreStore(ds datastore.Datastore) *DatastoreStore {
+	return &DatastoreStore{
+		ds:  namespace.Wrap(ds, datastore.NewKey(dsNamespace)),
+		now: time.Now,
+	}
+}
+
+type dsRegistration struct {
+	Registration
+	counter uint64
+}
+
+func nsKey(ns string) datastore.Key {
+	return datastore.NewKey(dsRegPrefix).ChildString(keyEncoding.EncodeToString([]byte(ns)))
+}
+
+func regKey(ns string, p peer.ID) datastore.Key {
+	return nsKey(ns).ChildString(keyEncoding.EncodeToString([]byte(p)))
+}
+
+func peerNamespacesKey(p peer.ID) datastore.Key {
+	return datastore.NewKey(dsPeerPrefix).ChildString(keyEncoding.EncodeToString([]byte(p)))
+}
+
+func peerKey(p peer.ID, ns string) datastore.Key {
+	return peerNamespacesKey(p).ChildString(keyEncoding.EncodeToString([]byte(ns)))
+}
+
+func encodeRegistration(r *dsRegistration) []byte {
+	b := make([]byte, 16, 16+len(r.SignedPeerRecord))
+	binary.BigEndian.PutUint64(b, r.counter)
+	binary.BigEndian.PutUint64(b[8:], uint64(r.Expiry.UnixNano()))
+	return append(b, r.SignedPeerRecord...)
+}
+
+func decodeRegistration(key datastore.Key, b []byte) (*dsRegistration, error) {
+	if len(b) < 16 {
+		return nil, errors.New("registration too short")
+	}
+	nss := key.Namespaces()
+	if len(nss) != 3 {
+		return nil, fmt.Errorf("invalid registration key: %s", key)
+	}
+	ns, err := keyEncoding.DecodeString(nss[1])
+	if err != nil {
+		return nil, err
+	}
+	p, err := keyEncoding.DecodeString(nss[2])
+	if err != nil {
+		return nil, err
+	}
+	return &dsRegistration{
+		Registration: Registration{
+			Namespace:        string(ns),
+			Peer:             peer.ID(p),
+			SignedPeerRecord: b[16:],
+			Expiry:           time.Unix(0, int64(binary.BigEndian.Uint64(b[8:]))),
+		},
+		counter: binary.BigEndian.Uint64(b),
+	}, nil
+}
+
+func (s *DatastoreStore) get(ctx context.Context, ns string, p peer.ID) (*dsRegistration, error) {
+	key := regKey(ns, p)
+	b, err := s.ds.Get(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	return decodeRegistration(key, b)
+}
+
+// query returns all registrations under prefix, removing the expired ones.
+func (s *DatastoreStore) query(ctx context.Context, prefix string) ([]*dsRegistration, error) {
+	res, err := s.ds.Query(ctx, query.Query{Prefix: prefix})
+	if err != nil {
+		return nil, err
+	}
+	entries, err := res.Rest()
+	if err != nil {
+		return nil, err
+	}
+
+	now := s.now()
+	var regs []*dsRegistration
+	for _, r := range entries {
+		reg, err := decodeRegistration(datastore.RawKey(r.Key), r.Value)
+		if err != nil {
+			log.Debugw("removing invalid registration", "key", r.Key, "error", err)
+			if err := s.ds.Delete(ctx, datastore.RawKey(r.Key)); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if !reg.Expiry.After(now) {
+			if err := s.delete(ctx, reg.Namespace, reg.Peer); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		regs = append(regs, reg)
+	}
+	return regs, nil
+}
+
+func (s *DatastoreStore) delete(ctx context.Context, ns string, p peer.ID) error {
+	if err := s.ds.Delete(ctx, regKey(ns, p)); err != nil {
+		return err
+	}
+	return s.ds.Delete(ctx, peerKey(p, ns))
+}
+
+func (s *DatastoreStore) countPeer(ctx context.Context, p peer.ID) (int, error) {
+	res, err := s.ds.Query(ctx, query.Query{
+		Prefix:   peerNamespacesKey(p).String(),
+		KeysOnly: true,
+	})
+	if err != nil {
+		return 0, err
+	}
+	entries, err := res.Rest()
+	if err != nil {
+		return 0, err
+	}
+
+	now := s.now()
+	var count int
+	for _, e := range entries {
+		nsb, err := keyEncoding.DecodeString(datastore.RawKey(e.Key).BaseNamespace())
+		if err != nil {
+			if err := s.ds.Delete(ctx, datastore.RawKey(e.Key)); err != nil {
+				return 0, err
+			}
+			continue
+		}
+		ns := string(nsb)
+		reg, err := s.get(ctx, ns, p)
+		if err != nil && !errors.Is(err, datastore.ErrNotFound) {
+			return 0, err
+		}
+		if reg == nil || !reg.Expiry.After(now) {
+			if err := s.delete(ctx, ns, p); err != nil {
+				return 0, err
+			}
+			continue
+		}
+		count++
+	}
+	return count, nil
+}
+
+func (s *DatastoreStore) nextCounter(ctx context.Context) (uint64, error) {
+	var counter uint64
+	b, err := s.ds.Get(ctx, dsCounterKey)
+	switch {
+	case err == nil:
+		if len(b) != 8 {
+			return 0, errors.New("invalid counter")
+		}
+		counter = binary.BigEndian.Uint64(b)
+	case !errors.Is(err, datastore.ErrNotFound):
+		return 0, err
+	}
+	counter++
+	b = binary.BigEndian.AppendUint64(nil, counter)
+	if err := s.ds.Put(ctx, dsCounterKey, b); err != nil {
+		return 0, err
+	}
+	return counter, nil
+}
+
+func (s *DatastoreStore) Register(ctx context.Context, reg Registration, maxNamespaceRegs, maxPeerRegs int) error {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	existing, err := s.get(ctx, reg.Namespace, reg.Peer)
+	if err != nil && !errors.Is(err, datastore.ErrNotFound) {
+		return err
+	}
+	if existing == nil || !existing.Expiry.After(s.now()) {
+		nsRegs, err := s.query(ctx, nsKey(reg.Namespace).String())
+		if err != nil {
+			return err
+		}
+		if len(nsRegs) >= maxNamespaceRegs {
+			return ErrTooManyRegistrations
+		}
+		peerRegs, err := s.countPeer(ctx, reg.Peer)
+		if err != nil {
+			return err
+		}
+		if peerRegs >= maxPeerRegs {
+			return ErrTooManyRegistrations
+		}
+	}
+
+	counter, err := s.nextCounter(ctx)
+	if err != nil {
+		return err
+	}
+	if err := s.ds.Put(ctx, regKey(reg.Namespace, reg.Peer), encodeRegistration(&dsRegistration{Registration: reg, counter: counter})); err != nil {
+		return err
+	}
+	if err := s.ds.Put(ctx, peerKey(reg.Peer, reg.Namespace), nil); err != nil {
+		return err
+	}
+	return s.ds.Sync(ctx, datastore.NewKey(""))
+}
+
+func (s *DatastoreStore) Unregister(ctx context.Context, ns string, p peer.ID) error {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+	return s.delete(ctx, ns, p)
+}
+
+func (s *DatastoreStore) Discover(ctx context.Context, ns string, cookie []byte, limit int) ([]Registration, []byte, error) {
+	after, err := decodeCookie(cookie, ns)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	prefix := dsRegPrefix
+	if ns != "" {
+		prefix = nsKey(ns).String()
+	}
+	s.mx.Lock()
+	regs, err := s.query(ctx, prefix)
+	s.mx.Unlock()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	regs = slices.DeleteFunc(regs, func(r *dsRegistration) bool { return r.counter <= after })
+	slices.SortFunc(regs, func(a, b *dsRegistration) int {
+		if a.counter < b.counter {
+			return -1
+		}
+		return 1
+	})
+	if len(regs) > limit {
+		regs = regs[:limit]
+	}
+	res := make([]Registration, 0, len(regs))
+	for _, r := range regs {
+		res = append(res, r.Registration)
+		after = r.counter
+	}
+	return res, encodeCookie(after, ns), nil
+}
+
+func (s *DatastoreStore) GC(ctx context.Context) error {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+	if _, err := s.query(ctx, dsRegPrefix); err != nil {
+		return err
+	}
+	return s.ds.Sync(ctx, datastore.NewKey(""))
+}
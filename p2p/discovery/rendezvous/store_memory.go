@@ -0,0 +1,156 @@
+package rendezvous
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+
+	"golang.org/x/exp/slices"
+)
+
+type memoryRegistration struct {
+	Registration
+	counter uint64
+}
+
+// MemoryStore is a Store that keeps the registrations in memory.
+type MemoryStore struct {
+	mx      sync.Mutex
+	counter uint64
+	// namespace -> peer -> registration
+	regs map[string]map[peer.ID]*memoryRegistration
+	// peer -> namespaces
+	peers map[peer.ID]map[string]struct{}
+
+	now func() time.Time
+}
+
+var _ Store = &MemoryStore{}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		regs:  make(map[string]map[peer.ID]*memoryRegistration),
+		peers: make(map[peer.ID]map[string]struct{}),
+		now:   time.Now,
+	}
+}
+
+func (s *MemoryStore) Register(_ context.Context, reg Registration, maxNamespaceRegs, maxPeerRegs int) error {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	now := s.now()
+	nsRegs := s.regs[reg.Namespace]
+	if existing, ok := nsRegs[reg.Peer]; !ok || !existing.Expiry.After(now) {
+		if s.countNamespace(reg.Namespace, now) >= maxNamespaceRegs || s.countPeer(reg.Peer, now) >= maxPeerRegs {
+			return ErrTooManyRegistrations
+		}
+	}
+
+	if nsRegs == nil {
+		nsRegs = make(map[peer.ID]*memoryRegistration)
+		s.regs[reg.Namespace] = nsRegs
+	}
+	s.counter++
+	nsRegs[reg.Peer] = &memoryRegistration{Registration: reg, counter: s.counter}
+	if s.peers[reg.Peer] == nil {
+		s.peers[reg.Peer] = make(map[string]struct{})
+	}
+	s.peers[reg.Peer][reg.Namespace] = struct{}{}
+	return nil
+}
+
+// countNamespace returns the number of registrations in ns, removing the
+// expired ones.
+func (s *MemoryStore) countNamespace(ns string, now time.Time) int {
+	for p, r := range s.regs[ns] {
+		if !r.Expiry.After(now) {
+			s.remove(ns, p)
+		}
+	}
+	return len(s.regs[ns])
+}
+
+// countPeer returns the number of namespaces p is registered in, removing the
+// expired registrations.
+func (s *MemoryStore) countPeer(p peer.ID, now time.Time) int {
+	for ns := range s.peers[p] {
+		if !s.regs[ns][p].Expiry.After(now) {
+			s.remove(ns, p)
+		}
+	}
+	return len(s.peers[p])
+}
+
+func (s *MemoryStore) remove(ns string, p peer.ID) {
+	delete(s.regs[ns], p)
+	if len(s.regs[ns]) == 0 {
+		delete(s.regs, ns)
+	}
+	delete(s.peers[p], ns)
+	if len(s.peers[p]) == 0 {
+		delete(s.peers, p)
+	}
+}
+
+func (s *MemoryStore) Unregister(_ context.Context, ns string, p peer.ID) error {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+	s.remove(ns, p)
+	return nil
+}
+
+func (s *MemoryStore) Discover(_ context.Context, ns string, cookie []byte, limit int) ([]Registration, []byte, error) {
+	after, err := decodeCookie(cookie, ns)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	now := s.now()
+	var regs []*memoryRegistration
+	collect := func(nsRegs map[peer.ID]*memoryRegistration) {
+		for _, r := range nsRegs {
+			if r.counter > after && r.Expiry.After(now) {
+				regs = append(regs, r)
+			}
+		}
+	}
+	if ns == "" {
+		for _, nsRegs := range s.regs {
+			collect(nsRegs)
+		}
+	} else {
+		collect(s.regs[ns])
+	}
+
+	slices.SortFunc(regs, func(a, b *memoryRegistration) int {
+		if a.counter < b.counter {
+			return -1
+		}
+		return 1
+	})
+	if len(regs) > limit {
+		regs = regs[:limit]
+	}
+	res := make([]Registration, 0, len(regs))
+	for _, r := range regs {
+		res = append(res, r.Registration)
+		after = r.counter
+	}
+	return res, encodeCookie(after, ns), nil
+}
+
+func (s *MemoryStore) GC(context.Context) error {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+	now := s.now()
+	for ns := range s.regs {
+		s.countNamespace(ns, now)
+	}
+	return nil
+}
@@ -0,0 +1,130 @@
+package rendezvous
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+
+	"github.com/ipfs/go-datastore"
+	dssync "github.com/ipfs/go-datastore/sync"
+	"github.com/stretchr/testify/require"
+)
+
+func testStores(t *testing.T, f func(t *testing.T, s Store, setNow func(time.Time))) {
+	t.Run("memory", func(t *testing.T) {
+		s := NewMemoryStore()
+		f(t, s, func(now time.Time) { s.now = func() time.Time { return now } })
+	})
+	t.Run("datastore", func(t *testing.T) {
+		s := NewDatastoreStore(dssync.MutexWrap(datastore.NewMapDatastore()))
+		f(t, s, func(now time.Time) { s.now = func() time.Time { return now } })
+	})
+}
+
+func newRegistration(ns string, i int, expiry time.Time) Registration {
+	return Registration{
+		Namespace:        ns,
+		Peer:             peer.ID(fmt.Sprintf("peer-%d", i)),
+		SignedPeerRecord: []byte(fmt.Sprintf("record-%d", i)),
+		Expiry:           expiry,
+	}
+}
+
+func TestStorePagination(t *testing.T) {
+	testStores(t, func(t *testing.T, s Store, setNow func(time.Time)) {
+		ctx := context.Background()
+		now := time.Now()
+		setNow(now)
+		for i := 0; i < 5; i++ {
+			require.NoError(t, s.Register(ctx, newRegistration("foo", i, now.Add(time.Hour)), 100, 100))
+		}
+		require.NoError(t, s.Register(ctx, newRegistration("bar", 5, now.Add(time.Hour)), 100, 100))
+
+		regs, cookie, err := s.Discover(ctx, "foo", nil, 3)
+		require.NoError(t, err)
+		require.Len(t, regs, 3)
+		for i, r := range regs {
+			require.Equal(t, newRegistration("foo", i, now.Add(time.Hour)).Peer, r.Peer)
+			require.Equal(t, fmt.Sprintf("record-%d", i), string(r.SignedPeerRecord))
+		}
+
+		regs, cookie, err = s.Discover(ctx, "foo", cookie, 3)
+		require.NoError(t, err)
+		require.Len(t, regs, 2)
+		regs, cookie, err = s.Discover(ctx, "foo", cookie, 3)
+		require.NoError(t, err)
+		require.Empty(t, regs)
+
+		// refreshing a registration makes it show up again
+		require.NoError(t, s.Register(ctx, newRegistration("foo", 1, now.Add(2*time.Hour)), 100, 100))
+		regs, _, err = s.Discover(ctx, "foo", cookie, 3)
+		require.NoError(t, err)
+		require.Len(t, regs, 1)
+		require.Equal(t, peer.ID("peer-1"), regs[0].Peer)
+
+		// cookies are bound to the namespace
+		_, _, err = s.Discover(ctx, "bar", cookie, 3)
+		require.ErrorIs(t, err, ErrInvalidCookie)
+
+		// an empty namespace returns all registrations
+		regs, _, err = s.Discover(ctx, "", nil, 100)
+		require.NoError(t, err)
+		require.Len(t, regs, 6)
+
+		require.NoError(t, s.Unregister(ctx, "foo", "peer-0"))
+		regs, _, err = s.Discover(ctx, "foo", nil, 100)
+		require.NoError(t, err)
+		require.Len(t, regs, 4)
+	})
+}
+
+func TestStoreExpiry(t *testing.T) {
+	testStores(t, func(t *testing.T, s Store, setNow func(time.Time)) {
+		ctx := context.Background()
+		now := time.Now()
+		setNow(now)
+		require.NoError(t, s.Register(ctx, newRegistration("foo", 0, now.Add(time.Minute)), 100, 100))
+		require.NoError(t, s.Register(ctx, newRegistration("foo", 1, now.Add(time.Hour)), 100, 100))
+
+		setNow(now.Add(2 * time.Minute))
+		regs, _, err := s.Discover(ctx, "foo", nil, 100)
+		require.NoError(t, err)
+		require.Len(t, regs, 1)
+		require.Equal(t, peer.ID("peer-1"), regs[0].Peer)
+
+		setNow(now.Add(2 * time.Hour))
+		require.NoError(t, s.GC(ctx))
+		regs, _, err = s.Discover(ctx, "", nil, 100)
+		require.NoError(t, err)
+		require.Empty(t, regs)
+	})
+}
+
+func TestStoreLimits(t *testing.T) {
+	testStores(t, func(t *testing.T, s Store, setNow func(time.Time)) {
+		ctx := context.Background()
+		now := time.Now()
+		setNow(now)
+		expiry := now.Add(time.Hour)
+
+		// namespace limit
+		require.NoError(t, s.Register(ctx, newRegistration("foo", 0, expiry), 2, 100))
+		require.NoError(t, s.Register(ctx, newRegistration("foo", 1, expiry), 2, 100))
+		require.ErrorIs(t, s.Register(ctx, newRegistration("foo", 2, expiry), 2, 100), ErrTooManyRegistrations)
+		// refreshing an existing registration is always possible
+		require.NoError(t, s.Register(ctx, newRegistration("foo", 1, expiry), 2, 100))
+
+		// peer limit
+		require.NoError(t, s.Register(ctx, newRegistration("bar", 0, expiry), 100, 2))
+		require.ErrorIs(t, s.Register(ctx, newRegistration("baz", 0, expiry), 100, 2), ErrTooManyRegistrations)
+		require.NoError(t, s.Unregister(ctx, "bar", "peer-0"))
+		require.NoError(t, s.Register(ctx, newRegistration("baz", 0, expiry), 100, 2))
+
+		// expired registrations don't count towards the limits
+		setNow(expiry)
+		require.NoError(t, s.Register(ctx, newRegistration("foo", 2, expiry.Add(time.Hour)), 2, 100))
+	})
+}
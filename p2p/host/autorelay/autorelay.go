@@ -13,6 +13,8 @@ import (
 
 	logging "github.com/ipfs/go-log/v2"
 	ma "github.com/multiformats/go-multiaddr"
+	manet "github.com/multiformats/go-multiaddr/net"
+	"golang.org/x/exp/slices"
 )
 
 var log = logging.Logger("autorelay")
@@ -26,6 +28,9 @@ type AutoRelay struct {
 
 	mx     sync.Mutex
 	status network.Reachability
+	// reachableAddrs are the addresses that AutoNAT v2 confirmed to be
+	// reachable. They are advertised even when we're using relays.
+	reachableAddrs []ma.Multiaddr
 
 	relayFinder *relayFinder
 
@@ -65,44 +70,56 @@ func (r *AutoRelay) Start() {
 }
 
 func (r *AutoRelay) background() {
-	subReachability, err := r.host.EventBus().Subscribe(new(event.EvtLocalReachabilityChanged), eventbus.Name("autorelay (background)"))
+	sub, err := r.host.EventBus().Subscribe(
+		[]interface{}{new(event.EvtLocalReachabilityChanged), new(event.EvtHostReachableAddrsChanged)},
+		eventbus.Name("autorelay (background)"))
 	if err != nil {
-		log.Debug("failed to subscribe to the EvtLocalReachabilityChanged")
+		log.Debug("failed to subscribe to reachability events")
 		return
 	}
-	defer subReachability.Close()
+	defer sub.Close()
 
 	for {
 		select {
 		case <-r.ctx.Done():
 			return
-		case ev, ok := <-subReachability.Out():
+		case ev, ok := <-sub.Out():
 			if !ok {
 				return
 			}
-			// TODO: push changed addresses
-			evt := ev.(event.EvtLocalReachabilityChanged)
-			switch evt.Reachability {
-			case network.ReachabilityPrivate, network.ReachabilityUnknown:
-				err := r.relayFinder.Start()
-				if errors.Is(err, errAlreadyRunning) {
-					log.Debug("tried to start already running relay finder")
-				} else if err != nil {
-					log.Errorw("failed to start relay finder", "error", err)
-				} else {
-					r.metricsTracer.RelayFinderStatus(true)
-				}
-			case network.ReachabilityPublic:
-				r.relayFinder.Stop()
-				r.metricsTracer.RelayFinderStatus(false)
+			switch evt := ev.(type) {
+			case event.EvtLocalReachabilityChanged:
+				r.handleReachabilityChanged(evt)
+			case event.EvtHostReachableAddrsChanged:
+				r.mx.Lock()
+				r.reachableAddrs = evt.Reachable
+				r.mx.Unlock()
 			}
-			r.mx.Lock()
-			r.status = evt.Reachability
-			r.mx.Unlock()
 		}
 	}
 }
 
+func (r *AutoRelay) handleReachabilityChanged(evt event.EvtLocalReachabilityChanged) {
+	// TODO: push changed addresses
+	switch evt.Reachability {
+	case network.ReachabilityPrivate, network.ReachabilityUnknown:
+		err := r.relayFinder.Start()
+		if errors.Is(err, errAlreadyRunning) {
+			log.Debug("tried to start already running relay finder")
+		} else if err != nil {
+			log.Errorw("failed to start relay finder", "error", err)
+		} else {
+			r.metricsTracer.RelayFinderStatus(true)
+		}
+	case network.ReachabilityPublic:
+		r.relayFinder.Stop()
+		r.metricsTracer.RelayFinderStatus(false)
+	}
+	r.mx.Lock()
+	r.status = evt.Reachability
+	r.mx.Unlock()
+}
+
 func (r *AutoRelay) hostAddrs(addrs []ma.Multiaddr) []ma.Multiaddr {
 	return r.relayAddrs(r.addrsF(addrs))
 }
@@ -114,7 +131,21 @@ func (r *AutoRelay) relayAddrs(addrs []ma.Multiaddr) []ma.Multiaddr {
 	if r.status != network.ReachabilityPrivate {
 		return addrs
 	}
-	return r.relayFinder.relayAddrs(addrs)
+	raddrs := r.relayFinder.relayAddrs(addrs)
+	if len(r.reachableAddrs) == 0 {
+		return raddrs
+	}
+
+	// Keep the public addresses that were confirmed to be reachable.
+	// Don't append to raddrs directly, it's cached by the relay finder.
+	res := make([]ma.Multiaddr, 0, len(raddrs)+len(r.reachableAddrs))
+	res = append(res, raddrs...)
+	for _, a := range addrs {
+		if manet.IsPublicAddr(a) && slices.ContainsFunc(r.reachableAddrs, a.Equal) {
+			res = append(res, a)
+		}
+	}
+	return res
 }
 
 func (r *AutoRelay) Close() error {
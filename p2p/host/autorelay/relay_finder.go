@@ -85,6 +85,9 @@ type relayFinder struct {
 
 var errAlreadyRunning = errors.New("relayFinder already running")
 
+// webrtcAddr is the listen address of the private-to-private WebRTC transport.
+var webrtcAddr = ma.StringCast("/webrtc")
+
 func newRelayFinder(host *basic.BasicHost, peerSource PeerSource, conf *config) *relayFinder {
 	if peerSource == nil {
 		panic("Can not create a new relayFinder. Need a Peer Source fn or a list of static relays. Refer to the documentation around `libp2p.EnableAutoRelay`")
@@ -725,10 +728,14 @@ func (rf *relayFinder) relayAddrs(addrs []ma.Multiaddr) []ma.Multiaddr {
 	raddrs := make([]ma.Multiaddr, 0, 4*len(rf.relays)+4)
 
 	// only keep private addrs from the original addr set
+	var listensOnWebRTC bool
 	for _, addr := range addrs {
 		if manet.IsPrivateAddr(addr) {
 			raddrs = append(raddrs, addr)
 		}
+		if addr.Equal(webrtcAddr) {
+			listensOnWebRTC = true
+		}
 	}
 
 	// add relay specific addrs to the list
@@ -740,6 +747,10 @@ func (rf *relayFinder) relayAddrs(addrs []ma.Multiaddr) []ma.Multiaddr {
 		for _, addr := range addrs {
 			pub := addr.Encapsulate(circuit)
 			raddrs = append(raddrs, pub)
+			// private-to-private WebRTC uses the relay for signaling
+			if listensOnWebRTC {
+				raddrs = append(raddrs, pub.Encapsulate(webrtcAddr))
+			}
 		}
 	}
 
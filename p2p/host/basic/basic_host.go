@@ -23,6 +23,7 @@ import (
 	"github.com/libp2p/go-libp2p/p2p/host/eventbus"
 	"github.com/libp2p/go-libp2p/p2p/host/pstoremanager"
 	"github.com/libp2p/go-libp2p/p2p/host/relaysvc"
+	"github.com/libp2p/go-libp2p/p2p/protocol/autonatv2"
 	relayv2 "github.com/libp2p/go-libp2p/p2p/protocol/circuitv2/relay"
 	"github.com/libp2p/go-libp2p/p2p/protocol/holepunch"
 	"github.com/libp2p/go-libp2p/p2p/protocol/identify"
@@ -92,6 +93,10 @@ type BasicHost struct {
 
 	addrChangeChan chan struct{}
 
+	// transports whose certificate hashes are watched, see watchCertHashes
+	certHashWatchMu sync.Mutex
+	certHashWatched map[transport.Transport]struct{}
+
 	addrMu                 sync.RWMutex
 	filteredInterfaceAddrs []ma.Multiaddr
 	allInterfaceAddrs      []ma.Multiaddr
@@ -101,6 +106,8 @@ type BasicHost struct {
 	caBook                  peerstore.CertifiedAddrBook
 
 	autoNat autonat.AutoNAT
+
+	autonatv2 *autonatv2.AutoNAT
 }
 
 var _ host.Host = (*BasicHost)(nil)
@@ -160,6 +167,12 @@ type HostOpts struct {
 	EnableMetrics bool
 	// PrometheusRegisterer is the PrometheusRegisterer used for metrics
 	PrometheusRegisterer prometheus.Registerer
+
+	// EnableAutoNATv2 enables the AutoNAT v2 client and server.
+	EnableAutoNATv2 bool
+	// AutoNATv2Dialer is the host used by the AutoNAT v2 server to dial back
+	// peers. It must have a different identity than this host.
+	AutoNATv2Dialer host.Host
 }
 
 // NewHost constructs a new *BasicHost and activates it by attaching its stream and connection handlers to the given inet.Network.
@@ -274,6 +287,22 @@ func NewHost(n network.Network, opts *HostOpts) (*BasicHost, error) {
 		h.natmgr = opts.NATManager(n)
 	}
 
+	if opts.EnableAutoNATv2 {
+		if opts.AutoNATv2Dialer == nil {
+			return nil, errors.New("autonat v2 requires a dialer host")
+		}
+		// Use the addresses before they're modified by autorelay, which
+		// drops public addresses when we're not reachable.
+		addrsFactory := h.AddrsFactory
+		h.autonatv2, err = autonatv2.New(h, opts.AutoNATv2Dialer,
+			autonatv2.UsingAddresses(func() []ma.Multiaddr {
+				return addrsFactory(h.AllAddrs())
+			}))
+		if err != nil {
+			return nil, fmt.Errorf("failed to create autonatv2: %w", err)
+		}
+	}
+
 	if opts.MultiaddrResolver != nil {
 		h.maResolver = opts.MultiaddrResolver
 	}
@@ -304,7 +333,8 @@ func NewHost(n network.Network, opts *HostOpts) (*BasicHost, error) {
 
 	// register to be notified when the network's listen addrs change,
 	// so we can update our address set and push events if needed
-	listenHandler := func(network.Network, ma.Multiaddr) {
+	listenHandler := func(_ network.Network, a ma.Multiaddr) {
+		h.watchCertHashes(a)
 		h.SignalAddressChange()
 	}
 	n.Notify(&network.NotifyBundle{
@@ -388,6 +418,11 @@ func (h *BasicHost) Start() {
 	h.psManager.Start()
 	h.refCount.Add(1)
 	h.ids.Start()
+	if h.autonatv2 != nil {
+		if err := h.autonatv2.Start(); err != nil {
+			log.Errorf("autonat v2 failed to start: %s", err)
+		}
+	}
 	go h.background()
 }
 
@@ -416,7 +451,7 @@ func (h *BasicHost) newStreamHandler(s network.Stream) {
 		} else {
 			log.Debugf("protocol mux failed: %s (took %s)", err, took)
 		}
-		s.Reset()
+		s.ResetWithError(network.StreamProtocolNegotiationFailed)
 		return
 	}
 
@@ -430,7 +465,7 @@ func (h *BasicHost) newStreamHandler(s network.Stream) {
 
 	if err := s.SetProtocol(protoID); err != nil {
 		log.Debugf("error setting stream protocol: %s", err)
-		s.Reset()
+		s.ResetWithError(network.StreamResourceLimitExceeded)
 		return
 	}
 
@@ -449,6 +484,53 @@ func (h *BasicHost) SignalAddressChange() {
 	}
 }
 
+// watchCertHashes starts watching the certificate hashes of the transport
+// listening on a, if it adds certificate hashes to its addresses, so that the
+// addresses are updated when the certificates are rotated.
+func (h *BasicHost) watchCertHashes(a ma.Multiaddr) {
+	type listenTransportLookup interface {
+		TransportForListening(a ma.Multiaddr) transport.Transport
+	}
+	type certHashNotifier interface {
+		CertHashesChanged() <-chan struct{}
+	}
+
+	s, ok := h.Network().(listenTransportLookup)
+	if !ok {
+		return
+	}
+	// The listen addresses of the transports that add certificate hashes
+	// already contain them, and the transports aren't registered for them.
+	t := s.TransportForListening(h.NormalizeMultiaddr(a))
+	n, ok := t.(certHashNotifier)
+	if !ok {
+		return
+	}
+
+	h.certHashWatchMu.Lock()
+	defer h.certHashWatchMu.Unlock()
+	if _, ok := h.certHashWatched[t]; ok {
+		return
+	}
+	if h.certHashWatched == nil {
+		h.certHashWatched = make(map[transport.Transport]struct{})
+	}
+	h.certHashWatched[t] = struct{}{}
+
+	h.refCount.Add(1)
+	go func() {
+		defer h.refCount.Done()
+		for {
+			select {
+			case <-n.CertHashesChanged():
+				h.SignalAddressChange()
+			case <-h.ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
 func makeUpdatedAddrEvent(prev, current []ma.Multiaddr) *event.EvtLocalAddressesUpdated {
 	prevmap := make(map[string]ma.Multiaddr, len(prev))
 	evt := event.EvtLocalAddressesUpdated{Diffs: true}
@@ -685,7 +767,7 @@ func (h *BasicHost) NewStream(ctx context.Context, p peer.ID, pids ...protocol.I
 	select {
 	case err = <-errCh:
 		if err != nil {
-			s.Reset()
+			s.ResetWithError(network.StreamProtocolNegotiationFailed)
 			return nil, fmt.Errorf("failed to negotiate protocol: %w", err)
 		}
 	case <-ctx.Done():
@@ -1020,6 +1102,9 @@ func (h *BasicHost) Close() error {
 		if h.autoNat != nil {
 			h.autoNat.Close()
 		}
+		if h.autonatv2 != nil {
+			h.autonatv2.Close()
+		}
 		if h.relayManager != nil {
 			h.relayManager.Close()
 		}
@@ -2,8 +2,15 @@ package basichost
 
 import (
 	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
 	"fmt"
 	"io"
+	"math/big"
 	"reflect"
 	"sort"
 	"strings"
@@ -11,6 +18,7 @@ import (
 	"testing"
 	"time"
 
+	"github.com/libp2p/go-libp2p/core/crypto"
 	"github.com/libp2p/go-libp2p/core/event"
 	"github.com/libp2p/go-libp2p/core/host"
 	"github.com/libp2p/go-libp2p/core/network"
@@ -22,8 +30,13 @@ import (
 	"github.com/libp2p/go-libp2p/p2p/host/eventbus"
 	swarmt "github.com/libp2p/go-libp2p/p2p/net/swarm/testing"
 	"github.com/libp2p/go-libp2p/p2p/protocol/identify"
+	"github.com/libp2p/go-libp2p/p2p/transport/quicreuse"
+	libp2pwebtransport "github.com/libp2p/go-libp2p/p2p/transport/webtransport"
 
 	ma "github.com/multiformats/go-multiaddr"
+	"github.com/multiformats/go-multibase"
+	"github.com/multiformats/go-multihash"
+	"github.com/quic-go/quic-go"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -593,6 +606,121 @@ func TestAddrChangeImmediatelyIfAddressNonEmpty(t *testing.T) {
 	require.Equal(t, taddrs, rc.Addrs)
 }
 
+// rotatingCertProvider is a WebTransport certificate provider whose
+// certificate is replaced by rotate.
+type rotatingCertProvider struct {
+	t       *testing.T
+	changed chan struct{}
+
+	mx   sync.Mutex
+	cert *tls.Certificate
+}
+
+var _ libp2pwebtransport.CertificateNotifier = &rotatingCertProvider{}
+
+func newRotatingCertProvider(t *testing.T) *rotatingCertProvider {
+	p := &rotatingCertProvider{t: t, changed: make(chan struct{}, 1)}
+	p.cert = p.newCertificate()
+	return p
+}
+
+func (p *rotatingCertProvider) newCertificate() *tls.Certificate {
+	priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(p.t, err)
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(7 * 24 * time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, priv.Public(), priv)
+	require.NoError(p.t, err)
+	leaf, err := x509.ParseCertificate(der)
+	require.NoError(p.t, err)
+	return &tls.Certificate{Certificate: [][]byte{der}, PrivateKey: priv, Leaf: leaf}
+}
+
+func (p *rotatingCertProvider) Certificates(time.Time) (current, next *tls.Certificate, err error) {
+	p.mx.Lock()
+	defer p.mx.Unlock()
+	return p.cert, nil, nil
+}
+
+func (p *rotatingCertProvider) CertificatesChanged() <-chan struct{} {
+	return p.changed
+}
+
+// rotate replaces the certificate, and returns the certhash component of the
+// new one.
+func (p *rotatingCertProvider) rotate() ma.Multiaddr {
+	c := p.newCertificate()
+	p.mx.Lock()
+	p.cert = c
+	p.mx.Unlock()
+	p.changed <- struct{}{}
+	return certHashComponent(p.t, c)
+}
+
+func certHashComponent(t *testing.T, c *tls.Certificate) ma.Multiaddr {
+	h := sha256.Sum256(c.Certificate[0])
+	mh, err := multihash.Encode(h[:], multihash.SHA2_256)
+	require.NoError(t, err)
+	s, err := multibase.Encode(multibase.Base58BTC, mh)
+	require.NoError(t, err)
+	comp, err := ma.NewComponent("certhash", s)
+	require.NoError(t, err)
+	return comp
+}
+
+func TestCertHashChangeUpdatesAddrs(t *testing.T) {
+	priv, _, err := crypto.GenerateEd25519Key(rand.Reader)
+	require.NoError(t, err)
+	swrm := swarmt.GenSwarm(t, swarmt.OptPeerPrivateKey(priv), swarmt.OptDisableTCP, swarmt.OptDisableQUIC)
+	cm, err := quicreuse.NewConnManager(quic.StatelessResetKey{}, quic.TokenGeneratorKey{})
+	require.NoError(t, err)
+	provider := newRotatingCertProvider(t)
+	tpt, err := libp2pwebtransport.New(priv, nil, cm, nil, nil, libp2pwebtransport.WithCertificateProvider(provider))
+	require.NoError(t, err)
+	require.NoError(t, swrm.AddTransport(tpt))
+
+	h, err := NewHost(swrm, nil)
+	require.NoError(t, err)
+	defer h.Close()
+	sub, err := h.EventBus().Subscribe(&event.EvtLocalAddressesUpdated{}, eventbus.BufSize(10))
+	require.NoError(t, err)
+	defer sub.Close()
+	h.Start()
+
+	// waitForCertHash waits for an address update adding an address with the
+	// certhash component comp. The host checks its addresses for changes
+	// every addrChangeTickrInterval anyway, the update must come sooner.
+	waitForCertHash := func(comp ma.Multiaddr) {
+		t.Helper()
+		timeout := time.After(addrChangeTickrInterval / 2)
+		for {
+			select {
+			case e := <-sub.Out():
+				for _, a := range e.(event.EvtLocalAddressesUpdated).Current {
+					if a.Action != event.Added {
+						continue
+					}
+					_, certHashes := ma.SplitFunc(a.Address, func(c ma.Component) bool { return c.Protocol().Code == ma.P_CERTHASH })
+					if certHashes != nil && certHashes.Equal(comp) {
+						return
+					}
+				}
+			case <-timeout:
+				t.Fatalf("timed out waiting for an address with %s", comp)
+			}
+		}
+	}
+
+	require.NoError(t, swrm.Listen(ma.StringCast("/ip4/127.0.0.1/udp/0/quic-v1/webtransport")))
+	waitForCertHash(certHashComponent(t, provider.cert))
+
+	// the addresses are updated when the provider changes the certificate
+	waitForCertHash(provider.rotate())
+}
+
 func TestStatefulAddrEvents(t *testing.T) {
 	h, err := NewHost(swarmt.GenSwarm(t), nil)
 	require.NoError(t, err)
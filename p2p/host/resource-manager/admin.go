@@ -0,0 +1,434 @@
+package rcmgr
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/netip"
+	"strings"
+	"sync"
+
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/protocol"
+
+	"github.com/multiformats/go-multiaddr"
+)
+
+const (
+	// maxAdminRequestSize is the maximum size of a PATCH request body.
+	maxAdminRequestSize = 1 << 20
+	// adminEventBufferSize is the number of trace events buffered per event
+	// stream. Events are dropped if a client doesn't keep up.
+	adminEventBufferSize = 1024
+)
+
+// ScopeSnapshot is the usage and the limit of a scope.
+type ScopeSnapshot struct {
+	Stat  network.ScopeStat
+	Limit BaseLimit
+}
+
+// ResourceManagerSnapshot is a snapshot of all scopes of the resource manager,
+// as served by the AdminHandler.
+type ResourceManagerSnapshot struct {
+	System               ScopeSnapshot
+	Transient            ScopeSnapshot
+	AllowlistedSystem    ScopeSnapshot
+	AllowlistedTransient ScopeSnapshot
+	Services             map[string]ScopeSnapshot
+	Protocols            map[protocol.ID]ScopeSnapshot
+	Peers                map[peer.ID]ScopeSnapshot
+	Subnets              map[netip.Prefix]ScopeSnapshot
+	ASNs                 map[uint32]ScopeSnapshot
+}
+
+// AllowlistPatch is the body of a PATCH request to the allowlist endpoint of
+// the AdminHandler. Entries have the format accepted by Allowlist.Add.
+type AllowlistPatch struct {
+	Add    []string `json:",omitempty"`
+	Remove []string `json:",omitempty"`
+}
+
+// AdminHandler is an http.Handler that allows operators to inspect the
+// resource manager and change its limits at runtime. It serves:
+//
+//   - GET /scopes: a ResourceManagerSnapshot.
+//   - PATCH /limits: changes the limits of the system, transient, service,
+//     protocol and peer scopes. The body is a PartialLimitConfig, only the
+//     System, Transient, AllowlistedSystem, AllowlistedTransient, Service,
+//     Protocol and Peer fields may be set. Unset values keep their current
+//     limit. Like with ResourceScopeLimiter.SetLimit, the protocol and peer
+//     scopes changed this way are kept around. Responds with the new
+//     ResourceManagerSnapshot.
+//   - GET /allowlist: the allowlist entries.
+//   - PATCH /allowlist: adds and removes allowlist entries, see AllowlistPatch.
+//   - GET /events: a stream of trace events, as server-sent events.
+//
+// The handler doesn't authenticate requests, it must not be exposed to
+// untrusted clients.
+type AdminHandler struct {
+	rcmgr *resourceManager
+	mux   *http.ServeMux
+
+	mx      sync.Mutex
+	closed  bool
+	streams map[chan TraceEvt]struct{}
+}
+
+var _ http.Handler = (*AdminHandler)(nil)
+var _ TraceReporter = (*AdminHandler)(nil)
+
+// NewAdminHandler creates an AdminHandler for the resource manager.
+// Streaming trace events is only possible if tracing is enabled, i.e. if
+// metrics aren't disabled, or a trace file or reporter is configured.
+// The handler must be closed when it's not used anymore.
+func NewAdminHandler(rm network.ResourceManager) (*AdminHandler, error) {
+	r, ok := rm.(*resourceManager)
+	if !ok {
+		return nil, fmt.Errorf("unsupported resource manager: %T", rm)
+	}
+	h := &AdminHandler{
+		rcmgr:   r,
+		mux:     http.NewServeMux(),
+		streams: make(map[chan TraceEvt]struct{}),
+	}
+	h.mux.HandleFunc("/scopes", h.handleScopes)
+	h.mux.HandleFunc("/limits", h.handleLimits)
+	h.mux.HandleFunc("/allowlist", h.handleAllowlist)
+	h.mux.HandleFunc("/events", h.handleEvents)
+
+	if r.trace != nil {
+		r.trace.addReporter(h)
+	}
+	return h, nil
+}
+
+func (h *AdminHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	h.mux.ServeHTTP(w, req)
+}
+
+// Close stops all event streams.
+func (h *AdminHandler) Close() error {
+	if h.rcmgr.trace != nil {
+		h.rcmgr.trace.removeReporter(h)
+	}
+
+	h.mx.Lock()
+	defer h.mx.Unlock()
+
+	h.closed = true
+	for ch := range h.streams {
+		close(ch)
+		delete(h.streams, ch)
+	}
+	return nil
+}
+
+// ConsumeEvent implements TraceReporter.
+func (h *AdminHandler) ConsumeEvent(evt TraceEvt) {
+	h.mx.Lock()
+	defer h.mx.Unlock()
+
+	for ch := range h.streams {
+		select {
+		case ch <- evt:
+		default:
+			// the client is too slow, drop the event
+		}
+	}
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Debugw("failed to write admin response", "error", err)
+	}
+}
+
+func (h *AdminHandler) handleScopes(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	writeJSON(w, h.rcmgr.snapshot())
+}
+
+func (h *AdminHandler) handleLimits(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPatch {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var patch PartialLimitConfig
+	if err := json.NewDecoder(http.MaxBytesReader(w, req.Body, maxAdminRequestSize)).Decode(&patch); err != nil {
+		http.Error(w, fmt.Sprintf("invalid limits: %s", err), http.StatusBadRequest)
+		return
+	}
+	if err := h.rcmgr.applyLimitPatch(patch); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	writeJSON(w, h.rcmgr.snapshot())
+}
+
+func (h *AdminHandler) handleAllowlist(w http.ResponseWriter, req *http.Request) {
+	switch req.Method {
+	case http.MethodGet:
+	case http.MethodPatch:
+		var patch AllowlistPatch
+		if err := json.NewDecoder(http.MaxBytesReader(w, req.Body, maxAdminRequestSize)).Decode(&patch); err != nil {
+			http.Error(w, fmt.Sprintf("invalid allowlist patch: %s", err), http.StatusBadRequest)
+			return
+		}
+		// parse everything first, so that we don't apply a partial patch
+		add, err := parseMultiaddrs(patch.Add)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		remove, err := parseMultiaddrs(patch.Remove)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		for _, a := range remove {
+			if err := h.rcmgr.allowlist.Remove(a); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+		for _, a := range add {
+			if err := h.rcmgr.allowlist.Add(a); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	addrs := h.rcmgr.allowlist.Multiaddrs()
+	out := make([]string, 0, len(addrs))
+	for _, a := range addrs {
+		out = append(out, a.String())
+	}
+	writeJSON(w, out)
+}
+
+func parseMultiaddrs(in []string) ([]multiaddr.Multiaddr, error) {
+	out := make([]multiaddr.Multiaddr, 0, len(in))
+	for _, s := range in {
+		a, err := multiaddr.NewMultiaddr(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid multiaddr %q: %w", s, err)
+		}
+		if _, _, err := toIPNet(a); err != nil {
+			return nil, fmt.Errorf("invalid allowlist entry %q: %w", s, err)
+		}
+		out = append(out, a)
+	}
+	return out, nil
+}
+
+func (h *AdminHandler) handleEvents(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if h.rcmgr.trace == nil {
+		http.Error(w, "tracing is disabled", http.StatusNotImplemented)
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming not supported", http.StatusInternalServerError)
+		return
+	}
+
+	ch := make(chan TraceEvt, adminEventBufferSize)
+	h.mx.Lock()
+	if h.closed {
+		h.mx.Unlock()
+		http.Error(w, "handler closed", http.StatusServiceUnavailable)
+		return
+	}
+	h.streams[ch] = struct{}{}
+	h.mx.Unlock()
+	defer func() {
+		h.mx.Lock()
+		defer h.mx.Unlock()
+		if _, ok := h.streams[ch]; ok {
+			delete(h.streams, ch)
+			close(ch)
+		}
+	}()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	for {
+		select {
+		case evt, ok := <-ch:
+			if !ok {
+				return
+			}
+			b, err := json.Marshal(evt)
+			if err != nil {
+				log.Debugw("failed to marshal trace event", "error", err)
+				continue
+			}
+			if _, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", evt.Type, b); err != nil {
+				return
+			}
+			flusher.Flush()
+		case <-req.Context().Done():
+			return
+		}
+	}
+}
+
+func snapshotOf(s *resourceScope) ScopeSnapshot {
+	s.Lock()
+	defer s.Unlock()
+
+	l := s.rc.limit
+	snapshot := ScopeSnapshot{
+		Stat: s.rc.stat(),
+		Limit: BaseLimit{
+			Streams:         l.GetStreamTotalLimit(),
+			StreamsInbound:  l.GetStreamLimit(network.DirInbound),
+			StreamsOutbound: l.GetStreamLimit(network.DirOutbound),
+			Conns:           l.GetConnTotalLimit(),
+			ConnsInbound:    l.GetConnLimit(network.DirInbound),
+			ConnsOutbound:   l.GetConnLimit(network.DirOutbound),
+			FD:              l.GetFDLimit(),
+			Memory:          l.GetMemoryLimit(),
+		},
+	}
+	if bl, ok := l.(BandwidthLimit); ok {
+		snapshot.Limit.BandwidthInbound = bl.GetBandwidthLimit(network.DirInbound)
+		snapshot.Limit.BandwidthOutbound = bl.GetBandwidthLimit(network.DirOutbound)
+	}
+	return snapshot
+}
+
+func (r *resourceManager) snapshot() ResourceManagerSnapshot {
+	r.mx.Lock()
+	svcs := make(map[string]*resourceScope, len(r.svc))
+	for svc, s := range r.svc {
+		svcs[svc] = s.resourceScope
+	}
+	protos := make(map[protocol.ID]*resourceScope, len(r.proto))
+	for proto, s := range r.proto {
+		protos[proto] = s.resourceScope
+	}
+	peers := make(map[peer.ID]*resourceScope, len(r.peer))
+	for p, s := range r.peer {
+		peers[p] = s.resourceScope
+	}
+	subnets := make(map[netip.Prefix]*resourceScope, len(r.subnet))
+	for prefix, s := range r.subnet {
+		subnets[prefix] = s
+	}
+	asns := make(map[uint32]*resourceScope, len(r.asn))
+	for asn, s := range r.asn {
+		asns[asn] = s
+	}
+	r.mx.Unlock()
+
+	res := ResourceManagerSnapshot{
+		Services:  make(map[string]ScopeSnapshot, len(svcs)),
+		Protocols: make(map[protocol.ID]ScopeSnapshot, len(protos)),
+		Peers:     make(map[peer.ID]ScopeSnapshot, len(peers)),
+		Subnets:   make(map[netip.Prefix]ScopeSnapshot, len(subnets)),
+		ASNs:      make(map[uint32]ScopeSnapshot, len(asns)),
+	}
+	for svc, s := range svcs {
+		res.Services[svc] = snapshotOf(s)
+	}
+	for proto, s := range protos {
+		res.Protocols[proto] = snapshotOf(s)
+	}
+	for p, s := range peers {
+		res.Peers[p] = snapshotOf(s)
+	}
+	for prefix, s := range subnets {
+		res.Subnets[prefix] = snapshotOf(s)
+	}
+	for asn, s := range asns {
+		res.ASNs[asn] = snapshotOf(s)
+	}
+	res.System = snapshotOf(r.system.resourceScope)
+	res.Transient = snapshotOf(r.transient.resourceScope)
+	res.AllowlistedSystem = snapshotOf(r.allowlistedSystem.resourceScope)
+	res.AllowlistedTransient = snapshotOf(r.allowlistedTransient.resourceScope)
+	return res
+}
+
+// applyLimitPatch sets the limits of the scopes configured in patch. Unset
+// values keep their current limit. The patch is validated before any limit is
+// changed, so a rejected patch leaves all limits untouched.
+func (r *resourceManager) applyLimitPatch(patch PartialLimitConfig) error {
+	var unsupported []string
+	for name, l := range map[string]*ResourceLimits{
+		"ServiceDefault":      &patch.ServiceDefault,
+		"ServicePeerDefault":  &patch.ServicePeerDefault,
+		"ProtocolDefault":     &patch.ProtocolDefault,
+		"ProtocolPeerDefault": &patch.ProtocolPeerDefault,
+		"PeerDefault":         &patch.PeerDefault,
+		"Conn":                &patch.Conn,
+		"Stream":              &patch.Stream,
+		"Subnet":              &patch.Subnet,
+		"ASN":                 &patch.ASN,
+	} {
+		if !l.IsDefault() {
+			unsupported = append(unsupported, name)
+		}
+	}
+	if len(patch.ServicePeer) > 0 {
+		unsupported = append(unsupported, "ServicePeer")
+	}
+	if len(patch.ProtocolPeer) > 0 {
+		unsupported = append(unsupported, "ProtocolPeer")
+	}
+	if len(unsupported) > 0 {
+		return fmt.Errorf("changing %s limits is not supported", strings.Join(unsupported, ", "))
+	}
+	for p := range patch.Peer {
+		if p == "" {
+			return errors.New("invalid peer ID")
+		}
+	}
+
+	set := func(s ResourceScopeLimiter, l ResourceLimits) {
+		if l.IsDefault() {
+			return
+		}
+		limit := l.Build(s.Limit())
+		s.SetLimit(&limit)
+	}
+	set(r.system, patch.System)
+	set(r.transient, patch.Transient)
+	set(r.allowlistedSystem, patch.AllowlistedSystem)
+	set(r.allowlistedTransient, patch.AllowlistedTransient)
+	for svc, l := range patch.Service {
+		s := r.getServiceScope(svc)
+		set(s, l)
+		s.DecRef()
+	}
+	for proto, l := range patch.Protocol {
+		s := r.getProtocolScope(proto)
+		set(s, l)
+		s.DecRef()
+	}
+	for p, l := range patch.Peer {
+		s := r.getPeerScope(p)
+		set(s, l)
+		s.DecRef()
+	}
+	return nil
+}
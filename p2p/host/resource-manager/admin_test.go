@@ -0,0 +1,170 @@
+package rcmgr
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/test"
+
+	"github.com/multiformats/go-multiaddr"
+	"github.com/stretchr/testify/require"
+)
+
+func newAdminTestServer(t *testing.T, opts ...Option) (*resourceManager, *httptest.Server) {
+	t.Helper()
+	mgr, err := NewResourceManager(NewFixedLimiter(DefaultLimits.AutoScale()), opts...)
+	require.NoError(t, err)
+	t.Cleanup(func() { mgr.Close() })
+	h, err := NewAdminHandler(mgr)
+	require.NoError(t, err)
+	t.Cleanup(func() { h.Close() })
+	srv := httptest.NewServer(h)
+	t.Cleanup(srv.Close)
+	return mgr.(*resourceManager), srv
+}
+
+func patchJSON(t *testing.T, url, body string) *http.Response {
+	t.Helper()
+	req, err := http.NewRequest(http.MethodPatch, url, strings.NewReader(body))
+	require.NoError(t, err)
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	t.Cleanup(func() { resp.Body.Close() })
+	return resp
+}
+
+func TestAdminHandlerScopes(t *testing.T) {
+	mgr, srv := newAdminTestServer(t, WithMetricsDisabled())
+
+	p := test.RandPeerIDFatal(t)
+	conn, err := mgr.OpenConnection(network.DirInbound, true, multiaddr.StringCast("/ip4/1.2.3.4/tcp/1234"))
+	require.NoError(t, err)
+	defer conn.Done()
+	require.NoError(t, conn.SetPeer(p))
+
+	resp, err := http.Get(srv.URL + "/scopes")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	var snapshot ResourceManagerSnapshot
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&snapshot))
+	require.Equal(t, 1, snapshot.System.Stat.NumConnsInbound)
+	require.Equal(t, mgr.limits.GetSystemLimits().GetConnTotalLimit(), snapshot.System.Limit.Conns)
+	require.Contains(t, snapshot.Peers, p)
+	require.Equal(t, 1, snapshot.Peers[p].Stat.NumConnsInbound)
+	require.Len(t, snapshot.Subnets, 1)
+
+	resp, err = http.Post(srv.URL+"/scopes", "application/json", nil)
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusMethodNotAllowed, resp.StatusCode)
+}
+
+func TestAdminHandlerLimits(t *testing.T) {
+	mgr, srv := newAdminTestServer(t, WithMetricsDisabled())
+	systemStreams := mgr.limits.GetSystemLimits().GetStreamTotalLimit()
+	p := test.RandPeerIDFatal(t)
+
+	resp := patchJSON(t, srv.URL+"/limits", `{"System":{"Conns":10,"BandwidthInbound":1000},"Peer":{"`+p.String()+`":{"Streams":"blockAll"}}}`)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	var snapshot ResourceManagerSnapshot
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&snapshot))
+	require.Equal(t, 10, snapshot.System.Limit.Conns)
+	require.Equal(t, int64(1000), snapshot.System.Limit.BandwidthInbound)
+	require.Zero(t, snapshot.System.Limit.BandwidthOutbound)
+	// unset values are kept
+	require.Equal(t, systemStreams, snapshot.System.Limit.Streams)
+	require.Equal(t, 0, snapshot.Peers[p].Limit.Streams)
+
+	// the limits are applied
+	require.NoError(t, mgr.ViewSystem(func(s network.ResourceScope) error {
+		require.Equal(t, 10, s.(ResourceScopeLimiter).Limit().GetConnTotalLimit())
+		return nil
+	}))
+	_, err := mgr.OpenStream(p, network.DirOutbound)
+	require.Error(t, err)
+
+	for _, body := range []string{
+		`{"Conn":{"Streams":1}}`,
+		`{"ProtocolPeer":{"/foo":{"Streams":1}}}`,
+		`{"System":`,
+	} {
+		resp := patchJSON(t, srv.URL+"/limits", body)
+		require.Equal(t, http.StatusBadRequest, resp.StatusCode, body)
+	}
+
+	// a rejected patch doesn't change any limit
+	require.Error(t, mgr.applyLimitPatch(PartialLimitConfig{
+		System: ResourceLimits{Conns: 20},
+		Peer:   map[peer.ID]ResourceLimits{"": {Streams: 1}},
+	}))
+	require.Equal(t, 10, mgr.system.Limit().GetConnTotalLimit())
+}
+
+func TestAdminHandlerAllowlist(t *testing.T) {
+	mgr, srv := newAdminTestServer(t, WithMetricsDisabled(), WithAllowlistedMultiaddrs([]multiaddr.Multiaddr{
+		multiaddr.StringCast("/ip4/1.2.3.4"),
+	}))
+
+	resp := patchJSON(t, srv.URL+"/allowlist", `{"Add":["/ip4/10.0.0.0/ipcidr/8"],"Remove":["/ip4/1.2.3.4"]}`)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	var entries []string
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&entries))
+	require.Equal(t, []string{"/ip4/10.0.0.0/ipcidr/8"}, entries)
+	require.True(t, mgr.allowlist.Allowed(multiaddr.StringCast("/ip4/10.1.2.3/tcp/1234")))
+	require.False(t, mgr.allowlist.Allowed(multiaddr.StringCast("/ip4/1.2.3.4/tcp/1234")))
+
+	// invalid patches are rejected without partially applying them
+	resp = patchJSON(t, srv.URL+"/allowlist", `{"Add":["/ip4/1.1.1.1"],"Remove":["/dns4/example.com"]}`)
+	require.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	require.False(t, mgr.allowlist.Allowed(multiaddr.StringCast("/ip4/1.1.1.1/tcp/1234")))
+
+	getResp, err := http.Get(srv.URL + "/allowlist")
+	require.NoError(t, err)
+	defer getResp.Body.Close()
+	require.NoError(t, json.NewDecoder(getResp.Body).Decode(&entries))
+	require.Equal(t, []string{"/ip4/10.0.0.0/ipcidr/8"}, entries)
+}
+
+func TestAdminHandlerEvents(t *testing.T) {
+	t.Run("tracing disabled", func(t *testing.T) {
+		_, srv := newAdminTestServer(t, WithMetricsDisabled())
+		resp, err := http.Get(srv.URL + "/events")
+		require.NoError(t, err)
+		resp.Body.Close()
+		require.Equal(t, http.StatusNotImplemented, resp.StatusCode)
+	})
+
+	t.Run("stream", func(t *testing.T) {
+		mgr, srv := newAdminTestServer(t)
+		resp, err := http.Get(srv.URL + "/events")
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		require.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+
+		conn, err := mgr.OpenConnection(network.DirInbound, true, multiaddr.StringCast("/ip4/1.2.3.4/tcp/1234"))
+		require.NoError(t, err)
+		conn.Done()
+
+		scanner := bufio.NewScanner(resp.Body)
+		var evt TraceEvt
+		for scanner.Scan() {
+			data, ok := strings.CutPrefix(scanner.Text(), "data: ")
+			if !ok {
+				continue
+			}
+			require.NoError(t, json.Unmarshal([]byte(data), &evt))
+			if evt.Type == TraceAddConnEvt {
+				break
+			}
+		}
+		require.Equal(t, TraceAddConnEvt, evt.Type)
+	})
+}
@@ -164,6 +164,41 @@ func (al *Allowlist) Remove(ma multiaddr.Multiaddr) error {
 	return nil
 }
 
+// Multiaddrs returns the entries of the allowlist, in the format accepted by
+// Add.
+func (al *Allowlist) Multiaddrs() []multiaddr.Multiaddr {
+	al.mu.RLock()
+	defer al.mu.RUnlock()
+
+	out := make([]multiaddr.Multiaddr, 0, len(al.allowedNetworks))
+	for _, ipnet := range al.allowedNetworks {
+		if ma, err := ipNetToMultiaddr(ipnet, ""); err == nil {
+			out = append(out, ma)
+		}
+	}
+	for p, ipnets := range al.allowedPeerByNetwork {
+		for _, ipnet := range ipnets {
+			if ma, err := ipNetToMultiaddr(ipnet, p); err == nil {
+				out = append(out, ma)
+			}
+		}
+	}
+	return out
+}
+
+func ipNetToMultiaddr(ipnet *net.IPNet, p peer.ID) (multiaddr.Multiaddr, error) {
+	proto := "ip6"
+	if ipnet.IP.To4() != nil {
+		proto = "ip4"
+	}
+	ones, _ := ipnet.Mask.Size()
+	s := fmt.Sprintf("/%s/%s/ipcidr/%d", proto, ipnet.IP, ones)
+	if p != "" {
+		s += "/p2p/" + p.String()
+	}
+	return multiaddr.NewMultiaddr(s)
+}
+
 func (al *Allowlist) Allowed(ma multiaddr.Multiaddr) bool {
 	ip, err := manet.ToIP(ma)
 	if err != nil {
@@ -0,0 +1,132 @@
+package rcmgr
+
+import (
+	"context"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/network"
+)
+
+// tokenBucket rate limits bandwidth. It holds up to one second worth of
+// tokens, and tokens can be taken in advance: the caller then has to wait
+// until the bucket is refilled.
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// take takes n tokens from the bucket, refilled at rate tokens per second, and
+// returns how long to wait until the tokens are available.
+func (b *tokenBucket) take(rate int64, n int, now time.Time) time.Duration {
+	if rate <= 0 {
+		// unlimited. Start with a full bucket if a limit is set later on.
+		b.last = time.Time{}
+		return 0
+	}
+
+	burst := float64(rate)
+	if b.last.IsZero() {
+		b.tokens = burst
+	} else if elapsed := now.Sub(b.last); elapsed > 0 {
+		b.tokens = min(burst, b.tokens+elapsed.Seconds()*float64(rate))
+	}
+	if now.After(b.last) {
+		b.last = now
+	}
+
+	b.tokens -= float64(n)
+	if b.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens / float64(rate) * float64(time.Second))
+}
+
+// hasBandwidthLimit reports whether l limits the inbound or outbound bandwidth.
+func hasBandwidthLimit(l Limit) bool {
+	bl, ok := l.(BandwidthLimit)
+	return ok && (bl.GetBandwidthLimit(network.DirInbound) > 0 || bl.GetBandwidthLimit(network.DirOutbound) > 0)
+}
+
+// addBytes accounts for n bytes transferred in direction dir. It doesn't
+// require holding the scope lock.
+func (rc *resources) addBytes(dir network.Direction, n int) {
+	if dir == network.DirInbound {
+		rc.bytesIn.Add(int64(n))
+	} else {
+		rc.bytesOut.Add(int64(n))
+	}
+}
+
+// throttle returns how long to wait for n bytes transferred in direction dir
+// to fit in the bandwidth limit.
+func (rc *resources) throttle(dir network.Direction, n int, now time.Time) time.Duration {
+	var limit int64
+	if bl, ok := rc.limit.(BandwidthLimit); ok {
+		limit = bl.GetBandwidthLimit(dir)
+	}
+	if dir == network.DirInbound {
+		return rc.bwIn.take(limit, n, now)
+	}
+	return rc.bwOut.take(limit, n, now)
+}
+
+var _ network.BandwidthScope = (*resourceScope)(nil)
+
+// WaitBandwidth accounts for n bytes transferred in the scope, and in all the
+// scopes it belongs to. It blocks until the transfer fits in the bandwidth
+// limits of all these scopes, or ctx is done.
+//
+// This is called on every read and write, so it avoids contending on the
+// locks of the scopes shared by many streams: the byte counters are updated
+// atomically, and only the scopes that limit the bandwidth are locked.
+func (s *resourceScope) WaitBandwidth(ctx context.Context, dir network.Direction, n int) error {
+	if n <= 0 {
+		return nil
+	}
+
+	s.Lock()
+	if s.done {
+		s.Unlock()
+		return s.wrapError(network.ErrResourceScopeClosed)
+	}
+	edges := s.edges
+	s.Unlock()
+
+	var limitedBuf [8]*resourceScope
+	limited := limitedBuf[:0]
+	s.rc.addBytes(dir, n)
+	if s.bwLimited.Load() {
+		limited = append(limited, s)
+	}
+	for _, e := range edges {
+		e.rc.addBytes(dir, n)
+		if e.bwLimited.Load() {
+			limited = append(limited, e)
+		}
+	}
+	if len(limited) == 0 {
+		return nil
+	}
+
+	now := time.Now()
+	var delay time.Duration
+	for _, e := range limited {
+		e.Lock()
+		if !e.done {
+			delay = max(delay, e.rc.throttle(dir, n, now))
+		}
+		e.Unlock()
+	}
+	if delay <= 0 {
+		return nil
+	}
+
+	t := time.NewTimer(delay)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
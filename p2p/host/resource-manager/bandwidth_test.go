@@ -0,0 +1,102 @@
+package rcmgr
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/network"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestTokenBucket(t *testing.T) {
+	var b tokenBucket
+	now := time.Now()
+
+	// unlimited
+	require.Zero(t, b.take(0, 1<<30, now))
+
+	// the bucket starts full, with one second worth of tokens
+	require.Zero(t, b.take(1000, 1000, now))
+	require.Equal(t, 500*time.Millisecond, b.take(1000, 500, now))
+
+	// the tokens taken in advance are refilled first
+	now = now.Add(500 * time.Millisecond)
+	require.Equal(t, 100*time.Millisecond, b.take(1000, 100, now))
+
+	// the bucket doesn't hold more than one second worth of tokens
+	now = now.Add(10 * time.Second)
+	require.Zero(t, b.take(1000, 1000, now))
+	require.Equal(t, time.Millisecond, b.take(1000, 1, now))
+}
+
+func TestWaitBandwidth(t *testing.T) {
+	system := newResourceScope(&BaseLimit{BandwidthOutbound: 1000}, nil, "system", nil, nil)
+	peer := newResourceScope(&BaseLimit{BandwidthInbound: 1000}, []*resourceScope{system}, "peer", nil, nil)
+	stream := newResourceScope(&BaseLimit{}, []*resourceScope{peer, system}, "stream", nil, nil)
+
+	// the bursts are within the limits
+	require.NoError(t, stream.WaitBandwidth(context.Background(), network.DirInbound, 1000))
+	require.NoError(t, stream.WaitBandwidth(context.Background(), network.DirOutbound, 1000))
+
+	// the limit of the peer scope applies to inbound traffic
+	start := time.Now()
+	require.NoError(t, stream.WaitBandwidth(context.Background(), network.DirInbound, 100))
+	require.GreaterOrEqual(t, time.Since(start), 80*time.Millisecond)
+
+	// the limit of the system scope applies to outbound traffic
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	require.ErrorIs(t, stream.WaitBandwidth(ctx, network.DirOutbound, 1000), context.DeadlineExceeded)
+
+	for _, s := range []*resourceScope{system, peer, stream} {
+		st := s.Stat()
+		require.Equal(t, int64(1100), st.BytesInbound)
+		require.Equal(t, int64(2000), st.BytesOutbound)
+	}
+
+	stream.Done()
+	require.ErrorIs(t, stream.WaitBandwidth(context.Background(), network.DirInbound, 1), network.ErrResourceScopeClosed)
+}
+
+func TestWaitBandwidthUnlimited(t *testing.T) {
+	system := newResourceScope(&BaseLimit{}, nil, "system", nil, nil)
+	peer := newResourceScope(&BaseLimit{}, []*resourceScope{system}, "peer", nil, nil)
+	stream := newResourceScope(&BaseLimit{}, []*resourceScope{peer, system}, "stream", nil, nil)
+
+	// Without bandwidth limits, the shared scopes aren't locked.
+	system.Lock()
+	peer.Lock()
+	require.NoError(t, stream.WaitBandwidth(context.Background(), network.DirInbound, 1000))
+	peer.Unlock()
+	system.Unlock()
+	for _, s := range []*resourceScope{system, peer, stream} {
+		require.Equal(t, int64(1000), s.Stat().BytesInbound)
+	}
+
+	// Setting a limit enables the throttling.
+	system.SetLimit(&BaseLimit{BandwidthInbound: 1000})
+	require.NoError(t, stream.WaitBandwidth(context.Background(), network.DirInbound, 1000))
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	require.ErrorIs(t, stream.WaitBandwidth(ctx, network.DirInbound, 1000), context.DeadlineExceeded)
+}
+
+func TestBandwidthLimitConfig(t *testing.T) {
+	var cfg PartialLimitConfig
+	require.NoError(t, json.Unmarshal([]byte(`{"Peer": {"12D3KooWGh3H4iTqA1Mde4ArzThK6XZA7nEj8m6S5FxqoeYJ8LaT": {"BandwidthInbound": "1000", "BandwidthOutbound": "unlimited"}}, "System": {"BandwidthOutbound": 4096}}`), &cfg))
+
+	concrete := cfg.Build(DefaultLimits.AutoScale())
+	require.Equal(t, int64(4096), concrete.system.GetBandwidthLimit(network.DirOutbound))
+	require.Zero(t, concrete.system.GetBandwidthLimit(network.DirInbound))
+	for _, l := range concrete.peer {
+		require.Equal(t, int64(1000), l.GetBandwidthLimit(network.DirInbound))
+		require.Zero(t, l.GetBandwidthLimit(network.DirOutbound))
+	}
+	require.Zero(t, concrete.peerDefault.GetBandwidthLimit(network.DirInbound))
+
+	// Roundtrip
+	require.Equal(t, concrete, concrete.ToPartialLimitConfig().Build(DefaultLimits.AutoScale()))
+}
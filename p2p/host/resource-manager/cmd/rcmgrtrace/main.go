@@ -0,0 +1,153 @@
+// rcmgrtrace analyzes traces written by the resource manager (see
+// rcmgr.WithTrace).
+//
+// It prints the peak usage of the most important scopes, and the scopes,
+// peers and protocols that the most reservations were blocked by. If a limit
+// config is passed, the trace is replayed against it.
+//
+//	rcmgrtrace [-top 10] [-limits limits.json] [-scope system] trace.json.gz
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	rcmgr "github.com/libp2p/go-libp2p/p2p/host/resource-manager"
+	"github.com/libp2p/go-libp2p/p2p/host/resource-manager/tracereader"
+)
+
+func main() {
+	top := flag.Int("top", 10, "number of scopes, peers and protocols to print")
+	limitsFile := flag.String("limits", "", "JSON limit config (rcmgr.PartialLimitConfig) to replay the trace against. Unset values are taken from the default limits")
+	memory := flag.Int64("memory", 0, "memory (in bytes) to scale the default limits to when replaying. Defaults to 1/8th of the system memory")
+	fds := flag.Int("fds", 0, "number of file descriptors to scale the default limits to when replaying. Defaults to half of the process limit")
+	scope := flag.String("scope", "", "print the usage of this scope over time, as CSV")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [flags] <trace file>\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	if err := run(flag.Arg(0), *top, *limitsFile, *memory, *fds, *scope); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+func run(path string, top int, limitsFile string, memory int64, fds int, scope string) error {
+	var replayer *tracereader.Replayer
+	if limitsFile != "" {
+		limits, err := loadLimits(limitsFile, memory, fds)
+		if err != nil {
+			return err
+		}
+		replayer = tracereader.NewReplayer(rcmgr.NewFixedLimiter(limits))
+	}
+
+	r, err := tracereader.Open(path)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	a := tracereader.NewAnalyzer()
+	if err := tracereader.ForEach(r, func(evt tracereader.Event) error {
+		a.Consume(evt)
+		if replayer != nil {
+			replayer.Consume(evt)
+		}
+		return nil
+	}); err != nil {
+		return err
+	}
+
+	if scope != "" {
+		return printSeries(a, scope)
+	}
+
+	fmt.Printf("%d events from %s to %s (%s)\n\n", a.NumEvents(), a.Start(), a.End(), a.End().Sub(a.Start()))
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "SCOPE\tPEAK MEMORY\tPEAK STREAMS IN\tPEAK STREAMS OUT\tPEAK CONNS IN\tPEAK CONNS OUT\tPEAK FD\tBLOCKS")
+	for _, name := range []string{"system", "transient", "allowlistedSystem", "allowlistedTransient"} {
+		u := a.Scope(name)
+		if u == nil {
+			continue
+		}
+		fmt.Fprintf(w, "%s\t%d\t%d\t%d\t%d\t%d\t%d\t%d\n", name,
+			u.Peak.Memory, u.Peak.NumStreamsInbound, u.Peak.NumStreamsOutbound,
+			u.Peak.NumConnsInbound, u.Peak.NumConnsOutbound, u.Peak.NumFD, u.Blocks)
+	}
+	w.Flush()
+
+	fmt.Printf("\n%d blocked reservations\n", len(a.Blocks()))
+	printCounts("Scopes", a.TopScopes(top))
+	printCounts("Peers", a.TopPeers(top))
+	printCounts("Protocols", a.TopProtocols(top))
+
+	if replayer != nil {
+		fmt.Printf("\nReplay against %s:\n", limitsFile)
+		fmt.Printf("%d reservations would have been blocked\n", len(replayer.Blocked()))
+		printCounts("Scopes", tracereader.TopScopes(replayer.Blocked(), top))
+		printCounts("Peers", tracereader.TopPeers(replayer.Blocked(), top))
+		printCounts("Protocols", tracereader.TopProtocols(replayer.Blocked(), top))
+		fmt.Printf("\n%d blocked reservations would have been allowed by the scope that blocked them\n", len(replayer.Unblocked()))
+		printCounts("Scopes", tracereader.TopScopes(replayer.Unblocked(), top))
+	}
+	return nil
+}
+
+func loadLimits(path string, memory int64, fds int) (rcmgr.ConcreteLimitConfig, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return rcmgr.ConcreteLimitConfig{}, err
+	}
+	var cfg rcmgr.PartialLimitConfig
+	if err := json.Unmarshal(b, &cfg); err != nil {
+		return rcmgr.ConcreteLimitConfig{}, fmt.Errorf("failed to parse limits: %w", err)
+	}
+	scalingLimits := rcmgr.DefaultLimits
+	var defaults rcmgr.ConcreteLimitConfig
+	if memory > 0 || fds > 0 {
+		if memory <= 0 || fds <= 0 {
+			return rcmgr.ConcreteLimitConfig{}, fmt.Errorf("-memory and -fds must be set together")
+		}
+		defaults = scalingLimits.Scale(memory, fds)
+	} else {
+		defaults = scalingLimits.AutoScale()
+	}
+	return cfg.Build(defaults), nil
+}
+
+func printSeries(a *tracereader.Analyzer, scope string) error {
+	u := a.Scope(scope)
+	if u == nil {
+		return fmt.Errorf("scope %s not found in trace", scope)
+	}
+	fmt.Println("time,memory,streams_in,streams_out,conns_in,conns_out,fd")
+	for _, s := range u.Samples {
+		fmt.Printf("%s,%d,%d,%d,%d,%d,%d\n", s.Time.Format("2006-01-02T15:04:05.000000Z07:00"),
+			s.Stat.Memory, s.Stat.NumStreamsInbound, s.Stat.NumStreamsOutbound,
+			s.Stat.NumConnsInbound, s.Stat.NumConnsOutbound, s.Stat.NumFD)
+	}
+	return nil
+}
+
+func printCounts(title string, counts []tracereader.Count) {
+	if len(counts) == 0 {
+		return
+	}
+	fmt.Printf("\n%s:\n", title)
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	for _, c := range counts {
+		fmt.Fprintf(w, "  %s\t%d\n", c.Key, c.Blocks)
+	}
+	w.Flush()
+}
@@ -2,6 +2,7 @@ package rcmgr
 
 import (
 	"bytes"
+	"net/netip"
 	"sort"
 	"strings"
 
@@ -33,6 +34,8 @@ type ResourceManagerStat struct {
 	Services  map[string]network.ScopeStat
 	Protocols map[protocol.ID]network.ScopeStat
 	Peers     map[peer.ID]network.ScopeStat
+	Subnets   map[netip.Prefix]network.ScopeStat
+	ASNs      map[uint32]network.ScopeStat
 }
 
 var _ ResourceManagerState = (*resourceManager)(nil)
@@ -49,6 +52,12 @@ func (s *resourceScope) SetLimit(limit Limit) {
 	defer s.Unlock()
 
 	s.rc.limit = limit
+	s.bwLimited.Store(hasBandwidthLimit(limit))
+}
+
+func (s *serviceScope) SetLimit(limit Limit) {
+	s.rcmgr.setStickyService(s.service)
+	s.resourceScope.SetLimit(limit)
 }
 
 func (s *protocolScope) SetLimit(limit Limit) {
@@ -123,6 +132,14 @@ func (r *resourceManager) Stat() (result ResourceManagerStat) {
 	for _, peer := range r.peer {
 		peers = append(peers, peer)
 	}
+	subnets := make(map[netip.Prefix]*resourceScope, len(r.subnet))
+	for prefix, s := range r.subnet {
+		subnets[prefix] = s
+	}
+	asns := make(map[uint32]*resourceScope, len(r.asn))
+	for asn, s := range r.asn {
+		asns[asn] = s
+	}
 	r.mx.Unlock()
 
 	// Note: there is no global lock, so the system is updating while we are dumping its state...
@@ -140,6 +157,14 @@ func (r *resourceManager) Stat() (result ResourceManagerStat) {
 	for _, svc := range svcs {
 		result.Services[svc.service] = svc.Stat()
 	}
+	result.Subnets = make(map[netip.Prefix]network.ScopeStat, len(subnets))
+	for prefix, s := range subnets {
+		result.Subnets[prefix] = s.Stat()
+	}
+	result.ASNs = make(map[uint32]network.ScopeStat, len(asns))
+	for asn, s := range asns {
+		result.ASNs[asn] = s.Stat()
+	}
 	result.Transient = r.transient.Stat()
 	result.System = r.system.Stat()
 
@@ -13,6 +13,7 @@ import (
 	"encoding/json"
 	"io"
 	"math"
+	"net/netip"
 
 	"github.com/libp2p/go-libp2p/core/network"
 	"github.com/libp2p/go-libp2p/core/peer"
@@ -50,6 +51,24 @@ type Limiter interface {
 	GetConnLimits() Limit
 }
 
+// IPLimiter is an optional interface for Limiters that also limit the
+// connections from a single IP subnet or autonomous system (ASN). This protects
+// against a single network occupying all our connection slots.
+// Limiters that don't implement it don't limit subnets and ASNs.
+type IPLimiter interface {
+	GetSubnetLimits(subnet netip.Prefix) Limit
+	GetASNLimits(asn uint32) Limit
+}
+
+// BandwidthLimit is an optional interface for Limits that also limit the
+// bandwidth of the streams in a scope. Limits that don't implement it don't
+// limit bandwidth.
+type BandwidthLimit interface {
+	// GetBandwidthLimit returns the bandwidth limit in bytes per second, for
+	// inbound or outbound traffic. Zero means unlimited.
+	GetBandwidthLimit(network.Direction) int64
+}
+
 // NewDefaultLimiterFromJSON creates a new limiter by parsing a json configuration,
 // using the default limits for fallback.
 func NewDefaultLimiterFromJSON(in io.Reader) (Limiter, error) {
@@ -79,6 +98,7 @@ type fixedLimiter struct {
 }
 
 var _ Limiter = (*fixedLimiter)(nil)
+var _ IPLimiter = (*fixedLimiter)(nil)
 
 func NewFixedLimiter(conf ConcreteLimitConfig) Limiter {
 	log.Debugw("initializing new limiter with config", "limits", conf)
@@ -95,6 +115,10 @@ type BaseLimit struct {
 	ConnsOutbound   int   `json:",omitempty"`
 	FD              int   `json:",omitempty"`
 	Memory          int64 `json:",omitempty"`
+	// BandwidthInbound and BandwidthOutbound are in bytes per second. Unlike
+	// the other limits, zero means unlimited.
+	BandwidthInbound  int64 `json:",omitempty"`
+	BandwidthOutbound int64 `json:",omitempty"`
 }
 
 func valueOrBlockAll(n int) LimitVal {
@@ -114,17 +138,30 @@ func valueOrBlockAll64(n int64) LimitVal64 {
 	return LimitVal64(n)
 }
 
+// bandwidthLimitVal converts a bandwidth limit to a LimitVal64. Bandwidth is
+// unlimited by default, so zero doesn't block all traffic.
+func bandwidthLimitVal(n int64) LimitVal64 {
+	if n <= 0 {
+		return DefaultLimit64
+	} else if n == math.MaxInt64 {
+		return Unlimited64
+	}
+	return LimitVal64(n)
+}
+
 // ToResourceLimits converts the BaseLimit to a ResourceLimits
 func (l BaseLimit) ToResourceLimits() ResourceLimits {
 	return ResourceLimits{
-		Streams:         valueOrBlockAll(l.Streams),
-		StreamsInbound:  valueOrBlockAll(l.StreamsInbound),
-		StreamsOutbound: valueOrBlockAll(l.StreamsOutbound),
-		Conns:           valueOrBlockAll(l.Conns),
-		ConnsInbound:    valueOrBlockAll(l.ConnsInbound),
-		ConnsOutbound:   valueOrBlockAll(l.ConnsOutbound),
-		FD:              valueOrBlockAll(l.FD),
-		Memory:          valueOrBlockAll64(l.Memory),
+		Streams:           valueOrBlockAll(l.Streams),
+		StreamsInbound:    valueOrBlockAll(l.StreamsInbound),
+		StreamsOutbound:   valueOrBlockAll(l.StreamsOutbound),
+		Conns:             valueOrBlockAll(l.Conns),
+		ConnsInbound:      valueOrBlockAll(l.ConnsInbound),
+		ConnsOutbound:     valueOrBlockAll(l.ConnsOutbound),
+		FD:                valueOrBlockAll(l.FD),
+		Memory:            valueOrBlockAll64(l.Memory),
+		BandwidthInbound:  bandwidthLimitVal(l.BandwidthInbound),
+		BandwidthOutbound: bandwidthLimitVal(l.BandwidthOutbound),
 	}
 }
 
@@ -155,6 +192,12 @@ func (l *BaseLimit) Apply(l2 BaseLimit) {
 	if l.FD == 0 {
 		l.FD = l2.FD
 	}
+	if l.BandwidthInbound == 0 {
+		l.BandwidthInbound = l2.BandwidthInbound
+	}
+	if l.BandwidthOutbound == 0 {
+		l.BandwidthOutbound = l2.BandwidthOutbound
+	}
 }
 
 // BaseLimitIncrease is the increase per GiB of allowed memory.
@@ -232,6 +275,19 @@ func (l BaseLimit) GetMemoryLimit() int64 {
 	return l.Memory
 }
 
+func (l BaseLimit) GetBandwidthLimit(dir network.Direction) int64 {
+	var limit int64
+	if dir == network.DirInbound {
+		limit = l.BandwidthInbound
+	} else {
+		limit = l.BandwidthOutbound
+	}
+	if limit < 0 || limit == math.MaxInt64 {
+		return 0
+	}
+	return limit
+}
+
 func (l *fixedLimiter) GetSystemLimits() Limit {
 	return &l.system
 }
@@ -295,3 +351,11 @@ func (l *fixedLimiter) GetStreamLimits(_ peer.ID) Limit {
 func (l *fixedLimiter) GetConnLimits() Limit {
 	return &l.conn
 }
+
+func (l *fixedLimiter) GetSubnetLimits(_ netip.Prefix) Limit {
+	return &l.subnet
+}
+
+func (l *fixedLimiter) GetASNLimits(_ uint32) Limit {
+	return &l.asn
+}
@@ -59,6 +59,14 @@ type ScalingLimitConfig struct {
 
 	StreamBaseLimit     BaseLimit
 	StreamLimitIncrease BaseLimitIncrease
+
+	// Subnet and ASN limits apply to all connections from the same IP subnet or
+	// autonomous system. If they are not set, these aren't limited.
+	SubnetBaseLimit     BaseLimit
+	SubnetLimitIncrease BaseLimitIncrease
+
+	ASNBaseLimit     BaseLimit
+	ASNLimitIncrease BaseLimitIncrease
 }
 
 func (cfg *ScalingLimitConfig) AddServiceLimit(svc string, base BaseLimit, inc BaseLimitIncrease) {
@@ -242,6 +250,15 @@ func (l *LimitVal64) UnmarshalJSON(b []byte) error {
 	return nil
 }
 
+// buildBandwidth is like Build, for bandwidth limits. Bandwidth can't be
+// blocked, blockAll results in zero, meaning unlimited.
+func (l LimitVal64) buildBandwidth(defaultVal int64) int64 {
+	if l == BlockAllLimit64 {
+		return 0
+	}
+	return l.Build(defaultVal)
+}
+
 func (l LimitVal64) Build(defaultVal int64) int64 {
 	if l == DefaultLimit64 {
 		return defaultVal
@@ -265,6 +282,10 @@ type ResourceLimits struct {
 	ConnsOutbound   LimitVal   `json:",omitempty"`
 	FD              LimitVal   `json:",omitempty"`
 	Memory          LimitVal64 `json:",omitempty"`
+	// BandwidthInbound and BandwidthOutbound are in bytes per second.
+	// Bandwidth can't be blocked, blockAll is the same as unlimited.
+	BandwidthInbound  LimitVal64 `json:",omitempty"`
+	BandwidthOutbound LimitVal64 `json:",omitempty"`
 }
 
 func (l *ResourceLimits) IsDefault() bool {
@@ -279,7 +300,9 @@ func (l *ResourceLimits) IsDefault() bool {
 		l.ConnsInbound == DefaultLimit &&
 		l.ConnsOutbound == DefaultLimit &&
 		l.FD == DefaultLimit &&
-		l.Memory == DefaultLimit64 {
+		l.Memory == DefaultLimit64 &&
+		l.BandwidthInbound == DefaultLimit64 &&
+		l.BandwidthOutbound == DefaultLimit64 {
 		return true
 	}
 	return false
@@ -318,34 +341,53 @@ func (l *ResourceLimits) Apply(l2 ResourceLimits) {
 	if l.Memory == DefaultLimit64 {
 		l.Memory = l2.Memory
 	}
+	if l.BandwidthInbound == DefaultLimit64 {
+		l.BandwidthInbound = l2.BandwidthInbound
+	}
+	if l.BandwidthOutbound == DefaultLimit64 {
+		l.BandwidthOutbound = l2.BandwidthOutbound
+	}
 }
 
 func (l *ResourceLimits) Build(defaults Limit) BaseLimit {
 	if l == nil {
 		return BaseLimit{
-			Streams:         defaults.GetStreamTotalLimit(),
-			StreamsInbound:  defaults.GetStreamLimit(network.DirInbound),
-			StreamsOutbound: defaults.GetStreamLimit(network.DirOutbound),
-			Conns:           defaults.GetConnTotalLimit(),
-			ConnsInbound:    defaults.GetConnLimit(network.DirInbound),
-			ConnsOutbound:   defaults.GetConnLimit(network.DirOutbound),
-			FD:              defaults.GetFDLimit(),
-			Memory:          defaults.GetMemoryLimit(),
+			Streams:           defaults.GetStreamTotalLimit(),
+			StreamsInbound:    defaults.GetStreamLimit(network.DirInbound),
+			StreamsOutbound:   defaults.GetStreamLimit(network.DirOutbound),
+			Conns:             defaults.GetConnTotalLimit(),
+			ConnsInbound:      defaults.GetConnLimit(network.DirInbound),
+			ConnsOutbound:     defaults.GetConnLimit(network.DirOutbound),
+			FD:                defaults.GetFDLimit(),
+			Memory:            defaults.GetMemoryLimit(),
+			BandwidthInbound:  defaultBandwidthLimit(defaults, network.DirInbound),
+			BandwidthOutbound: defaultBandwidthLimit(defaults, network.DirOutbound),
 		}
 	}
 
 	return BaseLimit{
-		Streams:         l.Streams.Build(defaults.GetStreamTotalLimit()),
-		StreamsInbound:  l.StreamsInbound.Build(defaults.GetStreamLimit(network.DirInbound)),
-		StreamsOutbound: l.StreamsOutbound.Build(defaults.GetStreamLimit(network.DirOutbound)),
-		Conns:           l.Conns.Build(defaults.GetConnTotalLimit()),
-		ConnsInbound:    l.ConnsInbound.Build(defaults.GetConnLimit(network.DirInbound)),
-		ConnsOutbound:   l.ConnsOutbound.Build(defaults.GetConnLimit(network.DirOutbound)),
-		FD:              l.FD.Build(defaults.GetFDLimit()),
-		Memory:          l.Memory.Build(defaults.GetMemoryLimit()),
+		Streams:           l.Streams.Build(defaults.GetStreamTotalLimit()),
+		StreamsInbound:    l.StreamsInbound.Build(defaults.GetStreamLimit(network.DirInbound)),
+		StreamsOutbound:   l.StreamsOutbound.Build(defaults.GetStreamLimit(network.DirOutbound)),
+		Conns:             l.Conns.Build(defaults.GetConnTotalLimit()),
+		ConnsInbound:      l.ConnsInbound.Build(defaults.GetConnLimit(network.DirInbound)),
+		ConnsOutbound:     l.ConnsOutbound.Build(defaults.GetConnLimit(network.DirOutbound)),
+		FD:                l.FD.Build(defaults.GetFDLimit()),
+		Memory:            l.Memory.Build(defaults.GetMemoryLimit()),
+		BandwidthInbound:  l.BandwidthInbound.buildBandwidth(defaultBandwidthLimit(defaults, network.DirInbound)),
+		BandwidthOutbound: l.BandwidthOutbound.buildBandwidth(defaultBandwidthLimit(defaults, network.DirOutbound)),
 	}
 }
 
+// defaultBandwidthLimit returns the bandwidth limit of defaults, or zero if
+// defaults doesn't limit bandwidth.
+func defaultBandwidthLimit(defaults Limit, dir network.Direction) int64 {
+	if bl, ok := defaults.(BandwidthLimit); ok {
+		return bl.GetBandwidthLimit(dir)
+	}
+	return 0
+}
+
 type PartialLimitConfig struct {
 	System    ResourceLimits `json:",omitempty"`
 	Transient ResourceLimits `json:",omitempty"`
@@ -373,6 +415,11 @@ type PartialLimitConfig struct {
 
 	Conn   ResourceLimits `json:",omitempty"`
 	Stream ResourceLimits `json:",omitempty"`
+
+	// Limits that are applied to all connections from a single IP subnet or
+	// autonomous system, only for public IP addresses.
+	Subnet ResourceLimits `json:",omitempty"`
+	ASN    ResourceLimits `json:",omitempty"`
 }
 
 func (cfg *PartialLimitConfig) MarshalJSON() ([]byte, error) {
@@ -406,6 +453,9 @@ func (cfg *PartialLimitConfig) MarshalJSON() ([]byte, error) {
 
 		Conn   *ResourceLimits `json:",omitempty"`
 		Stream *ResourceLimits `json:",omitempty"`
+
+		Subnet *ResourceLimits `json:",omitempty"`
+		ASN    *ResourceLimits `json:",omitempty"`
 	}{
 		Alias: (*Alias)(cfg),
 		Peer:  encodedPeerMap,
@@ -421,6 +471,8 @@ func (cfg *PartialLimitConfig) MarshalJSON() ([]byte, error) {
 		PeerDefault:          cfg.PeerDefault.ToMaybeNilPtr(),
 		Conn:                 cfg.Conn.ToMaybeNilPtr(),
 		Stream:               cfg.Stream.ToMaybeNilPtr(),
+		Subnet:               cfg.Subnet.ToMaybeNilPtr(),
+		ASN:                  cfg.ASN.ToMaybeNilPtr(),
 	})
 }
 
@@ -455,6 +507,8 @@ func (cfg *PartialLimitConfig) Apply(c PartialLimitConfig) {
 	cfg.PeerDefault.Apply(c.PeerDefault)
 	cfg.Conn.Apply(c.Conn)
 	cfg.Stream.Apply(c.Stream)
+	cfg.Subnet.Apply(c.Subnet)
+	cfg.ASN.Apply(c.ASN)
 
 	applyResourceLimitsMap(&cfg.Service, c.Service, cfg.ServiceDefault)
 	applyResourceLimitsMap(&cfg.ServicePeer, c.ServicePeer, cfg.ServicePeerDefault)
@@ -477,6 +531,8 @@ func (cfg PartialLimitConfig) Build(defaults ConcreteLimitConfig) ConcreteLimitC
 	out.peerDefault = cfg.PeerDefault.Build(defaults.peerDefault)
 	out.conn = cfg.Conn.Build(defaults.conn)
 	out.stream = cfg.Stream.Build(defaults.stream)
+	out.subnet = cfg.Subnet.Build(defaults.subnet)
+	out.asn = cfg.ASN.Build(defaults.asn)
 
 	out.service = buildMapWithDefault(cfg.Service, defaults.service, out.serviceDefault)
 	out.servicePeer = buildMapWithDefault(cfg.ServicePeer, defaults.servicePeer, out.servicePeerDefault)
@@ -538,6 +594,9 @@ type ConcreteLimitConfig struct {
 
 	conn   BaseLimit
 	stream BaseLimit
+
+	subnet BaseLimit
+	asn    BaseLimit
 }
 
 func resourceLimitsMapFromBaseLimitMap[K comparable](baseLimitMap map[K]BaseLimit) map[K]ResourceLimits {
@@ -573,6 +632,8 @@ func (cfg ConcreteLimitConfig) ToPartialLimitConfig() PartialLimitConfig {
 		Peer:                 resourceLimitsMapFromBaseLimitMap(cfg.peer),
 		Conn:                 cfg.conn.ToResourceLimits(),
 		Stream:               cfg.stream.ToResourceLimits(),
+		Subnet:               cfg.subnet.ToResourceLimits(),
+		ASN:                  cfg.asn.ToResourceLimits(),
 	}
 }
 
@@ -593,6 +654,8 @@ func (cfg *ScalingLimitConfig) Scale(memory int64, numFD int) ConcreteLimitConfi
 		peerDefault:          scale(cfg.PeerBaseLimit, cfg.PeerLimitIncrease, memory, numFD),
 		conn:                 scale(cfg.ConnBaseLimit, cfg.ConnLimitIncrease, memory, numFD),
 		stream:               scale(cfg.StreamBaseLimit, cfg.ConnLimitIncrease, memory, numFD),
+		subnet:               scaleOrInfinite(cfg.SubnetBaseLimit, cfg.SubnetLimitIncrease, memory, numFD),
+		asn:                  scaleOrInfinite(cfg.ASNBaseLimit, cfg.ASNLimitIncrease, memory, numFD),
 	}
 	if cfg.ServiceLimits != nil {
 		lc.service = make(map[string]BaseLimit)
@@ -651,6 +714,9 @@ func scale(base BaseLimit, inc BaseLimitIncrease, memory int64, numFD int) BaseL
 		Conns:           base.Conns + (inc.Conns*mebibytesAvailable)>>10,
 		Memory:          base.Memory + (inc.Memory*int64(mebibytesAvailable))>>10,
 		FD:              base.FD,
+		// bandwidth doesn't scale with memory
+		BandwidthInbound:  base.BandwidthInbound,
+		BandwidthOutbound: base.BandwidthOutbound,
 	}
 	if inc.FDFraction > 0 && numFD > 0 {
 		l.FD = int(inc.FDFraction * float64(numFD))
@@ -662,6 +728,17 @@ func scale(base BaseLimit, inc BaseLimitIncrease, memory int64, numFD int) BaseL
 	return l
 }
 
+// scaleOrInfinite is like scale, but doesn't limit anything if neither the
+// base limit nor the increase are set. This is used for the subnet and ASN
+// scopes, so that configurations that predate them don't block all
+// connections.
+func scaleOrInfinite(base BaseLimit, inc BaseLimitIncrease, memory int64, numFD int) BaseLimit {
+	if base == (BaseLimit{}) && inc == (BaseLimitIncrease{}) {
+		return infiniteBaseLimit
+	}
+	return scale(base, inc, memory, numFD)
+}
+
 // DefaultLimits are the limits used by the default limiter constructors.
 var DefaultLimits = ScalingLimitConfig{
 	SystemBaseLimit: BaseLimit{
@@ -849,6 +926,44 @@ var DefaultLimits = ScalingLimitConfig{
 		Streams:         1,
 		Memory:          16 << 20,
 	},
+
+	// The subnet and ASN scopes only constrain connections, streams and memory
+	// are limited by the peer scopes.
+	SubnetBaseLimit: BaseLimit{
+		ConnsInbound:    8,
+		ConnsOutbound:   32,
+		Conns:           32,
+		StreamsInbound:  math.MaxInt,
+		StreamsOutbound: math.MaxInt,
+		Streams:         math.MaxInt,
+		Memory:          math.MaxInt64,
+		FD:              32,
+	},
+
+	SubnetLimitIncrease: BaseLimitIncrease{
+		ConnsInbound:  8,
+		ConnsOutbound: 32,
+		Conns:         32,
+		FDFraction:    1.0 / 32,
+	},
+
+	ASNBaseLimit: BaseLimit{
+		ConnsInbound:    16,
+		ConnsOutbound:   64,
+		Conns:           64,
+		StreamsInbound:  math.MaxInt,
+		StreamsOutbound: math.MaxInt,
+		Streams:         math.MaxInt,
+		Memory:          math.MaxInt64,
+		FD:              64,
+	},
+
+	ASNLimitIncrease: BaseLimitIncrease{
+		ConnsInbound:  16,
+		ConnsOutbound: 64,
+		Conns:         64,
+		FDFraction:    1.0 / 16,
+	},
 }
 
 var infiniteBaseLimit = BaseLimit{
@@ -876,4 +991,6 @@ var InfiniteLimits = ConcreteLimitConfig{
 	peerDefault:          infiniteBaseLimit,
 	conn:                 infiniteBaseLimit,
 	stream:               infiniteBaseLimit,
+	subnet:               infiniteBaseLimit,
+	asn:                  infiniteBaseLimit,
 }
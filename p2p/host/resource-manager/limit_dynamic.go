@@ -0,0 +1,387 @@
+package rcmgr
+
+import (
+	"context"
+	"errors"
+	"net/netip"
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/event"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/protocol"
+)
+
+// DefaultLimitUpdateInterval is the default interval at which the
+// DynamicLimiter checks for changes of the available resources.
+const DefaultLimitUpdateInterval = time.Minute
+
+// LimitChangeEvent is emitted on the event bus of the host when the available
+// resources changed, and the DynamicLimiter recomputed the limits. It is
+// emitted after the new limits have been applied to the scopes of the
+// resource manager, see LimitChangeReporter.
+type LimitChangeEvent struct {
+	// OldMemory and NewMemory are the amount of memory reported by the
+	// ResourceSource, in bytes.
+	OldMemory, NewMemory int64
+	// OldFDs and NewFDs are the number of file descriptors reported by the
+	// ResourceSource.
+	OldFDs, NewFDs int
+	// Limits are the new limits.
+	Limits ConcreteLimitConfig
+}
+
+// DynamicLimiter is a Limiter that scales its limits with the resources
+// available to the process, like ScalingLimitConfig.AutoScale does, but keeps
+// watching the resources and recomputes the limits when they change. This is
+// useful when the memory or file descriptor limits change at runtime, e.g.
+// when a container is resized.
+//
+// New limits are applied to the existing system, transient, service, protocol,
+// peer, subnet and ASN scopes of the resource managers using this limiter.
+// Service, protocol and peer scopes whose limits were set explicitly using
+// ResourceScopeLimiter.SetLimit keep their limits. Connection and stream
+// scopes keep the limits they were created with.
+type DynamicLimiter struct {
+	scaling   ScalingLimitConfig
+	overrides PartialLimitConfig
+	source    ResourceSource
+	interval  time.Duration
+
+	updateMx sync.Mutex // serializes updates
+
+	mx       sync.RWMutex
+	current  *fixedLimiter
+	memory   int64
+	fds      int
+	managers map[*resourceManager]struct{}
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+var _ Limiter = (*DynamicLimiter)(nil)
+var _ IPLimiter = (*DynamicLimiter)(nil)
+
+// DynamicLimiterOption configures the DynamicLimiter.
+type DynamicLimiterOption func(*DynamicLimiter) error
+
+// WithResourceSource sets the source of the available resources. Defaults to
+// NewCgroupResourceSource.
+func WithResourceSource(s ResourceSource) DynamicLimiterOption {
+	return func(l *DynamicLimiter) error {
+		if s == nil {
+			return errors.New("nil resource source")
+		}
+		l.source = s
+		return nil
+	}
+}
+
+// WithLimitUpdateInterval sets the interval at which the available resources
+// are checked. Defaults to DefaultLimitUpdateInterval.
+func WithLimitUpdateInterval(d time.Duration) DynamicLimiterOption {
+	return func(l *DynamicLimiter) error {
+		if d <= 0 {
+			return errors.New("update interval must be positive")
+		}
+		l.interval = d
+		return nil
+	}
+}
+
+// WithLimitOverrides sets limits that are applied on top of the scaled limits,
+// like PartialLimitConfig.Build does.
+func WithLimitOverrides(cfg PartialLimitConfig) DynamicLimiterOption {
+	return func(l *DynamicLimiter) error {
+		l.overrides = cfg
+		return nil
+	}
+}
+
+// NewDynamicLimiter creates a new DynamicLimiter, scaling cfg with the
+// resources reported by the ResourceSource. Like AutoScale, it uses 1/8 of the
+// available memory and half of the file descriptors.
+// The limiter must be closed when it's not used anymore.
+func NewDynamicLimiter(cfg ScalingLimitConfig, opts ...DynamicLimiterOption) (*DynamicLimiter, error) {
+	l := &DynamicLimiter{
+		scaling:  cfg,
+		source:   NewCgroupResourceSource(),
+		interval: DefaultLimitUpdateInterval,
+		managers: make(map[*resourceManager]struct{}),
+	}
+	for _, opt := range opts {
+		if err := opt(l); err != nil {
+			return nil, err
+		}
+	}
+	if err := l.Update(); err != nil {
+		return nil, err
+	}
+
+	l.ctx, l.cancel = context.WithCancel(context.Background())
+	l.wg.Add(1)
+	go l.background()
+	return l, nil
+}
+
+func (l *DynamicLimiter) background() {
+	defer l.wg.Done()
+
+	ticker := time.NewTicker(l.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if err := l.Update(); err != nil {
+				log.Warnw("failed to update resource limits", "error", err)
+			}
+		case <-l.ctx.Done():
+			return
+		}
+	}
+}
+
+// Update checks the available resources and recomputes the limits if they
+// changed. It is called periodically, but can also be called to apply
+// changes immediately.
+func (l *DynamicLimiter) Update() error {
+	l.updateMx.Lock()
+	defer l.updateMx.Unlock()
+
+	mem, err := l.source.Memory()
+	if err != nil {
+		return err
+	}
+	fds, err := l.source.FDs()
+	if err != nil {
+		return err
+	}
+
+	l.mx.Lock()
+	if l.current != nil && mem == l.memory && fds == l.fds {
+		l.mx.Unlock()
+		return nil
+	}
+	evt := LimitChangeEvent{
+		OldMemory: l.memory,
+		NewMemory: mem,
+		OldFDs:    l.fds,
+		NewFDs:    fds,
+		Limits:    l.overrides.Build(l.scaling.Scale(mem/8, fds/2)),
+	}
+	initial := l.current == nil
+	l.current = &fixedLimiter{evt.Limits}
+	l.memory = mem
+	l.fds = fds
+	managers := make([]*resourceManager, 0, len(l.managers))
+	for r := range l.managers {
+		managers = append(managers, r)
+	}
+	l.mx.Unlock()
+
+	if initial {
+		return nil
+	}
+
+	log.Infow("resources changed, updating limits", "memory", mem, "fds", fds)
+	for _, r := range managers {
+		r.updateLimits()
+		r.emitLimitChange(evt)
+	}
+	return nil
+}
+
+// Limits returns the current limits.
+func (l *DynamicLimiter) Limits() ConcreteLimitConfig {
+	return l.limiter().ConcreteLimitConfig
+}
+
+// Close stops watching the available resources.
+func (l *DynamicLimiter) Close() error {
+	l.cancel()
+	l.wg.Wait()
+	return nil
+}
+
+func (l *DynamicLimiter) register(r *resourceManager) {
+	l.mx.Lock()
+	defer l.mx.Unlock()
+	l.managers[r] = struct{}{}
+}
+
+func (l *DynamicLimiter) unregister(r *resourceManager) {
+	l.mx.Lock()
+	defer l.mx.Unlock()
+	delete(l.managers, r)
+}
+
+func (l *DynamicLimiter) limiter() *fixedLimiter {
+	l.mx.RLock()
+	defer l.mx.RUnlock()
+	return l.current
+}
+
+func (l *DynamicLimiter) GetSystemLimits() Limit {
+	return l.limiter().GetSystemLimits()
+}
+
+func (l *DynamicLimiter) GetTransientLimits() Limit {
+	return l.limiter().GetTransientLimits()
+}
+
+func (l *DynamicLimiter) GetAllowlistedSystemLimits() Limit {
+	return l.limiter().GetAllowlistedSystemLimits()
+}
+
+func (l *DynamicLimiter) GetAllowlistedTransientLimits() Limit {
+	return l.limiter().GetAllowlistedTransientLimits()
+}
+
+func (l *DynamicLimiter) GetServiceLimits(svc string) Limit {
+	return l.limiter().GetServiceLimits(svc)
+}
+
+func (l *DynamicLimiter) GetServicePeerLimits(svc string) Limit {
+	return l.limiter().GetServicePeerLimits(svc)
+}
+
+func (l *DynamicLimiter) GetProtocolLimits(proto protocol.ID) Limit {
+	return l.limiter().GetProtocolLimits(proto)
+}
+
+func (l *DynamicLimiter) GetProtocolPeerLimits(proto protocol.ID) Limit {
+	return l.limiter().GetProtocolPeerLimits(proto)
+}
+
+func (l *DynamicLimiter) GetPeerLimits(p peer.ID) Limit {
+	return l.limiter().GetPeerLimits(p)
+}
+
+func (l *DynamicLimiter) GetStreamLimits(p peer.ID) Limit {
+	return l.limiter().GetStreamLimits(p)
+}
+
+func (l *DynamicLimiter) GetConnLimits() Limit {
+	return l.limiter().GetConnLimits()
+}
+
+func (l *DynamicLimiter) GetSubnetLimits(subnet netip.Prefix) Limit {
+	return l.limiter().GetSubnetLimits(subnet)
+}
+
+func (l *DynamicLimiter) GetASNLimits(asn uint32) Limit {
+	return l.limiter().GetASNLimits(asn)
+}
+
+// LimitChangeReporter is implemented by the resource managers of this
+// package. libp2p.New passes the event bus of the host to EmitLimitChanges.
+type LimitChangeReporter interface {
+	// EmitLimitChanges makes the resource manager emit a LimitChangeEvent on
+	// bus every time its DynamicLimiter changes the limits. It replaces the
+	// bus passed by previous calls.
+	EmitLimitChanges(bus event.Bus) error
+}
+
+var _ LimitChangeReporter = (*resourceManager)(nil)
+
+func (r *resourceManager) EmitLimitChanges(bus event.Bus) error {
+	em, err := bus.Emitter(new(LimitChangeEvent))
+	if err != nil {
+		return err
+	}
+	r.mx.Lock()
+	old := r.limitEmitter
+	r.limitEmitter = em
+	r.mx.Unlock()
+	if old != nil {
+		old.Close()
+	}
+	return nil
+}
+
+func (r *resourceManager) emitLimitChange(evt LimitChangeEvent) {
+	r.mx.Lock()
+	em := r.limitEmitter
+	r.mx.Unlock()
+	if em == nil {
+		return
+	}
+	if err := em.Emit(evt); err != nil {
+		log.Debugw("failed to emit limit change event", "error", err)
+	}
+}
+
+// updateLimits applies the current limits of the limiter to the existing
+// scopes. Service, protocol and peer scopes with explicitly set limits keep
+// them, the limits of their peer scopes are still updated.
+func (r *resourceManager) updateLimits() {
+	r.system.SetLimit(r.limits.GetSystemLimits())
+	r.transient.SetLimit(r.limits.GetTransientLimits())
+	r.allowlistedSystem.SetLimit(r.limits.GetAllowlistedSystemLimits())
+	r.allowlistedTransient.SetLimit(r.limits.GetAllowlistedTransientLimits())
+
+	r.mx.Lock()
+	// svcs and protos map the scopes to whether they are sticky
+	svcs := make(map[*serviceScope]bool, len(r.svc))
+	for svc, s := range r.svc {
+		_, sticky := r.stickyService[svc]
+		svcs[s] = sticky
+	}
+	protos := make(map[*protocolScope]bool, len(r.proto))
+	for proto, s := range r.proto {
+		_, sticky := r.stickyProto[proto]
+		protos[s] = sticky
+	}
+	peers := make([]*peerScope, 0, len(r.peer))
+	for p, s := range r.peer {
+		if _, sticky := r.stickyPeer[p]; !sticky {
+			peers = append(peers, s)
+		}
+	}
+	subnets := make(map[netip.Prefix]*resourceScope, len(r.subnet))
+	for prefix, s := range r.subnet {
+		subnets[prefix] = s
+	}
+	asns := make(map[uint32]*resourceScope, len(r.asn))
+	for asn, s := range r.asn {
+		asns[asn] = s
+	}
+	r.mx.Unlock()
+
+	// Don't use the SetLimit methods of the service, protocol and peer scopes,
+	// that would make the scopes sticky.
+	for s, sticky := range svcs {
+		if !sticky {
+			s.resourceScope.SetLimit(r.limits.GetServiceLimits(s.service))
+		}
+		s.Lock()
+		for _, ps := range s.peers {
+			ps.SetLimit(r.limits.GetServicePeerLimits(s.service))
+		}
+		s.Unlock()
+	}
+	for s, sticky := range protos {
+		if !sticky {
+			s.resourceScope.SetLimit(r.limits.GetProtocolLimits(s.proto))
+		}
+		s.Lock()
+		for _, ps := range s.peers {
+			ps.SetLimit(r.limits.GetProtocolPeerLimits(s.proto))
+		}
+		s.Unlock()
+	}
+	for _, s := range peers {
+		s.resourceScope.SetLimit(r.limits.GetPeerLimits(s.peer))
+	}
+	if l, ok := r.limits.(IPLimiter); ok {
+		for prefix, s := range subnets {
+			s.SetLimit(l.GetSubnetLimits(prefix))
+		}
+		for asn, s := range asns {
+			s.SetLimit(l.GetASNLimits(asn))
+		}
+	}
+}
@@ -0,0 +1,195 @@
+package rcmgr
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/protocol"
+	"github.com/libp2p/go-libp2p/p2p/host/eventbus"
+
+	"github.com/stretchr/testify/require"
+)
+
+type mockResourceSource struct {
+	mx     sync.Mutex
+	memory int64
+	fds    int
+}
+
+func (s *mockResourceSource) set(memory int64, fds int) {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+	s.memory = memory
+	s.fds = fds
+}
+
+func (s *mockResourceSource) Memory() (int64, error) {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+	return s.memory, nil
+}
+
+func (s *mockResourceSource) FDs() (int, error) {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+	return s.fds, nil
+}
+
+func TestDynamicLimiter(t *testing.T) {
+	src := &mockResourceSource{memory: 8 << 30, fds: 1024}
+	l, err := NewDynamicLimiter(DefaultLimits,
+		WithResourceSource(src),
+		WithLimitOverrides(PartialLimitConfig{Transient: ResourceLimits{Conns: 42}}),
+	)
+	require.NoError(t, err)
+	defer l.Close()
+	require.Equal(t, DefaultLimits.Scale(1<<30, 512).system, l.Limits().system)
+	require.Equal(t, 42, l.GetTransientLimits().GetConnTotalLimit())
+
+	mgr, err := NewResourceManager(l, WithMetricsDisabled())
+	require.NoError(t, err)
+	defer mgr.Close()
+	bus := eventbus.NewBus()
+	sub, err := bus.Subscribe(new(LimitChangeEvent))
+	require.NoError(t, err)
+	defer sub.Close()
+	require.NoError(t, mgr.(LimitChangeReporter).EmitLimitChanges(bus))
+
+	p1, p2 := peer.ID("p1"), peer.ID("p2")
+	for _, p := range []peer.ID{p1, p2} {
+		require.NoError(t, mgr.ViewPeer(p, func(network.PeerScope) error { return nil }))
+	}
+	require.NoError(t, mgr.ViewProtocol("/proto", func(network.ProtocolScope) error { return nil }))
+	// explicitly set limits are kept
+	custom := &BaseLimit{Conns: 1}
+	require.NoError(t, mgr.ViewPeer(p2, func(s network.PeerScope) error {
+		s.(ResourceScopeLimiter).SetLimit(custom)
+		return nil
+	}))
+	require.NoError(t, mgr.ViewProtocol("/custom", func(s network.ProtocolScope) error {
+		s.(ResourceScopeLimiter).SetLimit(custom)
+		return nil
+	}))
+	require.NoError(t, mgr.ViewService("custom", func(s network.ServiceScope) error {
+		s.(ResourceScopeLimiter).SetLimit(custom)
+		return nil
+	}))
+	require.NoError(t, mgr.ViewService("svc", func(network.ServiceScope) error { return nil }))
+
+	// nothing changed
+	require.NoError(t, l.Update())
+	require.Empty(t, sub.Out())
+
+	src.set(16<<30, 4096)
+	require.NoError(t, l.Update())
+	select {
+	case e := <-sub.Out():
+		require.Equal(t, LimitChangeEvent{
+			OldMemory: 8 << 30,
+			NewMemory: 16 << 30,
+			OldFDs:    1024,
+			NewFDs:    4096,
+			Limits:    l.Limits(),
+		}, e)
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected a limit change event")
+	}
+	expected := DefaultLimits.Scale(2<<30, 2048)
+	require.Equal(t, expected.system, l.Limits().system)
+	require.Equal(t, 42, l.Limits().transient.Conns)
+
+	limitOf := func(s network.ResourceScope) Limit { return s.(ResourceScopeLimiter).Limit() }
+	require.NoError(t, mgr.ViewSystem(func(s network.ResourceScope) error {
+		require.Equal(t, expected.system, *limitOf(s).(*BaseLimit))
+		return nil
+	}))
+	require.NoError(t, mgr.ViewPeer(p1, func(s network.PeerScope) error {
+		require.Equal(t, expected.peerDefault, *limitOf(s).(*BaseLimit))
+		return nil
+	}))
+	require.NoError(t, mgr.ViewPeer(p2, func(s network.PeerScope) error {
+		require.Equal(t, custom, limitOf(s))
+		return nil
+	}))
+	require.NoError(t, mgr.ViewProtocol(protocol.ID("/proto"), func(s network.ProtocolScope) error {
+		require.Equal(t, expected.protocolDefault, *limitOf(s).(*BaseLimit))
+		return nil
+	}))
+	require.NoError(t, mgr.ViewProtocol(protocol.ID("/custom"), func(s network.ProtocolScope) error {
+		require.Equal(t, custom, limitOf(s))
+		return nil
+	}))
+	require.NoError(t, mgr.ViewService("svc", func(s network.ServiceScope) error {
+		require.Equal(t, expected.serviceDefault, *limitOf(s).(*BaseLimit))
+		return nil
+	}))
+	require.NoError(t, mgr.ViewService("custom", func(s network.ServiceScope) error {
+		require.Equal(t, custom, limitOf(s))
+		return nil
+	}))
+	// updating the limits doesn't make the scopes sticky
+	require.Equal(t, map[protocol.ID]struct{}{"/custom": {}}, mgr.(*resourceManager).stickyProto)
+	require.Equal(t, map[string]struct{}{"custom": {}}, mgr.(*resourceManager).stickyService)
+	require.Equal(t, map[peer.ID]struct{}{p2: {}}, mgr.(*resourceManager).stickyPeer)
+}
+
+func TestCgroupResourceSource(t *testing.T) {
+	write := func(t *testing.T, path, content string) {
+		t.Helper()
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), 0o755))
+		require.NoError(t, os.WriteFile(path, []byte(content), 0o644))
+	}
+
+	t.Run("cgroup v2", func(t *testing.T) {
+		dir := t.TempDir()
+		s := &cgroupResourceSource{root: filepath.Join(dir, "cgroup"), procCgroup: filepath.Join(dir, "proc")}
+		write(t, s.procCgroup, "0::/kubepods/pod1\n")
+		write(t, filepath.Join(s.root, "kubepods/pod1/memory.max"), "536870912\n")
+		mem, err := s.Memory()
+		require.NoError(t, err)
+		require.Equal(t, int64(512<<20), mem)
+
+		// resized
+		write(t, filepath.Join(s.root, "kubepods/pod1/memory.max"), "1073741824\n")
+		mem, err = s.Memory()
+		require.NoError(t, err)
+		require.Equal(t, int64(1<<30), mem)
+	})
+
+	t.Run("cgroup v2 namespace", func(t *testing.T) {
+		dir := t.TempDir()
+		s := &cgroupResourceSource{root: filepath.Join(dir, "cgroup"), procCgroup: filepath.Join(dir, "proc")}
+		write(t, s.procCgroup, "0::/\n")
+		write(t, filepath.Join(s.root, "memory.max"), "268435456\n")
+		mem, err := s.Memory()
+		require.NoError(t, err)
+		require.Equal(t, int64(256<<20), mem)
+	})
+
+	t.Run("cgroup v1", func(t *testing.T) {
+		dir := t.TempDir()
+		s := &cgroupResourceSource{root: filepath.Join(dir, "cgroup"), procCgroup: filepath.Join(dir, "proc")}
+		write(t, s.procCgroup, "12:cpu,cpuacct:/docker/abc\n4:memory:/docker/abc\n")
+		write(t, filepath.Join(s.root, "memory/docker/abc/memory.limit_in_bytes"), "268435456\n")
+		mem, err := s.Memory()
+		require.NoError(t, err)
+		require.Equal(t, int64(256<<20), mem)
+	})
+
+	t.Run("unlimited", func(t *testing.T) {
+		dir := t.TempDir()
+		s := &cgroupResourceSource{root: filepath.Join(dir, "cgroup"), procCgroup: filepath.Join(dir, "proc")}
+		write(t, s.procCgroup, "0::/\n")
+		write(t, filepath.Join(s.root, "memory.max"), "max\n")
+		mem, err := s.Memory()
+		require.NoError(t, err)
+		require.Positive(t, mem)
+		_, ok := s.cgroupMemoryLimit()
+		require.False(t, ok)
+	})
+}
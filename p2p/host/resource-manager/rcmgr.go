@@ -3,10 +3,12 @@ package rcmgr
 import (
 	"context"
 	"fmt"
+	"net/netip"
 	"strings"
 	"sync"
 	"time"
 
+	"github.com/libp2p/go-libp2p/core/event"
 	"github.com/libp2p/go-libp2p/core/network"
 	"github.com/libp2p/go-libp2p/core/peer"
 	"github.com/libp2p/go-libp2p/core/protocol"
@@ -41,8 +43,20 @@ type resourceManager struct {
 	proto map[protocol.ID]*protocolScope
 	peer  map[peer.ID]*peerScope
 
-	stickyProto map[protocol.ID]struct{}
-	stickyPeer  map[peer.ID]struct{}
+	// subnet and asn scopes constrain the connections from a single IP subnet or
+	// autonomous system, see subnet.go.
+	subnet                       map[netip.Prefix]*resourceScope
+	asn                          map[uint32]*resourceScope
+	ipv4PrefixLen, ipv6PrefixLen int
+
+	// stickyService, stickyProto and stickyPeer are the scopes whose limits
+	// were set explicitly.
+	stickyService map[string]struct{}
+	stickyProto   map[protocol.ID]struct{}
+	stickyPeer    map[peer.ID]struct{}
+
+	// limitEmitter, if set, is used to emit LimitChangeEvent.
+	limitEmitter event.Emitter
 
 	connId, streamId int64
 }
@@ -103,6 +117,8 @@ type connectionScope struct {
 	rcmgr         *resourceManager
 	peer          *peerScope
 	endpoint      multiaddr.Multiaddr
+	// ipScopes are the subnet and ASN scopes of the connection
+	ipScopes []*resourceScope
 }
 
 var _ network.ConnScope = (*connectionScope)(nil)
@@ -134,6 +150,11 @@ func NewResourceManager(limits Limiter, opts ...Option) (network.ResourceManager
 		svc:       make(map[string]*serviceScope),
 		proto:     make(map[protocol.ID]*protocolScope),
 		peer:      make(map[peer.ID]*peerScope),
+		subnet:    make(map[netip.Prefix]*resourceScope),
+		asn:       make(map[uint32]*resourceScope),
+
+		ipv4PrefixLen: DefaultIPv4SubnetPrefixLength,
+		ipv6PrefixLen: DefaultIPv6SubnetPrefixLength,
 	}
 
 	for _, opt := range opts {
@@ -178,6 +199,10 @@ func NewResourceManager(limits Limiter, opts ...Option) (network.ResourceManager
 	r.allowlistedTransient = newTransientScope(limits.GetAllowlistedTransientLimits(), r, "allowlistedTransient", r.allowlistedSystem.resourceScope)
 	r.allowlistedTransient.IncRef()
 
+	if l, ok := limits.(*DynamicLimiter); ok {
+		l.register(r)
+	}
+
 	r.cancelCtx, r.cancel = context.WithCancel(context.Background())
 
 	r.wg.Add(1)
@@ -259,6 +284,16 @@ func (r *resourceManager) getProtocolScope(proto protocol.ID) *protocolScope {
 	return s
 }
 
+func (r *resourceManager) setStickyService(svc string) {
+	r.mx.Lock()
+	defer r.mx.Unlock()
+
+	if r.stickyService == nil {
+		r.stickyService = make(map[string]struct{})
+	}
+	r.stickyService[svc] = struct{}{}
+}
+
 func (r *resourceManager) setStickyProtocol(proto protocol.ID) {
 	r.mx.Lock()
 	defer r.mx.Unlock()
@@ -312,7 +347,11 @@ func (r *resourceManager) nextStreamId() int64 {
 
 func (r *resourceManager) OpenConnection(dir network.Direction, usefd bool, endpoint multiaddr.Multiaddr) (network.ConnManagementScope, error) {
 	var conn *connectionScope
-	conn = newConnectionScope(dir, usefd, r.limits.GetConnLimits(), r, endpoint)
+	ipScopes := r.getIPScopes(endpoint)
+	conn = newConnectionScope(dir, usefd, r.limits.GetConnLimits(), r, endpoint, ipScopes)
+	for _, s := range ipScopes {
+		s.DecRef() // we have the reference in edges
+	}
 
 	err := conn.AddConn(dir, usefd)
 	if err != nil {
@@ -353,10 +392,20 @@ func (r *resourceManager) OpenStream(p peer.ID, dir network.Direction) (network.
 }
 
 func (r *resourceManager) Close() error {
+	if l, ok := r.limits.(*DynamicLimiter); ok {
+		l.unregister(r)
+	}
 	r.cancel()
 	r.wg.Wait()
 	r.trace.Close()
 
+	r.mx.Lock()
+	if r.limitEmitter != nil {
+		r.limitEmitter.Close()
+		r.limitEmitter = nil
+	}
+	r.mx.Unlock()
+
 	return nil
 }
 
@@ -406,6 +455,20 @@ func (r *resourceManager) gc() {
 		}
 	}
 
+	for prefix, s := range r.subnet {
+		if s.IsUnused() {
+			s.Done()
+			delete(r.subnet, prefix)
+		}
+	}
+
+	for asn, s := range r.asn {
+		if s.IsUnused() {
+			s.Done()
+			delete(r.asn, asn)
+		}
+	}
+
 	for _, s := range r.svc {
 		s.Lock()
 		for _, p := range deadPeers {
@@ -476,15 +539,16 @@ func newPeerScope(p peer.ID, limit Limit, rcmgr *resourceManager) *peerScope {
 	}
 }
 
-func newConnectionScope(dir network.Direction, usefd bool, limit Limit, rcmgr *resourceManager, endpoint multiaddr.Multiaddr) *connectionScope {
+func newConnectionScope(dir network.Direction, usefd bool, limit Limit, rcmgr *resourceManager, endpoint multiaddr.Multiaddr, ipScopes []*resourceScope) *connectionScope {
+	edges := append([]*resourceScope{rcmgr.transient.resourceScope, rcmgr.system.resourceScope}, ipScopes...)
 	return &connectionScope{
-		resourceScope: newResourceScope(limit,
-			[]*resourceScope{rcmgr.transient.resourceScope, rcmgr.system.resourceScope},
+		resourceScope: newResourceScope(limit, edges,
 			connScopeName(rcmgr.nextConnId()), rcmgr.trace, rcmgr.metrics),
 		dir:      dir,
 		usefd:    usefd,
 		rcmgr:    rcmgr,
 		endpoint: endpoint,
+		ipScopes: ipScopes,
 	}
 }
 
@@ -678,11 +742,35 @@ func (s *connectionScope) transferAllowedToStandard() (err error) {
 	}
 	transientScope.IncRef()
 
+	// Undo this if we fail later
+	defer func() {
+		if err != nil {
+			transientScope.ReleaseForChild(stat)
+			transientScope.DecRef()
+		}
+	}()
+
+	// Allowlisted connections aren't constrained by their subnet and ASN, but
+	// now this connection needs to be.
+	ipScopes := s.rcmgr.getIPScopes(s.endpoint)
+	for i, ipScope := range ipScopes {
+		if err := ipScope.ReserveForChild(stat); err != nil {
+			for _, reserved := range ipScopes[:i] {
+				reserved.ReleaseForChild(stat)
+			}
+			for _, ipScope := range ipScopes {
+				ipScope.DecRef()
+			}
+			return err
+		}
+	}
+
 	// Update edges
-	s.edges = []*resourceScope{
+	s.edges = append([]*resourceScope{
 		systemScope,
 		transientScope,
-	}
+	}, ipScopes...)
+	s.ipScopes = ipScopes
 	return nil
 }
 
@@ -736,10 +824,10 @@ func (s *connectionScope) SetPeer(p peer.ID) error {
 	transient.DecRef() // removed from edges
 
 	// update edges
-	edges := []*resourceScope{
+	edges := append([]*resourceScope{
 		s.peer.resourceScope,
 		system.resourceScope,
-	}
+	}, s.ipScopes...)
 	s.resourceScope.edges = edges
 
 	s.rcmgr.metrics.AllowPeer(p)
@@ -151,6 +151,8 @@ func TestResourceManager(t *testing.T) {
 				StreamsOutbound: 1,
 				Streams:         1,
 			},
+			subnet: infiniteBaseLimit,
+			asn:    infiniteBaseLimit,
 		}),
 	)
 
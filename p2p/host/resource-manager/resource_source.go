@@ -0,0 +1,110 @@
+package rcmgr
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/pbnjay/memory"
+)
+
+// ResourceSource provides the resources available to the process. It is used
+// by the DynamicLimiter to scale the limits.
+type ResourceSource interface {
+	// Memory returns the amount of memory available to the process, in bytes.
+	Memory() (int64, error)
+	// FDs returns the number of file descriptors the process may open.
+	FDs() (int, error)
+}
+
+type cgroupResourceSource struct {
+	// root is the mount point of the cgroup filesystem
+	root string
+	// procCgroup is the path of the file listing the cgroups of the process
+	procCgroup string
+}
+
+var _ ResourceSource = (*cgroupResourceSource)(nil)
+
+// NewCgroupResourceSource returns a ResourceSource that reads the memory limit
+// of the (v1 or v2) cgroup the process runs in, and the RLIMIT_NOFILE limit.
+// If the process is not constrained by a cgroup memory limit, the total system
+// memory is used.
+func NewCgroupResourceSource() ResourceSource {
+	return &cgroupResourceSource{root: "/sys/fs/cgroup", procCgroup: "/proc/self/cgroup"}
+}
+
+func (s *cgroupResourceSource) Memory() (int64, error) {
+	total := int64(memory.TotalMemory())
+	limit, ok := s.cgroupMemoryLimit()
+	if !ok || (total > 0 && limit > total) {
+		if total == 0 {
+			return 0, errors.New("failed to determine the available memory")
+		}
+		return total, nil
+	}
+	return limit, nil
+}
+
+func (s *cgroupResourceSource) FDs() (int, error) {
+	n := getNumFDs()
+	if n == 0 {
+		return 0, errors.New("failed to determine the file descriptor limit")
+	}
+	return n, nil
+}
+
+// cgroupMemoryLimit returns the memory limit of the cgroup of the process.
+// ok is false if there is no limit, or if it can't be determined.
+func (s *cgroupResourceSource) cgroupMemoryLimit() (limit int64, ok bool) {
+	var candidates []string
+	// The cgroup path listed in /proc/self/cgroup is relative to the root of
+	// the hierarchy. Inside a container with its own cgroup namespace the
+	// container's cgroup is mounted as the root, so try that as well.
+	if b, err := os.ReadFile(s.procCgroup); err == nil {
+		scanner := bufio.NewScanner(bytes.NewReader(b))
+		for scanner.Scan() {
+			// hierarchy-ID:controller-list:cgroup-path
+			fields := strings.SplitN(scanner.Text(), ":", 3)
+			if len(fields) != 3 {
+				continue
+			}
+			if fields[0] == "0" && fields[1] == "" {
+				// cgroup v2
+				candidates = append(candidates, filepath.Join(s.root, fields[2], "memory.max"))
+				continue
+			}
+			for _, c := range strings.Split(fields[1], ",") {
+				if c == "memory" {
+					candidates = append(candidates, filepath.Join(s.root, "memory", fields[2], "memory.limit_in_bytes"))
+				}
+			}
+		}
+	}
+	candidates = append(candidates,
+		filepath.Join(s.root, "memory.max"),
+		filepath.Join(s.root, "memory", "memory.limit_in_bytes"),
+	)
+
+	for _, path := range candidates {
+		b, err := os.ReadFile(path)
+		if err != nil {
+			continue
+		}
+		v := strings.TrimSpace(string(b))
+		if v == "max" {
+			return 0, false
+		}
+		limit, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || limit <= 0 {
+			log.Debugw("failed to parse cgroup memory limit", "path", path, "value", v)
+			continue
+		}
+		return limit, true
+	}
+	return 0, false
+}
@@ -6,6 +6,7 @@ import (
 	"math/big"
 	"strings"
 	"sync"
+	"sync/atomic"
 
 	"github.com/libp2p/go-libp2p/core/network"
 )
@@ -19,6 +20,12 @@ type resources struct {
 	nfd                     int
 
 	memory int64
+
+	// bytes transferred on the streams in the scope. They're updated without
+	// holding the scope lock, see resourceScope.WaitBandwidth.
+	bytesIn, bytesOut atomic.Int64
+	// the token buckets enforcing the bandwidth limits
+	bwIn, bwOut tokenBucket
 }
 
 // A resourceScope can be a DAG, where a downstream node is not allowed to outlive an upstream node
@@ -37,9 +44,12 @@ type resourceScope struct {
 
 	spanID int
 
-	rc    resources
-	owner *resourceScope   // set in span scopes, which define trees
-	edges []*resourceScope // set in DAG scopes, it's the linearized parent set
+	rc resources
+	// bwLimited is set if the limit of the scope limits the bandwidth. It can
+	// be read without holding the scope lock.
+	bwLimited atomic.Bool
+	owner     *resourceScope   // set in span scopes, which define trees
+	edges     []*resourceScope // set in DAG scopes, it's the linearized parent set
 
 	name    string   // for debugging purposes
 	trace   *trace   // debug tracing
@@ -60,6 +70,7 @@ func newResourceScope(limit Limit, edges []*resourceScope, name string, trace *t
 		trace:   trace,
 		metrics: metrics,
 	}
+	r.bwLimited.Store(hasBandwidthLimit(limit))
 	r.trace.CreateScope(name, limit)
 	return r
 }
@@ -72,6 +83,7 @@ func newResourceScopeSpan(owner *resourceScope, id int) *resourceScope {
 		trace:   owner.trace,
 		metrics: owner.metrics,
 	}
+	r.bwLimited.Store(hasBandwidthLimit(r.rc.limit))
 	r.trace.CreateScope(r.name, r.rc.limit)
 	return r
 }
@@ -328,6 +340,8 @@ func (rc *resources) stat() network.ScopeStat {
 		NumConnsInbound:    rc.nconnsIn,
 		NumConnsOutbound:   rc.nconnsOut,
 		NumFD:              rc.nfd,
+		BytesInbound:       rc.bytesIn.Load(),
+		BytesOutbound:      rc.bytesOut.Load(),
 	}
 }
 
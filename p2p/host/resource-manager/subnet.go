@@ -0,0 +1,112 @@
+package rcmgr
+
+import (
+	"errors"
+	"fmt"
+	"net/netip"
+	"strings"
+
+	asnutil "github.com/libp2p/go-libp2p-asn-util"
+	"github.com/multiformats/go-multiaddr"
+	manet "github.com/multiformats/go-multiaddr/net"
+)
+
+const (
+	// DefaultIPv4SubnetPrefixLength is the default prefix length of the IPv4 subnet scopes.
+	DefaultIPv4SubnetPrefixLength = 24
+	// DefaultIPv6SubnetPrefixLength is the default prefix length of the IPv6 subnet scopes.
+	DefaultIPv6SubnetPrefixLength = 48
+)
+
+// WithSubnetPrefixLengths sets the prefix lengths used to group connections
+// into subnet scopes. Defaults to DefaultIPv4SubnetPrefixLength and
+// DefaultIPv6SubnetPrefixLength.
+func WithSubnetPrefixLengths(ipv4, ipv6 int) Option {
+	return func(r *resourceManager) error {
+		if ipv4 < 0 || ipv4 > 32 || ipv6 < 0 || ipv6 > 128 {
+			return errors.New("invalid subnet prefix length")
+		}
+		r.ipv4PrefixLen = ipv4
+		r.ipv6PrefixLen = ipv6
+		return nil
+	}
+}
+
+func subnetScopeName(prefix netip.Prefix) string {
+	return fmt.Sprintf("subnet:%s", prefix)
+}
+
+func asnScopeName(asn uint32) string {
+	return fmt.Sprintf("asn:%d", asn)
+}
+
+func IsSubnetScope(name string) bool {
+	return strings.HasPrefix(name, "subnet:") && !IsSpan(name)
+}
+
+func IsASNScope(name string) bool {
+	return strings.HasPrefix(name, "asn:") && !IsSpan(name)
+}
+
+// getIPScopes returns the subnet and ASN scopes that constrain a connection
+// with the given remote endpoint. Only public IP addresses are limited, and
+// only IPv6 addresses can be mapped to an ASN.
+// The returned scopes have their reference count incremented.
+func (r *resourceManager) getIPScopes(endpoint multiaddr.Multiaddr) []*resourceScope {
+	limiter, ok := r.limits.(IPLimiter)
+	if !ok || endpoint == nil {
+		return nil
+	}
+	if _, err := endpoint.ValueForProtocol(multiaddr.P_CIRCUIT); err == nil {
+		// a relayed connection, the IP is the relay's
+		return nil
+	}
+	if !manet.IsPublicAddr(endpoint) {
+		return nil
+	}
+	ip, err := manet.ToIP(endpoint)
+	if err != nil {
+		return nil
+	}
+	addr, ok := netip.AddrFromSlice(ip)
+	if !ok {
+		return nil
+	}
+	addr = addr.Unmap()
+
+	bits := r.ipv6PrefixLen
+	if addr.Is4() {
+		bits = r.ipv4PrefixLen
+	}
+	prefix, err := addr.Prefix(bits)
+	if err != nil {
+		return nil
+	}
+	var asn uint32
+	if addr.Is6() {
+		asn = asnutil.AsnForIPv6(ip)
+	}
+
+	r.mx.Lock()
+	defer r.mx.Unlock()
+
+	scopes := make([]*resourceScope, 0, 2)
+	s, ok := r.subnet[prefix]
+	if !ok {
+		s = newResourceScope(limiter.GetSubnetLimits(prefix), nil, subnetScopeName(prefix), r.trace, r.metrics)
+		r.subnet[prefix] = s
+	}
+	s.IncRef()
+	scopes = append(scopes, s)
+
+	if asn != 0 {
+		s, ok := r.asn[asn]
+		if !ok {
+			s = newResourceScope(limiter.GetASNLimits(asn), nil, asnScopeName(asn), r.trace, r.metrics)
+			r.asn[asn] = s
+		}
+		s.IncRef()
+		scopes = append(scopes, s)
+	}
+	return scopes
+}
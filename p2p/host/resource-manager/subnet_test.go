@@ -0,0 +1,181 @@
+package rcmgr
+
+import (
+	"bytes"
+	"net/netip"
+	"testing"
+
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/test"
+
+	"github.com/multiformats/go-multiaddr"
+	"github.com/stretchr/testify/require"
+)
+
+func newSubnetTestManager(t *testing.T, subnet, asn BaseLimit, opts ...Option) *resourceManager {
+	t.Helper()
+	limits := InfiniteLimits
+	limits.subnet = subnet
+	limits.asn = asn
+	opts = append([]Option{WithMetricsDisabled()}, opts...)
+	mgr, err := NewResourceManager(NewFixedLimiter(limits), opts...)
+	require.NoError(t, err)
+	t.Cleanup(func() { mgr.Close() })
+	return mgr.(*resourceManager)
+}
+
+func TestSubnetLimits(t *testing.T) {
+	mgr := newSubnetTestManager(t,
+		BaseLimit{ConnsInbound: 2, ConnsOutbound: 1, Conns: 2, FD: 2},
+		infiniteBaseLimit,
+	)
+
+	open := func(dir network.Direction, addr string) (network.ConnManagementScope, error) {
+		return mgr.OpenConnection(dir, true, multiaddr.StringCast(addr))
+	}
+
+	c1, err := open(network.DirInbound, "/ip4/1.2.3.4/tcp/1")
+	require.NoError(t, err)
+	c2, err := open(network.DirInbound, "/ip4/1.2.3.5/udp/1/quic-v1")
+	require.NoError(t, err)
+	_, err = open(network.DirInbound, "/ip4/1.2.3.6/tcp/1")
+	require.ErrorIs(t, err, network.ErrResourceLimitExceeded)
+	_, err = open(network.DirOutbound, "/ip4/1.2.3.6/tcp/1")
+	require.ErrorIs(t, err, network.ErrResourceLimitExceeded)
+
+	// other subnets, private addresses and relayed connections are not affected
+	for _, addr := range []string{
+		"/ip4/1.2.4.1/tcp/1",
+		"/ip4/192.168.1.1/tcp/1",
+		"/ip4/192.168.1.2/tcp/1",
+		"/ip4/127.0.0.1/tcp/1",
+		"/ip4/1.2.3.4/tcp/1/p2p/" + test.RandPeerIDFatal(t).String() + "/p2p-circuit",
+	} {
+		_, err := open(network.DirInbound, addr)
+		require.NoError(t, err, addr)
+	}
+
+	stat := mgr.Stat()
+	require.Len(t, stat.Subnets, 2)
+	require.Equal(t, network.ScopeStat{NumConnsInbound: 2, NumFD: 2}, stat.Subnets[netip.MustParsePrefix("1.2.3.0/24")])
+	require.Equal(t, network.ScopeStat{NumConnsInbound: 1, NumFD: 1}, stat.Subnets[netip.MustParsePrefix("1.2.4.0/24")])
+
+	// the connection still counts towards the subnet once it is attached to a peer
+	require.NoError(t, c1.SetPeer(test.RandPeerIDFatal(t)))
+	_, err = open(network.DirInbound, "/ip4/1.2.3.6/tcp/1")
+	require.ErrorIs(t, err, network.ErrResourceLimitExceeded)
+
+	c1.Done()
+	c3, err := open(network.DirInbound, "/ip4/1.2.3.6/tcp/1")
+	require.NoError(t, err)
+
+	c2.Done()
+	c3.Done()
+	mgr.gc()
+	require.Equal(t, []netip.Prefix{netip.MustParsePrefix("1.2.4.0/24")}, keys(mgr.Stat().Subnets))
+}
+
+func keys[K comparable, V any](m map[K]V) []K {
+	res := make([]K, 0, len(m))
+	for k := range m {
+		res = append(res, k)
+	}
+	return res
+}
+
+func TestSubnetPrefixLengths(t *testing.T) {
+	mgr := newSubnetTestManager(t,
+		BaseLimit{ConnsInbound: 1, Conns: 1, FD: 1},
+		infiniteBaseLimit,
+		WithSubnetPrefixLengths(16, 32),
+	)
+
+	_, err := mgr.OpenConnection(network.DirInbound, true, multiaddr.StringCast("/ip4/1.2.3.4/tcp/1"))
+	require.NoError(t, err)
+	_, err = mgr.OpenConnection(network.DirInbound, true, multiaddr.StringCast("/ip4/1.2.100.4/tcp/1"))
+	require.ErrorIs(t, err, network.ErrResourceLimitExceeded)
+
+	_, err = mgr.OpenConnection(network.DirInbound, true, multiaddr.StringCast("/ip6/2606:4700:1::1/tcp/1"))
+	require.NoError(t, err)
+	_, err = mgr.OpenConnection(network.DirInbound, true, multiaddr.StringCast("/ip6/2606:4700:ffff::1/tcp/1"))
+	require.ErrorIs(t, err, network.ErrResourceLimitExceeded)
+
+	_, err = NewResourceManager(NewFixedLimiter(InfiniteLimits), WithSubnetPrefixLengths(33, 48))
+	require.Error(t, err)
+}
+
+func TestASNLimits(t *testing.T) {
+	mgr := newSubnetTestManager(t,
+		infiniteBaseLimit,
+		BaseLimit{ConnsInbound: 2, Conns: 2, FD: 2},
+	)
+
+	// all in AS15169, but in different /48 subnets
+	for _, addr := range []string{"/ip6/2001:4860:1::1/tcp/1", "/ip6/2001:4860:2::1/tcp/1"} {
+		_, err := mgr.OpenConnection(network.DirInbound, true, multiaddr.StringCast(addr))
+		require.NoError(t, err)
+	}
+	_, err := mgr.OpenConnection(network.DirInbound, true, multiaddr.StringCast("/ip6/2001:4860:4860::8888/tcp/1"))
+	require.ErrorIs(t, err, network.ErrResourceLimitExceeded)
+
+	// AS13335
+	_, err = mgr.OpenConnection(network.DirInbound, true, multiaddr.StringCast("/ip6/2606:4700::1111/tcp/1"))
+	require.NoError(t, err)
+
+	stat := mgr.Stat()
+	// includes the scope of the blocked connection, until it's garbage collected
+	require.Len(t, stat.Subnets, 4)
+	require.Equal(t, map[uint32]network.ScopeStat{
+		15169: {NumConnsInbound: 2, NumFD: 2},
+		13335: {NumConnsInbound: 1, NumFD: 1},
+	}, stat.ASNs)
+}
+
+func TestSubnetLimitsAllowlist(t *testing.T) {
+	allowlistedPeer := test.RandPeerIDFatal(t)
+	mgr := newSubnetTestManager(t,
+		BaseLimit{ConnsInbound: 1, Conns: 1, FD: 1},
+		infiniteBaseLimit,
+		WithAllowlistedMultiaddrs([]multiaddr.Multiaddr{multiaddr.StringCast("/ip4/1.2.3.5/p2p/" + allowlistedPeer.String())}),
+	)
+
+	c1, err := mgr.OpenConnection(network.DirInbound, true, multiaddr.StringCast("/ip4/1.2.3.4/tcp/1"))
+	require.NoError(t, err)
+	// allowlisted connections are not constrained by their subnet
+	c2, err := mgr.OpenConnection(network.DirInbound, true, multiaddr.StringCast("/ip4/1.2.3.5/tcp/1"))
+	require.NoError(t, err)
+	require.True(t, c2.(*connectionScope).isAllowlisted)
+
+	// once it turns out that the peer isn't allowlisted, the subnet limit applies
+	require.ErrorIs(t, c2.SetPeer(test.RandPeerIDFatal(t)), network.ErrResourceLimitExceeded)
+	c2.Done()
+
+	c3, err := mgr.OpenConnection(network.DirInbound, true, multiaddr.StringCast("/ip4/1.2.3.5/tcp/1"))
+	require.NoError(t, err)
+	require.NoError(t, c3.SetPeer(allowlistedPeer))
+	require.Equal(t, network.ScopeStat{NumConnsInbound: 1, NumFD: 1}, mgr.Stat().Subnets[netip.MustParsePrefix("1.2.3.0/24")])
+
+	c1.Done()
+	c3.Done()
+	c4, err := mgr.OpenConnection(network.DirInbound, true, multiaddr.StringCast("/ip4/1.2.3.5/tcp/1"))
+	require.NoError(t, err)
+	require.False(t, c4.(*connectionScope).isAllowlisted)
+	require.NoError(t, c4.SetPeer(test.RandPeerIDFatal(t)))
+	require.Equal(t, network.ScopeStat{NumConnsInbound: 1, NumFD: 1}, mgr.Stat().Subnets[netip.MustParsePrefix("1.2.3.0/24")])
+}
+
+func TestSubnetLimitsJSON(t *testing.T) {
+	in := []byte(`{"Subnet": {"ConnsInbound": 5}, "ASN": {"Conns": "unlimited"}}`)
+	l, err := NewLimiterFromJSON(bytes.NewReader(in), DefaultLimits.AutoScale())
+	require.NoError(t, err)
+
+	subnet := l.(IPLimiter).GetSubnetLimits(netip.MustParsePrefix("1.2.3.0/24"))
+	require.Equal(t, 5, subnet.GetConnLimit(network.DirInbound))
+	require.Equal(t, DefaultLimits.AutoScale().subnet.ConnsOutbound, subnet.GetConnLimit(network.DirOutbound))
+	require.Greater(t, l.(IPLimiter).GetASNLimits(1).GetConnTotalLimit(), 1<<30)
+
+	// configurations that don't set subnet and ASN limits don't limit them
+	cfg := ScalingLimitConfig{SystemBaseLimit: BaseLimit{Conns: 10}}
+	require.Equal(t, infiniteBaseLimit, cfg.Scale(1<<30, 100).subnet)
+	require.Equal(t, infiniteBaseLimit, cfg.Scale(1<<30, 100).asn)
+}
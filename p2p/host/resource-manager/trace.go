@@ -181,6 +181,30 @@ func (s scopeClass) MarshalJSON() ([]byte, error) {
 		}
 	}
 
+	if strings.HasPrefix(name, "subnet:") {
+		return json.Marshal(struct {
+			Class  string
+			Subnet string
+			Span   string `json:",omitempty"`
+		}{
+			Class:  "subnet",
+			Subnet: name[7:],
+			Span:   span,
+		})
+	}
+
+	if strings.HasPrefix(name, "asn:") {
+		return json.Marshal(struct {
+			Class string
+			ASN   string
+			Span  string `json:",omitempty"`
+		}{
+			Class: "asn",
+			ASN:   name[4:],
+			Span:  span,
+		})
+	}
+
 	return nil, fmt.Errorf("unrecognized scope: %s", name)
 }
 
@@ -334,6 +358,25 @@ func (t *trace) Start(limits Limiter) error {
 	return nil
 }
 
+func (t *trace) addReporter(reporter TraceReporter) {
+	t.mx.Lock()
+	defer t.mx.Unlock()
+
+	t.reporters = append(t.reporters, reporter)
+}
+
+func (t *trace) removeReporter(reporter TraceReporter) {
+	t.mx.Lock()
+	defer t.mx.Unlock()
+
+	for i, r := range t.reporters {
+		if r == reporter {
+			t.reporters = append(t.reporters[:i:i], t.reporters[i+1:]...)
+			return
+		}
+	}
+}
+
 func (t *trace) Close() error {
 	if t == nil {
 		return nil
@@ -0,0 +1,204 @@
+package tracereader
+
+import (
+	"sort"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/network"
+	rcmgr "github.com/libp2p/go-libp2p/p2p/host/resource-manager"
+)
+
+// Sample is the usage of a scope at a point in time.
+type Sample struct {
+	Time time.Time
+	Stat network.ScopeStat
+}
+
+// ScopeUsage is the usage of a scope over the course of a trace.
+type ScopeUsage struct {
+	Name string
+	// Samples contains the usage after every change. It is only recorded for
+	// scopes that are not connection, stream or span scopes.
+	Samples []Sample
+	// Peak is the peak usage of every resource. The peaks of the different
+	// resources may have been reached at different times.
+	Peak network.ScopeStat
+	// Blocks is the number of reservations blocked by this scope.
+	Blocks int
+}
+
+// Count is the number of blocked reservations attributed to a key.
+type Count struct {
+	Key    string
+	Blocks int
+}
+
+// Analyzer rebuilds the usage of all scopes from the events of a trace, and
+// records the reservations that were blocked.
+type Analyzer struct {
+	state *state
+
+	start, end time.Time
+	events     int
+	scopes     map[string]*ScopeUsage
+	blocks     []Block
+}
+
+// NewAnalyzer creates a new Analyzer.
+func NewAnalyzer() *Analyzer {
+	return &Analyzer{
+		state:  newState(),
+		scopes: make(map[string]*ScopeUsage),
+	}
+}
+
+// Consume processes the next event of the trace.
+func (a *Analyzer) Consume(evt Event) {
+	if a.events == 0 {
+		a.start = evt.Timestamp
+	}
+	a.end = evt.Timestamp
+	a.events++
+
+	res, kind, before, after := a.state.apply(evt)
+	if evt.Type == rcmgr.TraceDestroyScopeEvt && isShortLived(evt.Name) {
+		delete(a.scopes, evt.Name)
+		return
+	}
+	if kind == kindOther {
+		return
+	}
+
+	u := a.scope(evt.Name)
+	if kind == kindBlock {
+		u.Blocks++
+		a.blocks = append(a.blocks, a.state.block(evt, res, before))
+		return
+	}
+	if !isShortLived(evt.Name) {
+		u.Samples = append(u.Samples, Sample{Time: evt.Timestamp, Stat: after})
+	}
+	u.Peak.Memory = max(u.Peak.Memory, after.Memory)
+	u.Peak.NumStreamsInbound = max(u.Peak.NumStreamsInbound, after.NumStreamsInbound)
+	u.Peak.NumStreamsOutbound = max(u.Peak.NumStreamsOutbound, after.NumStreamsOutbound)
+	u.Peak.NumConnsInbound = max(u.Peak.NumConnsInbound, after.NumConnsInbound)
+	u.Peak.NumConnsOutbound = max(u.Peak.NumConnsOutbound, after.NumConnsOutbound)
+	u.Peak.NumFD = max(u.Peak.NumFD, after.NumFD)
+}
+
+func (a *Analyzer) scope(name string) *ScopeUsage {
+	u, ok := a.scopes[name]
+	if !ok {
+		u = &ScopeUsage{Name: name}
+		a.scopes[name] = u
+	}
+	return u
+}
+
+func isShortLived(name string) bool {
+	return rcmgr.IsConnScope(name) || rcmgr.IsStreamScope(name) || rcmgr.IsSpan(name)
+}
+
+// Start returns the time of the first event.
+func (a *Analyzer) Start() time.Time { return a.start }
+
+// End returns the time of the last event.
+func (a *Analyzer) End() time.Time { return a.end }
+
+// NumEvents returns the number of events consumed.
+func (a *Analyzer) NumEvents() int { return a.events }
+
+// Scope returns the usage of the scope with the given name, or nil if the
+// scope wasn't used. The usage of connection and stream scopes is discarded
+// when the scope is destroyed.
+func (a *Analyzer) Scope(name string) *ScopeUsage {
+	return a.scopes[name]
+}
+
+// Scopes returns the names of all scopes that were used, sorted by name.
+func (a *Analyzer) Scopes() []string {
+	names := make([]string, 0, len(a.scopes))
+	for name := range a.scopes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Blocks returns all blocked reservations, in the order they happened.
+func (a *Analyzer) Blocks() []Block {
+	return a.blocks
+}
+
+// TopScopes returns the n scopes that blocked the most reservations.
+// Connection and stream scopes are grouped into "conn" and "stream".
+func (a *Analyzer) TopScopes(n int) []Count {
+	return topCounts(a.blocks, n, func(b Block) []string {
+		switch {
+		case rcmgr.IsConnScope(b.Scope):
+			return []string{"conn"}
+		case rcmgr.IsStreamScope(b.Scope):
+			return []string{"stream"}
+		}
+		return []string{b.Scope}
+	})
+}
+
+// TopPeers returns the n peers that the most blocked reservations were
+// attributed to.
+func (a *Analyzer) TopPeers(n int) []Count {
+	return TopPeers(a.blocks, n)
+}
+
+// TopProtocols returns the n protocols that the most blocked reservations
+// were attributed to.
+func (a *Analyzer) TopProtocols(n int) []Count {
+	return TopProtocols(a.blocks, n)
+}
+
+// TopPeers returns the n peers that the most blocks were attributed to.
+func TopPeers(blocks []Block, n int) []Count {
+	return topCounts(blocks, n, func(b Block) []string { return b.Peers })
+}
+
+// TopProtocols returns the n protocols that the most blocks were attributed
+// to.
+func TopProtocols(blocks []Block, n int) []Count {
+	return topCounts(blocks, n, func(b Block) []string {
+		keys := make([]string, 0, len(b.Protocols))
+		for _, p := range b.Protocols {
+			keys = append(keys, string(p))
+		}
+		return keys
+	})
+}
+
+// TopScopes returns the n scopes that the most blocks happened in.
+func TopScopes(blocks []Block, n int) []Count {
+	return topCounts(blocks, n, func(b Block) []string { return []string{b.Scope} })
+}
+
+// topCounts counts the blocks by the keys returned by keys, and returns the
+// n keys with the highest count. If n is 0, all keys are returned.
+func topCounts(blocks []Block, n int, keys func(Block) []string) []Count {
+	counts := make(map[string]int)
+	for _, b := range blocks {
+		for _, k := range keys(b) {
+			counts[k]++
+		}
+	}
+	res := make([]Count, 0, len(counts))
+	for k, c := range counts {
+		res = append(res, Count{Key: k, Blocks: c})
+	}
+	sort.Slice(res, func(i, j int) bool {
+		if res[i].Blocks != res[j].Blocks {
+			return res[i].Blocks > res[j].Blocks
+		}
+		return res[i].Key < res[j].Key
+	})
+	if n > 0 && len(res) > n {
+		res = res[:n]
+	}
+	return res
+}
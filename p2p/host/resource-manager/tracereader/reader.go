@@ -0,0 +1,100 @@
+// Package tracereader reads the traces written by the resource manager (see
+// rcmgr.WithTrace), and analyzes them offline: it rebuilds the usage of the
+// scopes over time, finds the limits that were hit, and replays traces against
+// candidate limits.
+package tracereader
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	rcmgr "github.com/libp2p/go-libp2p/p2p/host/resource-manager"
+)
+
+// Event is an event read from a trace.
+type Event struct {
+	rcmgr.TraceEvt
+
+	// Timestamp is the parsed Time of the event.
+	Timestamp time.Time
+}
+
+// Reader reads events from a gzipped trace, as written by rcmgr.WithTrace.
+type Reader struct {
+	gz     *gzip.Reader
+	dec    *json.Decoder
+	closer io.Closer
+}
+
+// NewReader creates a Reader reading the gzipped trace from r.
+func NewReader(r io.Reader) (*Reader, error) {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open trace: %w", err)
+	}
+	return &Reader{gz: gz, dec: json.NewDecoder(gz)}, nil
+}
+
+// Open opens the trace file at path.
+func Open(path string) (*Reader, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	r, err := NewReader(f)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	r.closer = f
+	return r, nil
+}
+
+// Next returns the next event. It returns io.EOF at the end of the trace.
+// Traces of processes that didn't shut down cleanly are usually truncated,
+// in that case io.ErrUnexpectedEOF is returned after the last complete event.
+func (r *Reader) Next() (Event, error) {
+	var evt Event
+	if err := r.dec.Decode(&evt.TraceEvt); err != nil {
+		return Event{}, err
+	}
+	t, err := time.Parse(time.RFC3339Nano, evt.Time)
+	if err != nil {
+		return Event{}, fmt.Errorf("invalid event time %q: %w", evt.Time, err)
+	}
+	evt.Timestamp = t
+	return evt, nil
+}
+
+// Close closes the reader, and the underlying file if the reader was created
+// using Open.
+func (r *Reader) Close() error {
+	err := r.gz.Close()
+	if r.closer != nil {
+		if cerr := r.closer.Close(); err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
+
+// ForEach calls f for every event read from r, until the end of the trace or
+// until f returns an error. A truncated trace is not considered an error.
+func ForEach(r *Reader, f func(Event) error) error {
+	for {
+		evt, err := r.Next()
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err := f(evt); err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,124 @@
+package tracereader
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/protocol"
+	"github.com/libp2p/go-libp2p/core/test"
+	rcmgr "github.com/libp2p/go-libp2p/p2p/host/resource-manager"
+
+	"github.com/stretchr/testify/require"
+)
+
+func readAll(t *testing.T, path string) []Event {
+	t.Helper()
+	r, err := Open(path)
+	require.NoError(t, err)
+	defer r.Close()
+	var events []Event
+	require.NoError(t, ForEach(r, func(evt Event) error {
+		events = append(events, evt)
+		return nil
+	}))
+	return events
+}
+
+func TestAnalyzeAndReplay(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "trace.json.gz")
+	limits := rcmgr.PartialLimitConfig{
+		System:          rcmgr.ResourceLimits{Streams: 10, StreamsOutbound: 10},
+		ProtocolDefault: rcmgr.ResourceLimits{Streams: 2, StreamsOutbound: 2},
+	}.Build(rcmgr.InfiniteLimits)
+	mgr, err := rcmgr.NewResourceManager(rcmgr.NewFixedLimiter(limits), rcmgr.WithTrace(path), rcmgr.WithMetricsDisabled())
+	require.NoError(t, err)
+
+	p := test.RandPeerIDFatal(t)
+	const proto = protocol.ID("/test")
+	var streams []network.StreamManagementScope
+	for i := 0; i < 3; i++ {
+		s, err := mgr.OpenStream(p, network.DirOutbound)
+		require.NoError(t, err)
+		err = s.SetProtocol(proto)
+		if i < 2 {
+			require.NoError(t, err)
+			streams = append(streams, s)
+		} else {
+			require.Error(t, err)
+			s.Done()
+		}
+	}
+	for _, s := range streams {
+		s.Done()
+	}
+	require.NoError(t, mgr.Close())
+
+	events := readAll(t, path)
+	require.NotEmpty(t, events)
+	require.Equal(t, rcmgr.TraceStartEvt, events[0].Type)
+
+	a := NewAnalyzer()
+	for _, evt := range events {
+		a.Consume(evt)
+	}
+	require.Equal(t, len(events), a.NumEvents())
+	require.False(t, a.Start().After(a.End()))
+
+	require.Len(t, a.Blocks(), 1)
+	b := a.Blocks()[0]
+	require.Equal(t, "protocol:/test", b.Scope)
+	require.Equal(t, ResourceStreams, b.Resource)
+	require.Equal(t, 2, b.Stat.NumStreamsOutbound)
+	require.Equal(t, []protocol.ID{proto}, b.Protocols)
+	require.Equal(t, []string{p.String()}, b.Peers)
+	require.Equal(t, []Count{{Key: p.String(), Blocks: 1}}, a.TopPeers(10))
+	require.Equal(t, []Count{{Key: string(proto), Blocks: 1}}, a.TopProtocols(10))
+	require.Equal(t, []Count{{Key: "protocol:/test", Blocks: 1}}, a.TopScopes(10))
+
+	system := a.Scope("system")
+	require.NotNil(t, system)
+	require.Equal(t, 3, system.Peak.NumStreamsOutbound)
+	require.Equal(t, 0, system.Samples[len(system.Samples)-1].Stat.NumStreamsOutbound)
+	require.Equal(t, 1, a.Scope("protocol:/test").Blocks)
+
+	// more generous protocol limits, but a tighter system limit
+	candidate := rcmgr.PartialLimitConfig{
+		System:          rcmgr.ResourceLimits{Streams: 2, StreamsOutbound: 2},
+		ProtocolDefault: rcmgr.ResourceLimits{Streams: 5, StreamsOutbound: 5},
+	}.Build(rcmgr.InfiniteLimits)
+	r := NewReplayer(rcmgr.NewFixedLimiter(candidate))
+	for _, evt := range events {
+		r.Consume(evt)
+	}
+	require.Len(t, r.Unblocked(), 1)
+	require.Equal(t, "protocol:/test", r.Unblocked()[0].Scope)
+	require.Len(t, r.Blocked(), 1)
+	require.Equal(t, "system", r.Blocked()[0].Scope)
+	require.Equal(t, []string{p.String()}, r.Blocked()[0].Peers)
+}
+
+func TestParseScopeName(t *testing.T) {
+	for name, expected := range map[string]ScopeInfo{
+		"system":                      {Class: "system"},
+		"allowlistedTransient":        {Class: "allowlistedTransient"},
+		"conn-12":                     {Class: "conn"},
+		"stream-3":                    {Class: "stream"},
+		"peer:QmPeer":                 {Class: "peer", Peer: "QmPeer"},
+		"service:svc":                 {Class: "service", Service: "svc"},
+		"service:svc.peer:QmPeer":     {Class: "service-peer", Service: "svc", Peer: "QmPeer"},
+		"protocol:/a/1.0":             {Class: "protocol", Protocol: "/a/1.0"},
+		"protocol:/a/1.0.peer:QmPeer": {Class: "protocol-peer", Protocol: "/a/1.0", Peer: "QmPeer"},
+		"subnet:1.2.3.0/24":           {Class: "subnet", Subnet: "1.2.3.0/24"},
+		"asn:1234":                    {Class: "asn", ASN: "1234"},
+	} {
+		info, ok := ParseScopeName(name)
+		require.True(t, ok, name)
+		require.Equal(t, expected, info, name)
+	}
+
+	_, ok := ParseScopeName("conn-12.span-1")
+	require.False(t, ok)
+	_, ok = ParseScopeName("foobar")
+	require.False(t, ok)
+}
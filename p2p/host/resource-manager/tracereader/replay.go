@@ -0,0 +1,59 @@
+package tracereader
+
+import (
+	rcmgr "github.com/libp2p/go-libp2p/p2p/host/resource-manager"
+)
+
+// Replayer replays a trace against a set of candidate limits, and reports
+// the reservations that would have been blocked by these limits, and the
+// blocked reservations that would have been allowed.
+//
+// Every reservation is checked against the usage recorded in the trace. The
+// effects that blocking (or allowing) a reservation would have had on the
+// following reservations are not simulated. A blocked reservation that would
+// have been allowed by the scope that blocked it might still have been
+// blocked by another scope.
+type Replayer struct {
+	limits rcmgr.Limiter
+	state  *state
+
+	blocked   []Block
+	unblocked []Block
+}
+
+// NewReplayer creates a Replayer checking reservations against limits.
+// Use rcmgr.NewFixedLimiter to replay against a ConcreteLimitConfig.
+func NewReplayer(limits rcmgr.Limiter) *Replayer {
+	return &Replayer{limits: limits, state: newState()}
+}
+
+// Consume processes the next event of the trace.
+func (r *Replayer) Consume(evt Event) {
+	res, kind, before, _ := r.state.apply(evt)
+	if kind != kindAdd && kind != kindBlock {
+		return
+	}
+	limit := limitFor(r.limits, evt.Name)
+	if limit == nil {
+		return
+	}
+	exceeded := exceeds(limit, res, evt, before)
+	switch {
+	case kind == kindAdd && exceeded:
+		r.blocked = append(r.blocked, r.state.block(evt, res, before))
+	case kind == kindBlock && !exceeded:
+		r.unblocked = append(r.unblocked, r.state.block(evt, res, before))
+	}
+}
+
+// Blocked returns the reservations that succeeded, but would have been
+// blocked by the candidate limits.
+func (r *Replayer) Blocked() []Block {
+	return r.blocked
+}
+
+// Unblocked returns the reservations that were blocked, but would have been
+// allowed by the candidate limits.
+func (r *Replayer) Unblocked() []Block {
+	return r.unblocked
+}
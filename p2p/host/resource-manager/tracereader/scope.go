@@ -0,0 +1,115 @@
+package tracereader
+
+import (
+	"net/netip"
+	"strconv"
+	"strings"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/protocol"
+	rcmgr "github.com/libp2p/go-libp2p/p2p/host/resource-manager"
+)
+
+// ScopeInfo is the information encoded in the name of a scope.
+type ScopeInfo struct {
+	// Class is the class of the scope, like in the JSON representation of
+	// the scope in trace events, e.g. "system", "peer" or "protocol-peer".
+	Class    string
+	Service  string
+	Protocol protocol.ID
+	// Peer is the string representation of the peer ID.
+	Peer   string
+	Subnet string
+	ASN    string
+}
+
+// ParseScopeName parses the name of a scope. ok is false if the name wasn't
+// recognized, or if it's the name of a span.
+func ParseScopeName(name string) (info ScopeInfo, ok bool) {
+	if rcmgr.IsSpan(name) {
+		return ScopeInfo{}, false
+	}
+	switch {
+	case name == "system" || name == "transient" || name == "allowlistedSystem" || name == "allowlistedTransient":
+		return ScopeInfo{Class: name}, true
+	case rcmgr.IsConnScope(name):
+		return ScopeInfo{Class: "conn"}, true
+	case rcmgr.IsStreamScope(name):
+		return ScopeInfo{Class: "stream"}, true
+	case rcmgr.IsSubnetScope(name):
+		return ScopeInfo{Class: "subnet", Subnet: strings.TrimPrefix(name, "subnet:")}, true
+	case rcmgr.IsASNScope(name):
+		return ScopeInfo{Class: "asn", ASN: strings.TrimPrefix(name, "asn:")}, true
+	case strings.HasPrefix(name, "peer:"):
+		return ScopeInfo{Class: "peer", Peer: name[len("peer:"):]}, true
+	case strings.HasPrefix(name, "service:"):
+		svc := name[len("service:"):]
+		if idx := strings.LastIndex(svc, ".peer:"); idx >= 0 {
+			return ScopeInfo{Class: "service-peer", Service: svc[:idx], Peer: svc[idx+len(".peer:"):]}, true
+		}
+		return ScopeInfo{Class: "service", Service: svc}, true
+	case strings.HasPrefix(name, "protocol:"):
+		proto := name[len("protocol:"):]
+		if idx := strings.LastIndex(proto, ".peer:"); idx >= 0 {
+			return ScopeInfo{Class: "protocol-peer", Protocol: protocol.ID(proto[:idx]), Peer: proto[idx+len(".peer:"):]}, true
+		}
+		return ScopeInfo{Class: "protocol", Protocol: protocol.ID(proto)}, true
+	}
+	return ScopeInfo{}, false
+}
+
+// limitFor returns the limit l sets for the scope with the given name, or nil
+// if the scope isn't known.
+func limitFor(l rcmgr.Limiter, name string) rcmgr.Limit {
+	info, ok := ParseScopeName(name)
+	if !ok {
+		return nil
+	}
+	switch info.Class {
+	case "system":
+		return l.GetSystemLimits()
+	case "transient":
+		return l.GetTransientLimits()
+	case "allowlistedSystem":
+		return l.GetAllowlistedSystemLimits()
+	case "allowlistedTransient":
+		return l.GetAllowlistedTransientLimits()
+	case "conn":
+		return l.GetConnLimits()
+	case "stream":
+		// stream scopes are named after a counter, the peer is unknown
+		return l.GetStreamLimits("")
+	case "peer":
+		p, _ := peer.Decode(info.Peer)
+		return l.GetPeerLimits(p)
+	case "service":
+		return l.GetServiceLimits(info.Service)
+	case "service-peer":
+		return l.GetServicePeerLimits(info.Service)
+	case "protocol":
+		return l.GetProtocolLimits(info.Protocol)
+	case "protocol-peer":
+		return l.GetProtocolPeerLimits(info.Protocol)
+	case "subnet":
+		ipl, ok := l.(rcmgr.IPLimiter)
+		if !ok {
+			return nil
+		}
+		prefix, err := netip.ParsePrefix(info.Subnet)
+		if err != nil {
+			return nil
+		}
+		return ipl.GetSubnetLimits(prefix)
+	case "asn":
+		ipl, ok := l.(rcmgr.IPLimiter)
+		if !ok {
+			return nil
+		}
+		asn, err := strconv.ParseUint(info.ASN, 10, 32)
+		if err != nil {
+			return nil
+		}
+		return ipl.GetASNLimits(uint32(asn))
+	}
+	return nil
+}
@@ -0,0 +1,255 @@
+package tracereader
+
+import (
+	"math"
+	"math/big"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/protocol"
+	rcmgr "github.com/libp2p/go-libp2p/p2p/host/resource-manager"
+)
+
+// Resource is a resource limited by the resource manager.
+type Resource string
+
+const (
+	ResourceMemory  Resource = "memory"
+	ResourceStreams Resource = "streams"
+	ResourceConns   Resource = "conns"
+)
+
+type eventKind int
+
+const (
+	kindOther eventKind = iota
+	kindAdd
+	kindBlock
+	kindRemove
+)
+
+func classify(typ rcmgr.TraceEvtTyp) (Resource, eventKind) {
+	switch typ {
+	case rcmgr.TraceReserveMemoryEvt:
+		return ResourceMemory, kindAdd
+	case rcmgr.TraceBlockReserveMemoryEvt:
+		return ResourceMemory, kindBlock
+	case rcmgr.TraceReleaseMemoryEvt:
+		return ResourceMemory, kindRemove
+	case rcmgr.TraceAddStreamEvt:
+		return ResourceStreams, kindAdd
+	case rcmgr.TraceBlockAddStreamEvt:
+		return ResourceStreams, kindBlock
+	case rcmgr.TraceRemoveStreamEvt:
+		return ResourceStreams, kindRemove
+	case rcmgr.TraceAddConnEvt:
+		return ResourceConns, kindAdd
+	case rcmgr.TraceBlockAddConnEvt:
+		return ResourceConns, kindBlock
+	case rcmgr.TraceRemoveConnEvt:
+		return ResourceConns, kindRemove
+	}
+	return "", kindOther
+}
+
+// Block is a reservation that was (or would have been) blocked by a limit.
+type Block struct {
+	Time time.Time
+	// Scope is the name of the scope whose limit blocked the reservation.
+	Scope    string
+	Resource Resource
+	// Stat is the usage of the scope before the reservation.
+	Stat network.ScopeStat
+	// Delta is the amount of memory for memory reservations, and the number
+	// of file descriptors for connections.
+	Delta    int64
+	DeltaIn  int
+	DeltaOut int
+	Priority uint8
+
+	// Peers, Protocols and Services are the peers, protocols and services the
+	// reservation was made for, as far as they can be determined from the
+	// trace. The trace doesn't record the relationship between scopes, so
+	// they are inferred from the scopes the reservation was made in before
+	// it was blocked.
+	Peers     []string
+	Protocols []protocol.ID
+	Services  []string
+}
+
+// maxChainLen is the maximum number of scopes a single reservation is
+// expected to be made in.
+const maxChainLen = 16
+
+// state tracks the usage of all scopes while reading a trace.
+type state struct {
+	stats map[string]*network.ScopeStat
+
+	// chain is the list of scopes the current reservation was made in.
+	// Reservations are first made in the ancestors of a scope, and blocked
+	// reservations are rolled back, so this allows attributing a blocked
+	// reservation to the peers and protocols of the scopes it was made in.
+	chainRes Resource
+	chain    []string
+}
+
+func newState() *state {
+	return &state{stats: make(map[string]*network.ScopeStat)}
+}
+
+// apply applies evt to the state. before is the usage of the scope before
+// the event, after the usage after the event.
+func (s *state) apply(evt Event) (res Resource, kind eventKind, before, after network.ScopeStat) {
+	res, kind = classify(evt.Type)
+	switch evt.Type {
+	case rcmgr.TraceCreateScopeEvt:
+		s.stats[evt.Name] = &network.ScopeStat{}
+		return
+	case rcmgr.TraceDestroyScopeEvt:
+		delete(s.stats, evt.Name)
+		return
+	}
+	if kind == kindOther || evt.Name == "" {
+		return
+	}
+
+	stat, ok := s.stats[evt.Name]
+	if !ok {
+		// the scope was created before the trace started
+		stat = &network.ScopeStat{}
+		s.stats[evt.Name] = stat
+	}
+	// events contain the absolute usage of the scope after the event
+	switch res {
+	case ResourceMemory:
+		stat.Memory = evt.Memory
+	case ResourceStreams:
+		stat.NumStreamsInbound = evt.StreamsIn
+		stat.NumStreamsOutbound = evt.StreamsOut
+	case ResourceConns:
+		stat.NumConnsInbound = evt.ConnsIn
+		stat.NumConnsOutbound = evt.ConnsOut
+		stat.NumFD = evt.FD
+	}
+	after = *stat
+	before = after
+	if kind == kindAdd {
+		switch res {
+		case ResourceMemory:
+			before.Memory -= evt.Delta
+		case ResourceStreams:
+			before.NumStreamsInbound -= evt.DeltaIn
+			before.NumStreamsOutbound -= evt.DeltaOut
+		case ResourceConns:
+			before.NumConnsInbound -= evt.DeltaIn
+			before.NumConnsOutbound -= evt.DeltaOut
+			before.NumFD -= int(evt.Delta)
+		}
+	}
+
+	switch kind {
+	case kindAdd:
+		s.extendChain(res, evt.Name)
+	case kindBlock:
+		// the chain is used by the caller through block
+	default:
+		s.chain = s.chain[:0]
+	}
+	return res, kind, before, after
+}
+
+func (s *state) extendChain(res Resource, name string) {
+	if res != s.chainRes || len(s.chain) >= maxChainLen {
+		s.chain = s.chain[:0]
+		s.chainRes = res
+	}
+	for _, n := range s.chain {
+		if n == name {
+			// the scope is part of the previous reservation
+			s.chain = s.chain[:0]
+			break
+		}
+	}
+	s.chain = append(s.chain, name)
+}
+
+// block creates a Block for evt, blocked in a scope with the usage before.
+func (s *state) block(evt Event, res Resource, before network.ScopeStat) Block {
+	b := Block{
+		Time:     evt.Timestamp,
+		Scope:    evt.Name,
+		Resource: res,
+		Stat:     before,
+		Delta:    evt.Delta,
+		DeltaIn:  evt.DeltaIn,
+		DeltaOut: evt.DeltaOut,
+		Priority: evt.Priority,
+	}
+	names := []string{evt.Name}
+	if res == s.chainRes {
+		names = append(names, s.chain...)
+	}
+	for _, name := range names {
+		info, ok := ParseScopeName(name)
+		if !ok {
+			continue
+		}
+		if info.Peer != "" && !contains(b.Peers, info.Peer) {
+			b.Peers = append(b.Peers, info.Peer)
+		}
+		if info.Protocol != "" && !contains(b.Protocols, info.Protocol) {
+			b.Protocols = append(b.Protocols, info.Protocol)
+		}
+		if info.Service != "" && !contains(b.Services, info.Service) {
+			b.Services = append(b.Services, info.Service)
+		}
+	}
+	return b
+}
+
+func contains[T comparable](s []T, v T) bool {
+	for _, e := range s {
+		if e == v {
+			return true
+		}
+	}
+	return false
+}
+
+// exceeds checks if the reservation of evt, made in a scope with the usage
+// before, exceeds limit. This mirrors the checks done by the resource manager.
+func exceeds(limit rcmgr.Limit, res Resource, evt Event, before network.ScopeStat) bool {
+	switch res {
+	case ResourceMemory:
+		l := limit.GetMemoryLimit()
+		if l == math.MaxInt64 {
+			return false
+		}
+		threshold := new(big.Int).Mul(big.NewInt(l), big.NewInt(1+int64(evt.Priority)))
+		threshold.Rsh(threshold, 8)
+		mem := new(big.Int).Add(big.NewInt(before.Memory), big.NewInt(evt.Delta))
+		return mem.Cmp(threshold) > 0
+	case ResourceStreams:
+		in, out := evt.DeltaIn, evt.DeltaOut
+		if in > 0 && before.NumStreamsInbound+in > limit.GetStreamLimit(network.DirInbound) {
+			return true
+		}
+		if out > 0 && before.NumStreamsOutbound+out > limit.GetStreamLimit(network.DirOutbound) {
+			return true
+		}
+		return before.NumStreamsInbound+in+before.NumStreamsOutbound+out > limit.GetStreamTotalLimit()
+	case ResourceConns:
+		in, out, fd := evt.DeltaIn, evt.DeltaOut, int(evt.Delta)
+		if in > 0 && before.NumConnsInbound+in > limit.GetConnLimit(network.DirInbound) {
+			return true
+		}
+		if out > 0 && before.NumConnsOutbound+out > limit.GetConnLimit(network.DirOutbound) {
+			return true
+		}
+		if before.NumConnsInbound+in+before.NumConnsOutbound+out > limit.GetConnTotalLimit() {
+			return true
+		}
+		return fd > 0 && before.NumFD+fd > limit.GetFDLimit()
+	}
+	return false
+}
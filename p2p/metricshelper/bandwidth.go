@@ -0,0 +1,42 @@
+package metricshelper
+
+import (
+	"github.com/libp2p/go-libp2p/core/metrics"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// TransportBandwidthReporter is a bandwidth reporter that breaks bandwidth
+// down by transport, like metrics.ShardedBandwidthCounter.
+type TransportBandwidthReporter interface {
+	GetBandwidthByTransport() map[string]metrics.Stats
+}
+
+var bandwidthDesc = prometheus.NewDesc(
+	"libp2p_bandwidth_transport_bytes_total",
+	"Bytes transferred over connections, by transport",
+	[]string{"transport", "dir"},
+	nil,
+)
+
+type bandwidthCollector struct {
+	r TransportBandwidthReporter
+}
+
+// NewBandwidthCollector returns a collector exporting the bandwidth metrics of
+// r to Prometheus. The metrics are read from r when they are collected, so
+// recording bandwidth doesn't incur any additional cost.
+func NewBandwidthCollector(r TransportBandwidthReporter) prometheus.Collector {
+	return &bandwidthCollector{r: r}
+}
+
+func (c *bandwidthCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- bandwidthDesc
+}
+
+func (c *bandwidthCollector) Collect(ch chan<- prometheus.Metric) {
+	for tpt, st := range c.r.GetBandwidthByTransport() {
+		ch <- prometheus.MustNewConstMetric(bandwidthDesc, prometheus.CounterValue, float64(st.TotalIn), tpt, "inbound")
+		ch <- prometheus.MustNewConstMetric(bandwidthDesc, prometheus.CounterValue, float64(st.TotalOut), tpt, "outbound")
+	}
+}
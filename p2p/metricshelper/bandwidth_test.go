@@ -0,0 +1,33 @@
+package metricshelper
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/libp2p/go-libp2p/core/metrics"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+)
+
+type staticBandwidthReporter map[string]metrics.Stats
+
+func (r staticBandwidthReporter) GetBandwidthByTransport() map[string]metrics.Stats { return r }
+
+func TestBandwidthCollector(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	RegisterCollectors(reg, NewBandwidthCollector(staticBandwidthReporter{
+		"tcp":     {TotalIn: 10, TotalOut: 20},
+		"quic-v1": {TotalIn: 30, TotalOut: 40},
+	}))
+
+	require.NoError(t, testutil.GatherAndCompare(reg, strings.NewReader(`
+# HELP libp2p_bandwidth_transport_bytes_total Bytes transferred over connections, by transport
+# TYPE libp2p_bandwidth_transport_bytes_total counter
+libp2p_bandwidth_transport_bytes_total{dir="inbound",transport="quic-v1"} 30
+libp2p_bandwidth_transport_bytes_total{dir="inbound",transport="tcp"} 10
+libp2p_bandwidth_transport_bytes_total{dir="outbound",transport="quic-v1"} 40
+libp2p_bandwidth_transport_bytes_total{dir="outbound",transport="tcp"} 20
+`)))
+}
@@ -1,11 +1,12 @@
 package yamux
 
 import (
+	"errors"
 	"time"
 
 	"github.com/libp2p/go-libp2p/core/network"
 
-	"github.com/libp2p/go-yamux/v4"
+	"github.com/libp2p/go-yamux/v5"
 )
 
 // stream implements mux.MuxedStream over yamux.Stream.
@@ -13,22 +14,42 @@ type stream yamux.Stream
 
 var _ network.MuxedStream = &stream{}
 
-func (s *stream) Read(b []byte) (n int, err error) {
-	n, err = s.yamux().Read(b)
-	if err == yamux.ErrStreamReset {
-		err = network.ErrReset
+// parseError converts the errors returned by yamux to the libp2p stream and
+// connection errors, carrying the error code sent by the peer.
+func parseError(err error) error {
+	if err == nil {
+		return err
+	}
+	se := &yamux.StreamError{}
+	if errors.As(err, &se) {
+		return &network.StreamError{
+			ErrorCode:      network.StreamErrorCode(se.ErrorCode),
+			Remote:         se.Remote,
+			TransportError: err,
+		}
 	}
+	ge := &yamux.GoAwayError{}
+	if errors.As(err, &ge) {
+		return &network.ConnError{
+			ErrorCode:      network.ConnErrorCode(ge.ErrorCode),
+			Remote:         ge.Remote,
+			TransportError: err,
+		}
+	}
+	if errors.Is(err, yamux.ErrStreamReset) {
+		return network.ErrReset
+	}
+	return err
+}
 
-	return n, err
+func (s *stream) Read(b []byte) (n int, err error) {
+	n, err = s.yamux().Read(b)
+	return n, parseError(err)
 }
 
 func (s *stream) Write(b []byte) (n int, err error) {
 	n, err = s.yamux().Write(b)
-	if err == yamux.ErrStreamReset {
-		err = network.ErrReset
-	}
-
-	return n, err
+	return n, parseError(err)
 }
 
 func (s *stream) Close() error {
@@ -39,6 +60,11 @@ func (s *stream) Reset() error {
 	return s.yamux().Reset()
 }
 
+// ResetWithError resets the stream, sending the error code to the remote peer.
+func (s *stream) ResetWithError(errCode network.StreamErrorCode) error {
+	return s.yamux().ResetWithError(uint32(errCode))
+}
+
 func (s *stream) CloseRead() error {
 	return s.yamux().CloseRead()
 }
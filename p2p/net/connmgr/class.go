@@ -0,0 +1,147 @@
+package connmgr
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/network"
+
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+// Transport classes of connections.
+const (
+	TransportTCP          = "tcp"
+	TransportQUIC         = "quic"
+	TransportWebTransport = "webtransport"
+	TransportWebRTC       = "webrtc"
+	TransportWebSocket    = "websocket"
+	TransportRelay        = "relay"
+	TransportOther        = "other"
+)
+
+// transportClasses maps the protocols identifying a transport class to it.
+// They are checked in order: a relayed connection is a relay connection,
+// whatever the transport used to connect to the relay.
+var transportClasses = []struct {
+	code  int
+	class string
+}{
+	{ma.P_CIRCUIT, TransportRelay},
+	{ma.P_WEBRTC, TransportWebRTC},
+	{ma.P_WEBRTC_DIRECT, TransportWebRTC},
+	{ma.P_WEBTRANSPORT, TransportWebTransport},
+	{ma.P_QUIC, TransportQUIC},
+	{ma.P_QUIC_V1, TransportQUIC},
+	{ma.P_WS, TransportWebSocket},
+	{ma.P_WSS, TransportWebSocket},
+	{ma.P_TCP, TransportTCP},
+}
+
+// TransportClass returns the transport class of a connection to addr.
+func TransportClass(addr ma.Multiaddr) string {
+	for _, tc := range transportClasses {
+		if _, err := addr.ValueForProtocol(tc.code); err == nil {
+			return tc.class
+		}
+	}
+	return TransportOther
+}
+
+func isTransportClass(class string) bool {
+	if class == TransportOther {
+		return true
+	}
+	for _, tc := range transportClasses {
+		if tc.class == class {
+			return true
+		}
+	}
+	return false
+}
+
+// ConnClass is a class of connections that has its own watermarks, see
+// WithClassLimits.
+type ConnClass struct {
+	// Transport is the transport class of the connections, one of the
+	// Transport constants. If empty, the class includes the connections of
+	// all transports.
+	Transport string
+	// Direction is the direction of the connections. If DirUnknown, the
+	// class includes both inbound and outbound connections.
+	Direction network.Direction
+}
+
+func (cl ConnClass) String() string {
+	tpt := cl.Transport
+	if tpt == "" {
+		tpt = "all"
+	}
+	switch cl.Direction {
+	case network.DirInbound:
+		return tpt + "/inbound"
+	case network.DirOutbound:
+		return tpt + "/outbound"
+	default:
+		return tpt
+	}
+}
+
+// contains reports whether the connection c, over transport class tpt,
+// belongs to the class.
+func (cl ConnClass) contains(c network.Conn, tpt string) bool {
+	if cl.Direction != network.DirUnknown && c.Stat().Direction != cl.Direction {
+		return false
+	}
+	return cl.Transport == "" || cl.Transport == tpt
+}
+
+// ClassLimits are the watermarks and grace period of a class of connections.
+type ClassLimits struct {
+	// LowWater and HighWater are the watermarks of the class. When the
+	// number of connections in the class exceeds the high watermark,
+	// connections of the class are closed until the low watermark is
+	// reached. A zero high watermark disables the limits.
+	LowWater, HighWater int
+	// GracePeriod is the time a new connection of the class is given
+	// before it becomes subject to trimming. If zero, the grace period of
+	// the connection manager is used, unless NoGracePeriod is set.
+	GracePeriod time.Duration
+	// NoGracePeriod makes new connections of the class subject to trimming
+	// right away. GracePeriod must be zero if it's set.
+	NoGracePeriod bool
+}
+
+// classConfig is the configuration of a class of connections.
+type classConfig struct {
+	class ConnClass
+	ClassLimits
+}
+
+func (cc *classConfig) validate() error {
+	if cc.class.Transport != "" && !isTransportClass(cc.class.Transport) {
+		return fmt.Errorf("unknown transport class: %s", cc.class.Transport)
+	}
+	if cc.class.Direction != network.DirUnknown && cc.class.Direction != network.DirInbound && cc.class.Direction != network.DirOutbound {
+		return fmt.Errorf("invalid direction: %d", cc.class.Direction)
+	}
+	if cc.LowWater < 0 || cc.HighWater < cc.LowWater {
+		return fmt.Errorf("invalid watermarks for %s: low %d, high %d", cc.class, cc.LowWater, cc.HighWater)
+	}
+	if cc.GracePeriod < 0 {
+		return fmt.Errorf("grace period of %s must be non-negative", cc.class)
+	}
+	if cc.NoGracePeriod && cc.GracePeriod != 0 {
+		return fmt.Errorf("grace period of %s is set, but NoGracePeriod is too", cc.class)
+	}
+	return nil
+}
+
+// ClassInfo holds the configuration and the connection count of a class of
+// connections.
+type ClassInfo struct {
+	Class ConnClass
+	ClassLimits
+	// ConnCount is the current number of connections in the class.
+	ConnCount int
+}
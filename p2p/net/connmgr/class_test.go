@@ -0,0 +1,214 @@
+package connmgr
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/network"
+	tu "github.com/libp2p/go-libp2p/core/test"
+
+	ma "github.com/multiformats/go-multiaddr"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTransportClass(t *testing.T) {
+	for addr, class := range map[string]string{
+		"/ip4/1.2.3.4/tcp/1":                      TransportTCP,
+		"/ip4/1.2.3.4/tcp/1/ws":                   TransportWebSocket,
+		"/ip4/1.2.3.4/udp/1/quic-v1":              TransportQUIC,
+		"/ip4/1.2.3.4/udp/1/quic-v1/webtransport": TransportWebTransport,
+		"/ip4/1.2.3.4/udp/1/webrtc-direct":        TransportWebRTC,
+		"/ip4/1.2.3.4/udp/1/quic-v1/p2p-circuit":  TransportRelay,
+		"/ip4/1.2.3.4/tcp/1/p2p-circuit/webrtc":   TransportRelay,
+		"/ip4/1.2.3.4/udp/1":                      TransportOther,
+		"/dns/example.com/tcp/443/wss":            TransportWebSocket,
+	} {
+		require.Equal(t, class, TransportClass(ma.StringCast(addr)), addr)
+	}
+}
+
+func TestClassLimitsValidation(t *testing.T) {
+	for _, opt := range []Option{
+		WithClassLimits(ConnClass{Transport: "carrier-pigeon"}, ClassLimits{LowWater: 1, HighWater: 2}),
+		WithClassLimits(ConnClass{Direction: network.DirInbound}, ClassLimits{LowWater: 3, HighWater: 2}),
+		WithClassLimits(ConnClass{Direction: network.DirInbound}, ClassLimits{LowWater: -1, HighWater: 2}),
+		WithClassLimits(ConnClass{Direction: network.DirInbound}, ClassLimits{LowWater: 1, HighWater: 2, GracePeriod: -1}),
+		WithClassLimits(ConnClass{Direction: network.DirInbound}, ClassLimits{LowWater: 1, HighWater: 2, GracePeriod: time.Second, NoGracePeriod: true}),
+	} {
+		_, err := NewConnManager(1, 2, opt)
+		require.Error(t, err)
+	}
+
+	class := ConnClass{Transport: TransportRelay}
+	_, err := NewConnManager(1, 2, WithClassLimits(class, ClassLimits{HighWater: 1}), WithClassLimits(class, ClassLimits{HighWater: 2}))
+	require.Error(t, err)
+}
+
+func TestClassLimitsDirection(t *testing.T) {
+	inbound := ConnClass{Direction: network.DirInbound}
+	cm, err := NewConnManager(0, 0, WithGracePeriod(0), WithClassLimits(inbound, ClassLimits{LowWater: 2, HighWater: 4}))
+	require.NoError(t, err)
+	defer cm.Close()
+	not := cm.Notifee()
+
+	var in, out []*tconn
+	for i := 0; i < 3; i++ {
+		c := &tconn{peer: tu.RandPeerIDFatal(t), dir: network.DirOutbound, addr: ma.StringCast("/ip4/1.2.3.4/tcp/1"), disconnectNotify: not.Disconnected}
+		not.Connected(nil, c)
+		out = append(out, c)
+	}
+	for i := 0; i < 3; i++ {
+		c := &tconn{peer: tu.RandPeerIDFatal(t), dir: network.DirInbound, addr: ma.StringCast("/ip4/1.2.3.4/tcp/1"), disconnectNotify: not.Disconnected}
+		not.Connected(nil, c)
+		in = append(in, c)
+	}
+	info := cm.GetInfo()
+	require.Len(t, info.Classes, 1)
+	require.Equal(t, ClassInfo{Class: inbound, ClassLimits: ClassLimits{LowWater: 2, HighWater: 4}, ConnCount: 3}, info.Classes[0])
+
+	// the background trim only trims classes above their high watermark
+	require.Empty(t, cm.connsToClose(true))
+	c := &tconn{peer: tu.RandPeerIDFatal(t), dir: network.DirInbound, addr: ma.StringCast("/ip4/1.2.3.4/tcp/1"), disconnectNotify: not.Disconnected}
+	not.Connected(nil, c)
+	in = append(in, c)
+	require.Len(t, cm.connsToClose(true), 2)
+
+	cm.TrimOpenConns(context.Background())
+	var closed int
+	for _, c := range in {
+		if c.isClosed() {
+			closed++
+		}
+	}
+	require.Equal(t, 2, closed)
+	for _, c := range out {
+		require.False(t, c.isClosed())
+	}
+	require.Equal(t, 2, cm.GetInfo().Classes[0].ConnCount)
+}
+
+func TestClassLimitsTransport(t *testing.T) {
+	relay := ConnClass{Transport: TransportRelay}
+	cm, err := NewConnManager(0, 0, WithGracePeriod(0), WithClassLimits(relay, ClassLimits{LowWater: 1, HighWater: 2}))
+	require.NoError(t, err)
+	defer cm.Close()
+	not := cm.Notifee()
+
+	// a peer with a direct and a relayed connection
+	p := tu.RandPeerIDFatal(t)
+	direct := &tconn{peer: p, addr: ma.StringCast("/ip4/1.2.3.4/tcp/1"), disconnectNotify: not.Disconnected}
+	relayed := &tconn{peer: p, addr: ma.StringCast("/ip4/1.2.3.4/tcp/1/p2p-circuit"), disconnectNotify: not.Disconnected}
+	not.Connected(nil, direct)
+	not.Connected(nil, relayed)
+	cm.TagPeer(p, "important", 100)
+
+	var others []*tconn
+	for i := 0; i < 2; i++ {
+		c := &tconn{peer: tu.RandPeerIDFatal(t), addr: ma.StringCast("/ip4/1.2.3.4/udp/1/quic-v1/p2p-circuit"), disconnectNotify: not.Disconnected}
+		not.Connected(nil, c)
+		others = append(others, c)
+	}
+	require.Equal(t, 3, cm.GetInfo().Classes[0].ConnCount)
+
+	// the relayed connections of the least valuable peers are closed
+	cm.TrimOpenConns(context.Background())
+	require.True(t, others[0].isClosed())
+	require.True(t, others[1].isClosed())
+	require.False(t, relayed.isClosed())
+	require.False(t, direct.isClosed())
+
+	// only the relayed connection of a peer is closed
+	cm.UntagPeer(p, "important")
+	for i := 0; i < 2; i++ {
+		c := &tconn{peer: tu.RandPeerIDFatal(t), addr: ma.StringCast("/ip4/1.2.3.4/udp/1/quic-v1/p2p-circuit"), disconnectNotify: not.Disconnected}
+		not.Connected(nil, c)
+		cm.TagPeer(c.RemotePeer(), "important", 100*(i+1))
+	}
+	cm.TrimOpenConns(context.Background())
+	require.True(t, relayed.isClosed())
+	require.False(t, direct.isClosed())
+	require.Equal(t, 1, cm.GetInfo().Classes[0].ConnCount)
+	require.NotNil(t, cm.GetTagInfo(p))
+}
+
+func TestClassLimitsParallelConns(t *testing.T) {
+	tcp := ConnClass{Transport: TransportTCP}
+	cm, err := NewConnManager(0, 0, WithGracePeriod(0), WithClassLimits(tcp, ClassLimits{LowWater: 2, HighWater: 3}))
+	require.NoError(t, err)
+	defer cm.Close()
+	not := cm.Notifee()
+
+	// a peer with a first connection and two parallel connections
+	p := tu.RandPeerIDFatal(t)
+	first := &tconn{peer: p, addr: ma.StringCast("/ip4/1.2.3.4/tcp/1"), disconnectNotify: not.Disconnected}
+	not.Connected(nil, first)
+	var parallel []*tconn
+	for i := 0; i < 2; i++ {
+		c := &tconn{peer: p, parallel: true, addr: ma.StringCast("/ip4/1.2.3.4/tcp/1"), disconnectNotify: not.Disconnected}
+		not.Connected(nil, c)
+		parallel = append(parallel, c)
+	}
+	require.Equal(t, 1, cm.GetInfo().Classes[0].ConnCount)
+
+	// the peer still counts once when only parallel connections are left
+	first.Close()
+	require.Equal(t, 1, cm.GetInfo().Classes[0].ConnCount)
+
+	var others []*tconn
+	for i := 0; i < 2; i++ {
+		c := &tconn{peer: tu.RandPeerIDFatal(t), addr: ma.StringCast("/ip4/1.2.3.4/tcp/1"), disconnectNotify: not.Disconnected}
+		not.Connected(nil, c)
+		cm.TagPeer(c.RemotePeer(), "important", 100)
+		others = append(others, c)
+	}
+	require.Equal(t, 3, cm.GetInfo().Classes[0].ConnCount)
+
+	// and its parallel connections are trimmed like any other connection
+	cm.TrimOpenConns(context.Background())
+	for _, c := range parallel {
+		require.True(t, c.isClosed())
+	}
+	for _, c := range others {
+		require.False(t, c.isClosed())
+	}
+	require.Equal(t, 2, cm.GetInfo().Classes[0].ConnCount)
+}
+
+func TestClassLimitsGracePeriod(t *testing.T) {
+	relay := ConnClass{Transport: TransportRelay}
+	for _, tc := range []struct {
+		name   string
+		limits ClassLimits
+		closed bool
+	}{
+		{name: "inherited", limits: ClassLimits{LowWater: 1, HighWater: 2}},
+		{name: "none", limits: ClassLimits{LowWater: 1, HighWater: 2, NoGracePeriod: true}, closed: true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			cm, err := NewConnManager(0, 0, WithGracePeriod(time.Hour), WithClassLimits(relay, tc.limits))
+			require.NoError(t, err)
+			defer cm.Close()
+			not := cm.Notifee()
+
+			var conns []*tconn
+			for i := 0; i < 3; i++ {
+				c := &tconn{peer: tu.RandPeerIDFatal(t), addr: ma.StringCast("/ip4/1.2.3.4/tcp/1/p2p-circuit"), disconnectNotify: not.Disconnected}
+				not.Connected(nil, c)
+				conns = append(conns, c)
+			}
+			cm.TrimOpenConns(context.Background())
+			var closed int
+			for _, c := range conns {
+				if c.isClosed() {
+					closed++
+				}
+			}
+			if tc.closed {
+				require.Equal(t, 2, closed)
+			} else {
+				require.Zero(t, closed)
+			}
+		})
+	}
+}
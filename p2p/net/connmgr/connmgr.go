@@ -40,6 +40,8 @@ type BasicConnMgr struct {
 	// channel-based semaphore that enforces only a single trim is in progress
 	trimMutex sync.Mutex
 	connCount atomic.Int32
+	// classCounts are the connection counts of the classes in cfg.classes
+	classCounts []atomic.Int32
 	// to be accessed atomically. This is mimicking the implementation of a sync.Once.
 	// Take care of correct alignment when modifying this struct.
 	trimCount uint64
@@ -121,16 +123,23 @@ func NewConnManager(low, hi int, opts ...Option) (*BasicConnMgr, error) {
 		}
 	}
 
+	for _, cc := range cfg.classes {
+		if cc.GracePeriod == 0 && !cc.NoGracePeriod {
+			cc.GracePeriod = cfg.gracePeriod
+		}
+	}
+
 	if cfg.decayer == nil {
 		// Set the default decayer config.
 		cfg.decayer = (&DecayerCfg{}).WithDefaults()
 	}
 
 	cm := &BasicConnMgr{
-		cfg:       cfg,
-		clock:     cfg.clock,
-		protected: make(map[peer.ID]map[string]struct{}, 16),
-		segments:  segments{},
+		cfg:         cfg,
+		clock:       cfg.clock,
+		protected:   make(map[peer.ID]map[string]struct{}, 16),
+		segments:    segments{},
+		classCounts: make([]atomic.Int32, len(cfg.classes)),
 	}
 
 	for i := range cm.segments.buckets {
@@ -175,7 +184,7 @@ func (cm *BasicConnMgr) memoryEmergency() {
 	// Trim connections without paying attention to the silence period.
 	for _, c := range cm.getConnsToCloseEmergency(target) {
 		log.Infow("low on memory. closing conn", "peer", c.RemotePeer())
-		c.Close()
+		c.CloseWithError(network.ConnResourceLimitExceeded)
 	}
 
 	// finally, update the last trim time.
@@ -261,10 +270,46 @@ type peerInfo struct {
 	temp  bool // this is a temporary entry holding early tags, and awaiting connections
 
 	conns map[network.Conn]time.Time // start time of each connection
+	// parallelConns is the number of conns that are parallel connections,
+	// see network.Stats.Parallel.
+	parallelConns int
 
 	firstSeen time.Time // timestamp when we began tracking this peer.
 }
 
+// connCount returns the number of connections to the peer we account for.
+// Parallel connections are not counted separately, but a peer with only
+// parallel connections left still counts for one connection.
+func (pi *peerInfo) connCount() int {
+	return accountedConns(len(pi.conns), pi.parallelConns)
+}
+
+// classConnCount returns the number of connections of the peer in the class
+// cc we account for, like connCount. The connections for which skip returns
+// true are ignored.
+func (pi *peerInfo) classConnCount(cc *classConfig, skip func(network.Conn) bool) int {
+	var n, parallel int
+	for c := range pi.conns {
+		if skip(c) || !cc.class.contains(c, TransportClass(c.RemoteMultiaddr())) {
+			continue
+		}
+		n++
+		if c.Stat().Parallel {
+			parallel++
+		}
+	}
+	return accountedConns(n, parallel)
+}
+
+// accountedConns returns the number of connections we account for out of n
+// connections to a peer, parallel of which are parallel connections.
+func accountedConns(n, parallel int) int {
+	if n == 0 {
+		return 0
+	}
+	return max(n-parallel, 1)
+}
+
 type peerInfos []*peerInfo
 
 // SortByValueAndStreams sorts peerInfos by their value and stream count. It
@@ -358,14 +403,14 @@ func (cm *BasicConnMgr) background() {
 	for {
 		select {
 		case <-ticker.C:
-			if cm.connCount.Load() < int32(cm.cfg.highWater) {
+			if cm.connCount.Load() < int32(cm.cfg.highWater) && !cm.classAboveHighWater() {
 				// Below high water, skip.
 				continue
 			}
 		case <-cm.ctx.Done():
 			return
 		}
-		cm.trim()
+		cm.trim(true)
 	}
 }
 
@@ -375,7 +420,7 @@ func (cm *BasicConnMgr) doTrim() {
 	cm.trimMutex.Lock()
 	defer cm.trimMutex.Unlock()
 	if count == atomic.LoadUint64(&cm.trimCount) {
-		cm.trim()
+		cm.trim(false)
 		cm.lastTrimMu.Lock()
 		cm.lastTrim = cm.clock.Now()
 		cm.lastTrimMu.Unlock()
@@ -384,11 +429,13 @@ func (cm *BasicConnMgr) doTrim() {
 }
 
 // trim starts the trim, if the last trim happened before the configured silence period.
-func (cm *BasicConnMgr) trim() {
+// If highWaterOnly is set, only the connection counts above their high watermark
+// are trimmed.
+func (cm *BasicConnMgr) trim(highWaterOnly bool) {
 	// do the actual trim.
-	for _, c := range cm.getConnsToClose() {
+	for _, c := range cm.connsToClose(highWaterOnly) {
 		log.Debugw("closing conn", "peer", c.RemotePeer())
-		c.Close()
+		c.CloseWithError(network.ConnGarbageCollected)
 	}
 }
 
@@ -422,7 +469,7 @@ func (cm *BasicConnMgr) getConnsToCloseEmergency(target int) []network.Conn {
 		for c := range inf.conns {
 			selected = append(selected, c)
 		}
-		target -= len(inf.conns)
+		target -= inf.connCount()
 		s.Unlock()
 	}
 	if len(selected) >= target {
@@ -454,7 +501,7 @@ func (cm *BasicConnMgr) getConnsToCloseEmergency(target int) []network.Conn {
 		for c := range inf.conns {
 			selected = append(selected, c)
 		}
-		target -= len(inf.conns)
+		target -= inf.connCount()
 		s.Unlock()
 	}
 	return selected
@@ -463,6 +510,41 @@ func (cm *BasicConnMgr) getConnsToCloseEmergency(target int) []network.Conn {
 // getConnsToClose runs the heuristics described in TrimOpenConns and returns the
 // connections to close.
 func (cm *BasicConnMgr) getConnsToClose() []network.Conn {
+	return cm.connsToClose(false)
+}
+
+// connsToClose returns the connections to close, first to honor the global
+// watermarks, then the watermarks of each class of connections. If
+// highWaterOnly is set, only the connection counts above their high watermark
+// are brought down to their low watermark.
+func (cm *BasicConnMgr) connsToClose(highWaterOnly bool) []network.Conn {
+	var selected []network.Conn
+	if !highWaterOnly || cm.connCount.Load() >= int32(cm.cfg.highWater) {
+		selected = cm.getGlobalConnsToClose()
+	}
+	if len(cm.cfg.classes) == 0 {
+		return selected
+	}
+
+	closing := make(map[network.Conn]struct{}, len(selected))
+	for _, c := range selected {
+		closing[c] = struct{}{}
+	}
+	for i, cc := range cm.cfg.classes {
+		if highWaterOnly && cm.classCounts[i].Load() < int32(cc.HighWater) {
+			continue
+		}
+		for _, c := range cm.getClassConnsToClose(i, cc, closing) {
+			closing[c] = struct{}{}
+			selected = append(selected, c)
+		}
+	}
+	return selected
+}
+
+// getGlobalConnsToClose returns the connections to close to honor the global
+// watermarks.
+func (cm *BasicConnMgr) getGlobalConnsToClose() []network.Conn {
 	if cm.cfg.lowWater == 0 || cm.cfg.highWater == 0 {
 		// disabled
 		return nil
@@ -492,7 +574,7 @@ func (cm *BasicConnMgr) getConnsToClose() []network.Conn {
 			// note that we're copying the entry here,
 			// but since inf.conns is a map, it will still point to the original object
 			candidates = append(candidates, inf)
-			ncandidates += len(inf.conns)
+			ncandidates += inf.connCount()
 		}
 		s.Unlock()
 	}
@@ -509,6 +591,9 @@ func (cm *BasicConnMgr) getConnsToClose() []network.Conn {
 
 	// Sort peers according to their value.
 	candidates.SortByValueAndStreams(&cm.segments, false)
+	if cm.cfg.scorer != nil {
+		cm.sortByScore(candidates)
+	}
 
 	target := ncandidates - cm.cfg.lowWater
 
@@ -531,14 +616,137 @@ func (cm *BasicConnMgr) getConnsToClose() []network.Conn {
 			for c := range inf.conns {
 				selected = append(selected, c)
 			}
-			target -= len(inf.conns)
+			target -= inf.connCount()
+		}
+		s.Unlock()
+	}
+
+	return selected
+}
+
+// getClassConnsToClose returns the connections to close to honor the watermarks
+// of the class cc, the i-th class of the configuration. closing are the
+// connections that are being closed already.
+//
+// Only the connections of the class are closed, the peers they belong to stay
+// connected if they have other connections.
+func (cm *BasicConnMgr) getClassConnsToClose(i int, cc *classConfig, closing map[network.Conn]struct{}) []network.Conn {
+	if cc.HighWater == 0 {
+		// disabled
+		return nil
+	}
+
+	// Closing connections only lower the count.
+	if int(cm.classCounts[i].Load()) <= cc.LowWater {
+		return nil
+	}
+
+	// conns are the connections of the class of every candidate peer, and
+	// counts the number of connections we account for that closing them
+	// frees.
+	conns := make(map[peer.ID][]network.Conn)
+	counts := make(map[peer.ID]int)
+	var candidates peerInfos
+	var count, ncandidates int
+	gracePeriodStart := cm.clock.Now().Add(-cc.GracePeriod)
+	isClosing := func(c network.Conn) bool {
+		_, ok := closing[c]
+		return ok
+	}
+
+	cm.plk.RLock()
+	for _, s := range cm.segments.buckets {
+		s.Lock()
+		for id, inf := range s.peers {
+			open := inf.classConnCount(cc, isClosing)
+			count += open
+			if _, ok := cm.protected[id]; ok {
+				// skip over protected peer.
+				continue
+			}
+			for c, start := range inf.conns {
+				if isClosing(c) || start.After(gracePeriodStart) {
+					continue
+				}
+				if !cc.class.contains(c, TransportClass(c.RemoteMultiaddr())) {
+					continue
+				}
+				conns[id] = append(conns[id], c)
+			}
+			if len(conns[id]) == 0 {
+				continue
+			}
+			// The connections in the grace period stay open.
+			n := open - inf.classConnCount(cc, func(c network.Conn) bool {
+				return isClosing(c) || !inf.conns[c].After(gracePeriodStart)
+			})
+			if n == 0 {
+				delete(conns, id)
+				continue
+			}
+			counts[id] = n
+			candidates = append(candidates, inf)
+			ncandidates += n
 		}
 		s.Unlock()
 	}
+	cm.plk.RUnlock()
 
+	if count <= cc.LowWater {
+		return nil
+	}
+	if ncandidates < cc.LowWater {
+		log.Infow("open connection count of class above limit but too many are in the grace period", "class", cc.class)
+		return nil
+	}
+
+	candidates.SortByValueAndStreams(&cm.segments, false)
+	if cm.cfg.scorer != nil {
+		cm.sortByScore(candidates)
+	}
+
+	target := ncandidates - cc.LowWater
+	var selected []network.Conn
+	for _, inf := range candidates {
+		if target <= 0 {
+			break
+		}
+		selected = append(selected, conns[inf.id]...)
+		target -= counts[inf.id]
+	}
 	return selected
 }
 
+// classAboveHighWater reports whether the connection count of a class is above
+// its high watermark.
+func (cm *BasicConnMgr) classAboveHighWater() bool {
+	for i, cc := range cm.cfg.classes {
+		if cc.HighWater != 0 && cm.classCounts[i].Load() >= int32(cc.HighWater) {
+			return true
+		}
+	}
+	return false
+}
+
+// updateClassCounts updates the connection counts of the classes c belongs to
+// when c is added to (delta 1) or removed from (delta -1) the connections of
+// pi. Like for the total count, a peer whose connections in a class are all
+// parallel connections counts for one connection.
+func (cm *BasicConnMgr) updateClassCounts(pi *peerInfo, c network.Conn, delta int32) {
+	if len(cm.cfg.classes) == 0 {
+		return
+	}
+	tpt := TransportClass(c.RemoteMultiaddr())
+	for i, cc := range cm.cfg.classes {
+		if !cc.class.contains(c, tpt) {
+			continue
+		}
+		with := pi.classConnCount(cc, func(network.Conn) bool { return false })
+		without := pi.classConnCount(cc, func(oc network.Conn) bool { return oc == c })
+		cm.classCounts[i].Add(delta * int32(with-without))
+	}
+}
+
 // GetTagInfo is called to fetch the tag information associated with a given
 // peer, nil is returned if p refers to an unknown peer.
 func (cm *BasicConnMgr) GetTagInfo(p peer.ID) *connmgr.TagInfo {
@@ -631,6 +839,10 @@ type CMInfo struct {
 
 	// The current connection count.
 	ConnCount int
+
+	// The configuration and connection count of the classes of connections
+	// with their own watermarks, see WithClassLimits.
+	Classes []ClassInfo
 }
 
 // GetInfo returns the configuration and status data for this connection manager.
@@ -639,12 +851,22 @@ func (cm *BasicConnMgr) GetInfo() CMInfo {
 	lastTrim := cm.lastTrim
 	cm.lastTrimMu.RUnlock()
 
+	var classes []ClassInfo
+	for i, cc := range cm.cfg.classes {
+		classes = append(classes, ClassInfo{
+			Class:       cc.class,
+			ClassLimits: cc.ClassLimits,
+			ConnCount:   int(cm.classCounts[i].Load()),
+		})
+	}
+
 	return CMInfo{
 		HighWater:   cm.cfg.highWater,
 		LowWater:    cm.cfg.lowWater,
 		LastTrim:    lastTrim,
 		GracePeriod: cm.cfg.gracePeriod,
 		ConnCount:   int(cm.connCount.Load()),
+		Classes:     classes,
 	}
 }
 
@@ -697,8 +919,13 @@ func (nn *cmNotifee) Connected(n network.Network, c network.Conn) {
 		return
 	}
 
+	prev := pinfo.connCount()
 	pinfo.conns[c] = cm.clock.Now()
-	cm.connCount.Add(1)
+	if c.Stat().Parallel {
+		pinfo.parallelConns++
+	}
+	cm.connCount.Add(int32(pinfo.connCount() - prev))
+	cm.updateClassCounts(pinfo, c, 1)
 }
 
 // Disconnected is called by notifiers to inform that an existing connection has been closed or terminated.
@@ -723,11 +950,17 @@ func (nn *cmNotifee) Disconnected(n network.Network, c network.Conn) {
 		return
 	}
 
+	// c is still one of the connections of the peer here.
+	cm.updateClassCounts(cinf, c, -1)
+	prev := cinf.connCount()
 	delete(cinf.conns, c)
+	if c.Stat().Parallel {
+		cinf.parallelConns--
+	}
 	if len(cinf.conns) == 0 {
 		delete(s.peers, p)
 	}
-	cm.connCount.Add(-1)
+	cm.connCount.Add(int32(cinf.connCount() - prev))
 }
 
 // Listen is no-op in this implementation.
@@ -21,7 +21,11 @@ type tconn struct {
 	network.Conn
 
 	peer             peer.ID
-	closed           uint32 // to be used atomically. Closed if 1
+	parallel         bool
+	dir              network.Direction // outbound if unset
+	addr             ma.Multiaddr      // a UDP address if unset
+	closed           uint32            // to be used atomically. Closed if 1
+	errCode          uint32            // to be used atomically. The code passed to CloseWithError
 	disconnectNotify func(net network.Network, conn network.Conn)
 }
 
@@ -33,6 +37,11 @@ func (c *tconn) Close() error {
 	return nil
 }
 
+func (c *tconn) CloseWithError(code network.ConnErrorCode) error {
+	atomic.StoreUint32(&c.errCode, uint32(code))
+	return c.Close()
+}
+
 func (c *tconn) isClosed() bool {
 	return atomic.LoadUint32(&c.closed) == 1
 }
@@ -42,15 +51,23 @@ func (c *tconn) RemotePeer() peer.ID {
 }
 
 func (c *tconn) Stat() network.ConnStats {
+	dir := c.dir
+	if dir == network.DirUnknown {
+		dir = network.DirOutbound
+	}
 	return network.ConnStats{
 		Stats: network.Stats{
-			Direction: network.DirOutbound,
+			Direction: dir,
+			Parallel:  c.parallel,
 		},
 		NumStreams: 1,
 	}
 }
 
 func (c *tconn) RemoteMultiaddr() ma.Multiaddr {
+	if c.addr != nil {
+		return c.addr
+	}
 	addr, err := ma.NewMultiaddr("/ip4/127.0.0.1/udp/1234")
 	if err != nil {
 		panic("cannot create multiaddr")
@@ -164,6 +181,7 @@ func TestConnTrimming(t *testing.T) {
 	if !conns[299].(*tconn).isClosed() {
 		t.Fatal("conn with bad tag should have gotten closed")
 	}
+	require.Equal(t, uint32(network.ConnGarbageCollected), atomic.LoadUint32(&conns[299].(*tconn).errCode))
 }
 
 func TestConnsToClose(t *testing.T) {
@@ -410,6 +428,38 @@ func TestDisconnected(t *testing.T) {
 	}
 }
 
+func TestParallelConnections(t *testing.T) {
+	cm, err := NewConnManager(2, 3, WithGracePeriod(0))
+	require.NoError(t, err)
+	defer cm.Close()
+	not := cm.Notifee()
+
+	first := randConn(t, nil)
+	p := first.RemotePeer()
+	parallel1 := &tconn{peer: p, parallel: true}
+	parallel2 := &tconn{peer: p, parallel: true}
+	not.Connected(nil, first)
+	not.Connected(nil, parallel1)
+	not.Connected(nil, parallel2)
+	require.Equal(t, 1, cm.GetInfo().ConnCount)
+
+	// the parallel connections don't push us above the low watermark
+	other := randConn(t, nil)
+	not.Connected(nil, other)
+	require.Equal(t, 2, cm.GetInfo().ConnCount)
+	cm.TrimOpenConns(context.Background())
+	require.False(t, first.(*tconn).isClosed())
+	require.False(t, parallel1.isClosed())
+	require.False(t, other.(*tconn).isClosed())
+
+	// the peer still counts for one connection once the first one is closed
+	not.Disconnected(nil, first)
+	require.Equal(t, 2, cm.GetInfo().ConnCount)
+	not.Disconnected(nil, parallel1)
+	not.Disconnected(nil, parallel2)
+	require.Equal(t, 1, cm.GetInfo().ConnCount)
+}
+
 func TestGracePeriod(t *testing.T) {
 	const gp = 100 * time.Millisecond
 	mockClock := clock.NewMock()
@@ -794,6 +844,7 @@ type mockConn struct {
 }
 
 func (m mockConn) Close() error                                          { panic("implement me") }
+func (m mockConn) CloseWithError(errCode network.ConnErrorCode) error    { panic("implement me") }
 func (m mockConn) LocalPeer() peer.ID                                    { panic("implement me") }
 func (m mockConn) RemotePeer() peer.ID                                   { panic("implement me") }
 func (m mockConn) RemotePublicKey() crypto.PubKey                        { panic("implement me") }
@@ -2,6 +2,7 @@ package connmgr
 
 import (
 	"errors"
+	"fmt"
 	"time"
 
 	"github.com/benbjohnson/clock"
@@ -16,6 +17,8 @@ type config struct {
 	decayer       *DecayerCfg
 	emergencyTrim bool
 	clock         clock.Clock
+	scorer        PeerScorer
+	classes       []*classConfig
 }
 
 // Option represents an option for the basic connection manager.
@@ -62,3 +65,43 @@ func WithSilencePeriod(p time.Duration) Option {
 		return nil
 	}
 }
+
+// WithPeerScorer sets the PeerScorer that decides which peers are closed first
+// when trimming connections. Protected peers and peers in the grace period are
+// never passed to the scorer.
+// Trims triggered by memory pressure (see WithEmergencyTrim) don't use the
+// scorer, they close the connections with the most streams first.
+func WithPeerScorer(s PeerScorer) Option {
+	return func(cfg *config) error {
+		if s == nil {
+			return errors.New("nil peer scorer")
+		}
+		cfg.scorer = s
+		return nil
+	}
+}
+
+// WithClassLimits sets separate watermarks and grace period for the connections
+// in class, for example for the inbound connections, or for the relayed
+// connections. It can be used several times to configure several classes.
+//
+// The connections of a class are trimmed independently of the global
+// watermarks: when the class exceeds its high watermark, only connections in
+// the class are closed, until its low watermark is reached. Parallel
+// connections (see network.Stats.Parallel) are not counted, unless a peer only
+// has parallel connections in the class, in which case it counts for one.
+func WithClassLimits(class ConnClass, limits ClassLimits) Option {
+	return func(cfg *config) error {
+		cc := &classConfig{class: class, ClassLimits: limits}
+		if err := cc.validate(); err != nil {
+			return err
+		}
+		for _, other := range cfg.classes {
+			if other.class == class {
+				return fmt.Errorf("limits for %s set twice", class)
+			}
+		}
+		cfg.classes = append(cfg.classes, cc)
+		return nil
+	}
+}
@@ -0,0 +1,295 @@
+package connmgr
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/metrics"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/peerstore"
+
+	asnutil "github.com/libp2p/go-libp2p-asn-util"
+	ma "github.com/multiformats/go-multiaddr"
+	manet "github.com/multiformats/go-multiaddr/net"
+)
+
+// PeerScorer decides which peers are closed first when the connection manager
+// trims connections.
+//
+// Score is called with all candidates of a trim at once, so that scorers can
+// take the whole peer set into account, e.g. to keep a diverse set of peers.
+// It returns one score per peer, in the same order. Peers with lower scores
+// are closed first. Peers with equal scores are ordered like they would be
+// without a scorer.
+type PeerScorer interface {
+	Score(peers []PeerScoreInfo) []float64
+}
+
+// PeerScoreInfo is a snapshot of a peer, passed to the PeerScorer.
+type PeerScoreInfo struct {
+	ID peer.ID
+	// Value is the sum of the peer's tag values.
+	Value     int
+	FirstSeen time.Time
+	Conns     []ConnScoreInfo
+}
+
+// ConnScoreInfo is a snapshot of a connection, passed to the PeerScorer.
+type ConnScoreInfo struct {
+	Direction  network.Direction
+	NumStreams int
+	// Age is the time since the connection was opened.
+	Age             time.Duration
+	RemoteMultiaddr ma.Multiaddr
+}
+
+// sortByScore sorts the candidates by their score, as determined by the
+// configured PeerScorer. Temporary entries are still pruned first, and the
+// order of candidates with equal scores is preserved.
+func (cm *BasicConnMgr) sortByScore(candidates peerInfos) {
+	now := cm.clock.Now()
+	infos := make([]PeerScoreInfo, len(candidates))
+	for i, inf := range candidates {
+		// lock this to protect from concurrent modifications from connect/disconnect events
+		s := cm.segments.get(inf.id)
+		s.Lock()
+		infos[i] = PeerScoreInfo{
+			ID:        inf.id,
+			Value:     inf.value,
+			FirstSeen: inf.firstSeen,
+			Conns:     make([]ConnScoreInfo, 0, len(inf.conns)),
+		}
+		for c, start := range inf.conns {
+			infos[i].Conns = append(infos[i].Conns, ConnScoreInfo{
+				Direction:       c.Stat().Direction,
+				NumStreams:      c.Stat().NumStreams,
+				Age:             now.Sub(start),
+				RemoteMultiaddr: c.RemoteMultiaddr(),
+			})
+		}
+		s.Unlock()
+		// make the order of the connections deterministic
+		sort.Slice(infos[i].Conns, func(a, b int) bool { return infos[i].Conns[a].Age > infos[i].Conns[b].Age })
+	}
+
+	scores := cm.cfg.scorer.Score(infos)
+	if len(scores) != len(candidates) {
+		log.Errorf("peer scorer returned %d scores for %d peers", len(scores), len(candidates))
+		return
+	}
+	idx := make([]int, len(candidates))
+	for i := range idx {
+		idx[i] = i
+	}
+	sort.SliceStable(idx, func(a, b int) bool {
+		left, right := candidates[idx[a]], candidates[idx[b]]
+		// temporary peers are preferred for pruning.
+		if left.temp != right.temp {
+			return left.temp
+		}
+		return scores[idx[a]] < scores[idx[b]]
+	})
+	sorted := make(peerInfos, len(candidates))
+	for i, j := range idx {
+		sorted[i] = candidates[j]
+	}
+	copy(candidates, sorted)
+}
+
+// WeightedScorer is a PeerScorer with a weight, see CombineScorers.
+type WeightedScorer struct {
+	Scorer PeerScorer
+	Weight float64
+}
+
+type combinedScorer []WeightedScorer
+
+// CombineScorers returns a PeerScorer that sums the scores of the given
+// scorers. Every scorer's scores are first normalized to [0, 1] across all
+// candidates, so the weights don't depend on the unit of the individual
+// scores.
+func CombineScorers(scorers ...WeightedScorer) PeerScorer {
+	return combinedScorer(scorers)
+}
+
+func (c combinedScorer) Score(peers []PeerScoreInfo) []float64 {
+	res := make([]float64, len(peers))
+	for _, s := range c {
+		scores := s.Scorer.Score(peers)
+		min, max := math.Inf(1), math.Inf(-1)
+		for _, v := range scores {
+			min = math.Min(min, v)
+			max = math.Max(max, v)
+		}
+		if max == min {
+			// all equal, this scorer doesn't affect the order
+			continue
+		}
+		for i, v := range scores {
+			res[i] += s.Weight * (v - min) / (max - min)
+		}
+	}
+	return res
+}
+
+// PeerScorerFunc is an adapter to use a function scoring a single peer as a
+// PeerScorer.
+type PeerScorerFunc func(PeerScoreInfo) float64
+
+func (f PeerScorerFunc) Score(peers []PeerScoreInfo) []float64 {
+	res := make([]float64, len(peers))
+	for i, p := range peers {
+		res[i] = f(p)
+	}
+	return res
+}
+
+// TagValueScorer scores peers by the sum of their tag values. This is what the
+// connection manager uses if no scorer is configured.
+func TagValueScorer() PeerScorer {
+	return PeerScorerFunc(func(p PeerScoreInfo) float64 { return float64(p.Value) })
+}
+
+// LatencyScorer prefers peers with a lower latency, as recorded in the
+// peerstore metrics. Peers without a latency measurement get the score of
+// the slowest peer.
+func LatencyScorer(m peerstore.Metrics) PeerScorer {
+	return batchScorer(func(peers []PeerScoreInfo) []float64 {
+		res := make([]float64, len(peers))
+		var known bool
+		var min float64
+		for i, p := range peers {
+			l := m.LatencyEWMA(p.ID)
+			if l == 0 {
+				continue
+			}
+			res[i] = -l.Seconds()
+			if !known || res[i] < min {
+				min = res[i]
+			}
+			known = true
+		}
+		for i, p := range peers {
+			if m.LatencyEWMA(p.ID) == 0 {
+				res[i] = min
+			}
+		}
+		return res
+	})
+}
+
+// batchScorer is a PeerScorer that needs to look at all peers at once.
+type batchScorer func([]PeerScoreInfo) []float64
+
+func (f batchScorer) Score(peers []PeerScoreInfo) []float64 { return f(peers) }
+
+// BandwidthScorer prefers peers we exchange more data with, as measured by the
+// bandwidth counter.
+func BandwidthScorer(r metrics.Reporter) PeerScorer {
+	return PeerScorerFunc(func(p PeerScoreInfo) float64 {
+		s := r.GetBandwidthForPeer(p.ID)
+		return s.RateIn + s.RateOut
+	})
+}
+
+// ConnAgeScorer prefers peers we've been connected to for longer.
+func ConnAgeScorer() PeerScorer {
+	return PeerScorerFunc(func(p PeerScoreInfo) float64 {
+		var age time.Duration
+		for _, c := range p.Conns {
+			if c.Age > age {
+				age = c.Age
+			}
+		}
+		return age.Seconds()
+	})
+}
+
+// OutboundScorer prefers peers we have an outbound connection to. These are
+// the peers we chose to connect to, while inbound connections are easier for
+// an attacker to obtain.
+func OutboundScorer() PeerScorer {
+	return PeerScorerFunc(func(p PeerScoreInfo) float64 {
+		for _, c := range p.Conns {
+			if c.Direction == network.DirOutbound {
+				return 1
+			}
+		}
+		return 0
+	})
+}
+
+// DiversityScorer prefers peers in buckets that contain fewer peers, so that
+// trimming keeps a diverse peer set. bucket assigns an address to a bucket; an
+// empty bucket means that the address isn't bucketed. A peer's bucket is the
+// bucket of the remote address of its oldest connection.
+func DiversityScorer(bucket func(ma.Multiaddr) string) PeerScorer {
+	return batchScorer(func(peers []PeerScoreInfo) []float64 {
+		buckets := make([]string, len(peers))
+		counts := make(map[string]int)
+		for i, p := range peers {
+			if len(p.Conns) == 0 || p.Conns[0].RemoteMultiaddr == nil {
+				continue
+			}
+			if b := bucket(p.Conns[0].RemoteMultiaddr); b != "" {
+				buckets[i] = b
+				counts[b]++
+			}
+		}
+		res := make([]float64, len(peers))
+		for i, b := range buckets {
+			if b != "" {
+				res[i] = -float64(counts[b] - 1)
+			}
+		}
+		return res
+	})
+}
+
+// SubnetBucket returns a bucket function for DiversityScorer that groups
+// addresses by their IPv4 and IPv6 subnet of the given prefix lengths.
+func SubnetBucket(ipv4Bits, ipv6Bits int) func(ma.Multiaddr) string {
+	return func(a ma.Multiaddr) string {
+		ip, err := manet.ToIP(a)
+		if err != nil {
+			return ""
+		}
+		if ip4 := ip.To4(); ip4 != nil {
+			return fmt.Sprintf("%s/%d", ip4.Mask(netMask(ipv4Bits, 32)), ipv4Bits)
+		}
+		return fmt.Sprintf("%s/%d", ip.Mask(netMask(ipv6Bits, 128)), ipv6Bits)
+	}
+}
+
+func netMask(ones, bits int) []byte {
+	mask := make([]byte, bits/8)
+	for i := range mask {
+		switch {
+		case ones >= 8:
+			mask[i] = 0xff
+			ones -= 8
+		case ones > 0:
+			mask[i] = ^byte(0xff >> ones)
+			ones = 0
+		}
+	}
+	return mask
+}
+
+// ASNBucket is a bucket function for DiversityScorer that groups addresses by
+// the autonomous system they belong to. Only IPv6 addresses can be mapped to
+// an ASN, IPv4 addresses aren't bucketed.
+func ASNBucket(a ma.Multiaddr) string {
+	ip, err := manet.ToIP(a)
+	if err != nil || ip.To4() != nil {
+		return ""
+	}
+	asn := asnutil.AsnForIPv6(ip)
+	if asn == 0 {
+		return ""
+	}
+	return fmt.Sprintf("AS%d", asn)
+}
@@ -0,0 +1,125 @@
+package connmgr
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/benbjohnson/clock"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	tu "github.com/libp2p/go-libp2p/core/test"
+	"github.com/libp2p/go-libp2p/p2p/host/peerstore"
+
+	ma "github.com/multiformats/go-multiaddr"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPeerScorer(t *testing.T) {
+	scores := make(map[peer.ID]float64)
+	var calls int
+	scorer := PeerScorerFunc(func(p PeerScoreInfo) float64 {
+		calls++
+		return scores[p.ID]
+	})
+	cm, err := NewConnManager(5, 10, WithGracePeriod(0), WithPeerScorer(scorer))
+	require.NoError(t, err)
+	defer cm.Close()
+
+	not := cm.Notifee()
+	var conns []network.Conn
+	for i := 0; i < 10; i++ {
+		c := randConn(t, nil)
+		// tag values would close the peers in the opposite order
+		cm.TagPeer(c.RemotePeer(), "tag", 10-i)
+		scores[c.RemotePeer()] = float64(i)
+		not.Connected(nil, c)
+		conns = append(conns, c)
+	}
+
+	require.ElementsMatch(t, conns[:5], cm.getConnsToClose())
+	require.Equal(t, 10, calls)
+}
+
+func TestPeerScorerSnapshot(t *testing.T) {
+	mockClock := clock.NewMock()
+	var infos []PeerScoreInfo
+	scorer := batchScorer(func(peers []PeerScoreInfo) []float64 {
+		infos = peers
+		return make([]float64, len(peers))
+	})
+	cm, err := NewConnManager(1, 2, WithGracePeriod(0), WithClock(mockClock), WithPeerScorer(scorer))
+	require.NoError(t, err)
+	defer cm.Close()
+
+	not := cm.Notifee()
+	c1 := &tconn{peer: tu.RandPeerIDFatal(t), dir: network.DirInbound, addr: ma.StringCast("/ip4/1.2.3.4/tcp/1")}
+	not.Connected(nil, c1)
+	mockClock.Add(time.Minute)
+	c2 := &tconn{peer: c1.peer, dir: network.DirOutbound, addr: ma.StringCast("/ip4/1.2.3.4/tcp/2")}
+	not.Connected(nil, c2)
+	mockClock.Add(time.Minute)
+
+	require.ElementsMatch(t, []network.Conn{c1, c2}, cm.getConnsToClose())
+	require.Len(t, infos, 1)
+	require.Equal(t, c1.peer, infos[0].ID)
+	require.Equal(t, []ConnScoreInfo{
+		{Direction: network.DirInbound, NumStreams: 1, Age: 2 * time.Minute, RemoteMultiaddr: c1.addr},
+		{Direction: network.DirOutbound, NumStreams: 1, Age: time.Minute, RemoteMultiaddr: c2.addr},
+	}, infos[0].Conns)
+}
+
+func TestCombineScorers(t *testing.T) {
+	peers := make([]PeerScoreInfo, 3)
+	constant := func(s ...float64) PeerScorer {
+		return batchScorer(func([]PeerScoreInfo) []float64 { return s })
+	}
+	scorer := CombineScorers(
+		WeightedScorer{Scorer: constant(0, 100, 200), Weight: 1},
+		WeightedScorer{Scorer: constant(1, 0, 0.5), Weight: 2},
+		// doesn't affect the result, since all scores are equal
+		WeightedScorer{Scorer: constant(5, 5, 5), Weight: 10},
+	)
+	require.Equal(t, []float64{2, 0.5, 2}, scorer.Score(peers))
+}
+
+func TestLatencyScorer(t *testing.T) {
+	m := peerstore.NewMetrics()
+	peers := make([]PeerScoreInfo, 3)
+	for i := range peers {
+		peers[i].ID = peer.ID(fmt.Sprintf("peer%d", i))
+	}
+	require.Equal(t, []float64{0, 0, 0}, LatencyScorer(m).Score(peers))
+
+	m.RecordLatency(peers[0].ID, 100*time.Millisecond)
+	m.RecordLatency(peers[2].ID, 10*time.Millisecond)
+	// peers without a measurement are scored like the slowest peer
+	require.Equal(t, []float64{-0.1, -0.1, -0.01}, LatencyScorer(m).Score(peers))
+}
+
+func TestDiversityScorer(t *testing.T) {
+	addrs := []string{
+		"/ip4/1.2.3.4/tcp/1",
+		"/ip4/1.2.100.1/tcp/1",
+		"/ip4/1.3.0.1/tcp/1",
+		"/ip6/2001:db8::1/tcp/1",
+		"/ip6/2001:db8::2/tcp/1",
+		"/dns4/example.com/tcp/1",
+	}
+	peers := make([]PeerScoreInfo, 0, len(addrs)+1)
+	for _, a := range addrs {
+		peers = append(peers, PeerScoreInfo{Conns: []ConnScoreInfo{{RemoteMultiaddr: ma.StringCast(a)}}})
+	}
+	// peer without connections
+	peers = append(peers, PeerScoreInfo{})
+
+	require.Equal(t, []float64{-1, -1, 0, -1, -1, 0, 0}, DiversityScorer(SubnetBucket(16, 48)).Score(peers))
+	require.Equal(t, []float64{0, 0, 0, 0, 0, 0, 0}, DiversityScorer(SubnetBucket(32, 128)).Score(peers))
+}
+
+func TestSubnetBucket(t *testing.T) {
+	b := SubnetBucket(20, 33)
+	require.Equal(t, "10.1.16.0/20", b(ma.StringCast("/ip4/10.1.31.200/udp/1/quic-v1")))
+	require.Equal(t, "2001:db8:8000::/33", b(ma.StringCast("/ip6/2001:db8:ffff::1/tcp/1")))
+	require.Empty(t, b(ma.StringCast("/dns6/example.com/tcp/1")))
+}
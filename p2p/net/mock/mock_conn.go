@@ -86,6 +86,11 @@ func (c *conn) Close() error {
 	return nil
 }
 
+// CloseWithError closes the connection. The error code is ignored.
+func (c *conn) CloseWithError(_ network.ConnErrorCode) error {
+	return c.Close()
+}
+
 func (c *conn) teardown() {
 	for _, s := range c.allStreams() {
 		s.Reset()
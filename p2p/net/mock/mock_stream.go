@@ -130,9 +130,22 @@ func (s *stream) Close() error {
 }
 
 func (s *stream) Reset() error {
+	return s.resetWith(network.ErrReset, network.ErrReset)
+}
+
+func (s *stream) ResetWithError(errCode network.StreamErrorCode) error {
+	return s.resetWith(
+		&network.StreamError{ErrorCode: errCode, Remote: true},
+		&network.StreamError{ErrorCode: errCode, Remote: false},
+	)
+}
+
+// resetWith cancels the stream. remoteErr is returned by reads on the remote
+// stream, localErr by reads on this stream.
+func (s *stream) resetWith(remoteErr, localErr error) error {
 	// Cancel any pending reads/writes with an error.
-	s.write.CloseWithError(network.ErrReset)
-	s.read.CloseWithError(network.ErrReset)
+	s.write.CloseWithError(remoteErr)
+	s.read.CloseWithError(localErr)
 
 	select {
 	case s.reset <- struct{}{}:
@@ -1,9 +1,18 @@
 package swarm
 
 import (
+	"errors"
 	"fmt"
+	"net"
+	"strings"
 	"sync"
+	"time"
 
+	"github.com/libp2p/go-libp2p/core/event"
+	"github.com/libp2p/go-libp2p/core/network"
+
+	"github.com/google/gopacket/routing"
+	"github.com/libp2p/go-netroute"
 	ma "github.com/multiformats/go-multiaddr"
 	manet "github.com/multiformats/go-multiaddr/net"
 )
@@ -57,6 +66,9 @@ type blackHoleFilter struct {
 	minSuccesses int
 	// name for the detector.
 	name string
+	// route is the network interface the filter tracks the dials of. It's
+	// empty if the state isn't tracked per route.
+	route string
 
 	// requests counts number of dial requests to peers. We handle request at a peer
 	// level and record results at individual address dial level.
@@ -70,6 +82,17 @@ type blackHoleFilter struct {
 
 	mu            sync.Mutex
 	metricsTracer MetricsTracer
+	// onStateChange, if set, is called without holding mu when the state of
+	// the filter changes.
+	onStateChange func(b *blackHoleFilter, state blackHoleState, successFraction float64)
+}
+
+// label identifies the filter in logs and metrics.
+func (b *blackHoleFilter) label() string {
+	if b.route == "" {
+		return b.name
+	}
+	return b.name + "/" + b.route
 }
 
 // RecordResult records the outcome of a dial. A successful dial will change the state
@@ -77,8 +100,17 @@ type blackHoleFilter struct {
 // fraction over the last n outcomes is less than the minSuccessFraction of the filter.
 func (b *blackHoleFilter) RecordResult(success bool) {
 	b.mu.Lock()
-	defer b.mu.Unlock()
+	st := b.state
+	b.recordResult(success)
+	newSt, successFraction := b.state, b.successFraction()
+	b.mu.Unlock()
 
+	if newSt != st && b.onStateChange != nil {
+		b.onStateChange(b, newSt, successFraction)
+	}
+}
+
+func (b *blackHoleFilter) recordResult(success bool) {
 	if b.state == blackHoleStateBlocked && success {
 		// If the call succeeds in a blocked state we reset to allowed.
 		// This is better than slowly accumulating values till we cross the minSuccessFraction
@@ -140,7 +172,7 @@ func (b *blackHoleFilter) updateState() {
 	}
 
 	if st != b.state {
-		log.Debugf("%s blackHoleDetector state changed from %s to %s", b.name, st, b.state)
+		log.Debugf("%s blackHoleDetector state changed from %s to %s", b.label(), st, b.state)
 	}
 }
 
@@ -154,97 +186,312 @@ func (b *blackHoleFilter) trackMetrics() {
 		nextRequestAllowedAfter = b.n - (b.requests % b.n)
 	}
 
-	successFraction := 0.0
-	if len(b.dialResults) > 0 {
-		successFraction = float64(b.successes) / float64(len(b.dialResults))
-	}
-
 	b.metricsTracer.UpdatedBlackHoleFilterState(
-		b.name,
+		b.label(),
 		b.state,
 		nextRequestAllowedAfter,
-		successFraction,
+		b.successFraction(),
 	)
 }
 
-// blackHoleDetector provides UDP and IPv6 black hole detection using a `blackHoleFilter`
-// for each. For details of the black hole detection logic see `blackHoleFilter`.
+func (b *blackHoleFilter) successFraction() float64 {
+	if len(b.dialResults) == 0 {
+		return 0
+	}
+	return float64(b.successes) / float64(len(b.dialResults))
+}
+
+// BlackHoleFilterConfig configures a black hole filter. A black hole filter
+// tracks the outcome of the dials to the public addresses it matches, and
+// blocks dials to these addresses, except for periodic probes, if not enough
+// of them succeed. For details see WithBlackHoleFilter.
+type BlackHoleFilterConfig struct {
+	// Name identifies the filter in logs, metrics and events. It must be
+	// unique. "UDP" and "IPv6" are used by the built-in filters.
+	Name string
+	// Match reports whether the filter applies to the address.
+	Match func(ma.Multiaddr) bool
+	// N is the size of the sliding window used to evaluate black hole state.
+	N int
+	// MinSuccesses is the minimum number of successes out of N required to
+	// not block requests.
+	MinSuccesses int
+	// PerRoute tracks the state of the filter separately for every network
+	// interface that addresses are dialed from, as determined by the routing
+	// table. This is useful on hosts with multiple uplinks, when only some
+	// of them block the matched addresses.
+	PerRoute bool
+}
+
+func (c *BlackHoleFilterConfig) validate() error {
+	if c.Name == "" {
+		return errors.New("black hole filter name cannot be empty")
+	}
+	if strings.Contains(c.Name, "/") {
+		return fmt.Errorf("invalid black hole filter name %q", c.Name)
+	}
+	if c.Match == nil {
+		return fmt.Errorf("black hole filter %s: matcher cannot be nil", c.Name)
+	}
+	if c.N <= 0 || c.MinSuccesses < 0 || c.MinSuccesses > c.N {
+		return fmt.Errorf("black hole filter %s: invalid N %d and MinSuccesses %d", c.Name, c.N, c.MinSuccesses)
+	}
+	return nil
+}
+
+// blackHoleFilterGroup is a registered black hole filter. It keeps a
+// blackHoleFilter per route if the state is tracked per route, and a single
+// one otherwise.
+type blackHoleFilterGroup struct {
+	config        BlackHoleFilterConfig
+	metricsTracer MetricsTracer
+	onStateChange func(b *blackHoleFilter, state blackHoleState, successFraction float64)
+
+	mu      sync.Mutex
+	filters map[string]*blackHoleFilter // keyed by route
+}
+
+// filter returns the filter for route, creating it if needed.
+func (g *blackHoleFilterGroup) filter(route string) *blackHoleFilter {
+	if !g.config.PerRoute {
+		route = ""
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	f, ok := g.filters[route]
+	if !ok {
+		f = &blackHoleFilter{
+			n:             g.config.N,
+			minSuccesses:  g.config.MinSuccesses,
+			name:          g.config.Name,
+			route:         route,
+			metricsTracer: g.metricsTracer,
+			onStateChange: g.onStateChange,
+		}
+		g.filters[route] = f
+	}
+	return f
+}
+
+func (g *blackHoleFilterGroup) all() []*blackHoleFilter {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	fs := make([]*blackHoleFilter, 0, len(g.filters))
+	for _, f := range g.filters {
+		fs = append(fs, f)
+	}
+	return fs
+}
+
+// routeRefreshInterval is the interval after which the routing table is read
+// again.
+const routeRefreshInterval = time.Minute
+
+// routeResolver finds the network interface used to dial an IP address. The
+// routing table is read in the background, so that dials don't wait for it.
+type routeResolver struct {
+	mu         sync.Mutex
+	router     routing.Router
+	updated    time.Time
+	refreshing bool
+}
+
+func newRouteResolver() *routeResolver {
+	r := &routeResolver{refreshing: true}
+	go r.refresh()
+	return r
+}
+
+func (r *routeResolver) refresh() {
+	router, err := netroute.New()
+	if err != nil {
+		log.Debugf("failed to read the routing table: %s", err)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if err == nil {
+		r.router = router
+	}
+	r.updated = time.Now()
+	r.refreshing = false
+}
+
+// routeOf returns the interface used to dial ip according to the last read
+// of the routing table, and starts reading the table again if it's stale.
+func (r *routeResolver) routeOf(ip net.IP) string {
+	r.mu.Lock()
+	if !r.refreshing && time.Since(r.updated) > routeRefreshInterval {
+		r.refreshing = true
+		go r.refresh()
+	}
+	router := r.router
+	r.mu.Unlock()
+
+	if router == nil {
+		return ""
+	}
+	iface, _, _, err := router.Route(ip)
+	if err != nil || iface == nil {
+		return ""
+	}
+	return iface.Name
+}
+
+// blackHoleDetector provides black hole detection using a registry of
+// black hole filters. By default UDP and IPv6 black holes are detected. For
+// details of the black hole detection logic see `blackHoleFilter`.
 //
 // black hole filtering is done at a peer dial level to ensure that periodic probes to
 // detect change of the black hole state are actually dialed and are not skipped
 // because of dial prioritisation logic.
 type blackHoleDetector struct {
-	udp, ipv6 *blackHoleFilter
+	groups []*blackHoleFilterGroup
+	// routeOf returns the name of the interface used to dial ip, or an empty
+	// string if it can't be determined.
+	routeOf func(ip net.IP) string
+	// emitter, if set, is used to emit EvtBlackHoleStateChanged events.
+	emitter event.Emitter
 }
 
-// FilterAddrs filters the peer's addresses removing black holed addresses
-func (d *blackHoleDetector) FilterAddrs(addrs []ma.Multiaddr) (valid []ma.Multiaddr, blackHoled []ma.Multiaddr) {
-	hasUDP, hasIPv6 := false, false
-	for _, a := range addrs {
-		if !manet.IsPublicAddr(a) {
+type blackHoleFilterKey struct {
+	group *blackHoleFilterGroup
+	route string
+}
+
+// matchingFilters returns the filters applicable to addr.
+func (d *blackHoleDetector) matchingFilters(addr ma.Multiaddr) []blackHoleFilterKey {
+	if !manet.IsPublicAddr(addr) {
+		return nil
+	}
+	var keys []blackHoleFilterKey
+	var route string
+	var routeResolved bool
+	for _, g := range d.groups {
+		if !g.config.Match(addr) {
 			continue
 		}
-		if isProtocolAddr(a, ma.P_UDP) {
-			hasUDP = true
-		}
-		if isProtocolAddr(a, ma.P_IP6) {
-			hasIPv6 = true
+		k := blackHoleFilterKey{group: g}
+		if g.config.PerRoute {
+			if !routeResolved {
+				route = d.route(addr)
+				routeResolved = true
+			}
+			k.route = route
 		}
+		keys = append(keys, k)
 	}
+	return keys
+}
 
-	udpRes := blackHoleResultAllowed
-	if d.udp != nil && hasUDP {
-		udpRes = d.udp.HandleRequest()
+func (d *blackHoleDetector) route(addr ma.Multiaddr) string {
+	if d.routeOf == nil {
+		return ""
 	}
-
-	ipv6Res := blackHoleResultAllowed
-	if d.ipv6 != nil && hasIPv6 {
-		ipv6Res = d.ipv6.HandleRequest()
+	ip, err := manet.ToIP(addr)
+	if err != nil {
+		return ""
 	}
+	return d.routeOf(ip)
+}
 
-	blackHoled = make([]ma.Multiaddr, 0, len(addrs))
-	return ma.FilterAddrs(
-		addrs,
-		func(a ma.Multiaddr) bool {
-			if !manet.IsPublicAddr(a) {
-				return true
-			}
-			// allow all UDP addresses while probing irrespective of IPv6 black hole state
-			if udpRes == blackHoleResultProbing && isProtocolAddr(a, ma.P_UDP) {
-				return true
-			}
-			// allow all IPv6 addresses while probing irrespective of UDP black hole state
-			if ipv6Res == blackHoleResultProbing && isProtocolAddr(a, ma.P_IP6) {
-				return true
+// FilterAddrs filters the peer's addresses removing black holed addrs
+func (d *blackHoleDetector) FilterAddrs(addrs []ma.Multiaddr) (valid []ma.Multiaddr, blackHoled []ma.Multiaddr) {
+	matched := make([][]blackHoleFilterKey, len(addrs))
+	results := make(map[blackHoleFilterKey]blackHoleResult)
+	for i, a := range addrs {
+		matched[i] = d.matchingFilters(a)
+		for _, k := range matched[i] {
+			if _, ok := results[k]; !ok {
+				results[k] = k.group.filter(k.route).HandleRequest()
 			}
+		}
+	}
 
-			if udpRes == blackHoleResultBlocked && isProtocolAddr(a, ma.P_UDP) {
-				blackHoled = append(blackHoled, a)
-				return false
-			}
-			if ipv6Res == blackHoleResultBlocked && isProtocolAddr(a, ma.P_IP6) {
-				blackHoled = append(blackHoled, a)
-				return false
+	valid = make([]ma.Multiaddr, 0, len(addrs))
+	blackHoled = make([]ma.Multiaddr, 0, len(addrs))
+	for i, a := range addrs {
+		probing, blocked := false, false
+		for _, k := range matched[i] {
+			switch results[k] {
+			case blackHoleResultProbing:
+				probing = true
+			case blackHoleResultBlocked:
+				blocked = true
 			}
-			return true
-		},
-	), blackHoled
+		}
+		// allow all addresses of a filter while probing irrespective of the
+		// state of the other filters
+		if blocked && !probing {
+			blackHoled = append(blackHoled, a)
+		} else {
+			valid = append(valid, a)
+		}
+	}
+	return valid, blackHoled
 }
 
 // RecordResult updates the state of the relevant `blackHoleFilter`s for addr
 func (d *blackHoleDetector) RecordResult(addr ma.Multiaddr, success bool) {
-	if !manet.IsPublicAddr(addr) {
+	for _, k := range d.matchingFilters(addr) {
+		k.group.filter(k.route).RecordResult(success)
+	}
+}
+
+// filter returns the filter named name for route, or nil if there's no such
+// filter.
+func (d *blackHoleDetector) filter(name, route string) *blackHoleFilter {
+	if g := d.group(name); g != nil {
+		return g.filter(route)
+	}
+	return nil
+}
+
+func (d *blackHoleDetector) group(name string) *blackHoleFilterGroup {
+	for _, g := range d.groups {
+		if g.config.Name == name {
+			return g
+		}
+	}
+	return nil
+}
+
+// filters returns all the filters that were used so far.
+func (d *blackHoleDetector) filters() []*blackHoleFilter {
+	var fs []*blackHoleFilter
+	for _, g := range d.groups {
+		fs = append(fs, g.all()...)
+	}
+	return fs
+}
+
+func (d *blackHoleDetector) stateChanged(b *blackHoleFilter, state blackHoleState, successFraction float64) {
+	if d.emitter == nil {
 		return
 	}
-	if d.udp != nil && isProtocolAddr(addr, ma.P_UDP) {
-		d.udp.RecordResult(success)
+	if err := d.emitter.Emit(event.EvtBlackHoleStateChanged{
+		Name:            b.name,
+		Route:           b.route,
+		State:           state.toNetwork(),
+		SuccessFraction: successFraction,
+	}); err != nil {
+		log.Debugf("failed to emit black hole state change: %s", err)
 	}
-	if d.ipv6 != nil && isProtocolAddr(addr, ma.P_IP6) {
-		d.ipv6.RecordResult(success)
+}
+
+func (st blackHoleState) toNetwork() network.BlackHoleState {
+	switch st {
+	case blackHoleStateAllowed:
+		return network.BlackHoleStateAllowed
+	case blackHoleStateBlocked:
+		return network.BlackHoleStateBlocked
+	default:
+		return network.BlackHoleStateProbing
 	}
 }
 
-// blackHoleConfig is the config used for black hole detection
+// blackHoleConfig is the config used for the built-in UDP and IPv6 black hole
+// detection
 type blackHoleConfig struct {
 	// Enabled enables black hole detection
 	Enabled bool
@@ -255,25 +502,43 @@ type blackHoleConfig struct {
 	MinSuccesses int
 }
 
-func newBlackHoleDetector(udpConfig, ipv6Config blackHoleConfig, mt MetricsTracer) *blackHoleDetector {
+func isUDPAddr(a ma.Multiaddr) bool  { return isProtocolAddr(a, ma.P_UDP) }
+func isIPv6Addr(a ma.Multiaddr) bool { return isProtocolAddr(a, ma.P_IP6) }
+
+func newBlackHoleDetector(udpConfig, ipv6Config blackHoleConfig, mt MetricsTracer, filters ...BlackHoleFilterConfig) *blackHoleDetector {
 	d := &blackHoleDetector{}
 
+	var configs []BlackHoleFilterConfig
 	if udpConfig.Enabled {
-		d.udp = &blackHoleFilter{
-			n:             udpConfig.N,
-			minSuccesses:  udpConfig.MinSuccesses,
-			name:          "UDP",
-			metricsTracer: mt,
-		}
+		configs = append(configs, BlackHoleFilterConfig{
+			Name:         "UDP",
+			Match:        isUDPAddr,
+			N:            udpConfig.N,
+			MinSuccesses: udpConfig.MinSuccesses,
+		})
 	}
-
 	if ipv6Config.Enabled {
-		d.ipv6 = &blackHoleFilter{
-			n:             ipv6Config.N,
-			minSuccesses:  ipv6Config.MinSuccesses,
-			name:          "IPv6",
+		configs = append(configs, BlackHoleFilterConfig{
+			Name:         "IPv6",
+			Match:        isIPv6Addr,
+			N:            ipv6Config.N,
+			MinSuccesses: ipv6Config.MinSuccesses,
+		})
+	}
+	configs = append(configs, filters...)
+
+	perRoute := false
+	for _, c := range configs {
+		d.groups = append(d.groups, &blackHoleFilterGroup{
+			config:        c,
 			metricsTracer: mt,
-		}
+			onStateChange: d.stateChanged,
+			filters:       make(map[string]*blackHoleFilter),
+		})
+		perRoute = perRoute || c.PerRoute
+	}
+	if perRoute {
+		d.routeOf = newRouteResolver().routeOf
 	}
 	return d
 }
@@ -2,7 +2,13 @@ package swarm
 
 import (
 	"fmt"
+	"net"
 	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/event"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/p2p/host/eventbus"
 
 	ma "github.com/multiformats/go-multiaddr"
 	"github.com/stretchr/testify/require"
@@ -127,10 +133,11 @@ func TestBlackHoleDetectorIPv6Disabled(t *testing.T) {
 }
 
 func TestBlackHoleDetectorProbes(t *testing.T) {
-	bhd := &blackHoleDetector{
-		udp:  &blackHoleFilter{n: 2, minSuccesses: 1, name: "udp"},
-		ipv6: &blackHoleFilter{n: 3, minSuccesses: 1, name: "ipv6"},
-	}
+	bhd := newBlackHoleDetector(
+		blackHoleConfig{Enabled: true, N: 2, MinSuccesses: 1},
+		blackHoleConfig{Enabled: true, N: 3, MinSuccesses: 1},
+		nil,
+	)
 	udp6Addr := ma.StringCast("/ip6/1::1/udp/1234/quic-v1")
 	addrs := []ma.Multiaddr{udp6Addr}
 	for i := 0; i < 3; i++ {
@@ -162,10 +169,11 @@ func TestBlackHoleDetectorAddrFiltering(t *testing.T) {
 	tcp4Pri := ma.StringCast("/ip4/192.168.1.5/tcp/1234/quic-v1")
 
 	makeBHD := func(udpBlocked, ipv6Blocked bool) *blackHoleDetector {
-		bhd := &blackHoleDetector{
-			udp:  &blackHoleFilter{n: 100, minSuccesses: 10, name: "udp"},
-			ipv6: &blackHoleFilter{n: 100, minSuccesses: 10, name: "ipv6"},
-		}
+		bhd := newBlackHoleDetector(
+			blackHoleConfig{Enabled: true, N: 100, MinSuccesses: 10},
+			blackHoleConfig{Enabled: true, N: 100, MinSuccesses: 10},
+			nil,
+		)
 		for i := 0; i < 100; i++ {
 			bhd.RecordResult(udp4Pub, !udpBlocked)
 		}
@@ -199,3 +207,97 @@ func TestBlackHoleDetectorAddrFiltering(t *testing.T) {
 	require.ElementsMatch(t, bothBlockedOutput, gotAddrs)
 	require.ElementsMatch(t, bothPublicAddrs, gotRemovedAddrs)
 }
+
+func TestBlackHoleDetectorCustomFilter(t *testing.T) {
+	ws := ma.StringCast("/ip4/1.2.3.4/tcp/1234/ws")
+	tcp := ma.StringCast("/ip4/1.2.3.4/tcp/1234")
+	bhd := newBlackHoleDetector(blackHoleConfig{}, blackHoleConfig{}, nil, BlackHoleFilterConfig{
+		Name:         "WebSocket",
+		Match:        func(a ma.Multiaddr) bool { return isProtocolAddr(a, ma.P_WS) },
+		N:            10,
+		MinSuccesses: 1,
+	})
+	for i := 0; i < 10; i++ {
+		bhd.RecordResult(ws, false)
+		bhd.RecordResult(tcp, false)
+	}
+	gotAddrs, gotRemovedAddrs := bhd.FilterAddrs([]ma.Multiaddr{ws, tcp})
+	require.Equal(t, []ma.Multiaddr{tcp}, gotAddrs)
+	require.Equal(t, []ma.Multiaddr{ws}, gotRemovedAddrs)
+}
+
+func TestBlackHoleDetectorPerRoute(t *testing.T) {
+	eth0 := ma.StringCast("/ip4/1.2.3.4/udp/1234/quic-v1")
+	wlan0 := ma.StringCast("/ip4/5.6.7.8/udp/1234/quic-v1")
+	bhd := newBlackHoleDetector(blackHoleConfig{}, blackHoleConfig{}, nil, BlackHoleFilterConfig{
+		Name:         "UDP",
+		Match:        isUDPAddr,
+		N:            10,
+		MinSuccesses: 1,
+		PerRoute:     true,
+	})
+	bhd.routeOf = func(ip net.IP) string {
+		if ip.Equal(net.IPv4(1, 2, 3, 4)) {
+			return "eth0"
+		}
+		return "wlan0"
+	}
+	for i := 0; i < 10; i++ {
+		bhd.RecordResult(eth0, false)
+		bhd.RecordResult(wlan0, true)
+	}
+	require.Equal(t, blackHoleStateBlocked, bhd.filter("UDP", "eth0").state)
+	require.Equal(t, blackHoleStateAllowed, bhd.filter("UDP", "wlan0").state)
+
+	gotAddrs, gotRemovedAddrs := bhd.FilterAddrs([]ma.Multiaddr{eth0, wlan0})
+	require.Equal(t, []ma.Multiaddr{wlan0}, gotAddrs)
+	require.Equal(t, []ma.Multiaddr{eth0}, gotRemovedAddrs)
+}
+
+func TestRouteResolver(t *testing.T) {
+	r := newRouteResolver()
+	loopback := net.IPv4(127, 0, 0, 1)
+	require.Eventually(t, func() bool { return r.routeOf(loopback) != "" }, 5*time.Second, 10*time.Millisecond)
+	route := r.routeOf(loopback)
+
+	// a stale routing table is still used while it's read again
+	r.mu.Lock()
+	r.updated = time.Now().Add(-2 * routeRefreshInterval)
+	r.mu.Unlock()
+	require.Equal(t, route, r.routeOf(loopback))
+	require.Eventually(t, func() bool {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		return !r.refreshing && time.Since(r.updated) < routeRefreshInterval
+	}, 5*time.Second, 10*time.Millisecond)
+}
+
+func TestBlackHoleDetectorStateChangedEvent(t *testing.T) {
+	bus := eventbus.NewBus()
+	sub, err := bus.Subscribe(new(event.EvtBlackHoleStateChanged))
+	require.NoError(t, err)
+	defer sub.Close()
+	em, err := bus.Emitter(new(event.EvtBlackHoleStateChanged))
+	require.NoError(t, err)
+	defer em.Close()
+
+	bhd := newBlackHoleDetector(blackHoleConfig{Enabled: true, N: 3, MinSuccesses: 1}, blackHoleConfig{}, nil)
+	bhd.emitter = em
+	addr := ma.StringCast("/ip4/1.2.3.4/udp/1234/quic-v1")
+	for i := 0; i < 3; i++ {
+		bhd.RecordResult(addr, false)
+	}
+	bhd.RecordResult(addr, true)
+
+	for _, st := range []network.BlackHoleState{network.BlackHoleStateBlocked, network.BlackHoleStateProbing} {
+		select {
+		case e := <-sub.Out():
+			evt := e.(event.EvtBlackHoleStateChanged)
+			require.Equal(t, "UDP", evt.Name)
+			require.Empty(t, evt.Route)
+			require.Equal(t, st, evt.State)
+		case <-time.After(time.Second):
+			t.Fatal("expected a black hole state changed event")
+		}
+	}
+}
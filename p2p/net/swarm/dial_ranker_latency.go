@@ -0,0 +1,309 @@
+package swarm
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/p2p/metricshelper"
+
+	lru "github.com/hashicorp/golang-lru/v2"
+	ma "github.com/multiformats/go-multiaddr"
+	manet "github.com/multiformats/go-multiaddr/net"
+)
+
+const (
+	// DefaultLatencyRankerMaxPeers is the default number of peers the
+	// LatencyDialRanker keeps the dial history for.
+	DefaultLatencyRankerMaxPeers = 1024
+
+	// DefaultLatencyRankerMinDelay and DefaultLatencyRankerMaxDelay bound the
+	// delay between two consecutive dials scheduled by the LatencyDialRanker.
+	DefaultLatencyRankerMinDelay = PrivateTCPDelay
+	DefaultLatencyRankerMaxDelay = 2 * PublicTCPDelay
+
+	// latencyRankerDecay is the weight of a new observation in the moving
+	// averages of the success rate and the handshake latency.
+	latencyRankerDecay = 0.25
+	// latencyRankerMinSuccessRate is the success rate assumed for a transport
+	// that never succeeded, so that it is tried eventually.
+	latencyRankerMinSuccessRate = 0.05
+	// latencyRankerMinGlobalDials is the number of dials to a transport,
+	// across all peers, after which the global history is used for peers we
+	// don't have any history for.
+	latencyRankerMinGlobalDials = 10
+	// latencyRankerPriorSuccessRate is the success rate assumed for
+	// transports without any history.
+	latencyRankerPriorSuccessRate = 0.5
+)
+
+// dialStats are the moving averages of the outcome of dials.
+type dialStats struct {
+	dials       int
+	successRate float64
+	// latency is the average duration of successful dials, including the
+	// security and muxer handshakes.
+	latency time.Duration
+}
+
+func (s *dialStats) record(success bool, d time.Duration) {
+	var v float64
+	if success {
+		v = 1
+	}
+	if s.dials == 0 {
+		s.successRate = v
+	} else {
+		s.successRate += latencyRankerDecay * (v - s.successRate)
+	}
+	if success {
+		if s.latency == 0 {
+			s.latency = d
+		} else {
+			s.latency += time.Duration(latencyRankerDecay * float64(d-s.latency))
+		}
+	}
+	s.dials++
+}
+
+// dialHistoryKey identifies the transport an address is dialed with.
+type dialHistoryKey struct {
+	transport string
+	ipVersion string
+	private   bool
+}
+
+func dialHistoryKeyFor(a ma.Multiaddr) dialHistoryKey {
+	return dialHistoryKey{
+		transport: metricshelper.GetTransport(a),
+		ipVersion: metricshelper.GetIPVersion(a),
+		private:   manet.IsPrivateAddr(a),
+	}
+}
+
+// LatencyDialRanker is a dial ranker that learns from the outcome of previous
+// dials. It records the success rate and the handshake latency per peer and
+// transport, as well as per transport across all peers, and uses them to
+// order and stagger the addresses of a peer: transports that reliably connect
+// fast are dialed first, and transports that keep failing for a peer (e.g.
+// because UDP is black holed on its network) are dialed last.
+//
+// Addresses of peers without any history are ranked using the per transport
+// history. If there is no history at all, the fallback ranker is used.
+//
+// Use WithLatencyDialRanker to configure the swarm to use it.
+type LatencyDialRanker struct {
+	fallback network.DialRanker
+	minDelay time.Duration
+	maxDelay time.Duration
+
+	mx     sync.Mutex
+	peers  *lru.Cache[peer.ID, map[dialHistoryKey]*dialStats]
+	global map[dialHistoryKey]*dialStats
+}
+
+type latencyDialRankerConfig struct {
+	maxPeers int
+	fallback network.DialRanker
+	minDelay time.Duration
+	maxDelay time.Duration
+}
+
+// LatencyDialRankerOption configures the LatencyDialRanker.
+type LatencyDialRankerOption func(*latencyDialRankerConfig) error
+
+// WithLatencyRankerMaxPeers sets the number of peers to keep the dial history
+// for. The history of the least recently dialed peers is discarded first.
+func WithLatencyRankerMaxPeers(n int) LatencyDialRankerOption {
+	return func(c *latencyDialRankerConfig) error {
+		if n <= 0 {
+			return errors.New("max peers must be positive")
+		}
+		c.maxPeers = n
+		return nil
+	}
+}
+
+// WithLatencyRankerFallback sets the ranker used when there is no history for
+// any of the addresses. Defaults to DefaultDialRanker.
+func WithLatencyRankerFallback(d network.DialRanker) LatencyDialRankerOption {
+	return func(c *latencyDialRankerConfig) error {
+		if d == nil {
+			return errors.New("fallback ranker cannot be nil")
+		}
+		c.fallback = d
+		return nil
+	}
+}
+
+// WithLatencyRankerDelays sets the bounds of the delay between two consecutive
+// dials.
+func WithLatencyRankerDelays(min, max time.Duration) LatencyDialRankerOption {
+	return func(c *latencyDialRankerConfig) error {
+		if min < 0 || max < min {
+			return errors.New("invalid delays")
+		}
+		c.minDelay = min
+		c.maxDelay = max
+		return nil
+	}
+}
+
+// NewLatencyDialRanker creates a new LatencyDialRanker.
+func NewLatencyDialRanker(opts ...LatencyDialRankerOption) (*LatencyDialRanker, error) {
+	cfg := latencyDialRankerConfig{
+		maxPeers: DefaultLatencyRankerMaxPeers,
+		fallback: DefaultDialRanker,
+		minDelay: DefaultLatencyRankerMinDelay,
+		maxDelay: DefaultLatencyRankerMaxDelay,
+	}
+	for _, opt := range opts {
+		if err := opt(&cfg); err != nil {
+			return nil, err
+		}
+	}
+	peers, err := lru.New[peer.ID, map[dialHistoryKey]*dialStats](cfg.maxPeers)
+	if err != nil {
+		return nil, err
+	}
+	return &LatencyDialRanker{
+		fallback: cfg.fallback,
+		minDelay: cfg.minDelay,
+		maxDelay: cfg.maxDelay,
+		peers:    peers,
+		global:   make(map[dialHistoryKey]*dialStats),
+	}, nil
+}
+
+// RecordDial records the outcome of a dial to addr. d is the time it took to
+// establish the connection, or to fail. Dials that were canceled, e.g. because
+// a concurrent dial succeeded, are ignored.
+func (r *LatencyDialRanker) RecordDial(p peer.ID, addr ma.Multiaddr, d time.Duration, err error) {
+	r.recordDial(p, addr, d, err)
+}
+
+// recordDial records the outcome of a dial, and returns the success rate of
+// the transport across all peers. ok is false if the dial was ignored, or if
+// the address is not a public address.
+func (r *LatencyDialRanker) recordDial(p peer.ID, addr ma.Multiaddr, d time.Duration, err error) (successRate float64, ok bool) {
+	if errors.Is(err, context.Canceled) {
+		return 0, false
+	}
+	key := dialHistoryKeyFor(addr)
+
+	r.mx.Lock()
+	defer r.mx.Unlock()
+
+	history, found := r.peers.Get(p)
+	if !found {
+		history = make(map[dialHistoryKey]*dialStats)
+		r.peers.Add(p, history)
+	}
+	s, found := history[key]
+	if !found {
+		s = &dialStats{}
+		history[key] = s
+	}
+	s.record(err == nil, d)
+
+	// Whether private addresses are reachable depends on the network of the
+	// peer, so the history of other peers isn't useful.
+	if key.private {
+		return 0, false
+	}
+	g, found := r.global[key]
+	if !found {
+		g = &dialStats{}
+		r.global[key] = g
+	}
+	g.record(err == nil, d)
+	return g.successRate, true
+}
+
+// estimate returns the expected success rate and latency of a dial to an
+// address with the given key. known is false if there is no history.
+func (r *LatencyDialRanker) estimate(history map[dialHistoryKey]*dialStats, key dialHistoryKey) (successRate float64, latency time.Duration, known bool) {
+	s, ok := history[key]
+	if !ok || s.dials == 0 {
+		s, ok = r.global[key]
+		if !ok || s.dials < latencyRankerMinGlobalDials {
+			latency := PublicQUICDelay
+			if key.private {
+				latency = PrivateQUICDelay
+			}
+			return latencyRankerPriorSuccessRate, latency, false
+		}
+	}
+	latency = s.latency
+	if latency == 0 {
+		// never succeeded
+		latency = r.maxDelay
+	}
+	return s.successRate, latency, true
+}
+
+// RankAddrs ranks the addresses of peer p for dialing.
+//
+// Direct addresses are sorted by their expected time to connect, i.e. their
+// average handshake latency divided by their success rate. Every address is
+// delayed relative to the previous one by the time the previous one is
+// expected to take to connect, bounded by the minimum and maximum delay.
+// Relay addresses are dialed after all direct addresses, like
+// DefaultDialRanker does.
+func (r *LatencyDialRanker) RankAddrs(p peer.ID, addrs []ma.Multiaddr) []network.AddrDelay {
+	type rankedAddr struct {
+		addr        ma.Multiaddr
+		successRate float64
+		latency     time.Duration
+		cost        time.Duration
+		score       int
+	}
+
+	relay, direct := filterAddrs(addrs, isRelayAddr)
+
+	ranked := make([]rankedAddr, 0, len(direct))
+	var known int
+	r.mx.Lock()
+	history, _ := r.peers.Peek(p)
+	for _, a := range direct {
+		successRate, latency, ok := r.estimate(history, dialHistoryKeyFor(a))
+		if ok {
+			known++
+		}
+		cost := time.Duration(float64(latency) / max(successRate, latencyRankerMinSuccessRate))
+		ranked = append(ranked, rankedAddr{addr: a, successRate: successRate, latency: latency, cost: cost, score: score(a)})
+	}
+	r.mx.Unlock()
+
+	if known == 0 {
+		return r.fallback(addrs)
+	}
+
+	sort.SliceStable(ranked, func(i, j int) bool {
+		if ranked[i].cost != ranked[j].cost {
+			return ranked[i].cost < ranked[j].cost
+		}
+		return ranked[i].score < ranked[j].score
+	})
+
+	res := make([]network.AddrDelay, 0, len(addrs))
+	var delay time.Duration
+	for _, a := range ranked {
+		res = append(res, network.AddrDelay{Addr: a.addr, Delay: delay})
+		// Wait for the address to connect before dialing the next one. If
+		// it's unlikely to connect, don't wait as long.
+		step := time.Duration(float64(a.latency) * a.successRate)
+		delay += min(max(step, r.minDelay), r.maxDelay)
+	}
+
+	var relayOffset time.Duration
+	if len(ranked) > 0 {
+		relayOffset = res[len(res)-1].Delay + RelayDelay
+	}
+	res = append(res, getAddrDelay(relay, PublicTCPDelay, PublicQUICDelay, relayOffset)...)
+	return res
+}
@@ -0,0 +1,151 @@
+package swarm_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/peerstore"
+	"github.com/libp2p/go-libp2p/core/test"
+	"github.com/libp2p/go-libp2p/core/transport"
+	. "github.com/libp2p/go-libp2p/p2p/net/swarm"
+	swarmt "github.com/libp2p/go-libp2p/p2p/net/swarm/testing"
+	"github.com/libp2p/go-libp2p/p2p/transport/tcp"
+
+	ma "github.com/multiformats/go-multiaddr"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLatencyDialRankerFallback(t *testing.T) {
+	r, err := NewLatencyDialRanker()
+	require.NoError(t, err)
+
+	addrs := []ma.Multiaddr{
+		ma.StringCast("/ip4/1.2.3.4/tcp/1"),
+		ma.StringCast("/ip4/1.2.3.4/udp/1/quic-v1"),
+	}
+	p := test.RandPeerIDFatal(t)
+	require.ElementsMatch(t, DefaultDialRanker(append([]ma.Multiaddr{}, addrs...)), r.RankAddrs(p, append([]ma.Multiaddr{}, addrs...)))
+}
+
+func TestLatencyDialRankerBlackHoledQUIC(t *testing.T) {
+	r, err := NewLatencyDialRanker()
+	require.NoError(t, err)
+
+	quicAddr := ma.StringCast("/ip4/1.2.3.4/udp/1/quic-v1")
+	tcpAddr := ma.StringCast("/ip4/1.2.3.4/tcp/1")
+	relayAddr := ma.StringCast("/ip4/1.2.3.5/tcp/1/p2p/QmPKMmq2iwNuk4RSaqBVvS1MoS5uyrkw2W3TsvUoY8kY76/p2p-circuit")
+	p := test.RandPeerIDFatal(t)
+	for i := 0; i < 3; i++ {
+		r.RecordDial(p, quicAddr, 5*time.Second, context.DeadlineExceeded)
+		r.RecordDial(p, tcpAddr, 100*time.Millisecond, nil)
+	}
+
+	res := r.RankAddrs(p, []ma.Multiaddr{quicAddr, relayAddr, tcpAddr})
+	require.Len(t, res, 3)
+	require.Equal(t, network.AddrDelay{Addr: tcpAddr, Delay: 0}, res[0])
+	require.Equal(t, quicAddr, res[1].Addr)
+	require.Equal(t, 100*time.Millisecond, res[1].Delay)
+	require.Equal(t, relayAddr, res[2].Addr)
+	require.Equal(t, 100*time.Millisecond+RelayDelay, res[2].Delay)
+
+	// canceled dials are ignored
+	for i := 0; i < 10; i++ {
+		r.RecordDial(p, tcpAddr, time.Millisecond, context.Canceled)
+	}
+	res = r.RankAddrs(p, []ma.Multiaddr{quicAddr, tcpAddr})
+	require.Equal(t, tcpAddr, res[0].Addr)
+	require.Equal(t, 100*time.Millisecond, res[1].Delay)
+
+	// QUIC starts working again
+	for i := 0; i < 10; i++ {
+		r.RecordDial(p, quicAddr, 20*time.Millisecond, nil)
+	}
+	res = r.RankAddrs(p, []ma.Multiaddr{quicAddr, tcpAddr})
+	require.Equal(t, quicAddr, res[0].Addr)
+	require.Equal(t, tcpAddr, res[1].Addr)
+}
+
+func TestLatencyDialRankerGlobalHistory(t *testing.T) {
+	r, err := NewLatencyDialRanker(WithLatencyRankerMaxPeers(2))
+	require.NoError(t, err)
+
+	quicAddr := ma.StringCast("/ip4/1.2.3.4/udp/1/quic-v1")
+	tcpAddr := ma.StringCast("/ip4/1.2.3.4/tcp/1")
+	for i := 0; i < 10; i++ {
+		p := test.RandPeerIDFatal(t)
+		r.RecordDial(p, quicAddr, time.Second, errors.New("timeout"))
+		r.RecordDial(p, tcpAddr, 50*time.Millisecond, nil)
+	}
+
+	// a peer we've never dialed
+	res := r.RankAddrs(test.RandPeerIDFatal(t), []ma.Multiaddr{quicAddr, tcpAddr})
+	require.Equal(t, tcpAddr, res[0].Addr)
+	require.Equal(t, quicAddr, res[1].Addr)
+	require.Equal(t, 50*time.Millisecond, res[1].Delay)
+}
+
+func TestLatencyDialRankerOptions(t *testing.T) {
+	_, err := NewLatencyDialRanker(WithLatencyRankerMaxPeers(0))
+	require.Error(t, err)
+	_, err = NewLatencyDialRanker(WithLatencyRankerDelays(time.Second, time.Millisecond))
+	require.Error(t, err)
+	_, err = NewLatencyDialRanker(WithLatencyRankerFallback(nil))
+	require.Error(t, err)
+}
+
+func TestLatencyDialRankerSwarm(t *testing.T) {
+	r, err := NewLatencyDialRanker(WithLatencyRankerDelays(0, time.Second))
+	require.NoError(t, err)
+	s1 := swarmt.GenSwarm(t, swarmt.OptDisableQUIC, swarmt.WithSwarmOpts(WithLatencyDialRanker(r)))
+	s2 := swarmt.GenSwarm(t, swarmt.OptDisableQUIC)
+	defer s1.Close()
+	defer s2.Close()
+
+	s1.Peerstore().AddAddrs(s2.LocalPeer(), s2.ListenAddresses(), peerstore.PermanentAddrTTL)
+	_, err = s1.DialPeer(context.Background(), s2.LocalPeer())
+	require.NoError(t, err)
+
+	// DefaultDialRanker would dial QUIC first, but we know that TCP works
+	quicAddr := ma.StringCast("/ip4/127.0.0.1/udp/1234/quic-v1")
+	res := r.RankAddrs(s2.LocalPeer(), append([]ma.Multiaddr{quicAddr}, s2.ListenAddresses()...))
+	require.Equal(t, s2.ListenAddresses()[0], res[0].Addr)
+	require.Equal(t, quicAddr, res[1].Addr)
+}
+
+// wrongPeerTransport connects to peer instead of the peer that is dialed.
+type wrongPeerTransport struct {
+	transport.Transport
+	peer peer.ID
+}
+
+func (t *wrongPeerTransport) Dial(ctx context.Context, raddr ma.Multiaddr, _ peer.ID) (transport.CapableConn, error) {
+	return t.Transport.Dial(ctx, raddr, t.peer)
+}
+
+func TestLatencyDialRankerSwarmWrongPeer(t *testing.T) {
+	r, err := NewLatencyDialRanker(WithLatencyRankerDelays(0, time.Second))
+	require.NoError(t, err)
+	s1 := swarmt.GenSwarm(t, swarmt.OptDisableTCP, swarmt.OptDisableQUIC, swarmt.WithSwarmOpts(WithLatencyDialRanker(r)))
+	s2 := swarmt.GenSwarm(t, swarmt.OptDisableQUIC)
+	defer s1.Close()
+	defer s2.Close()
+
+	tpt, err := tcp.NewTCPTransport(swarmt.GenUpgrader(t, s1, nil), nil)
+	require.NoError(t, err)
+	require.NoError(t, s1.AddTransport(&wrongPeerTransport{Transport: tpt, peer: s2.LocalPeer()}))
+
+	p := test.RandPeerIDFatal(t)
+	s1.Peerstore().AddAddrs(p, s2.ListenAddresses(), peerstore.PermanentAddrTTL)
+	_, err = s1.DialPeer(context.Background(), p)
+	require.Error(t, err)
+
+	// The connection to the wrong peer counts as a failed dial, so an address
+	// without any history is preferred.
+	quicAddr := ma.StringCast("/ip4/127.0.0.1/udp/1234/quic-v1")
+	res := r.RankAddrs(p, append([]ma.Multiaddr{quicAddr}, s2.ListenAddresses()...))
+	require.Equal(t, quicAddr, res[0].Addr)
+}
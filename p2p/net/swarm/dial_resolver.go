@@ -0,0 +1,236 @@
+package swarm
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/transport"
+
+	ma "github.com/multiformats/go-multiaddr"
+	madns "github.com/multiformats/go-multiaddr-dns"
+)
+
+// resolutionDelay is the time we wait for the IPv6 addresses of a host name
+// after its IPv4 addresses have been resolved, before passing the IPv4
+// addresses on. This is the Resolution Delay recommended by RFC 8305.
+const resolutionDelay = 50 * time.Millisecond
+
+// resolveAddrsStream resolves the addresses of pi, and calls found with every
+// batch of resolved addresses as soon as it's available. The addresses that
+// don't need to be resolved are passed to found first, in a single batch.
+//
+// Host names are resolved concurrently. The IPv6 and IPv4 addresses of /dns
+// addresses are looked up in parallel, and the IPv4 addresses are held back
+// for up to resolutionDelay to give the IPv6 addresses a head start, as in
+// RFC 8305. /dnsaddr records are resolved recursively, also in parallel.
+//
+// Calls to found are serialized. resolveAddrsStream returns once all
+// addresses have been resolved, or ctx is done.
+func (s *Swarm) resolveAddrsStream(ctx context.Context, pi peer.AddrInfo, found func([]ma.Multiaddr)) error {
+	p2paddr, err := ma.NewMultiaddr("/" + ma.ProtocolWithCode(ma.P_P2P).Name + "/" + pi.ID.String())
+	if err != nil {
+		return err
+	}
+	r := &addrResolver{
+		s:       s,
+		ctx:     ctx,
+		p:       pi.ID,
+		p2paddr: p2paddr,
+		found:   found,
+	}
+	r.resolve(pi.Addrs)
+	r.wg.Wait()
+	return nil
+}
+
+// addrResolver resolves the addresses of a peer.
+type addrResolver struct {
+	s       *Swarm
+	ctx     context.Context
+	p       peer.ID
+	p2paddr ma.Multiaddr
+
+	wg sync.WaitGroup
+
+	mx    sync.Mutex
+	found func([]ma.Multiaddr)
+	// steps is the number of resolution steps performed so far
+	steps int
+}
+
+// resolve passes the addresses that are resolved already on, and starts
+// resolving the other ones.
+func (r *addrResolver) resolve(addrs []ma.Multiaddr) {
+	var resolved []ma.Multiaddr
+	for _, a := range addrs {
+		if !madns.Matches(a) {
+			resolved = append(resolved, a)
+			continue
+		}
+		if !r.takeStep() {
+			continue
+		}
+		r.wg.Add(1)
+		go func(a ma.Multiaddr) {
+			defer r.wg.Done()
+			r.resolveAddr(a)
+		}(a)
+	}
+	if len(resolved) > 0 {
+		r.mx.Lock()
+		r.found(interleaveAddrFamilies(resolved))
+		r.mx.Unlock()
+	}
+}
+
+// takeStep reports whether another resolution step is allowed.
+func (r *addrResolver) takeStep() bool {
+	r.mx.Lock()
+	defer r.mx.Unlock()
+	r.steps++
+	// We've resolved too many addresses. We can keep all the fully
+	// resolved addresses but we'll need to skip the rest.
+	if r.steps >= maxAddressResolution {
+		log.Warnf(
+			"peer %s asked us to resolve too many addresses: %d/%d",
+			r.p,
+			r.steps,
+			maxAddressResolution,
+		)
+		return false
+	}
+	return true
+}
+
+func (r *addrResolver) resolveAddr(addr ma.Multiaddr) {
+	if r.ctx.Err() != nil {
+		return
+	}
+
+	tpt := r.s.TransportForDialing(addr)
+	if resolver, ok := tpt.(transport.Resolver); ok {
+		resolvedAddrs, err := resolver.Resolve(r.ctx, addr)
+		if err != nil {
+			log.Warnf("Failed to resolve multiaddr %s by transport %v: %v", addr, tpt, err)
+			return
+		}
+		var next []ma.Multiaddr
+		for _, a := range resolvedAddrs {
+			if !addr.Equal(a) {
+				next = append(next, a)
+			}
+		}
+		if len(next) > 0 {
+			r.resolve(next)
+			return
+		}
+	}
+
+	ip6addr, ip4addr, ok := splitDNSAddr(addr)
+	if !ok {
+		r.resolve(r.lookup(addr))
+		return
+	}
+
+	// Look up the IPv6 and IPv4 addresses in parallel. Don't wait for the
+	// IPv6 lookup to pass the IPv6 addresses on, but give it some time to
+	// complete if the IPv4 lookup completes first.
+	ip6Done := make(chan struct{})
+	r.wg.Add(1)
+	go func() {
+		defer r.wg.Done()
+		defer close(ip6Done)
+		r.resolve(r.lookup(ip6addr))
+	}()
+
+	addrs := r.lookup(ip4addr)
+	if len(addrs) > 0 {
+		t := time.NewTimer(resolutionDelay)
+		select {
+		case <-ip6Done:
+		case <-t.C:
+		case <-r.ctx.Done():
+		}
+		t.Stop()
+	}
+	r.resolve(addrs)
+}
+
+// lookup resolves addr using the multiaddr resolver of the swarm.
+func (r *addrResolver) lookup(addr ma.Multiaddr) []ma.Multiaddr {
+	reqaddr := addr.Encapsulate(r.p2paddr)
+	resaddrs, err := r.s.maResolver.Resolve(r.ctx, reqaddr)
+	if err != nil {
+		log.Infof("error resolving %s: %s", reqaddr, err)
+	}
+
+	var addrs []ma.Multiaddr
+	for _, res := range resaddrs {
+		pi, err := peer.AddrInfoFromP2pAddr(res)
+		if err != nil {
+			log.Infof("error parsing %s: %s", res, err)
+			continue
+		}
+		addrs = append(addrs, pi.Addrs...)
+	}
+	return addrs
+}
+
+// splitDNSAddr splits a /dns address into the corresponding /dns6 and /dns4
+// addresses, so that the IPv6 and IPv4 addresses can be looked up separately.
+// ok is false if addr isn't a /dns address.
+func splitDNSAddr(addr ma.Multiaddr) (ip6addr, ip4addr ma.Multiaddr, ok bool) {
+	first, rest := ma.SplitFirst(addr)
+	if first == nil || first.Protocol().Code != ma.P_DNS {
+		return nil, nil, false
+	}
+	dns6, err := ma.NewComponent("dns6", first.Value())
+	if err != nil {
+		return nil, nil, false
+	}
+	dns4, err := ma.NewComponent("dns4", first.Value())
+	if err != nil {
+		return nil, nil, false
+	}
+	if rest == nil {
+		return dns6, dns4, true
+	}
+	return dns6.Encapsulate(rest), dns4.Encapsulate(rest), true
+}
+
+// interleaveAddrFamilies orders addrs so that IPv6 and IPv4 addresses
+// alternate, starting with IPv6, as recommended by RFC 8305. The relative
+// order of the addresses of a family, and the position of the addresses that
+// are neither IPv6 nor IPv4 addresses are preserved.
+func interleaveAddrFamilies(addrs []ma.Multiaddr) []ma.Multiaddr {
+	var ip6, ip4 []ma.Multiaddr
+	for _, a := range addrs {
+		if isProtocolAddr(a, ma.P_IP6) {
+			ip6 = append(ip6, a)
+		} else if isProtocolAddr(a, ma.P_IP4) {
+			ip4 = append(ip4, a)
+		}
+	}
+	if len(ip6) == 0 || len(ip4) == 0 {
+		return addrs
+	}
+
+	res := make([]ma.Multiaddr, 0, len(addrs))
+	var i6, i4 int
+	for _, a := range addrs {
+		if !isProtocolAddr(a, ma.P_IP6) && !isProtocolAddr(a, ma.P_IP4) {
+			res = append(res, a)
+			continue
+		}
+		if (i6 <= i4 && i6 < len(ip6)) || i4 == len(ip4) {
+			res = append(res, ip6[i6])
+			i6++
+		} else {
+			res = append(res, ip4[i4])
+			i4++
+		}
+	}
+	return res
+}
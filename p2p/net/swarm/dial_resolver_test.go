@@ -0,0 +1,272 @@
+package swarm
+
+import (
+	"context"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/peerstore"
+	"github.com/libp2p/go-libp2p/core/test"
+	"github.com/libp2p/go-libp2p/p2p/host/eventbus"
+
+	ma "github.com/multiformats/go-multiaddr"
+	madns "github.com/multiformats/go-multiaddr-dns"
+	"github.com/stretchr/testify/require"
+)
+
+// blockingResolver is a madns.BasicResolver that blocks all lookups until
+// unblock is closed.
+type blockingResolver struct {
+	madns.MockResolver
+	unblock chan struct{}
+}
+
+func (r *blockingResolver) LookupIPAddr(ctx context.Context, name string) ([]net.IPAddr, error) {
+	select {
+	case <-r.unblock:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	return r.MockResolver.LookupIPAddr(ctx, name)
+}
+
+func (r *blockingResolver) LookupTXT(ctx context.Context, name string) ([]string, error) {
+	select {
+	case <-r.unblock:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	return r.MockResolver.LookupTXT(ctx, name)
+}
+
+func TestInterleaveAddrFamilies(t *testing.T) {
+	ip4a := ma.StringCast("/ip4/1.2.3.4/tcp/1")
+	ip4b := ma.StringCast("/ip4/1.2.3.5/tcp/1")
+	ip4c := ma.StringCast("/ip4/1.2.3.6/tcp/1")
+	ip6a := ma.StringCast("/ip6/2001::1/tcp/1")
+	ip6b := ma.StringCast("/ip6/2001::2/tcp/1")
+	other := ma.StringCast("/dns4/example.com/tcp/1")
+
+	testCases := []struct {
+		name string
+		in   []ma.Multiaddr
+		out  []ma.Multiaddr
+	}{
+		{"ipv4 only", []ma.Multiaddr{ip4a, ip4b}, []ma.Multiaddr{ip4a, ip4b}},
+		{"ipv6 only", []ma.Multiaddr{ip6a, ip6b}, []ma.Multiaddr{ip6a, ip6b}},
+		{"ipv6 first", []ma.Multiaddr{ip4a, ip4b, ip6a, ip6b}, []ma.Multiaddr{ip6a, ip4a, ip6b, ip4b}},
+		{"more ipv4", []ma.Multiaddr{ip4a, ip4b, ip4c, ip6a}, []ma.Multiaddr{ip6a, ip4a, ip4b, ip4c}},
+		{"other addrs", []ma.Multiaddr{other, ip4a, ip6a}, []ma.Multiaddr{other, ip6a, ip4a}},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			require.Equal(t, tc.out, interleaveAddrFamilies(tc.in))
+		})
+	}
+}
+
+func TestResolveAddrsStream(t *testing.T) {
+	p := test.RandPeerIDFatal(t)
+	backend := &madns.MockResolver{
+		IP: map[string][]net.IPAddr{
+			"example.com": {{IP: net.ParseIP("1.2.3.4")}, {IP: net.ParseIP("2001::1")}},
+		},
+		TXT: map[string][]string{
+			"_dnsaddr.example.com":   {"dnsaddr=/dnsaddr/a.example.com/p2p/" + p.String(), "dnsaddr=/dnsaddr/b.example.com/p2p/" + p.String()},
+			"_dnsaddr.a.example.com": {"dnsaddr=/ip4/1.1.1.1/tcp/1/p2p/" + p.String()},
+			"_dnsaddr.b.example.com": {"dnsaddr=/ip4/2.2.2.2/tcp/1/p2p/" + p.String()},
+		},
+	}
+	resolver, err := madns.NewResolver(madns.WithDefaultResolver(backend))
+	require.NoError(t, err)
+	s := newTestSwarmWithResolver(t, resolver)
+
+	t.Run("ipv6 first", func(t *testing.T) {
+		var batches [][]ma.Multiaddr
+		err := s.resolveAddrsStream(context.Background(), peer.AddrInfo{
+			ID:    p,
+			Addrs: []ma.Multiaddr{ma.StringCast("/dns/example.com/tcp/1"), ma.StringCast("/ip4/5.5.5.5/tcp/1")},
+		}, func(addrs []ma.Multiaddr) {
+			batches = append(batches, addrs)
+		})
+		require.NoError(t, err)
+		require.Equal(t, [][]ma.Multiaddr{
+			{ma.StringCast("/ip4/5.5.5.5/tcp/1")},
+			{ma.StringCast("/ip6/2001::1/tcp/1")},
+			{ma.StringCast("/ip4/1.2.3.4/tcp/1")},
+		}, batches)
+	})
+
+	t.Run("dnsaddr", func(t *testing.T) {
+		var mx sync.Mutex
+		var resolved []string
+		err := s.resolveAddrsStream(context.Background(), peer.AddrInfo{
+			ID:    p,
+			Addrs: []ma.Multiaddr{ma.StringCast("/dnsaddr/example.com")},
+		}, func(addrs []ma.Multiaddr) {
+			mx.Lock()
+			defer mx.Unlock()
+			for _, a := range addrs {
+				resolved = append(resolved, a.String())
+			}
+		})
+		require.NoError(t, err)
+		require.ElementsMatch(t, []string{"/ip4/1.1.1.1/tcp/1", "/ip4/2.2.2.2/tcp/1"}, resolved)
+	})
+}
+
+func TestResolveAddrsStreamParallel(t *testing.T) {
+	p := test.RandPeerIDFatal(t)
+	backend := &blockingResolver{
+		MockResolver: madns.MockResolver{IP: map[string][]net.IPAddr{
+			"slow.example.com": {{IP: net.ParseIP("1.2.3.4")}},
+		}},
+		unblock: make(chan struct{}),
+	}
+	resolver, err := madns.NewResolver(madns.WithDomainResolver("slow.example.com", backend))
+	require.NoError(t, err)
+	s := newTestSwarmWithResolver(t, resolver)
+
+	var mx sync.Mutex
+	var resolved []ma.Multiaddr
+	done := make(chan error)
+	go func() {
+		done <- s.resolveAddrsStream(context.Background(), peer.AddrInfo{
+			ID:    p,
+			Addrs: []ma.Multiaddr{ma.StringCast("/dns4/slow.example.com/tcp/1"), ma.StringCast("/ip4/5.5.5.5/tcp/1")},
+		}, func(addrs []ma.Multiaddr) {
+			mx.Lock()
+			defer mx.Unlock()
+			resolved = append(resolved, addrs...)
+		})
+	}()
+
+	// the resolved addresses are passed on without waiting for the slow lookup
+	require.Eventually(t, func() bool {
+		mx.Lock()
+		defer mx.Unlock()
+		return len(resolved) == 1
+	}, 5*time.Second, 10*time.Millisecond)
+	close(backend.unblock)
+	require.NoError(t, <-done)
+	require.Equal(t, []ma.Multiaddr{ma.StringCast("/ip4/5.5.5.5/tcp/1"), ma.StringCast("/ip4/1.2.3.4/tcp/1")}, resolved)
+}
+
+func TestDialWorkerLoopResolvedAddrs(t *testing.T) {
+	backend := &blockingResolver{
+		MockResolver: madns.MockResolver{IP: map[string][]net.IPAddr{
+			"example.com": {{IP: net.ParseIP("127.0.0.1")}},
+		}},
+		unblock: make(chan struct{}),
+	}
+	resolver, err := madns.NewResolver(madns.WithDomainResolver("example.com", backend))
+	require.NoError(t, err)
+
+	s1, _ := makeTCPSwarm(t, eventbus.NewBus(), WithMultiaddrResolver(resolver))
+	s2, _ := makeTCPSwarm(t, eventbus.NewBus())
+	_, port := ma.SplitFirst(s2.ListenAddresses()[0])
+	dnsAddr := ma.StringCast("/dns4/example.com").Encapsulate(port)
+	s1.Peerstore().AddAddrs(s2.LocalPeer(), []ma.Multiaddr{dnsAddr}, peerstore.PermanentAddrTTL)
+
+	reqch := make(chan dialRequest)
+	resch := make(chan dialResponse, 1)
+	worker := newDialWorker(s1, s2.LocalPeer(), reqch, nil)
+	go worker.loop()
+	defer worker.wg.Wait()
+	defer close(reqch)
+
+	reqch <- dialRequest{ctx: context.Background(), resch: resch}
+	select {
+	case res := <-resch:
+		t.Fatalf("didn't expect a response before the address is resolved: %v", res)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	close(backend.unblock)
+	select {
+	case res := <-resch:
+		require.NoError(t, res.err)
+		require.Equal(t, s2.LocalPeer(), res.conn.RemotePeer())
+	case <-time.After(5 * time.Second):
+		t.Fatal("dial didn't complete")
+	}
+}
+
+func TestDialWorkerLoopDoesntWaitForResolution(t *testing.T) {
+	backend := &blockingResolver{unblock: make(chan struct{})}
+	resolver, err := madns.NewResolver(madns.WithDomainResolver("example.com", backend))
+	require.NoError(t, err)
+
+	s1, _ := makeTCPSwarm(t, eventbus.NewBus(), WithMultiaddrResolver(resolver))
+	s2, _ := makeTCPSwarm(t, eventbus.NewBus())
+	s1.Peerstore().AddAddrs(s2.LocalPeer(), []ma.Multiaddr{
+		ma.StringCast("/dns4/example.com/tcp/1"),
+		s2.ListenAddresses()[0],
+	}, peerstore.PermanentAddrTTL)
+
+	reqch := make(chan dialRequest)
+	resch := make(chan dialResponse, 1)
+	worker := newDialWorker(s1, s2.LocalPeer(), reqch, nil)
+	go worker.loop()
+	defer worker.wg.Wait()
+	defer close(backend.unblock)
+	defer close(reqch)
+
+	reqch <- dialRequest{ctx: context.Background(), resch: resch}
+	select {
+	case res := <-resch:
+		require.NoError(t, res.err)
+		require.Equal(t, s2.LocalPeer(), res.conn.RemotePeer())
+	case <-time.After(5 * time.Second):
+		t.Fatal("dial didn't complete")
+	}
+}
+
+func TestDialWorkerLoopFailedDialWhileResolving(t *testing.T) {
+	backend := &blockingResolver{
+		MockResolver: madns.MockResolver{IP: map[string][]net.IPAddr{
+			"example.com": {{IP: net.ParseIP("127.0.0.1")}},
+		}},
+		unblock: make(chan struct{}),
+	}
+	resolver, err := madns.NewResolver(madns.WithDomainResolver("example.com", backend))
+	require.NoError(t, err)
+
+	s1, _ := makeTCPSwarm(t, eventbus.NewBus(), WithMultiaddrResolver(resolver))
+	s2, _ := makeTCPSwarm(t, eventbus.NewBus())
+	_, port := ma.SplitFirst(s2.ListenAddresses()[0])
+	s1.Peerstore().AddAddrs(s2.LocalPeer(), []ma.Multiaddr{
+		// nothing listens on this port, so the dial fails right away
+		ma.StringCast("/ip4/127.0.0.1/tcp/1"),
+		ma.StringCast("/dns4/example.com").Encapsulate(port),
+	}, peerstore.PermanentAddrTTL)
+
+	reqch := make(chan dialRequest)
+	resch := make(chan dialResponse, 1)
+	worker := newDialWorker(s1, s2.LocalPeer(), reqch, nil)
+	go worker.loop()
+	defer worker.wg.Wait()
+	unblock := sync.OnceFunc(func() { close(backend.unblock) })
+	defer unblock()
+	defer close(reqch)
+
+	reqch <- dialRequest{ctx: context.Background(), resch: resch}
+	// the request must not fail while the DNS address is still being resolved
+	select {
+	case res := <-resch:
+		t.Fatalf("didn't expect a response before the address is resolved: %v", res)
+	case <-time.After(200 * time.Millisecond):
+	}
+
+	unblock()
+	select {
+	case res := <-resch:
+		require.NoError(t, res.err)
+		require.Equal(t, s2.LocalPeer(), res.conn.RemotePeer())
+	case <-time.After(5 * time.Second):
+		t.Fatal("dial didn't complete")
+	}
+}
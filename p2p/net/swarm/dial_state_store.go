@@ -0,0 +1,313 @@
+package swarm
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+
+	ds "github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-datastore/namespace"
+	"github.com/ipfs/go-datastore/query"
+	b32 "github.com/multiformats/go-base32"
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+const (
+	dialStateNamespace = "/libp2p/net/swarm"
+
+	// blackHoleStateTTL is the duration for which a persisted black hole
+	// filter state is considered valid. The network a node is connected to
+	// can change while it's offline, so we don't want to rely on stale
+	// state for too long.
+	blackHoleStateTTL = time.Hour
+)
+
+var (
+	backoffBase   = ds.NewKey("/backoff")
+	blackHoleBase = ds.NewKey("/blackhole")
+)
+
+// WithDialStateDatastore configures swarm to persist the dial backoffs and the
+// state of the black hole detector in d. The state is loaded when the swarm is
+// constructed, and written back when it is closed, so that a restarted node
+// doesn't dial addresses that are known to be dead, nor has to learn again
+// that UDP or IPv6 are black holed on its network.
+//
+// Backoffs are stored per peer and address, and are discarded once they
+// expire. The black hole state is discarded if it is older than an hour.
+func WithDialStateDatastore(d ds.Datastore) Option {
+	return func(s *Swarm) error {
+		if d == nil {
+			return errors.New("swarm: dial state datastore cannot be nil")
+		}
+		s.dialStateDS = namespace.Wrap(d, ds.NewKey(dialStateNamespace))
+		return nil
+	}
+}
+
+type backoffRecord struct {
+	Tries int
+	Until time.Time
+}
+
+type blackHoleRecord struct {
+	Requests int
+	Results  []bool
+	Updated  time.Time
+}
+
+func backoffPeerKey(p peer.ID) ds.Key {
+	return backoffBase.ChildString(b32.RawStdEncoding.EncodeToString([]byte(p)))
+}
+
+func backoffKey(p peer.ID, saddr string) ds.Key {
+	return backoffPeerKey(p).ChildString(b32.RawStdEncoding.EncodeToString([]byte(saddr)))
+}
+
+// backoffExpiry returns the time after which a backoff entry is forgotten.
+// This must match the logic in DialBackoff.cleanup.
+func backoffExpiry(ba *backoffAddr) time.Time {
+	backoffTime := BackoffBase + BackoffCoef*time.Duration(ba.tries*ba.tries)
+	if backoffTime > BackoffMax {
+		backoffTime = BackoffMax
+	}
+	return ba.until.Add(backoffTime)
+}
+
+// load restores the unexpired backoffs stored in d.
+func (db *DialBackoff) load(ctx context.Context, d ds.Datastore) error {
+	res, err := d.Query(ctx, query.Query{Prefix: backoffBase.String()})
+	if err != nil {
+		return err
+	}
+	defer res.Close()
+
+	now := time.Now()
+	db.lock.Lock()
+	defer db.lock.Unlock()
+	for r := range res.Next() {
+		if r.Error != nil {
+			return r.Error
+		}
+		k := ds.RawKey(r.Key)
+		pb, err := b32.RawStdEncoding.DecodeString(k.Parent().BaseNamespace())
+		if err != nil {
+			log.Debugf("invalid peer in dial backoff key %s: %s", k, err)
+			continue
+		}
+		p, err := peer.IDFromBytes(pb)
+		if err != nil {
+			log.Debugf("invalid peer in dial backoff key %s: %s", k, err)
+			continue
+		}
+		ab, err := b32.RawStdEncoding.DecodeString(k.BaseNamespace())
+		if err != nil {
+			log.Debugf("invalid address in dial backoff key %s: %s", k, err)
+			continue
+		}
+		if _, err := ma.NewMultiaddrBytes(ab); err != nil {
+			log.Debugf("invalid address in dial backoff key %s: %s", k, err)
+			continue
+		}
+		var rec backoffRecord
+		if err := json.Unmarshal(r.Value, &rec); err != nil {
+			log.Debugf("invalid dial backoff record %s: %s", k, err)
+			continue
+		}
+		ba := &backoffAddr{tries: rec.Tries, until: rec.Until}
+		if !now.Before(backoffExpiry(ba)) {
+			continue
+		}
+		bp, ok := db.entries[p]
+		if !ok {
+			bp = make(map[string]*backoffAddr, 1)
+			db.entries[p] = bp
+		}
+		bp[string(ab)] = ba
+	}
+	return nil
+}
+
+// persist replaces the backoffs stored in d with the current, unexpired ones.
+func (db *DialBackoff) persist(ctx context.Context, d ds.Datastore) error {
+	b, err := newDialStateBatch(ctx, d)
+	if err != nil {
+		return err
+	}
+	if err := deletePrefix(ctx, d, b, backoffBase); err != nil {
+		return err
+	}
+
+	now := time.Now()
+	db.lock.RLock()
+	for p, bp := range db.entries {
+		for saddr, ba := range bp {
+			if !now.Before(backoffExpiry(ba)) {
+				continue
+			}
+			val, err := json.Marshal(backoffRecord{Tries: ba.tries, Until: ba.until})
+			if err != nil {
+				db.lock.RUnlock()
+				return err
+			}
+			if err := b.Put(ctx, backoffKey(p, saddr), val); err != nil {
+				db.lock.RUnlock()
+				return err
+			}
+		}
+	}
+	db.lock.RUnlock()
+	return b.Commit(ctx)
+}
+
+func blackHoleKey(b *blackHoleFilter) ds.Key {
+	k := blackHoleBase.ChildString(b.name)
+	if b.route != "" {
+		k = k.ChildString(b.route)
+	}
+	return k
+}
+
+// restore restores the state of the filter from rec.
+func (b *blackHoleFilter) restore(rec blackHoleRecord) {
+	b.mu.Lock()
+	// The window size may have changed since the state was persisted. Keep
+	// the most recent results.
+	if len(rec.Results) > b.n {
+		rec.Results = rec.Results[len(rec.Results)-b.n:]
+	}
+	b.dialResults = append(b.dialResults[:0], rec.Results...)
+	b.successes = 0
+	for _, r := range b.dialResults {
+		if r {
+			b.successes++
+		}
+	}
+	b.requests = rec.Requests
+	b.updateState()
+	b.trackMetrics()
+	st, successFraction := b.state, b.successFraction()
+	b.mu.Unlock()
+
+	if st != blackHoleStateProbing && b.onStateChange != nil {
+		b.onStateChange(b, st, successFraction)
+	}
+}
+
+// persist stores the state of the filter in d.
+func (b *blackHoleFilter) persist(ctx context.Context, d ds.Datastore) error {
+	b.mu.Lock()
+	rec := blackHoleRecord{
+		Requests: b.requests,
+		Results:  append([]bool(nil), b.dialResults...),
+		Updated:  time.Now(),
+	}
+	b.mu.Unlock()
+
+	val, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	return d.Put(ctx, blackHoleKey(b), val)
+}
+
+// load restores the state of the black hole filters stored in d, unless it's
+// too old. The state of filters that are not registered anymore is ignored.
+func (d *blackHoleDetector) load(ctx context.Context, store ds.Datastore) error {
+	res, err := store.Query(ctx, query.Query{Prefix: blackHoleBase.String()})
+	if err != nil {
+		return err
+	}
+	defer res.Close()
+
+	for r := range res.Next() {
+		if r.Error != nil {
+			return r.Error
+		}
+		// /blackhole/<name> or /blackhole/<name>/<route>
+		nss := ds.RawKey(r.Key).Namespaces()
+		if len(nss) < 2 || len(nss) > 3 {
+			continue
+		}
+		g := d.group(nss[1])
+		if g == nil || g.config.PerRoute != (len(nss) == 3) {
+			continue
+		}
+		var route string
+		if len(nss) == 3 {
+			route = nss[2]
+		}
+		var rec blackHoleRecord
+		if err := json.Unmarshal(r.Value, &rec); err != nil {
+			log.Debugf("invalid black hole record %s: %s", r.Key, err)
+			continue
+		}
+		if time.Since(rec.Updated) > blackHoleStateTTL {
+			continue
+		}
+		g.filter(route).restore(rec)
+	}
+	return nil
+}
+
+// loadDialState restores the dial backoffs and the black hole state from the
+// dial state datastore, if one is configured.
+func (s *Swarm) loadDialState(ctx context.Context) error {
+	if s.dialStateDS == nil {
+		return nil
+	}
+	if err := s.backf.load(ctx, s.dialStateDS); err != nil {
+		return err
+	}
+	return s.bhd.load(ctx, s.dialStateDS)
+}
+
+// persistDialState writes the dial backoffs and the black hole state to the
+// dial state datastore, if one is configured.
+func (s *Swarm) persistDialState(ctx context.Context) error {
+	if s.dialStateDS == nil {
+		return nil
+	}
+	if err := s.backf.persist(ctx, s.dialStateDS); err != nil {
+		return err
+	}
+	for _, f := range s.bhd.filters() {
+		if err := f.persist(ctx, s.dialStateDS); err != nil {
+			return err
+		}
+	}
+	return s.dialStateDS.Sync(ctx, ds.NewKey("/"))
+}
+
+// newDialStateBatch returns a batch for d, or a batch that writes to d
+// directly if d doesn't support batching.
+func newDialStateBatch(ctx context.Context, d ds.Datastore) (ds.Batch, error) {
+	if bd, ok := d.(ds.Batching); ok {
+		return bd.Batch(ctx)
+	}
+	return unbatched{d}, nil
+}
+
+type unbatched struct{ ds.Datastore }
+
+func (u unbatched) Commit(context.Context) error { return nil }
+
+func deletePrefix(ctx context.Context, d ds.Datastore, b ds.Batch, prefix ds.Key) error {
+	res, err := d.Query(ctx, query.Query{Prefix: prefix.String(), KeysOnly: true})
+	if err != nil {
+		return err
+	}
+	entries, err := res.Rest()
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		if err := b.Delete(ctx, ds.RawKey(e.Key)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
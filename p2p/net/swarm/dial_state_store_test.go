@@ -0,0 +1,97 @@
+package swarm
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/test"
+	"github.com/libp2p/go-libp2p/p2p/host/eventbus"
+	"github.com/libp2p/go-libp2p/p2p/host/peerstore/pstoremem"
+
+	ds "github.com/ipfs/go-datastore"
+	dssync "github.com/ipfs/go-datastore/sync"
+	ma "github.com/multiformats/go-multiaddr"
+	"github.com/stretchr/testify/require"
+)
+
+func newDialStateSwarm(t *testing.T, d ds.Datastore) *Swarm {
+	t.Helper()
+	ps, err := pstoremem.NewPeerstore()
+	require.NoError(t, err)
+	t.Cleanup(func() { ps.Close() })
+	s, err := NewSwarm(test.RandPeerIDFatal(t), ps, eventbus.NewBus(),
+		WithDialStateDatastore(d),
+		WithUDPBlackHoleConfig(true, 10, 2),
+	)
+	require.NoError(t, err)
+	return s
+}
+
+func TestDialStatePersisted(t *testing.T) {
+	d := dssync.MutexWrap(ds.NewMapDatastore())
+	p := test.RandPeerIDFatal(t)
+	addr := ma.StringCast("/ip4/1.2.3.4/tcp/1")
+	other := ma.StringCast("/ip4/1.2.3.4/tcp/2")
+	udpAddr := ma.StringCast("/ip4/1.2.3.4/udp/1/quic-v1")
+
+	s := newDialStateSwarm(t, d)
+	s.backf.AddBackoff(p, addr)
+	s.backf.AddBackoff(p, addr)
+	for i := 0; i < 10; i++ {
+		s.bhd.RecordResult(udpAddr, false)
+	}
+	require.Equal(t, blackHoleStateBlocked, s.bhd.filter("UDP", "").state)
+	require.NoError(t, s.Close())
+
+	s = newDialStateSwarm(t, d)
+	defer s.Close()
+	require.True(t, s.backf.Backoff(p, addr))
+	require.False(t, s.backf.Backoff(p, other))
+	require.Equal(t, 2, s.backf.entries[p][string(addr.Bytes())].tries)
+	require.Equal(t, blackHoleStateBlocked, s.bhd.filter("UDP", "").state)
+	require.Nil(t, s.bhd.filter("IPv6", "").dialResults)
+
+	// a successful connection clears the backoff, this must be persisted as well
+	s.backf.Clear(p)
+	require.NoError(t, s.Close())
+	s = newDialStateSwarm(t, d)
+	defer s.Close()
+	require.False(t, s.backf.Backoff(p, addr))
+}
+
+func TestDialStateExpired(t *testing.T) {
+	d := dssync.MutexWrap(ds.NewMapDatastore())
+	p := test.RandPeerIDFatal(t)
+	addr := ma.StringCast("/ip4/1.2.3.4/tcp/1")
+
+	s := newDialStateSwarm(t, d)
+	s.backf.AddBackoff(p, addr)
+	s.backf.entries[p][string(addr.Bytes())].until = time.Now().Add(-BackoffMax)
+	for i := 0; i < 10; i++ {
+		s.bhd.RecordResult(ma.StringCast("/ip4/1.2.3.4/udp/1/quic-v1"), false)
+	}
+	require.NoError(t, s.Close())
+
+	// expired backoffs aren't persisted
+	res, err := d.Get(context.Background(), ds.NewKey(dialStateNamespace).Child(backoffKey(p, string(addr.Bytes()))))
+	require.ErrorIs(t, err, ds.ErrNotFound)
+	require.Nil(t, res)
+
+	// the black hole state expires after blackHoleStateTTL
+	key := ds.NewKey(dialStateNamespace).Child(blackHoleBase.ChildString("UDP"))
+	val, err := d.Get(context.Background(), key)
+	require.NoError(t, err)
+	var rec blackHoleRecord
+	require.NoError(t, json.Unmarshal(val, &rec))
+	rec.Updated = rec.Updated.Add(-2 * blackHoleStateTTL)
+	val, err = json.Marshal(rec)
+	require.NoError(t, err)
+	require.NoError(t, d.Put(context.Background(), key, val))
+
+	s = newDialStateSwarm(t, d)
+	defer s.Close()
+	require.Equal(t, blackHoleStateProbing, s.bhd.filter("UDP", "").state)
+	require.Empty(t, s.bhd.filter("UDP", "").dialResults)
+}
@@ -0,0 +1,228 @@
+package swarm
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+// DialTraceEventType is the type of a DialTraceEvent.
+type DialTraceEventType string
+
+const (
+	// DialTraceStart is recorded when a dial to a peer starts.
+	DialTraceStart DialTraceEventType = "start"
+	// DialTraceExistingConn is recorded when a dial is satisfied by an
+	// existing connection. Addr is the remote address of the connection.
+	DialTraceExistingConn DialTraceEventType = "existing_conn"
+	// DialTraceResolved is recorded when the addresses of the peer have been
+	// resolved. Addrs are the resolved addresses.
+	DialTraceResolved DialTraceEventType = "resolved"
+	// DialTraceFiltered is recorded for every address that is not dialed
+	// because it is known to be undialable, because it's black holed, or
+	// because a better address is available. Error is the reason, if any.
+	DialTraceFiltered DialTraceEventType = "filtered"
+	// DialTraceRanked is recorded for every address that will be dialed.
+	// Delay is the delay assigned by the dial ranker.
+	DialTraceRanked DialTraceEventType = "ranked"
+	// DialTraceJoined is recorded when a dial joins a dial to an address
+	// started by a concurrent dial to the same peer.
+	DialTraceJoined DialTraceEventType = "joined"
+	// DialTraceSkipped is recorded when an address is not dialed, e.g.
+	// because of dial backoff, or because a previous dial to the address
+	// already failed. Error is the reason.
+	DialTraceSkipped DialTraceEventType = "skipped"
+	// DialTraceQueued is recorded when the dial limiter delays a dial to an
+	// address. Reason is the limit that was hit.
+	DialTraceQueued DialTraceEventType = "queued"
+	// DialTraceAttemptStart is recorded when an address is dialed.
+	DialTraceAttemptStart DialTraceEventType = "attempt_start"
+	// DialTraceAttemptEnd is recorded when a dial to an address completes.
+	// Duration is the duration of the attempt, Error is set if it failed.
+	DialTraceAttemptEnd DialTraceEventType = "attempt_end"
+	// DialTraceConnected is recorded when the connection dialed to Addr is
+	// used for the dial.
+	DialTraceConnected DialTraceEventType = "connected"
+	// DialTraceFinished is recorded when the dial to a peer completes. Error
+	// is set if it failed, Addr is the remote address of the connection
+	// otherwise.
+	DialTraceFinished DialTraceEventType = "finished"
+)
+
+// Reasons of DialTraceQueued events.
+const (
+	DialTraceReasonPeerLimit = "peer limit"
+	DialTraceReasonFDLimit   = "fd limit"
+)
+
+// DialTraceEvent is an event recorded in a DialTrace.
+type DialTraceEvent struct {
+	Time     time.Time
+	Type     DialTraceEventType
+	Peer     peer.ID
+	Addr     ma.Multiaddr
+	Addrs    []ma.Multiaddr
+	Delay    time.Duration
+	Duration time.Duration
+	Reason   string
+	Error    error
+}
+
+func (e DialTraceEvent) MarshalJSON() ([]byte, error) {
+	var addr string
+	if e.Addr != nil {
+		addr = e.Addr.String()
+	}
+	var addrs []string
+	for _, a := range e.Addrs {
+		addrs = append(addrs, a.String())
+	}
+	var err string
+	if e.Error != nil {
+		err = e.Error.Error()
+	}
+	return json.Marshal(struct {
+		Time     time.Time
+		Type     DialTraceEventType
+		Peer     peer.ID
+		Addr     string        `json:",omitempty"`
+		Addrs    []string      `json:",omitempty"`
+		Delay    time.Duration `json:",omitempty"`
+		Duration time.Duration `json:",omitempty"`
+		Reason   string        `json:",omitempty"`
+		Error    string        `json:",omitempty"`
+	}{
+		Time:     e.Time,
+		Type:     e.Type,
+		Peer:     e.Peer,
+		Addr:     addr,
+		Addrs:    addrs,
+		Delay:    e.Delay,
+		Duration: e.Duration,
+		Reason:   e.Reason,
+		Error:    err,
+	})
+}
+
+// DialTrace records the steps of dials to peers: the resolution and
+// filtering of the addresses, the delays assigned by the dial ranker, the time
+// spent waiting for the dial limiter, the start, end and error of every
+// attempt, and the connection that was eventually used.
+//
+// Use WithDialTrace to record the dials made with a context. A DialTrace is
+// safe for concurrent use, and can be marshaled to JSON.
+type DialTrace struct {
+	mx     sync.Mutex
+	events []DialTraceEvent
+}
+
+type dialTraceKey struct{}
+
+// WithDialTrace returns a context that records all dials made with it in the
+// returned DialTrace. Concurrent dials to the same peer share the dials to
+// the peer's addresses, the events of the shared dials are recorded in the
+// traces of all the contexts that are waiting for them.
+func WithDialTrace(ctx context.Context) (context.Context, *DialTrace) {
+	t := &DialTrace{}
+	return context.WithValue(ctx, dialTraceKey{}, t), t
+}
+
+// GetDialTrace returns the DialTrace of the context, or nil if the context
+// doesn't record dials.
+func GetDialTrace(ctx context.Context) *DialTrace {
+	t, _ := ctx.Value(dialTraceKey{}).(*DialTrace)
+	return t
+}
+
+// Events returns the events recorded so far.
+func (t *DialTrace) Events() []DialTraceEvent {
+	t.mx.Lock()
+	defer t.mx.Unlock()
+	return append([]DialTraceEvent(nil), t.events...)
+}
+
+func (t *DialTrace) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct{ Events []DialTraceEvent }{Events: t.Events()})
+}
+
+func (t *DialTrace) record(evt DialTraceEvent) {
+	if t == nil {
+		return
+	}
+	if evt.Time.IsZero() {
+		evt.Time = time.Now()
+	}
+	t.mx.Lock()
+	defer t.mx.Unlock()
+	t.events = append(t.events, evt)
+}
+
+// dialTraceSet is the set of traces interested in the dial to an address.
+// Traces are added when dial requests join a dial that is already in flight.
+type dialTraceSet struct {
+	mx     sync.Mutex
+	traces []*DialTrace
+}
+
+type dialTraceSetKey struct{}
+
+func withDialTraceSet(ctx context.Context, s *dialTraceSet) context.Context {
+	return context.WithValue(ctx, dialTraceSetKey{}, s)
+}
+
+func getDialTraceSet(ctx context.Context) *dialTraceSet {
+	s, _ := ctx.Value(dialTraceSetKey{}).(*dialTraceSet)
+	return s
+}
+
+func (s *dialTraceSet) add(t *DialTrace) {
+	if s == nil || t == nil {
+		return
+	}
+	s.mx.Lock()
+	defer s.mx.Unlock()
+	for _, tr := range s.traces {
+		if tr == t {
+			return
+		}
+	}
+	s.traces = append(s.traces, t)
+}
+
+func (s *dialTraceSet) record(evt DialTraceEvent) {
+	if s == nil {
+		return
+	}
+	s.mx.Lock()
+	defer s.mx.Unlock()
+	if len(s.traces) == 0 {
+		return
+	}
+	evt.Time = time.Now()
+	for _, t := range s.traces {
+		t.record(evt)
+	}
+}
+
+// recordFilteredAddrs records a DialTraceFiltered event for every address in
+// candidates that is not in good.
+func recordFilteredAddrs(t *DialTrace, p peer.ID, candidates, good []ma.Multiaddr, addrErrs []TransportError) {
+	for _, a := range candidates {
+		if ma.Contains(good, a) {
+			continue
+		}
+		evt := DialTraceEvent{Type: DialTraceFiltered, Peer: p, Addr: a}
+		for _, e := range addrErrs {
+			if e.Address.Equal(a) {
+				evt.Error = e.Cause
+				break
+			}
+		}
+		t.record(evt)
+	}
+}
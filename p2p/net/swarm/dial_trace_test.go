@@ -0,0 +1,115 @@
+package swarm_test
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/libp2p/go-libp2p/core/peerstore"
+	. "github.com/libp2p/go-libp2p/p2p/net/swarm"
+	swarmt "github.com/libp2p/go-libp2p/p2p/net/swarm/testing"
+
+	ma "github.com/multiformats/go-multiaddr"
+	"github.com/stretchr/testify/require"
+)
+
+func eventsOfType(trace *DialTrace, typ DialTraceEventType) []DialTraceEvent {
+	var res []DialTraceEvent
+	for _, evt := range trace.Events() {
+		if evt.Type == typ {
+			res = append(res, evt)
+		}
+	}
+	return res
+}
+
+func TestDialTrace(t *testing.T) {
+	s1 := swarmt.GenSwarm(t, swarmt.OptDisableQUIC)
+	s2 := swarmt.GenSwarm(t, swarmt.OptDisableQUIC)
+	defer s1.Close()
+	defer s2.Close()
+
+	draft29 := ma.StringCast("/ip4/127.0.0.1/udp/1234/quic")
+	s1.Peerstore().AddAddrs(s2.LocalPeer(), append(s2.ListenAddresses(), draft29), peerstore.PermanentAddrTTL)
+
+	ctx, trace := WithDialTrace(context.Background())
+	require.Equal(t, trace, GetDialTrace(ctx))
+	conn, err := s1.DialPeer(ctx, s2.LocalPeer())
+	require.NoError(t, err)
+
+	events := trace.Events()
+	require.Equal(t, DialTraceStart, events[0].Type)
+	require.Equal(t, s2.LocalPeer(), events[0].Peer)
+	require.Equal(t, DialTraceFinished, events[len(events)-1].Type)
+	require.NoError(t, events[len(events)-1].Error)
+	require.Equal(t, conn.RemoteMultiaddr(), events[len(events)-1].Addr)
+
+	require.Len(t, eventsOfType(trace, DialTraceResolved), 1)
+	filtered := eventsOfType(trace, DialTraceFiltered)
+	require.Len(t, filtered, 1)
+	require.Equal(t, draft29, filtered[0].Addr)
+	require.ErrorIs(t, filtered[0].Error, ErrQUICDraft29)
+	require.Len(t, eventsOfType(trace, DialTraceRanked), 1)
+	require.Len(t, eventsOfType(trace, DialTraceAttemptStart), 1)
+	end := eventsOfType(trace, DialTraceAttemptEnd)
+	require.Len(t, end, 1)
+	require.NoError(t, end[0].Error)
+	require.Positive(t, end[0].Duration)
+	connected := eventsOfType(trace, DialTraceConnected)
+	require.Len(t, connected, 1)
+	require.Equal(t, conn.RemoteMultiaddr(), connected[0].Addr)
+
+	b, err := json.Marshal(trace)
+	require.NoError(t, err)
+	var decoded struct {
+		Events []struct {
+			Type  DialTraceEventType
+			Addr  string
+			Error string
+		}
+	}
+	require.NoError(t, json.Unmarshal(b, &decoded))
+	require.Len(t, decoded.Events, len(events))
+	for _, evt := range decoded.Events {
+		if evt.Type == DialTraceFiltered {
+			require.Equal(t, draft29.String(), evt.Addr)
+			require.NotEmpty(t, evt.Error)
+		}
+	}
+
+	// dialing again uses the existing connection
+	ctx, trace = WithDialTrace(context.Background())
+	_, err = s1.DialPeer(ctx, s2.LocalPeer())
+	require.NoError(t, err)
+	require.Len(t, eventsOfType(trace, DialTraceExistingConn), 1)
+}
+
+func TestDialTraceFailure(t *testing.T) {
+	s1 := swarmt.GenSwarm(t, swarmt.OptDisableQUIC)
+	s2 := swarmt.GenSwarm(t, swarmt.OptDisableQUIC)
+	defer s1.Close()
+	defer s2.Close()
+
+	closed := ma.StringCast("/ip4/127.0.0.1/tcp/1")
+	s1.Peerstore().AddAddrs(s2.LocalPeer(), []ma.Multiaddr{closed}, peerstore.PermanentAddrTTL)
+
+	ctx, trace := WithDialTrace(context.Background())
+	_, err := s1.DialPeer(ctx, s2.LocalPeer())
+	require.Error(t, err)
+	end := eventsOfType(trace, DialTraceAttemptEnd)
+	require.Len(t, end, 1)
+	require.Equal(t, closed, end[0].Addr)
+	require.Error(t, end[0].Error)
+	finished := eventsOfType(trace, DialTraceFinished)
+	require.Len(t, finished, 1)
+	require.Error(t, finished[0].Error)
+
+	// the address is now backed off
+	ctx, trace = WithDialTrace(context.Background())
+	_, err = s1.DialPeer(ctx, s2.LocalPeer())
+	require.Error(t, err)
+	skipped := eventsOfType(trace, DialTraceSkipped)
+	require.Len(t, skipped, 1)
+	require.ErrorIs(t, skipped[0].Error, ErrDialBackoff)
+	require.Empty(t, eventsOfType(trace, DialTraceAttemptStart))
+}
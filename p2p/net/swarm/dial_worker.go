@@ -11,6 +11,7 @@ import (
 	tpt "github.com/libp2p/go-libp2p/core/transport"
 
 	ma "github.com/multiformats/go-multiaddr"
+	madns "github.com/multiformats/go-multiaddr-dns"
 	manet "github.com/multiformats/go-multiaddr/net"
 )
 
@@ -42,10 +43,25 @@ type pendRequest struct {
 	// err comprises errors of all failed dials
 	err *DialError
 	// addrs are the addresses on which we are waiting for pending dials
-	// At the time of creation addrs is initialised to all the addresses of the peer. On a failed dial,
-	// the addr is removed from the map and err is updated. On a successful dial, the dialRequest is
-	// completed and response is sent with the connection
+	// At the time of creation addrs is initialised to all the resolved addresses of the peer. Addresses
+	// are added as they are resolved. On a failed dial, the addr is removed from the map and err is
+	// updated. On a successful dial, the dialRequest is completed and response is sent with the connection
 	addrs map[string]struct{}
+	// resolving is true while the peer's addresses are being resolved for this request. The request
+	// doesn't fail before the resolution completes.
+	resolving bool
+	// dialable is true if any of the peer's addresses could be dialed
+	dialable bool
+}
+
+// resolvedAddrs is a batch of addresses resolved for a pendRequest. Resolved addresses are sent
+// to the worker loop as soon as they are available, so that dials to them can start before all the
+// peer's addresses are resolved.
+type resolvedAddrs struct {
+	pr    *pendRequest
+	addrs []ma.Multiaddr
+	// done is set on the last message for pr, sent once the resolution completed
+	done bool
 }
 
 // addrDial tracks dials to a particular multiaddress.
@@ -66,6 +82,8 @@ type addrDial struct {
 	dialRankingDelay time.Duration
 	// expectedTCPUpgradeTime is the expected time by which security upgrade will complete
 	expectedTCPUpgradeTime time.Time
+	// traces are the dial traces of the requests interested in this dial
+	traces *dialTraceSet
 }
 
 // dialWorker synchronises concurrent dials to a peer. It ensures that we make at most one dial to a
@@ -82,6 +100,10 @@ type dialWorker struct {
 	trackedDials map[string]*addrDial
 	// resch is used to receive response for dials to the peers addresses.
 	resch chan tpt.DialUpdate
+	// resolvech is used to receive addresses resolved for pending requests
+	resolvech chan resolvedAddrs
+	// done is closed when the worker loop exits
+	done chan struct{}
 
 	connected bool // true when a connection has been successfully established
 
@@ -101,6 +123,8 @@ func newDialWorker(s *Swarm, p peer.ID, reqch <-chan dialRequest, cl Clock) *dia
 		pendingRequests: make(map[*pendRequest]struct{}),
 		trackedDials:    make(map[string]*addrDial),
 		resch:           make(chan tpt.DialUpdate),
+		resolvech:       make(chan resolvedAddrs),
+		done:            make(chan struct{}),
 		cl:              cl,
 	}
 }
@@ -111,6 +135,7 @@ func (w *dialWorker) loop() {
 	w.wg.Add(1)
 	defer w.wg.Done()
 	defer w.s.limiter.clearAllPeerDials(w.peer)
+	defer close(w.done)
 
 	// dq is used to pace dials to different addresses of the peer
 	dq := newDialQueue()
@@ -146,16 +171,117 @@ func (w *dialWorker) loop() {
 		}
 	}
 
+	// dialAddrs tracks the dials to addrs for pr and schedules the new ones. delayOffset is added to
+	// the delays assigned by the dial ranker. pr must be in w.pendingRequests, it's completed if a
+	// dial to one of the addresses already succeeded, or if all the dials failed.
+	dialAddrs := func(pr *pendRequest, addrs []ma.Multiaddr, delayOffset time.Duration) {
+		req := pr.req
+		trace := GetDialTrace(req.ctx)
+
+		// get the delays to dial these addrs from the swarms dialRanker
+		simConnect, _, _ := network.GetSimultaneousConnect(req.ctx)
+		addrRanking := w.rankAddrs(addrs, simConnect)
+		addrDelay := make(map[string]time.Duration, len(addrRanking))
+		for i := range addrRanking {
+			addrRanking[i].Delay += delayOffset
+			adelay := addrRanking[i]
+			pr.addrs[string(adelay.Addr.Bytes())] = struct{}{}
+			addrDelay[string(adelay.Addr.Bytes())] = adelay.Delay
+			trace.record(DialTraceEvent{Type: DialTraceRanked, Peer: w.peer, Addr: adelay.Addr, Delay: adelay.Delay})
+		}
+
+		// Check if dials to any of the addrs have completed already
+		// If they have errored, record the error in pr. If they have succeeded,
+		// respond with the connection.
+		// If they are pending, add them to tojoin.
+		// If we haven't seen any of the addresses before, add them to todial.
+		var todial []ma.Multiaddr
+		var tojoin []*addrDial
+
+		for _, adelay := range addrRanking {
+			ad, ok := w.trackedDials[string(adelay.Addr.Bytes())]
+			if !ok {
+				todial = append(todial, adelay.Addr)
+				continue
+			}
+
+			if ad.conn != nil {
+				// dial to this addr was successful, complete the request
+				trace.record(DialTraceEvent{Type: DialTraceConnected, Peer: w.peer, Addr: ad.addr})
+				req.resch <- dialResponse{conn: ad.conn}
+				delete(w.pendingRequests, pr)
+				return
+			}
+
+			if ad.err != nil {
+				// dial to this addr errored, accumulate the error
+				trace.record(DialTraceEvent{Type: DialTraceSkipped, Peer: w.peer, Addr: ad.addr, Error: ad.err})
+				pr.err.recordErr(ad.addr, ad.err)
+				delete(pr.addrs, string(ad.addr.Bytes()))
+				continue
+			}
+
+			// dial is still pending, add to the join list
+			trace.record(DialTraceEvent{Type: DialTraceJoined, Peer: w.peer, Addr: ad.addr})
+			ad.traces.add(trace)
+			tojoin = append(tojoin, ad)
+		}
+
+		if w.completeIfDone(pr) {
+			// all request applicable addrs have been dialed, we must have errored
+			return
+		}
+
+		for _, ad := range tojoin {
+			if !ad.dialed {
+				// we haven't dialed this address. update the ad.ctx to have simultaneous connect values
+				// set correctly
+				if simConnect, isClient, reason := network.GetSimultaneousConnect(req.ctx); simConnect {
+					if simConnect, _, _ := network.GetSimultaneousConnect(ad.ctx); !simConnect {
+						ad.ctx = network.WithSimultaneousConnect(ad.ctx, isClient, reason)
+						// update the element in dq to use the simultaneous connect delay.
+						dq.Add(network.AddrDelay{
+							Addr:  ad.addr,
+							Delay: addrDelay[string(ad.addr.Bytes())],
+						})
+					}
+				}
+			}
+			// add the request to the addrDial
+		}
+
+		if len(todial) > 0 {
+			now := time.Now()
+			// these are new addresses, track them and add them to dq
+			for _, a := range todial {
+				traces := &dialTraceSet{}
+				traces.add(trace)
+				w.trackedDials[string(a.Bytes())] = &addrDial{
+					addr:      a,
+					ctx:       withDialTraceSet(req.ctx, traces),
+					createdAt: now,
+					traces:    traces,
+				}
+				dq.Add(network.AddrDelay{Addr: a, Delay: addrDelay[string(a.Bytes())]})
+			}
+		}
+		// setup dialTimer for updates to dq
+		scheduleNextDial()
+	}
+
 	// totalDials is used to track number of dials made by this worker for metrics
 	totalDials := 0
 loop:
 	for {
-		// The loop has three parts
+		// The loop has four parts
 		//  1. Input requests are received on w.reqch. If a suitable connection is not available we create
-		//     a pendRequest object to track the dialRequest and add the addresses to dq.
-		//  2. Addresses from the dialQueue are dialed at appropriate time intervals depending on delay logic.
+		//     a pendRequest object to track the dialRequest and add the addresses to dq. Addresses that
+		//     need to be resolved are resolved in the background.
+		//  2. Addresses resolved for pending requests are received on w.resolvech, and added to dq as
+		//     soon as they are resolved.
+		//  3. Addresses from the dialQueue are dialed at appropriate time intervals depending on delay logic.
 		//     We are notified of the completion of these dials on w.resch.
-		//  3. Responses for dials are received on w.resch. On receiving a response, we updated the pendRequests
+		//  4. Responses for dials are received on w.resch. On receiving a response, we updated the pendRequests
 		//     interested in dials on this address.
 
 		select {
@@ -171,113 +297,71 @@ loop:
 			// Enqueue the peer's addresses relevant to this request in dq and
 			// track dials to the addresses relevant to this request.
 
+			trace := GetDialTrace(req.ctx)
 			c := w.s.bestAcceptableConnToPeer(req.ctx, w.peer)
 			if c != nil {
+				trace.record(DialTraceEvent{Type: DialTraceExistingConn, Peer: w.peer, Addr: c.RemoteMultiaddr()})
 				req.resch <- dialResponse{conn: c}
 				continue loop
 			}
 
-			addrs, addrErrs, err := w.s.addrsForDial(req.ctx, w.peer)
-			if err != nil {
+			peerAddrs := w.s.peers.Addrs(w.peer)
+			if len(peerAddrs) == 0 {
 				req.resch <- dialResponse{
 					err: &DialError{
-						Peer:       w.peer,
-						DialErrors: addrErrs,
-						Cause:      err,
+						Peer:  w.peer,
+						Cause: ErrNoAddresses,
 					}}
 				continue loop
 			}
 
-			// get the delays to dial these addrs from the swarms dialRanker
-			simConnect, _, _ := network.GetSimultaneousConnect(req.ctx)
-			addrRanking := w.rankAddrs(addrs, simConnect)
-			addrDelay := make(map[string]time.Duration, len(addrRanking))
+			// Dial the addresses that don't need to be resolved right away, and resolve the
+			// others in the background.
+			toResolve, resolved := filterAddrs(peerAddrs, madns.Matches)
+			addrs, addrErrs := w.s.filterAddrsForDial(req.ctx, w.peer, resolved)
 
 			// create the pending request object
 			pr := &pendRequest{
-				req:   req,
-				addrs: make(map[string]struct{}, len(addrRanking)),
-				err:   &DialError{Peer: w.peer, DialErrors: addrErrs},
+				req:       req,
+				addrs:     make(map[string]struct{}, len(addrs)),
+				err:       &DialError{Peer: w.peer, DialErrors: addrErrs},
+				resolving: len(toResolve) > 0,
+				dialable:  len(addrs) > 0,
 			}
-			for _, adelay := range addrRanking {
-				pr.addrs[string(adelay.Addr.Bytes())] = struct{}{}
-				addrDelay[string(adelay.Addr.Bytes())] = adelay.Delay
+			w.pendingRequests[pr] = struct{}{}
+			if pr.resolving {
+				w.resolveAddrs(pr, toResolve)
 			}
+			dialAddrs(pr, addrs, 0)
 
-			// Check if dials to any of the addrs have completed already
-			// If they have errored, record the error in pr. If they have succeeded,
-			// respond with the connection.
-			// If they are pending, add them to tojoin.
-			// If we haven't seen any of the addresses before, add them to todial.
-			var todial []ma.Multiaddr
-			var tojoin []*addrDial
-
-			for _, adelay := range addrRanking {
-				ad, ok := w.trackedDials[string(adelay.Addr.Bytes())]
-				if !ok {
-					todial = append(todial, adelay.Addr)
-					continue
-				}
-
-				if ad.conn != nil {
-					// dial to this addr was successful, complete the request
-					req.resch <- dialResponse{conn: ad.conn}
-					continue loop
-				}
-
-				if ad.err != nil {
-					// dial to this addr errored, accumulate the error
-					pr.err.recordErr(ad.addr, ad.err)
-					delete(pr.addrs, string(ad.addr.Bytes()))
-					continue
-				}
-
-				// dial is still pending, add to the join list
-				tojoin = append(tojoin, ad)
+		case res := <-w.resolvech:
+			// Addresses have been resolved for a pending request.
+			pr := res.pr
+			if _, ok := w.pendingRequests[pr]; !ok {
+				// the request was completed already
+				continue loop
 			}
-
-			if len(todial) == 0 && len(tojoin) == 0 {
-				// all request applicable addrs have been dialed, we must have errored
-				pr.err.Cause = ErrAllDialsFailed
-				req.resch <- dialResponse{err: pr.err}
+			if c := w.s.bestAcceptableConnToPeer(pr.req.ctx, w.peer); c != nil {
+				GetDialTrace(pr.req.ctx).record(DialTraceEvent{Type: DialTraceExistingConn, Peer: w.peer, Addr: c.RemoteMultiaddr()})
+				pr.req.resch <- dialResponse{conn: c}
+				delete(w.pendingRequests, pr)
 				continue loop
 			}
-
-			// The request has some pending or new dials
-			w.pendingRequests[pr] = struct{}{}
-
-			for _, ad := range tojoin {
-				if !ad.dialed {
-					// we haven't dialed this address. update the ad.ctx to have simultaneous connect values
-					// set correctly
-					if simConnect, isClient, reason := network.GetSimultaneousConnect(req.ctx); simConnect {
-						if simConnect, _, _ := network.GetSimultaneousConnect(ad.ctx); !simConnect {
-							ad.ctx = network.WithSimultaneousConnect(ad.ctx, isClient, reason)
-							// update the element in dq to use the simultaneous connect delay.
-							dq.Add(network.AddrDelay{
-								Addr:  ad.addr,
-								Delay: addrDelay[string(ad.addr.Bytes())],
-							})
-						}
-					}
-				}
-				// add the request to the addrDial
+			if res.done {
+				pr.resolving = false
+				w.completeIfDone(pr)
+				continue loop
 			}
 
-			if len(todial) > 0 {
-				now := time.Now()
-				// these are new addresses, track them and add them to dq
-				for _, a := range todial {
-					w.trackedDials[string(a.Bytes())] = &addrDial{
-						addr:      a,
-						ctx:       req.ctx,
-						createdAt: now,
-					}
-					dq.Add(network.AddrDelay{Addr: a, Delay: addrDelay[string(a.Bytes())]})
-				}
+			addrs, addrErrs := w.s.filterAddrsForDial(pr.req.ctx, w.peer, res.addrs)
+			for _, e := range addrErrs {
+				pr.err.recordErr(e.Address, e.Cause)
+			}
+			if len(addrs) > 0 {
+				pr.dialable = true
+				// These addresses are dialed as if the dial had started when they were resolved.
+				dialAddrs(pr, addrs, w.cl.Now().Sub(startTime))
 			}
-			// setup dialTimer for updates to dq
-			scheduleNextDial()
 
 		case <-dialTimer.Ch():
 			// It's time to dial the next batch of addresses.
@@ -299,6 +383,7 @@ loop:
 				if err != nil {
 					// Errored without attempting a dial. This happens in case of
 					// backoff or black hole.
+					ad.traces.record(DialTraceEvent{Type: DialTraceSkipped, Peer: w.peer, Addr: ad.addr, Error: err})
 					w.dispatchError(ad, err)
 				} else {
 					// the dial was successful. update inflight dials
@@ -352,10 +437,18 @@ loop:
 					if _, ok := pr.addrs[string(ad.addr.Bytes())]; ok {
 						pr.req.resch <- dialResponse{conn: conn}
 						delete(w.pendingRequests, pr)
+					} else if pr.resolving {
+						// Don't wait for the resolution to complete if the connection is
+						// acceptable for the request.
+						if c := w.s.bestAcceptableConnToPeer(pr.req.ctx, w.peer); c != nil {
+							pr.req.resch <- dialResponse{conn: c}
+							delete(w.pendingRequests, pr)
+						}
 					}
 				}
 
 				ad.conn = conn
+				ad.traces.record(DialTraceEvent{Type: DialTraceConnected, Peer: w.peer, Addr: ad.addr})
 				if !w.connected {
 					w.connected = true
 					if w.s.metricsTracer != nil {
@@ -394,19 +487,9 @@ func (w *dialWorker) dispatchError(ad *addrDial, err error) {
 		if _, ok := pr.addrs[string(ad.addr.Bytes())]; ok {
 			pr.err.recordErr(ad.addr, err)
 			delete(pr.addrs, string(ad.addr.Bytes()))
-			if len(pr.addrs) == 0 {
-				// all addrs have erred, dispatch dial error
-				// but first do a last one check in case an acceptable connection has landed from
-				// a simultaneous dial that started later and added new acceptable addrs
-				c := w.s.bestAcceptableConnToPeer(pr.req.ctx, w.peer)
-				if c != nil {
-					pr.req.resch <- dialResponse{conn: c}
-				} else {
-					pr.err.Cause = ErrAllDialsFailed
-					pr.req.resch <- dialResponse{err: pr.err}
-				}
-				delete(w.pendingRequests, pr)
-			}
+			// complete the request if all its addrs have erred, unless more addresses are
+			// still being resolved for it
+			w.completeIfDone(pr)
 		}
 	}
 
@@ -419,12 +502,62 @@ func (w *dialWorker) dispatchError(ad *addrDial, err error) {
 	}
 }
 
+// completeIfDone completes pr with an error if all the dials to its addresses have failed, and no more
+// addresses are being resolved for it. It returns true if pr was completed.
+func (w *dialWorker) completeIfDone(pr *pendRequest) bool {
+	if len(pr.addrs) > 0 || pr.resolving {
+		return false
+	}
+	// all addrs have erred, dispatch dial error
+	// but first do a last one check in case an acceptable connection has landed from
+	// a simultaneous dial that started later and added new acceptable addrs
+	c := w.s.bestAcceptableConnToPeer(pr.req.ctx, w.peer)
+	if c != nil {
+		GetDialTrace(pr.req.ctx).record(DialTraceEvent{Type: DialTraceExistingConn, Peer: w.peer, Addr: c.RemoteMultiaddr()})
+		pr.req.resch <- dialResponse{conn: c}
+	} else {
+		if pr.dialable {
+			pr.err.Cause = ErrAllDialsFailed
+		} else {
+			pr.err.Cause = ErrNoGoodAddresses
+		}
+		pr.req.resch <- dialResponse{err: pr.err}
+	}
+	delete(w.pendingRequests, pr)
+	return true
+}
+
+// resolveAddrs resolves addrs for pr in the background. The resolved addresses are sent to the worker
+// loop as soon as they are available.
+func (w *dialWorker) resolveAddrs(pr *pendRequest, addrs []ma.Multiaddr) {
+	send := func(res resolvedAddrs) {
+		select {
+		case w.resolvech <- res:
+		case <-w.done:
+		}
+	}
+	w.wg.Add(1)
+	go func() {
+		defer w.wg.Done()
+		err := w.s.resolveAddrsStream(pr.req.ctx, peer.AddrInfo{ID: w.peer, Addrs: addrs}, func(addrs []ma.Multiaddr) {
+			send(resolvedAddrs{pr: pr, addrs: addrs})
+		})
+		if err != nil {
+			log.Debugf("failed to resolve addresses of %s: %s", w.peer, err)
+		}
+		send(resolvedAddrs{pr: pr, done: true})
+	}()
+}
+
 // rankAddrs ranks addresses for dialing. if it's a simConnect request we
 // dial all addresses immediately without any delay
 func (w *dialWorker) rankAddrs(addrs []ma.Multiaddr, isSimConnect bool) []network.AddrDelay {
 	if isSimConnect {
 		return NoDelayDialRanker(addrs)
 	}
+	if w.s.latencyRanker != nil {
+		return w.s.latencyRanker.RankAddrs(w.peer, addrs)
+	}
 	return w.s.dialRanker(addrs)
 }
 
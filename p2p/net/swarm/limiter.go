@@ -19,6 +19,7 @@ type dialJob struct {
 	ctx     context.Context
 	resp    chan transport.DialUpdate
 	timeout time.Duration
+	traces  *dialTraceSet
 }
 
 func (dj *dialJob) cancelled() bool {
@@ -149,6 +150,7 @@ func (dl *dialLimiter) addCheckFdLimit(dj *dialJob) {
 			log.Debugf("[limiter] blocked dial waiting on FD token; peer: %s; addr: %s; consuming: %d; "+
 				"limit: %d; waiting: %d", dj.peer, dj.addr, dl.fdConsuming, dl.fdLimit, len(dl.waitingOnFd))
 			dl.waitingOnFd = append(dl.waitingOnFd, dj)
+			dj.traces.record(DialTraceEvent{Type: DialTraceQueued, Peer: dj.peer, Addr: dj.addr, Reason: DialTraceReasonFDLimit})
 			return
 		}
 
@@ -170,6 +172,7 @@ func (dl *dialLimiter) addCheckPeerLimit(dj *dialJob) {
 			len(dl.waitingOnPeerLimit[dj.peer]))
 		wlist := dl.waitingOnPeerLimit[dj.peer]
 		dl.waitingOnPeerLimit[dj.peer] = append(wlist, dj)
+		dj.traces.record(DialTraceEvent{Type: DialTraceQueued, Peer: dj.peer, Addr: dj.addr, Reason: DialTraceReasonPeerLimit})
 		return
 	}
 	dl.activePerPeer[dj.peer]++
@@ -210,7 +213,10 @@ func (dl *dialLimiter) executeDial(j *dialJob) {
 	dctx, cancel := context.WithTimeout(j.ctx, j.timeout)
 	defer cancel()
 
+	start := time.Now()
+	j.traces.record(DialTraceEvent{Type: DialTraceAttemptStart, Peer: j.peer, Addr: j.addr})
 	con, err := dl.dialFunc(dctx, j.peer, j.addr, j.resp)
+	j.traces.record(DialTraceEvent{Type: DialTraceAttemptEnd, Peer: j.peer, Addr: j.addr, Duration: time.Since(start), Error: err})
 	kind := transport.UpdateKindDialSuccessful
 	if err != nil {
 		kind = transport.UpdateKindDialFailed
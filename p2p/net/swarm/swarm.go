@@ -17,8 +17,10 @@ import (
 	"github.com/libp2p/go-libp2p/core/peer"
 	"github.com/libp2p/go-libp2p/core/peerstore"
 	"github.com/libp2p/go-libp2p/core/transport"
+	"github.com/libp2p/go-libp2p/p2p/metricshelper"
 	"golang.org/x/exp/slices"
 
+	ds "github.com/ipfs/go-datastore"
 	logging "github.com/ipfs/go-log/v2"
 	ma "github.com/multiformats/go-multiaddr"
 	madns "github.com/multiformats/go-multiaddr-dns"
@@ -112,6 +114,19 @@ func WithDialRanker(d network.DialRanker) Option {
 	}
 }
 
+// WithLatencyDialRanker configures swarm to rank addresses using r, and to
+// record the outcome of all dials in r. It takes precedence over the ranker
+// configured using WithDialRanker.
+func WithLatencyDialRanker(r *LatencyDialRanker) Option {
+	return func(s *Swarm) error {
+		if r == nil {
+			return errors.New("swarm: latency dial ranker cannot be nil")
+		}
+		s.latencyRanker = r
+		return nil
+	}
+}
+
 // WithUDPBlackHoleConfig configures swarm to use c as the config for UDP black hole detection
 // n is the size of the sliding window used to evaluate black hole state
 // min is the minimum number of successes out of n required to not block requests
@@ -132,6 +147,31 @@ func WithIPv6BlackHoleConfig(enabled bool, n, min int) Option {
 	}
 }
 
+// WithBlackHoleFilter registers an additional black hole filter, e.g. for
+// WebSocket addresses on networks where they can only be dialed through a
+// proxy that doesn't work.
+//
+// The filter tracks the outcome of dials to the public addresses matched by
+// c.Match. If less than c.MinSuccesses of the last c.N dials succeeded, dials
+// to these addresses are refused with ErrDialRefusedBlackHole, except for
+// one dial out of every c.N, which is used to probe whether the addresses
+// became reachable again. The state of the filter is reported using
+// event.EvtBlackHoleStateChanged.
+func WithBlackHoleFilter(c BlackHoleFilterConfig) Option {
+	return func(s *Swarm) error {
+		if err := c.validate(); err != nil {
+			return fmt.Errorf("swarm: %w", err)
+		}
+		for _, f := range s.blackHoleFilters {
+			if f.Name == c.Name {
+				return fmt.Errorf("swarm: duplicate black hole filter %s", c.Name)
+			}
+		}
+		s.blackHoleFilters = append(s.blackHoleFilters, c)
+		return nil
+	}
+}
+
 // Swarm is a connection muxer, allowing connections to other peers to
 // be opened and closed, while still using the same Chan for all
 // communication. The Chan sends/receives Messages, which note the
@@ -201,11 +241,30 @@ type Swarm struct {
 	bwc           metrics.Reporter
 	metricsTracer MetricsTracer
 
-	dialRanker network.DialRanker
+	dialRanker    network.DialRanker
+	latencyRanker *LatencyDialRanker
 
 	udpBlackHoleConfig  blackHoleConfig
 	ipv6BlackHoleConfig blackHoleConfig
+	blackHoleFilters    []BlackHoleFilterConfig
 	bhd                 *blackHoleDetector
+	blackHoleEmitter    event.Emitter
+
+	supersededConnDrainTimeout time.Duration
+	supersededEmitter          event.Emitter
+
+	// multiConn is the policy for parallel connections to peers. nil if
+	// parallel connections are disabled.
+	multiConn *MultiConnPolicy
+	// parallelDials tracks the peers we're dialing parallel connections to.
+	parallelDials struct {
+		sync.Mutex
+		m map[peer.ID]struct{}
+	}
+
+	// dialStateDS persists the dial backoffs and the black hole state
+	// across restarts. nil if not configured.
+	dialStateDS ds.Datastore
 }
 
 // NewSwarm constructs a Swarm.
@@ -226,6 +285,8 @@ func NewSwarm(local peer.ID, peers peerstore.Peerstore, eventBus event.Bus, opts
 		maResolver:       madns.DefaultResolver,
 		dialRanker:       DefaultDialRanker,
 
+		supersededConnDrainTimeout: DefaultSupersededConnDrainTimeout,
+
 		// A black hole is a binary property. On a network if UDP dials are blocked or there is
 		// no IPv6 connectivity, all dials will fail. So a low success rate of 5 out 100 dials
 		// is good enough.
@@ -238,12 +299,18 @@ func NewSwarm(local peer.ID, peers peerstore.Peerstore, eventBus event.Bus, opts
 	s.transports.m = make(map[int]transport.Transport)
 	s.notifs.m = make(map[network.Notifiee]struct{})
 	s.directConnNotifs.m = make(map[peer.ID][]chan struct{})
+	s.parallelDials.m = make(map[peer.ID]struct{})
 
 	for _, opt := range opts {
 		if err := opt(s); err != nil {
 			return nil, err
 		}
 	}
+	for _, f := range s.blackHoleFilters {
+		if (f.Name == "UDP" && s.udpBlackHoleConfig.Enabled) || (f.Name == "IPv6" && s.ipv6BlackHoleConfig.Enabled) {
+			return nil, fmt.Errorf("swarm: black hole filter %s conflicts with the built-in filter", f.Name)
+		}
+	}
 	if s.rcmgr == nil {
 		s.rcmgr = &network.NullResourceManager{}
 	}
@@ -253,7 +320,26 @@ func NewSwarm(local peer.ID, peers peerstore.Peerstore, eventBus event.Bus, opts
 	s.limiter = newDialLimiter(s.dialAddr)
 	s.backf.init(s.ctx)
 
-	s.bhd = newBlackHoleDetector(s.udpBlackHoleConfig, s.ipv6BlackHoleConfig, s.metricsTracer)
+	s.bhd = newBlackHoleDetector(s.udpBlackHoleConfig, s.ipv6BlackHoleConfig, s.metricsTracer, s.blackHoleFilters...)
+	s.blackHoleEmitter, err = eventBus.Emitter(new(event.EvtBlackHoleStateChanged))
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	s.bhd.emitter = s.blackHoleEmitter
+	s.supersededEmitter, err = eventBus.Emitter(new(event.EvtConnSuperseded))
+	if err != nil {
+		cancel()
+		s.blackHoleEmitter.Close()
+		return nil, err
+	}
+
+	if err := s.loadDialState(ctx); err != nil {
+		cancel()
+		s.blackHoleEmitter.Close()
+		s.supersededEmitter.Close()
+		return nil, fmt.Errorf("failed to load dial state: %w", err)
+	}
 
 	return s, nil
 }
@@ -267,6 +353,8 @@ func (s *Swarm) close() {
 	s.ctxCancel()
 
 	s.emitter.Close()
+	s.blackHoleEmitter.Close()
+	s.supersededEmitter.Close()
 
 	// Prevents new connections and/or listeners from being added to the swarm.
 	s.listeners.Lock()
@@ -293,7 +381,7 @@ func (s *Swarm) close() {
 	for _, cs := range conns {
 		for _, c := range cs {
 			go func(c *Conn) {
-				if err := c.Close(); err != nil {
+				if err := c.CloseWithError(network.ConnShutdown); err != nil {
 					log.Errorf("error when shutting down connection: %s", err)
 				}
 			}(c)
@@ -329,6 +417,10 @@ func (s *Swarm) close() {
 		}
 	}
 	wg.Wait()
+
+	if err := s.persistDialState(context.Background()); err != nil {
+		log.Errorf("error when persisting dial state: %s", err)
+	}
 }
 
 func (s *Swarm) addConn(tc transport.CapableConn, dir network.Direction) (*Conn, error) {
@@ -344,6 +436,7 @@ func (s *Swarm) addConn(tc transport.CapableConn, dir network.Direction) (*Conn,
 	}
 	stat.Direction = dir
 	stat.Opened = time.Now()
+	parallel := s.connsPerPeer(p) > 1
 
 	// Wrap and register the connection.
 	c := &Conn{
@@ -357,8 +450,7 @@ func (s *Swarm) addConn(tc transport.CapableConn, dir network.Direction) (*Conn,
 	// If we do this in the Upgrader, we will not be able to do this.
 	if s.gater != nil {
 		if allow, _ := s.gater.InterceptUpgraded(c); !allow {
-			// TODO Send disconnect with reason here
-			err := tc.Close()
+			err := tc.CloseWithError(network.ConnGated)
 			if err != nil {
 				log.Warnf("failed to close connection with peer %s and addr %s; err: %s", p, addr, err)
 			}
@@ -379,12 +471,18 @@ func (s *Swarm) addConn(tc transport.CapableConn, dir network.Direction) (*Conn,
 	// Check if we're still online
 	if s.conns.m == nil {
 		s.conns.Unlock()
-		tc.Close()
+		tc.CloseWithError(network.ConnShutdown)
 		return nil, ErrSwarmClosed
 	}
 
 	c.streams.m = make(map[*Stream]struct{})
+	if cr, ok := s.bwc.(metrics.ConnReporter); ok {
+		c.meter = cr.OpenConn(c.ID(), p, metricshelper.GetTransport(c.RemoteMultiaddr()))
+	}
 	isFirstConnection := len(s.conns.m[p]) == 0
+	// Only the first connection to a peer counts as a separate connection if
+	// we keep parallel connections to it.
+	c.stat.Parallel = parallel && !isFirstConnection
 	s.conns.m[p] = append(s.conns.m[p], c)
 
 	// Add two swarm refs:
@@ -425,6 +523,9 @@ func (s *Swarm) addConn(tc transport.CapableConn, dir network.Direction) (*Conn,
 	c.notifyLk.Unlock()
 
 	c.start()
+
+	s.supersedeConns(p)
+	s.maintainParallelConns(p)
 	return c, nil
 }
 
@@ -494,6 +595,7 @@ func (s *Swarm) NewStream(ctx context.Context, p peer.ID) (network.Stream, error
 			}
 		}
 
+		c = s.balancedConnToPeer(p, c)
 		str, err := c.NewStream(ctx)
 		if err != nil {
 			if c.conn.IsClosed() {
@@ -578,6 +680,14 @@ func isBetterConn(a, b *Conn) bool {
 		return !aTransient
 	}
 
+	// If one was superseded by a direct connection and not the other, prefer
+	// the other one.
+	aSuperseded := a.Superseded()
+	bSuperseded := b.Superseded()
+	if aSuperseded != bSuperseded {
+		return !aSuperseded
+	}
+
 	// If one is direct and not the other, prefer the direct connection.
 	aDirect := isDirectConn(a)
 	bDirect := isDirectConn(b)
@@ -811,6 +921,11 @@ func (c connWithMetrics) Close() error {
 	return c.CapableConn.Close()
 }
 
+func (c connWithMetrics) CloseWithError(errCode network.ConnErrorCode) error {
+	c.metricsTracer.ClosedConnection(c.dir, time.Since(c.opened), c.ConnState(), c.LocalMultiaddr())
+	return c.CapableConn.CloseWithError(errCode)
+}
+
 func (c connWithMetrics) Stat() network.ConnStats {
 	if cs, ok := c.CapableConn.(network.ConnStat); ok {
 		return cs.Stat()
@@ -5,9 +5,11 @@ import (
 	"errors"
 	"fmt"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	ic "github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/metrics"
 	"github.com/libp2p/go-libp2p/core/network"
 	"github.com/libp2p/go-libp2p/core/peer"
 	"github.com/libp2p/go-libp2p/core/transport"
@@ -37,6 +39,21 @@ type Conn struct {
 		m map[*Stream]struct{}
 	}
 
+	// supersededBy is the direct connection that superseded this connection.
+	// Guarded by streams.
+	supersededBy *Conn
+	// drained is closed when the last stream of a superseded connection is
+	// removed. Guarded by streams.
+	drained chan struct{}
+
+	// latency is the moving average of the RTTs recorded on the connection,
+	// in nanoseconds.
+	latency atomic.Int64
+
+	// meter records the traffic of the connection, if the bandwidth
+	// reporter of the swarm supports it.
+	meter *metrics.ConnMeter
+
 	stat network.ConnStats
 }
 
@@ -58,12 +75,30 @@ func (c *Conn) ID() string {
 // open notifications must finish before we can fire off the close
 // notifications).
 func (c *Conn) Close() error {
-	c.closeOnce.Do(c.doClose)
+	c.closeOnce.Do(func() { c.doClose(network.ConnNoError) })
+	return c.err
+}
+
+// CloseWithError closes this connection, sending the error code to the remote
+// peer if the transport supports it.
+func (c *Conn) CloseWithError(errCode network.ConnErrorCode) error {
+	c.closeOnce.Do(func() { c.doClose(errCode) })
 	return c.err
 }
 
-func (c *Conn) doClose() {
+func (c *Conn) doClose(errCode network.ConnErrorCode) {
 	c.swarm.removeConn(c)
+	if c.meter != nil {
+		c.meter.Close()
+	}
+	switch errCode {
+	case network.ConnGarbageCollected, network.ConnShutdown, network.ConnResourceLimitExceeded:
+		// Connections closed by the connection manager, or because we ran out
+		// of resources, are not replaced.
+	default:
+		// Replace the connection if we keep parallel connections to the peer.
+		c.swarm.maintainParallelConns(c.RemotePeer())
+	}
 
 	// Prevent new streams from opening.
 	c.streams.Lock()
@@ -71,7 +106,11 @@ func (c *Conn) doClose() {
 	c.streams.m = nil
 	c.streams.Unlock()
 
-	c.err = c.conn.Close()
+	if errCode != network.ConnNoError {
+		c.err = c.conn.CloseWithError(errCode)
+	} else {
+		c.err = c.conn.Close()
+	}
 
 	// This is just for cleaning up state. The connection has already been closed.
 	// We *could* optimize this but it really isn't worth it.
@@ -96,6 +135,10 @@ func (c *Conn) removeStream(s *Stream) {
 	c.streams.Lock()
 	c.stat.NumStreams--
 	delete(c.streams.m, s)
+	if c.drained != nil && len(c.streams.m) == 0 {
+		close(c.drained)
+		c.drained = nil
+	}
 	c.streams.Unlock()
 	s.scope.Done()
 }
@@ -116,7 +159,7 @@ func (c *Conn) start() {
 			}
 			scope, err := c.swarm.ResourceManager().OpenStream(c.RemotePeer(), network.DirInbound)
 			if err != nil {
-				ts.Reset()
+				ts.ResetWithError(network.StreamResourceLimitExceeded)
 				continue
 			}
 			c.swarm.refs.Add(1)
@@ -241,6 +284,13 @@ func (c *Conn) addStream(ts network.MuxedStream, dir network.Direction, scope ne
 		id:                             c.swarm.nextStreamID.Add(1),
 		acceptStreamGoroutineCompleted: dir != network.DirInbound,
 	}
+	if c.meter != nil {
+		s.meter = c.meter.NewStream()
+	}
+	if bs, ok := scope.(network.BandwidthScope); ok {
+		s.bwScope = bs
+		s.bwCtx, s.bwCancel = context.WithCancel(context.Background())
+	}
 	c.stat.NumStreams++
 	c.streams.m[s] = struct{}{}
 
@@ -16,7 +16,6 @@ import (
 	"github.com/libp2p/go-libp2p/core/transport"
 
 	ma "github.com/multiformats/go-multiaddr"
-	madns "github.com/multiformats/go-multiaddr-dns"
 	mafmt "github.com/multiformats/go-multiaddr-fmt"
 	manet "github.com/multiformats/go-multiaddr/net"
 )
@@ -236,9 +235,20 @@ func (s *Swarm) DialPeer(ctx context.Context, p peer.ID) (network.Conn, error) {
 //
 // It is gated by the swarm's dial synchronization systems: dialsync and
 // dialbackoff.
-func (s *Swarm) dialPeer(ctx context.Context, p peer.ID) (*Conn, error) {
+func (s *Swarm) dialPeer(ctx context.Context, p peer.ID) (conn *Conn, err error) {
 	log.Debugw("dialing peer", "from", s.local, "to", p)
-	err := p.Validate()
+	if trace := GetDialTrace(ctx); trace != nil {
+		trace.record(DialTraceEvent{Type: DialTraceStart, Peer: p})
+		defer func() {
+			evt := DialTraceEvent{Type: DialTraceFinished, Peer: p, Error: err}
+			if conn != nil {
+				evt.Addr = conn.RemoteMultiaddr()
+			}
+			trace.record(evt)
+		}()
+	}
+
+	err = p.Validate()
 	if err != nil {
 		return nil, err
 	}
@@ -248,8 +258,9 @@ func (s *Swarm) dialPeer(ctx context.Context, p peer.ID) (*Conn, error) {
 	}
 
 	// check if we already have an open (usable) connection.
-	conn := s.bestAcceptableConnToPeer(ctx, p)
+	conn = s.bestAcceptableConnToPeer(ctx, p)
 	if conn != nil {
+		GetDialTrace(ctx).record(DialTraceEvent{Type: DialTraceExistingConn, Peer: p, Addr: conn.RemoteMultiaddr()})
 		return conn, nil
 	}
 
@@ -307,98 +318,48 @@ func (s *Swarm) addrsForDial(ctx context.Context, p peer.ID) (goodAddrs []ma.Mul
 		return nil, nil, err
 	}
 
+	goodAddrs, addrErrs = s.filterAddrsForDial(ctx, p, resolved)
+	if len(goodAddrs) == 0 {
+		return nil, addrErrs, ErrNoGoodAddresses
+	}
+	return goodAddrs, addrErrs, nil
+}
+
+// filterAddrsForDial filters the resolved addresses of p, removing the
+// addresses we can't or shouldn't dial. The remaining addresses are added to
+// the peerstore.
+func (s *Swarm) filterAddrsForDial(ctx context.Context, p peer.ID, resolved []ma.Multiaddr) (goodAddrs []ma.Multiaddr, addrErrs []TransportError) {
 	goodAddrs = ma.Unique(resolved)
+	trace := GetDialTrace(ctx)
+	var candidates []ma.Multiaddr
+	if trace != nil {
+		trace.record(DialTraceEvent{Type: DialTraceResolved, Peer: p, Addrs: goodAddrs})
+		candidates = append(candidates, goodAddrs...)
+	}
 	goodAddrs, addrErrs = s.filterKnownUndialables(p, goodAddrs)
 	if forceDirect, _ := network.GetForceDirectDial(ctx); forceDirect {
 		goodAddrs = ma.FilterAddrs(goodAddrs, s.nonProxyAddr)
 	}
-
-	if len(goodAddrs) == 0 {
-		return nil, addrErrs, ErrNoGoodAddresses
+	if trace != nil {
+		recordFilteredAddrs(trace, p, candidates, goodAddrs, addrErrs)
 	}
 
-	s.peers.AddAddrs(p, goodAddrs, peerstore.TempAddrTTL)
-
-	return goodAddrs, addrErrs, nil
+	if len(goodAddrs) > 0 {
+		s.peers.AddAddrs(p, goodAddrs, peerstore.TempAddrTTL)
+	}
+	return goodAddrs, addrErrs
 }
 
+// resolveAddrs resolves all the addresses of pi, and returns the resolved
+// addresses once all of them have been resolved.
 func (s *Swarm) resolveAddrs(ctx context.Context, pi peer.AddrInfo) ([]ma.Multiaddr, error) {
-	p2paddr, err := ma.NewMultiaddr("/" + ma.ProtocolWithCode(ma.P_P2P).Name + "/" + pi.ID.String())
+	resolved := make([]ma.Multiaddr, 0, len(pi.Addrs))
+	err := s.resolveAddrsStream(ctx, pi, func(addrs []ma.Multiaddr) {
+		resolved = append(resolved, addrs...)
+	})
 	if err != nil {
 		return nil, err
 	}
-
-	var resolveSteps int
-	// Recursively resolve all addrs.
-	//
-	// While the toResolve list is non-empty:
-	// * Pop an address off.
-	// * If the address is fully resolved, add it to the resolved list.
-	// * Otherwise, resolve it and add the results to the "to resolve" list.
-	toResolve := append([]ma.Multiaddr{}, pi.Addrs...)
-	resolved := make([]ma.Multiaddr, 0, len(pi.Addrs))
-	for len(toResolve) > 0 {
-		// pop the last addr off.
-		addr := toResolve[len(toResolve)-1]
-		toResolve = toResolve[:len(toResolve)-1]
-
-		// if it's resolved, add it to the resolved list.
-		if !madns.Matches(addr) {
-			resolved = append(resolved, addr)
-			continue
-		}
-
-		resolveSteps++
-
-		// We've resolved too many addresses. We can keep all the fully
-		// resolved addresses but we'll need to skip the rest.
-		if resolveSteps >= maxAddressResolution {
-			log.Warnf(
-				"peer %s asked us to resolve too many addresses: %s/%s",
-				pi.ID,
-				resolveSteps,
-				maxAddressResolution,
-			)
-			continue
-		}
-
-		tpt := s.TransportForDialing(addr)
-		resolver, ok := tpt.(transport.Resolver)
-		if ok {
-			resolvedAddrs, err := resolver.Resolve(ctx, addr)
-			if err != nil {
-				log.Warnf("Failed to resolve multiaddr %s by transport %v: %v", addr, tpt, err)
-				continue
-			}
-			var added bool
-			for _, a := range resolvedAddrs {
-				if !addr.Equal(a) {
-					toResolve = append(toResolve, a)
-					added = true
-				}
-			}
-			if added {
-				continue
-			}
-		}
-
-		// otherwise, resolve it
-		reqaddr := addr.Encapsulate(p2paddr)
-		resaddrs, err := s.maResolver.Resolve(ctx, reqaddr)
-		if err != nil {
-			log.Infof("error resolving %s: %s", reqaddr, err)
-		}
-
-		// add the results to the toResolve list.
-		for _, res := range resaddrs {
-			pi, err := peer.AddrInfoFromP2pAddr(res)
-			if err != nil {
-				log.Infof("error parsing %s: %s", res, err)
-			}
-			toResolve = append(toResolve, pi.Addrs...)
-		}
-	}
-
 	return resolved, nil
 }
 
@@ -515,6 +476,7 @@ func (s *Swarm) limitedDial(ctx context.Context, p peer.ID, a ma.Multiaddr, resp
 		resp:    resp,
 		ctx:     ctx,
 		timeout: timeout,
+		traces:  getDialTraceSet(ctx),
 	})
 }
 
@@ -549,8 +511,10 @@ func (s *Swarm) dialAddr(ctx context.Context, p peer.ID, addr ma.Multiaddr, updC
 	// Notably, this also applies to cancellations (i.e. if another dial attempt was faster).
 	// This is ok since the black hole detector uses a very low threshold (5%).
 	s.bhd.RecordResult(addr, err == nil)
+	d := time.Since(start)
 
 	if err != nil {
+		s.recordDialHistory(p, addr, d, err)
 		if s.metricsTracer != nil {
 			s.metricsTracer.FailedDialing(addr, err, context.Cause(ctx))
 		}
@@ -568,13 +532,28 @@ func (s *Swarm) dialAddr(ctx context.Context, p peer.ID, addr ma.Multiaddr, updC
 		connC.Close()
 		err = fmt.Errorf("BUG in transport %T: tried to dial %s, dialed %s", p, connC.RemotePeer(), tpt)
 		log.Error(err)
+		s.recordDialHistory(p, addr, d, err)
 		return nil, err
 	}
 
 	// success! we got one!
+	s.recordDialHistory(p, addr, d, nil)
 	return connC, nil
 }
 
+// recordDialHistory records the outcome of a dial in the latency dial ranker,
+// if one is configured.
+func (s *Swarm) recordDialHistory(p peer.ID, addr ma.Multiaddr, d time.Duration, err error) {
+	if s.latencyRanker == nil {
+		return
+	}
+	if successRate, ok := s.latencyRanker.recordDial(p, addr, d, err); ok {
+		if ht, ok := s.metricsTracer.(DialHistoryTracer); ok {
+			ht.UpdatedDialHistory(addr, err == nil, d, successRate)
+		}
+	}
+}
+
 // TODO We should have a `IsFdConsuming() bool` method on the `Transport` interface in go-libp2p/core/transport.
 // This function checks if any of the transport protocols in the address requires a file descriptor.
 // For now:
@@ -109,6 +109,31 @@ var (
 		},
 		[]string{"name"},
 	)
+	dialHistoryOutcomes = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: metricNamespace,
+			Name:      "dial_history_outcomes_total",
+			Help:      "Outcomes of dials recorded by the latency dial ranker",
+		},
+		[]string{"transport", "ip_version", "outcome"},
+	)
+	dialHistorySuccessRate = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: metricNamespace,
+			Name:      "dial_history_success_rate",
+			Help:      "Moving average of the dial success rate per transport, as seen by the latency dial ranker",
+		},
+		[]string{"transport", "ip_version"},
+	)
+	dialHistoryHandshakeLatency = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Namespace: metricNamespace,
+			Name:      "dial_history_handshake_latency_seconds",
+			Help:      "Time to establish a connection, as seen by the latency dial ranker",
+			Buckets:   prometheus.ExponentialBuckets(0.001, 1.3, 35),
+		},
+		[]string{"transport", "ip_version"},
+	)
 	collectors = []prometheus.Collector{
 		connsOpened,
 		keyTypes,
@@ -121,6 +146,9 @@ var (
 		blackHoleFilterSuccessFraction,
 		blackHoleFilterState,
 		blackHoleFilterNextRequestAllowedAfter,
+		dialHistoryOutcomes,
+		dialHistorySuccessRate,
+		dialHistoryHandshakeLatency,
 	}
 )
 
@@ -134,9 +162,16 @@ type MetricsTracer interface {
 	UpdatedBlackHoleFilterState(name string, state blackHoleState, nextProbeAfter int, successFraction float64)
 }
 
+// DialHistoryTracer is an optional interface a MetricsTracer can implement to
+// be notified of the dial outcomes recorded by the LatencyDialRanker.
+type DialHistoryTracer interface {
+	UpdatedDialHistory(addr ma.Multiaddr, success bool, d time.Duration, successRate float64)
+}
+
 type metricsTracer struct{}
 
 var _ MetricsTracer = &metricsTracer{}
+var _ DialHistoryTracer = &metricsTracer{}
 
 type metricsTracerSetting struct {
 	reg prometheus.Registerer
@@ -285,3 +320,18 @@ func (m *metricsTracer) UpdatedBlackHoleFilterState(name string, state blackHole
 	blackHoleFilterSuccessFraction.WithLabelValues(*tags...).Set(successFraction)
 	blackHoleFilterNextRequestAllowedAfter.WithLabelValues(*tags...).Set(float64(nextProbeAfter))
 }
+
+func (m *metricsTracer) UpdatedDialHistory(addr ma.Multiaddr, success bool, d time.Duration, successRate float64) {
+	tags := metricshelper.GetStringSlice()
+	defer metricshelper.PutStringSlice(tags)
+
+	*tags = append(*tags, metricshelper.GetTransport(addr), metricshelper.GetIPVersion(addr))
+	dialHistorySuccessRate.WithLabelValues(*tags...).Set(successRate)
+	if success {
+		dialHistoryHandshakeLatency.WithLabelValues(*tags...).Observe(d.Seconds())
+		*tags = append(*tags, "success")
+	} else {
+		*tags = append(*tags, "failed")
+	}
+	dialHistoryOutcomes.WithLabelValues(*tags...).Inc()
+}
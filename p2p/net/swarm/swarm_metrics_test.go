@@ -102,6 +102,9 @@ func TestMetricsNoAllocNoCover(t *testing.T) {
 				mrand.Float64(),
 			)
 		},
+		"UpdatedDialHistory": func() {
+			mt.UpdatedDialHistory(randItem(addrs), mrand.Intn(2) == 1, time.Duration(mrand.Intn(1e10)), mrand.Float64())
+		},
 	}
 
 	for method, f := range tests {
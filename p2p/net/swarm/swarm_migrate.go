@@ -0,0 +1,152 @@
+package swarm
+
+import (
+	"errors"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/event"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+// DefaultSupersededConnDrainTimeout is the default time after which a limited
+// or relayed connection that was superseded by a direct connection is closed,
+// even if it still has open streams.
+const DefaultSupersededConnDrainTimeout = time.Minute
+
+// WithSupersededConnDrainTimeout sets the time after which a limited or
+// relayed connection that was superseded by a direct connection is closed. The
+// connection is closed earlier if all its streams are closed.
+func WithSupersededConnDrainTimeout(d time.Duration) Option {
+	return func(s *Swarm) error {
+		if d <= 0 {
+			return errors.New("swarm: superseded connection drain timeout must be positive")
+		}
+		s.supersededConnDrainTimeout = d
+		return nil
+	}
+}
+
+// Superseded returns whether the connection is a limited or relayed
+// connection that was superseded by a direct connection to the same peer. New
+// streams to the peer are opened on the direct connection, and the connection
+// is closed once drained.
+func (c *Conn) Superseded() bool {
+	c.streams.Lock()
+	defer c.streams.Unlock()
+	return c.supersededBy != nil
+}
+
+// canBeSuperseded returns whether c is a limited or relayed connection.
+func canBeSuperseded(c *Conn) bool {
+	return c.Stat().Transient || !isDirectConn(c)
+}
+
+// supersede marks c as superseded by direct. It returns a channel that is
+// closed once c has no streams anymore, and false if c was already superseded
+// or is closed.
+func (c *Conn) supersede(direct *Conn) (drained <-chan struct{}, ok bool) {
+	c.streams.Lock()
+	defer c.streams.Unlock()
+	if c.supersededBy != nil || c.streams.m == nil {
+		return nil, false
+	}
+	c.supersededBy = direct
+	ch := make(chan struct{})
+	if len(c.streams.m) == 0 {
+		close(ch)
+	} else {
+		c.drained = ch
+	}
+	return ch, true
+}
+
+func (c *Conn) unsupersede() {
+	c.streams.Lock()
+	defer c.streams.Unlock()
+	c.supersededBy = nil
+	c.drained = nil
+}
+
+// directConnToPeer returns a direct, unlimited connection to p, if any.
+func (s *Swarm) directConnToPeer(p peer.ID) *Conn {
+	conns := s.directConnsToPeer(p)
+	if len(conns) == 0 {
+		return nil
+	}
+	return conns[0]
+}
+
+// directConnsToPeer returns the open, direct and unlimited connections to p.
+func (s *Swarm) directConnsToPeer(p peer.ID) []*Conn {
+	s.conns.RLock()
+	defer s.conns.RUnlock()
+	var conns []*Conn
+	for _, c := range s.conns.m[p] {
+		if !c.conn.IsClosed() && !canBeSuperseded(c) {
+			conns = append(conns, c)
+		}
+	}
+	return conns
+}
+
+// supersedeConns marks the limited and relayed connections to p as superseded
+// if there's a direct connection to p. Applications are notified using
+// event.EvtConnSuperseded, and the superseded connections are closed once
+// drained.
+func (s *Swarm) supersedeConns(p peer.ID) {
+	direct := s.directConnToPeer(p)
+	if direct == nil {
+		return
+	}
+
+	s.conns.RLock()
+	var conns []*Conn
+	for _, c := range s.conns.m[p] {
+		if c != direct && canBeSuperseded(c) {
+			conns = append(conns, c)
+		}
+	}
+	s.conns.RUnlock()
+
+	for _, c := range conns {
+		drained, ok := c.supersede(direct)
+		if !ok {
+			continue
+		}
+		deadline := time.Now().Add(s.supersededConnDrainTimeout)
+		log.Debugw("connection superseded by direct connection", "peer", p, "conn", c.RemoteMultiaddr(), "direct", direct.RemoteMultiaddr())
+		if err := s.supersededEmitter.Emit(event.EvtConnSuperseded{
+			Peer:       p,
+			Superseded: c,
+			By:         direct,
+			Deadline:   deadline,
+		}); err != nil {
+			log.Debugf("failed to emit connection superseded event: %s", err)
+		}
+		go s.drainSupersededConn(c, drained, deadline)
+	}
+}
+
+// drainSupersededConn closes the superseded connection c once it's drained,
+// or at the deadline. If there's no direct connection to the peer anymore by
+// then, c isn't closed, and is not considered superseded anymore.
+func (s *Swarm) drainSupersededConn(c *Conn, drained <-chan struct{}, deadline time.Time) {
+	t := time.NewTimer(time.Until(deadline))
+	defer t.Stop()
+	select {
+	case <-drained:
+	case <-t.C:
+	case <-s.ctx.Done():
+		return
+	}
+
+	if s.directConnToPeer(c.RemotePeer()) == nil {
+		log.Debugw("direct connection closed, keeping superseded connection", "peer", c.RemotePeer(), "conn", c.RemoteMultiaddr())
+		c.unsupersede()
+		return
+	}
+	if err := c.CloseWithError(network.ConnSupplanted); err != nil {
+		log.Debugf("error when closing superseded connection: %s", err)
+	}
+}
@@ -0,0 +1,139 @@
+package swarm
+
+import (
+	"context"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/event"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/transport"
+	"github.com/libp2p/go-libp2p/p2p/host/eventbus"
+	"github.com/libp2p/go-libp2p/p2p/host/peerstore/pstoremem"
+	"github.com/libp2p/go-libp2p/p2p/transport/tcp"
+
+	ma "github.com/multiformats/go-multiaddr"
+	"github.com/stretchr/testify/require"
+)
+
+// limitedConn is a connection that is reported as a limited relayed
+// connection.
+type limitedConn struct {
+	transport.CapableConn
+}
+
+func (c limitedConn) Stat() network.ConnStats {
+	return network.ConnStats{Stats: network.Stats{Transient: true}}
+}
+
+func makeTCPSwarm(t *testing.T, bus event.Bus, opts ...Option) (*Swarm, transport.Transport) {
+	t.Helper()
+	priv, id := newPeer(t)
+	ps, err := pstoremem.NewPeerstore()
+	require.NoError(t, err)
+	ps.AddPubKey(id, priv.GetPublic())
+	ps.AddPrivKey(id, priv)
+	t.Cleanup(func() { ps.Close() })
+
+	s, err := NewSwarm(id, ps, bus, opts...)
+	require.NoError(t, err)
+	t.Cleanup(func() { s.Close() })
+	tpt, err := tcp.NewTCPTransport(makeUpgrader(t, s), nil, tcp.DisableReuseport())
+	require.NoError(t, err)
+	require.NoError(t, s.AddTransport(tpt))
+	require.NoError(t, s.Listen(ma.StringCast("/ip4/127.0.0.1/tcp/0")))
+	return s, tpt
+}
+
+// connectLimitedAndDirect adds a limited connection from s1 to s2, opens a
+// stream on it, and then adds a direct connection.
+func connectLimitedAndDirect(t *testing.T, s1, s2 *Swarm, tpt transport.Transport) (limited, direct *Conn, str network.Stream) {
+	t.Helper()
+	s2.SetStreamHandler(func(s network.Stream) {
+		io.Copy(io.Discard, s)
+		s.Close()
+	})
+
+	tc, err := tpt.Dial(context.Background(), s2.ListenAddresses()[0], s2.LocalPeer())
+	require.NoError(t, err)
+	limited, err = s1.addConn(limitedConn{tc}, network.DirOutbound)
+	require.NoError(t, err)
+
+	_, err = limited.NewStream(context.Background())
+	require.ErrorIs(t, err, network.ErrTransientConn)
+	str, err = limited.NewStream(network.WithUseTransient(context.Background(), "test"))
+	require.NoError(t, err)
+
+	tc, err = tpt.Dial(context.Background(), s2.ListenAddresses()[0], s2.LocalPeer())
+	require.NoError(t, err)
+	direct, err = s1.addConn(tc, network.DirOutbound)
+	require.NoError(t, err)
+	return limited, direct, str
+}
+
+func TestSupersedeLimitedConn(t *testing.T) {
+	bus := eventbus.NewBus()
+	sub, err := bus.Subscribe(new(event.EvtConnSuperseded))
+	require.NoError(t, err)
+	defer sub.Close()
+
+	s1, tpt := makeTCPSwarm(t, bus)
+	s2, _ := makeTCPSwarm(t, eventbus.NewBus())
+
+	limited, direct, str := connectLimitedAndDirect(t, s1, s2, tpt)
+
+	select {
+	case e := <-sub.Out():
+		evt := e.(event.EvtConnSuperseded)
+		require.Equal(t, s2.LocalPeer(), evt.Peer)
+		require.Equal(t, limited, evt.Superseded)
+		require.Equal(t, direct, evt.By)
+		require.WithinDuration(t, time.Now().Add(DefaultSupersededConnDrainTimeout), evt.Deadline, time.Second)
+	case <-time.After(time.Second):
+		t.Fatal("expected a connection superseded event")
+	}
+	require.True(t, limited.Superseded())
+	require.False(t, direct.Superseded())
+	require.Equal(t, direct, s1.bestConnToPeer(s2.LocalPeer()))
+
+	// new streams are opened on the direct connection, even if limited
+	// connections are allowed
+	s, err := s1.NewStream(network.WithUseTransient(context.Background(), "test"), s2.LocalPeer())
+	require.NoError(t, err)
+	require.Equal(t, direct, s.Conn())
+	s.Reset()
+
+	// the limited connection is closed once drained
+	require.Never(t, limited.IsClosed, 100*time.Millisecond, 10*time.Millisecond)
+	str.Reset()
+	require.Eventually(t, limited.IsClosed, 5*time.Second, 10*time.Millisecond)
+	require.False(t, direct.IsClosed())
+	require.Len(t, s1.ConnsToPeer(s2.LocalPeer()), 1)
+}
+
+func TestSupersededConnDrainTimeout(t *testing.T) {
+	s1, tpt := makeTCPSwarm(t, eventbus.NewBus(), WithSupersededConnDrainTimeout(200*time.Millisecond))
+	s2, _ := makeTCPSwarm(t, eventbus.NewBus())
+
+	limited, direct, str := connectLimitedAndDirect(t, s1, s2, tpt)
+	defer str.Reset()
+
+	require.True(t, limited.Superseded())
+	require.Eventually(t, limited.IsClosed, 5*time.Second, 10*time.Millisecond)
+	require.False(t, direct.IsClosed())
+}
+
+func TestSupersededConnKeptWithoutDirectConn(t *testing.T) {
+	s1, tpt := makeTCPSwarm(t, eventbus.NewBus(), WithSupersededConnDrainTimeout(200*time.Millisecond))
+	s2, _ := makeTCPSwarm(t, eventbus.NewBus())
+
+	limited, direct, str := connectLimitedAndDirect(t, s1, s2, tpt)
+	defer str.Reset()
+
+	require.True(t, limited.Superseded())
+	direct.Close()
+	require.Eventually(t, func() bool { return !limited.Superseded() }, 5*time.Second, 10*time.Millisecond)
+	require.False(t, limited.IsClosed())
+	require.Equal(t, limited, s1.bestConnToPeer(s2.LocalPeer()))
+}
@@ -0,0 +1,284 @@
+package swarm
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/transport"
+
+	ma "github.com/multiformats/go-multiaddr"
+	"golang.org/x/exp/slices"
+)
+
+// StreamBalancing is the strategy used to spread new streams over the
+// parallel connections to a peer.
+type StreamBalancing int
+
+const (
+	// BalanceByStreamCount opens new streams on the connection with the
+	// fewest open streams.
+	BalanceByStreamCount StreamBalancing = iota
+	// BalanceByRTT opens new streams on the connection with the lowest RTT,
+	// weighted by the number of streams open on the connection. If the RTT
+	// of one of the connections hasn't been measured yet, streams are
+	// balanced by stream count.
+	BalanceByRTT
+)
+
+// MultiConnPolicy configures the swarm to keep several parallel connections
+// to some peers, and to spread the streams to these peers over all their
+// connections. This avoids a single connection becoming the throughput
+// bottleneck to high-bandwidth peers.
+type MultiConnPolicy struct {
+	// ConnsPerPeer returns the number of connections to keep to p. One or
+	// less disables parallel connections to p.
+	ConnsPerPeer func(p peer.ID) int
+	// Balancing is the strategy used to select the connection new streams
+	// are opened on.
+	Balancing StreamBalancing
+}
+
+// WithMultiConnPolicy configures the swarm to keep parallel connections to the
+// peers selected by p.
+//
+// Parallel connections are only opened once there's a direct connection to the
+// peer, preferably over transports that aren't used by the existing
+// connections yet. They are marked as parallel in their ConnStats, so that the
+// connection manager doesn't count them as separate connections.
+func WithMultiConnPolicy(p MultiConnPolicy) Option {
+	return func(s *Swarm) error {
+		if p.ConnsPerPeer == nil {
+			return errors.New("swarm: multi connection policy must set ConnsPerPeer")
+		}
+		if p.Balancing != BalanceByStreamCount && p.Balancing != BalanceByRTT {
+			return errors.New("swarm: invalid stream balancing strategy")
+		}
+		s.multiConn = &p
+		return nil
+	}
+}
+
+// connsPerPeer returns the number of connections to keep to p.
+func (s *Swarm) connsPerPeer(p peer.ID) int {
+	if s.multiConn == nil {
+		return 1
+	}
+	return s.multiConn.ConnsPerPeer(p)
+}
+
+// RecordLatency records an RTT measured on the connection. The swarm uses it
+// to balance streams over parallel connections.
+func (c *Conn) RecordLatency(next time.Duration) {
+	const smoothing = 0.1
+	for {
+		prev := c.latency.Load()
+		ewma := int64(next)
+		if prev != 0 {
+			ewma = int64((1.0-smoothing)*float64(prev) + smoothing*float64(next))
+		}
+		if c.latency.CompareAndSwap(prev, ewma) {
+			return
+		}
+	}
+}
+
+// Latency returns an exponentially-weighted moving average of the RTTs
+// recorded on the connection, or zero if none was recorded.
+func (c *Conn) Latency() time.Duration {
+	return time.Duration(c.latency.Load())
+}
+
+// balancedConnToPeer returns the connection to p new streams should be opened
+// on, among the connections that are as good as best according to
+// isBetterConn.
+func (s *Swarm) balancedConnToPeer(p peer.ID, best *Conn) *Conn {
+	if s.multiConn == nil {
+		return best
+	}
+	transient, superseded, direct := best.Stat().Transient, best.Superseded(), isDirectConn(best)
+
+	s.conns.RLock()
+	conns := make([]*Conn, 0, len(s.conns.m[p]))
+	for _, c := range s.conns.m[p] {
+		if c.conn.IsClosed() || c.Stat().Transient != transient || c.Superseded() != superseded || isDirectConn(c) != direct {
+			continue
+		}
+		conns = append(conns, c)
+	}
+	s.conns.RUnlock()
+	if len(conns) <= 1 {
+		return best
+	}
+
+	byRTT := s.multiConn.Balancing == BalanceByRTT
+	for _, c := range conns {
+		if c.Latency() == 0 {
+			byRTT = false
+			break
+		}
+	}
+	score := func(c *Conn) (float64, int) {
+		c.streams.Lock()
+		n := len(c.streams.m)
+		c.streams.Unlock()
+		if byRTT {
+			return float64(c.Latency()) * float64(n+1), n
+		}
+		return float64(n), n
+	}
+
+	// Pick the connection with the lowest score. On ties, pick the one with
+	// the fewest streams, and then the oldest one.
+	var balanced *Conn
+	var minScore float64
+	var minStreams int
+	for _, c := range conns {
+		sc, n := score(c)
+		if balanced == nil || sc < minScore || (sc == minScore && n < minStreams) {
+			balanced, minScore, minStreams = c, sc, n
+		}
+	}
+	return balanced
+}
+
+// maintainParallelConns opens parallel connections to p in the background,
+// until the number of connections required by the multi connection policy is
+// reached.
+func (s *Swarm) maintainParallelConns(p peer.ID) {
+	if s.connsPerPeer(p) <= 1 {
+		return
+	}
+
+	s.parallelDials.Lock()
+	if _, ok := s.parallelDials.m[p]; ok {
+		s.parallelDials.Unlock()
+		return
+	}
+	s.parallelDials.m[p] = struct{}{}
+	s.parallelDials.Unlock()
+
+	s.conns.RLock()
+	if s.conns.m == nil {
+		s.conns.RUnlock()
+		s.parallelDials.Lock()
+		delete(s.parallelDials.m, p)
+		s.parallelDials.Unlock()
+		return
+	}
+	s.refs.Add(1)
+	s.conns.RUnlock()
+
+	go func() {
+		defer s.refs.Done()
+		defer func() {
+			s.parallelDials.Lock()
+			delete(s.parallelDials.m, p)
+			s.parallelDials.Unlock()
+		}()
+		s.dialParallelConns(p)
+	}()
+}
+
+// dialParallelConns dials p until there are as many direct connections to p as
+// required by the multi connection policy, or none of the addresses of p can
+// be dialed.
+func (s *Swarm) dialParallelConns(p peer.ID) {
+	// failed are the addresses we failed to dial
+	failed := make(map[string]struct{})
+	for s.ctx.Err() == nil {
+		conns := s.directConnsToPeer(p)
+		if len(conns) == 0 || len(conns) >= s.connsPerPeer(p) {
+			return
+		}
+
+		addrs := s.parallelConnAddrs(p, conns)
+		addrs = slices.DeleteFunc(addrs, func(a ma.Multiaddr) bool {
+			_, ok := failed[string(a.Bytes())]
+			return ok
+		})
+		if len(addrs) == 0 {
+			log.Debugw("no address left to dial a parallel connection", "peer", p)
+			return
+		}
+		addr := addrs[0]
+
+		tc, err := s.dialParallelConn(p, addr)
+		if err != nil {
+			log.Debugw("failed to dial parallel connection", "peer", p, "addr", addr, "error", err)
+			failed[string(addr.Bytes())] = struct{}{}
+			continue
+		}
+		if _, err := s.addConn(tc, network.DirOutbound); err != nil {
+			log.Debugw("failed to add parallel connection", "peer", p, "addr", addr, "error", err)
+			return
+		}
+	}
+}
+
+// dialParallelConn dials a parallel connection to p on addr. Like the dials of
+// the dial worker, it respects the dial backoff and goes through the dial
+// limiter, and failed dials are backed off.
+func (s *Swarm) dialParallelConn(p peer.ID, addr ma.Multiaddr) (transport.CapableConn, error) {
+	resch := make(chan transport.DialUpdate, 1)
+	if err := s.dialNextAddr(s.ctx, p, addr, resch); err != nil {
+		return nil, err
+	}
+	for {
+		select {
+		case res := <-resch:
+			if res.Kind == transport.UpdateKindHandshakeProgressed {
+				continue
+			}
+			if res.Err != nil {
+				if res.Err != context.Canceled {
+					s.backf.AddBackoff(p, addr)
+				}
+				return nil, res.Err
+			}
+			return res.Conn, nil
+		case <-s.ctx.Done():
+			return nil, s.ctx.Err()
+		}
+	}
+}
+
+// parallelConnAddrs returns the addresses to dial a parallel connection to p
+// on, given the existing connections to p. Addresses of transports that are not
+// used by these connections come first, in the order of the dial ranker.
+func (s *Swarm) parallelConnAddrs(p peer.ID, conns []*Conn) []ma.Multiaddr {
+	addrs, _ := s.filterKnownUndialables(p, s.peers.Addrs(p))
+	addrs = ma.FilterAddrs(addrs, func(a ma.Multiaddr) bool {
+		t := s.TransportForDialing(a)
+		return t != nil && !t.Proxy()
+	})
+	if len(addrs) == 0 {
+		return nil
+	}
+
+	ranking := s.dialRanker(addrs)
+	slices.SortStableFunc(ranking, func(a, b network.AddrDelay) int {
+		if a.Delay < b.Delay {
+			return -1
+		} else if a.Delay > b.Delay {
+			return 1
+		}
+		return 0
+	})
+	used := make(map[transport.Transport]struct{}, len(conns))
+	for _, c := range conns {
+		used[c.conn.Transport()] = struct{}{}
+	}
+	res := make([]ma.Multiaddr, 0, len(ranking))
+	var usedAddrs []ma.Multiaddr
+	for _, ad := range ranking {
+		if _, ok := used[s.TransportForDialing(ad.Addr)]; ok {
+			usedAddrs = append(usedAddrs, ad.Addr)
+		} else {
+			res = append(res, ad.Addr)
+		}
+	}
+	return append(res, usedAddrs...)
+}
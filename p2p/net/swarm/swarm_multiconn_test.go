@@ -0,0 +1,132 @@
+package swarm
+
+import (
+	"context"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/peerstore"
+	"github.com/libp2p/go-libp2p/p2p/host/eventbus"
+
+	ma "github.com/multiformats/go-multiaddr"
+	"github.com/stretchr/testify/require"
+)
+
+func connectParallel(t *testing.T, balancing StreamBalancing) (s1, s2 *Swarm) {
+	t.Helper()
+	s2, _ = makeTCPSwarm(t, eventbus.NewBus())
+	s2.SetStreamHandler(func(s network.Stream) {
+		io.Copy(io.Discard, s)
+		s.Close()
+	})
+	s1, _ = makeTCPSwarm(t, eventbus.NewBus(), WithMultiConnPolicy(MultiConnPolicy{
+		ConnsPerPeer: func(p peer.ID) int {
+			if p == s2.LocalPeer() {
+				return 3
+			}
+			return 1
+		},
+		Balancing: balancing,
+	}))
+	s1.Peerstore().AddAddrs(s2.LocalPeer(), s2.ListenAddresses(), peerstore.PermanentAddrTTL)
+
+	_, err := s1.DialPeer(context.Background(), s2.LocalPeer())
+	require.NoError(t, err)
+	require.Eventually(t, func() bool { return len(s1.ConnsToPeer(s2.LocalPeer())) == 3 }, 5*time.Second, 10*time.Millisecond)
+	return s1, s2
+}
+
+func TestParallelConns(t *testing.T) {
+	s1, s2 := connectParallel(t, BalanceByStreamCount)
+
+	var parallel int
+	for _, c := range s1.ConnsToPeer(s2.LocalPeer()) {
+		if c.Stat().Parallel {
+			parallel++
+		}
+	}
+	require.Equal(t, 2, parallel)
+
+	// streams are spread over all connections
+	streams := make(map[network.Conn]int)
+	for i := 0; i < 6; i++ {
+		str, err := s1.NewStream(context.Background(), s2.LocalPeer())
+		require.NoError(t, err)
+		defer str.Reset()
+		streams[str.Conn()]++
+	}
+	require.Len(t, streams, 3)
+	for _, n := range streams {
+		require.Equal(t, 2, n)
+	}
+
+	// closed connections are replaced
+	s1.ConnsToPeer(s2.LocalPeer())[1].Close()
+	require.Eventually(t, func() bool {
+		conns := s1.ConnsToPeer(s2.LocalPeer())
+		return len(conns) == 3 && !conns[0].IsClosed()
+	}, 5*time.Second, 10*time.Millisecond)
+
+	// but not if they were closed by the connection manager
+	s1.ConnsToPeer(s2.LocalPeer())[1].CloseWithError(network.ConnGarbageCollected)
+	require.Never(t, func() bool { return len(s1.ConnsToPeer(s2.LocalPeer())) == 3 }, 200*time.Millisecond, 10*time.Millisecond)
+
+	// or because we ran out of resources
+	s1.ConnsToPeer(s2.LocalPeer())[1].CloseWithError(network.ConnResourceLimitExceeded)
+	require.Never(t, func() bool { return len(s1.ConnsToPeer(s2.LocalPeer())) == 2 }, 200*time.Millisecond, 10*time.Millisecond)
+}
+
+func TestParallelConnsDialBackoff(t *testing.T) {
+	s1, s2 := connectParallel(t, BalanceByStreamCount)
+
+	// addresses in backoff are not dialed
+	for _, a := range s2.ListenAddresses() {
+		s1.backf.AddBackoff(s2.LocalPeer(), a)
+	}
+	s1.ConnsToPeer(s2.LocalPeer())[1].Close()
+	require.Never(t, func() bool { return len(s1.ConnsToPeer(s2.LocalPeer())) == 3 }, 200*time.Millisecond, 10*time.Millisecond)
+
+	// failed dials are backed off
+	s1.backf.Clear(s2.LocalPeer())
+	unreachable := ma.StringCast("/ip4/127.0.0.1/tcp/1")
+	s1.Peerstore().ClearAddrs(s2.LocalPeer())
+	s1.Peerstore().AddAddr(s2.LocalPeer(), unreachable, peerstore.PermanentAddrTTL)
+	s1.ConnsToPeer(s2.LocalPeer())[1].Close()
+	require.Eventually(t, func() bool {
+		return s1.backf.Backoff(s2.LocalPeer(), unreachable)
+	}, 5*time.Second, 10*time.Millisecond)
+}
+
+func TestParallelConnsBalanceByRTT(t *testing.T) {
+	s1, s2 := connectParallel(t, BalanceByRTT)
+
+	conns := s1.ConnsToPeer(s2.LocalPeer())
+	for i, c := range conns {
+		c.(*Conn).RecordLatency(time.Duration(i+1) * 10 * time.Millisecond)
+	}
+
+	// The connection with a 10ms RTT is used until it has as many streams as
+	// needed to make its score worse than the 20ms connection, and so on.
+	streams := make(map[network.Conn]int)
+	for i := 0; i < 6; i++ {
+		str, err := s1.NewStream(context.Background(), s2.LocalPeer())
+		require.NoError(t, err)
+		defer str.Reset()
+		streams[str.Conn()]++
+	}
+	require.Equal(t, 3, streams[conns[0]])
+	require.Equal(t, 2, streams[conns[1]])
+	require.Equal(t, 1, streams[conns[2]])
+}
+
+func TestConnRecordLatency(t *testing.T) {
+	c := &Conn{}
+	require.Zero(t, c.Latency())
+	c.RecordLatency(100 * time.Millisecond)
+	require.Equal(t, 100*time.Millisecond, c.Latency())
+	c.RecordLatency(200 * time.Millisecond)
+	require.Equal(t, 110*time.Millisecond, c.Latency())
+}
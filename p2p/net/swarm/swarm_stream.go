@@ -1,11 +1,15 @@
 package swarm
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"os"
 	"sync"
 	"sync/atomic"
 	"time"
 
+	"github.com/libp2p/go-libp2p/core/metrics"
 	"github.com/libp2p/go-libp2p/core/network"
 	"github.com/libp2p/go-libp2p/core/protocol"
 )
@@ -22,6 +26,20 @@ type Stream struct {
 	conn   *Conn
 	scope  network.StreamManagementScope
 
+	// bwScope is set if the resource manager limits the bandwidth of the
+	// stream. bwCtx is cancelled when the stream is closed, to interrupt the
+	// reads and writes waiting for bandwidth. The waits are also interrupted
+	// when the read and write deadlines of the stream expire.
+	bwScope         network.BandwidthScope
+	bwCtx           context.Context
+	bwCancel        context.CancelFunc
+	bwReadDeadline  bwDeadline
+	bwWriteDeadline bwDeadline
+
+	// meter records the traffic of the stream, if the bandwidth reporter
+	// of the swarm supports it.
+	meter *metrics.StreamMeter
+
 	closeMx  sync.Mutex
 	isClosed bool
 	// acceptStreamGoroutineCompleted indicates whether the goroutine handling the incoming stream has exited
@@ -56,8 +74,20 @@ func (s *Stream) Conn() network.Conn {
 // Read reads bytes from a stream.
 func (s *Stream) Read(p []byte) (int, error) {
 	n, err := s.stream.Read(p)
+	if s.bwScope != nil && n > 0 {
+		// The bytes were read already, so we can only delay returning them.
+		// If the wait is interrupted, the bytes are returned with the error.
+		werr := s.bwReadDeadline.wait(s.bwCtx, func(ctx context.Context) error {
+			return s.bwScope.WaitBandwidth(ctx, network.DirInbound, n)
+		})
+		if err == nil {
+			err = werr
+		}
+	}
 	// TODO: push this down to a lower level for better accuracy.
-	if s.conn.swarm.bwc != nil {
+	if s.meter != nil {
+		s.meter.LogRecv(int64(n))
+	} else if s.conn.swarm.bwc != nil {
 		s.conn.swarm.bwc.LogRecvMessage(int64(n))
 		s.conn.swarm.bwc.LogRecvMessageStream(int64(n), s.Protocol(), s.Conn().RemotePeer())
 	}
@@ -66,9 +96,19 @@ func (s *Stream) Read(p []byte) (int, error) {
 
 // Write writes bytes to a stream, flushing for each call.
 func (s *Stream) Write(p []byte) (int, error) {
+	if s.bwScope != nil {
+		err := s.bwWriteDeadline.wait(s.bwCtx, func(ctx context.Context) error {
+			return s.bwScope.WaitBandwidth(ctx, network.DirOutbound, len(p))
+		})
+		if err != nil {
+			return 0, err
+		}
+	}
 	n, err := s.stream.Write(p)
 	// TODO: push this down to a lower level for better accuracy.
-	if s.conn.swarm.bwc != nil {
+	if s.meter != nil {
+		s.meter.LogSent(int64(n))
+	} else if s.conn.swarm.bwc != nil {
 		s.conn.swarm.bwc.LogSentMessage(int64(n))
 		s.conn.swarm.bwc.LogSentMessageStream(int64(n), s.Protocol(), s.Conn().RemotePeer())
 	}
@@ -91,6 +131,13 @@ func (s *Stream) Reset() error {
 	return err
 }
 
+// ResetWithError resets the stream, sending the error code to the remote peer.
+func (s *Stream) ResetWithError(errCode network.StreamErrorCode) error {
+	err := s.stream.ResetWithError(errCode)
+	s.closeAndRemoveStream()
+	return err
+}
+
 func (s *Stream) closeAndRemoveStream() {
 	s.closeMx.Lock()
 	defer s.closeMx.Unlock()
@@ -98,6 +145,9 @@ func (s *Stream) closeAndRemoveStream() {
 		return
 	}
 	s.isClosed = true
+	if s.bwCancel != nil {
+		s.bwCancel()
+	}
 	// We don't want to keep swarm from closing till the stream handler has exited
 	s.conn.swarm.refs.Done()
 	// Cleanup the stream from connection only after the stream handler has completed
@@ -151,21 +201,28 @@ func (s *Stream) SetProtocol(p protocol.ID) error {
 	}
 
 	s.protocol.Store(&p)
+	if s.meter != nil {
+		s.meter.SetProtocol(p)
+	}
 	return nil
 }
 
 // SetDeadline sets the read and write deadlines for this stream.
 func (s *Stream) SetDeadline(t time.Time) error {
+	s.bwReadDeadline.set(t)
+	s.bwWriteDeadline.set(t)
 	return s.stream.SetDeadline(t)
 }
 
 // SetReadDeadline sets the read deadline for this stream.
 func (s *Stream) SetReadDeadline(t time.Time) error {
+	s.bwReadDeadline.set(t)
 	return s.stream.SetReadDeadline(t)
 }
 
 // SetWriteDeadline sets the write deadline for this stream.
 func (s *Stream) SetWriteDeadline(t time.Time) error {
+	s.bwWriteDeadline.set(t)
 	return s.stream.SetWriteDeadline(t)
 }
 
@@ -177,3 +234,81 @@ func (s *Stream) Stat() network.Stats {
 func (s *Stream) Scope() network.StreamScope {
 	return s.scope
 }
+
+// bwDeadline interrupts the bandwidth waits in one direction of a stream when
+// the deadline set on the stream expires. Like for a net.Conn, moving the
+// deadline affects the waits in progress.
+type bwDeadline struct {
+	mx       sync.Mutex
+	deadline time.Time
+	timer    *time.Timer
+	// cancels are the cancel functions of the waits in progress
+	cancels map[*context.CancelCauseFunc]struct{}
+}
+
+func (d *bwDeadline) set(t time.Time) {
+	d.mx.Lock()
+	defer d.mx.Unlock()
+	d.deadline = t
+	d.resetTimer()
+}
+
+// resetTimer arms the timer for the current deadline, if any wait is in
+// progress. It must be called with mx held.
+func (d *bwDeadline) resetTimer() {
+	if d.timer != nil {
+		d.timer.Stop()
+		d.timer = nil
+	}
+	if d.deadline.IsZero() || len(d.cancels) == 0 {
+		return
+	}
+	d.timer = time.AfterFunc(time.Until(d.deadline), d.expire)
+}
+
+func (d *bwDeadline) expire() {
+	d.mx.Lock()
+	defer d.mx.Unlock()
+	if d.deadline.IsZero() || time.Now().Before(d.deadline) {
+		// the deadline was moved in the meantime
+		return
+	}
+	for cancel := range d.cancels {
+		(*cancel)(os.ErrDeadlineExceeded)
+	}
+}
+
+// wait calls waitFn with a context that is cancelled when ctx is done, or when
+// the deadline expires. In the latter case, it returns os.ErrDeadlineExceeded.
+func (d *bwDeadline) wait(ctx context.Context, waitFn func(context.Context) error) error {
+	ctx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+
+	d.mx.Lock()
+	if !d.deadline.IsZero() && !time.Now().Before(d.deadline) {
+		d.mx.Unlock()
+		return os.ErrDeadlineExceeded
+	}
+	if d.cancels == nil {
+		d.cancels = make(map[*context.CancelCauseFunc]struct{})
+	}
+	d.cancels[&cancel] = struct{}{}
+	if len(d.cancels) == 1 {
+		d.resetTimer()
+	}
+	d.mx.Unlock()
+
+	err := waitFn(ctx)
+
+	d.mx.Lock()
+	delete(d.cancels, &cancel)
+	if len(d.cancels) == 0 {
+		d.resetTimer()
+	}
+	d.mx.Unlock()
+
+	if err != nil && errors.Is(context.Cause(ctx), os.ErrDeadlineExceeded) {
+		return os.ErrDeadlineExceeded
+	}
+	return err
+}
@@ -0,0 +1,160 @@
+package swarm
+
+import (
+	"context"
+	"io"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/metrics"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peerstore"
+	"github.com/libp2p/go-libp2p/p2p/host/eventbus"
+	rcmgr "github.com/libp2p/go-libp2p/p2p/host/resource-manager"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestStreamBandwidthLimit(t *testing.T) {
+	limits := rcmgr.PartialLimitConfig{
+		System: rcmgr.ResourceLimits{BandwidthOutbound: 10 << 10},
+	}
+	rm, err := rcmgr.NewResourceManager(rcmgr.NewFixedLimiter(limits.Build(rcmgr.InfiniteLimits)))
+	require.NoError(t, err)
+	defer rm.Close()
+
+	s1, _ := makeTCPSwarm(t, eventbus.NewBus(), WithResourceManager(rm))
+	s2, _ := makeTCPSwarm(t, eventbus.NewBus())
+	s2.SetStreamHandler(func(s network.Stream) {
+		io.Copy(io.Discard, s)
+		s.Close()
+	})
+	s1.Peerstore().AddAddrs(s2.LocalPeer(), s2.ListenAddresses(), peerstore.PermanentAddrTTL)
+
+	str, err := s1.NewStream(context.Background(), s2.LocalPeer())
+	require.NoError(t, err)
+
+	// the first second worth of traffic goes through immediately, the
+	// rest is rate limited
+	start := time.Now()
+	_, err = str.Write(make([]byte, 15<<10))
+	require.NoError(t, err)
+	require.GreaterOrEqual(t, time.Since(start), 400*time.Millisecond)
+	require.Equal(t, int64(15<<10), str.Scope().Stat().BytesOutbound)
+
+	// writes blocked on the limit are interrupted when the stream is reset
+	done := make(chan error, 1)
+	go func() {
+		_, err := str.Write(make([]byte, 100<<10))
+		done <- err
+	}()
+	time.Sleep(50 * time.Millisecond)
+	str.Reset()
+	select {
+	case err := <-done:
+		require.ErrorIs(t, err, context.Canceled)
+	case <-time.After(5 * time.Second):
+		t.Fatal("write didn't return")
+	}
+}
+
+func newBandwidthLimitedStream(t *testing.T, limits rcmgr.ResourceLimits) network.Stream {
+	t.Helper()
+	l := rcmgr.PartialLimitConfig{System: limits}
+	rm, err := rcmgr.NewResourceManager(rcmgr.NewFixedLimiter(l.Build(rcmgr.InfiniteLimits)))
+	require.NoError(t, err)
+	t.Cleanup(func() { rm.Close() })
+
+	s1, _ := makeTCPSwarm(t, eventbus.NewBus(), WithResourceManager(rm))
+	s2, _ := makeTCPSwarm(t, eventbus.NewBus())
+	s2.SetStreamHandler(func(s network.Stream) {
+		io.Copy(s, s)
+		s.Close()
+	})
+	s1.Peerstore().AddAddrs(s2.LocalPeer(), s2.ListenAddresses(), peerstore.PermanentAddrTTL)
+
+	str, err := s1.NewStream(context.Background(), s2.LocalPeer())
+	require.NoError(t, err)
+	t.Cleanup(func() { str.Reset() })
+	return str
+}
+
+func TestStreamBandwidthLimitWriteDeadline(t *testing.T) {
+	str := newBandwidthLimitedStream(t, rcmgr.ResourceLimits{BandwidthOutbound: 10 << 10})
+
+	// a write waiting for bandwidth fails when the write deadline expires
+	require.NoError(t, str.SetWriteDeadline(time.Now().Add(100*time.Millisecond)))
+	start := time.Now()
+	_, err := str.Write(make([]byte, 30<<10))
+	require.ErrorIs(t, err, os.ErrDeadlineExceeded)
+	require.Less(t, time.Since(start), time.Second)
+
+	// moving the deadline interrupts the writes in progress
+	require.NoError(t, str.SetWriteDeadline(time.Time{}))
+	done := make(chan error, 1)
+	go func() {
+		_, err := str.Write(make([]byte, 100<<10))
+		done <- err
+	}()
+	time.Sleep(50 * time.Millisecond)
+	require.NoError(t, str.SetDeadline(time.Now()))
+	select {
+	case err := <-done:
+		require.ErrorIs(t, err, os.ErrDeadlineExceeded)
+	case <-time.After(5 * time.Second):
+		t.Fatal("write didn't return")
+	}
+}
+
+func TestStreamBandwidthLimitReadDeadline(t *testing.T) {
+	str := newBandwidthLimitedStream(t, rcmgr.ResourceLimits{BandwidthInbound: 10 << 10})
+
+	// a read waiting for bandwidth returns the bytes it read with the error
+	_, err := str.Write(make([]byte, 30<<10))
+	require.NoError(t, err)
+	require.NoError(t, str.SetReadDeadline(time.Now().Add(100*time.Millisecond)))
+	start := time.Now()
+	var read int
+	for err == nil {
+		var n int
+		n, err = str.Read(make([]byte, 30<<10))
+		read += n
+	}
+	require.ErrorIs(t, err, os.ErrDeadlineExceeded)
+	require.Positive(t, read)
+	require.Less(t, time.Since(start), time.Second)
+}
+
+func TestStreamConnMeter(t *testing.T) {
+	bwc := metrics.NewShardedBandwidthCounter()
+	s1, _ := makeTCPSwarm(t, eventbus.NewBus(), WithMetrics(bwc))
+	s2, _ := makeTCPSwarm(t, eventbus.NewBus())
+	done := make(chan struct{})
+	s2.SetStreamHandler(func(s network.Stream) {
+		defer close(done)
+		io.Copy(io.Discard, s)
+		s.Close()
+	})
+	s1.Peerstore().AddAddrs(s2.LocalPeer(), s2.ListenAddresses(), peerstore.PermanentAddrTTL)
+
+	str, err := s1.NewStream(context.Background(), s2.LocalPeer())
+	require.NoError(t, err)
+	require.NoError(t, str.SetProtocol("/test"))
+	_, err = str.Write(make([]byte, 1000))
+	require.NoError(t, err)
+	require.NoError(t, str.CloseWrite())
+	<-done
+
+	// the meters are only updated once per second
+	require.Eventually(t, func() bool {
+		return bwc.GetBandwidthForConn(str.Conn().ID()).TotalOut == 1000
+	}, 5*time.Second, 50*time.Millisecond)
+	require.Equal(t, int64(1000), bwc.GetBandwidthForPeer(s2.LocalPeer()).TotalOut)
+	require.Equal(t, int64(1000), bwc.GetBandwidthForProtocol("/test").TotalOut)
+	require.Equal(t, int64(1000), bwc.GetBandwidthByTransport()["tcp"].TotalOut)
+
+	// closed connections are forgotten
+	str.Conn().Close()
+	require.Zero(t, bwc.GetBandwidthForConn(str.Conn().ID()))
+}
@@ -28,6 +28,11 @@ func (s *Swarm) TransportForDialing(a ma.Multiaddr) transport.Transport {
 		return nil
 	}
 	if isRelayAddr(a) {
+		// private-to-private WebRTC addresses are relay addresses that are
+		// dialed by the WebRTC transport, using the relay for signaling.
+		if t, ok := s.transports.m[ma.P_WEBRTC]; ok && t.CanDial(a) {
+			return t
+		}
 		return s.transports.m[ma.P_CIRCUIT]
 	}
 	for _, t := range s.transports.m {
@@ -0,0 +1,144 @@
+package autonatv2
+
+import (
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/network"
+
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+// maxConfidence is the number of consecutive agreeing results after which we
+// consider the reachability of an address confirmed and only refresh it
+// periodically.
+const maxConfidence = 3
+
+type addrStatus struct {
+	addr         ma.Multiaddr
+	reachability network.Reachability
+	// confidence reflects how sure we are about the reachability of the
+	// address. A single dial back may fail for reasons unrelated to the
+	// address, so a conflicting result first reduces the confidence before
+	// flipping the reachability.
+	confidence int
+	nextProbe  time.Time
+}
+
+// addrsReachability tracks the reachability of a set of addresses.
+// It is not safe for concurrent use.
+type addrsReachability struct {
+	// addrs preserves the order in which the addresses were added
+	addrs []*addrStatus
+}
+
+func newAddrsReachability() *addrsReachability {
+	return &addrsReachability{}
+}
+
+func (r *addrsReachability) find(a ma.Multiaddr) *addrStatus {
+	for _, s := range r.addrs {
+		if s.addr.Equal(a) {
+			return s
+		}
+	}
+	return nil
+}
+
+// SetAddrs updates the set of tracked addresses. New addresses are scheduled
+// for an immediate probe. It returns true if any address was added or removed.
+func (r *addrsReachability) SetAddrs(addrs []ma.Multiaddr, now time.Time) bool {
+	changed := false
+	res := make([]*addrStatus, 0, len(addrs))
+	for _, a := range addrs {
+		s := r.find(a)
+		if s == nil {
+			s = &addrStatus{addr: a, reachability: network.ReachabilityUnknown, nextProbe: now}
+			changed = true
+		}
+		res = append(res, s)
+	}
+	if len(res) != len(r.addrs) {
+		changed = true
+	}
+	r.addrs = res
+	return changed
+}
+
+// DueForProbe returns the addresses that should be probed at time now.
+// Addresses with unknown reachability are returned first.
+func (r *addrsReachability) DueForProbe(now time.Time) []ma.Multiaddr {
+	var unknown, known []ma.Multiaddr
+	for _, s := range r.addrs {
+		if s.nextProbe.After(now) {
+			continue
+		}
+		if s.reachability == network.ReachabilityUnknown {
+			unknown = append(unknown, s.addr)
+		} else {
+			known = append(known, s.addr)
+		}
+	}
+	return append(unknown, known...)
+}
+
+// NextProbe returns the time when the next address is due for a probe. If no
+// addresses are tracked, it returns now + fallback.
+func (r *addrsReachability) NextProbe(now time.Time, fallback time.Duration) time.Time {
+	next := now.Add(fallback)
+	for _, s := range r.addrs {
+		if s.nextProbe.Before(next) {
+			next = s.nextProbe
+		}
+	}
+	if next.Before(now) {
+		return now
+	}
+	return next
+}
+
+// Record records the result of a probe of address a. It returns true if the
+// reachability of a changed.
+func (r *addrsReachability) Record(a ma.Multiaddr, rch network.Reachability, now time.Time, retryInterval, refreshInterval time.Duration) bool {
+	s := r.find(a)
+	if s == nil {
+		return false
+	}
+
+	changed := false
+	switch {
+	case rch == network.ReachabilityUnknown:
+		// the probe was inconclusive, try again soon
+	case rch == s.reachability:
+		if s.confidence < maxConfidence {
+			s.confidence++
+		}
+	case s.reachability == network.ReachabilityUnknown || s.confidence == 0:
+		s.reachability = rch
+		s.confidence = 0
+		changed = true
+	default:
+		s.confidence--
+	}
+
+	if s.confidence >= maxConfidence {
+		s.nextProbe = now.Add(refreshInterval)
+	} else {
+		s.nextProbe = now.Add(retryInterval)
+	}
+	return changed
+}
+
+// Addrs returns the tracked addresses grouped by reachability.
+func (r *addrsReachability) Addrs() (reachable, unreachable, unknown []ma.Multiaddr) {
+	for _, s := range r.addrs {
+		switch s.reachability {
+		case network.ReachabilityPublic:
+			reachable = append(reachable, s.addr)
+		case network.ReachabilityPrivate:
+			unreachable = append(unreachable, s.addr)
+		default:
+			unknown = append(unknown, s.addr)
+		}
+	}
+	return reachable, unreachable, unknown
+}
@@ -0,0 +1,359 @@
+package autonatv2
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/event"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/p2p/host/eventbus"
+	"github.com/libp2p/go-libp2p/p2p/protocol/autonatv2/pb"
+
+	logging "github.com/ipfs/go-log/v2"
+	ma "github.com/multiformats/go-multiaddr"
+	manet "github.com/multiformats/go-multiaddr/net"
+	"golang.org/x/exp/slices"
+)
+
+//go:generate protoc --go_out=. --go_opt=Mpb/autonatv2.proto=./pb pb/autonatv2.proto
+
+const (
+	ServiceName      = "libp2p.autonatv2"
+	DialBackProtocol = "/libp2p/autonat/2/dial-back"
+	DialProtocol     = "/libp2p/autonat/2/dial-request"
+
+	maxMsgSize            = 8192
+	streamTimeout         = 15 * time.Second
+	dialBackStreamTimeout = 5 * time.Second
+	dialBackDialTimeout   = 10 * time.Second
+	dialBackMaxMsgSize    = 1024
+	minHandshakeSizeBytes = 30_000 // for amplification attack prevention
+	maxHandshakeSizeBytes = 100_000
+	// maxPeerAddresses is the number of addresses in a dial request the server
+	// will inspect, rest are ignored.
+	maxPeerAddresses = 50
+)
+
+var (
+	ErrNoValidPeers = errors.New("no valid peers for autonat v2")
+	ErrDialRefused  = errors.New("dial refused")
+
+	log = logging.Logger("autonatv2")
+)
+
+// Request is the request to verify reachability of a single address
+type Request struct {
+	// Addr is the multiaddr to verify
+	Addr ma.Multiaddr
+	// SendDialData indicates whether to send dial data if the server requests it for Addr
+	SendDialData bool
+}
+
+// Result is the result of the CheckReachability call
+type Result struct {
+	// Addr is the dialed address
+	Addr ma.Multiaddr
+	// Reachability of the dialed address
+	Reachability network.Reachability
+	// Status is the outcome of the dialback
+	Status pb.DialStatus
+}
+
+// AutoNAT implements the AutoNAT v2 client and server.
+// Users can check reachability for their addresses using the GetReachability method.
+// Once started, it also keeps track of the reachability of the host's own
+// addresses and emits event.EvtHostReachableAddrsChanged when it changes.
+// The server provides amplification attack prevention and rate limiting.
+type AutoNAT struct {
+	host host.Host
+	conf *config
+
+	// for cleanly closing
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+
+	srv *server
+	cli *client
+
+	mx    sync.Mutex
+	peers *peersMap
+
+	addrs        *addrsReachability
+	probeTrigger chan struct{}
+	emitter      event.Emitter
+}
+
+// New returns a new AutoNAT instance.
+// host and dialerHost should have the same dialing capabilities. In case the host doesn't support
+// a transport, dial back requests for address for that transport will be ignored.
+func New(h host.Host, dialerHost host.Host, opts ...Option) (*AutoNAT, error) {
+	conf := defaultConfig()
+	for _, o := range opts {
+		if err := o(conf); err != nil {
+			return nil, fmt.Errorf("failed to apply option: %w", err)
+		}
+	}
+	if conf.addrsFunc == nil {
+		conf.addrsFunc = h.Addrs
+	}
+
+	emitter, err := h.EventBus().Emitter(new(event.EvtHostReachableAddrsChanged), eventbus.Stateful)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create emitter: %w", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	an := &AutoNAT{
+		host:         h,
+		conf:         conf,
+		ctx:          ctx,
+		cancel:       cancel,
+		srv:          newServer(h, dialerHost, conf),
+		cli:          newClient(h),
+		peers:        newPeersMap(),
+		addrs:        newAddrsReachability(),
+		probeTrigger: make(chan struct{}, 1),
+		emitter:      emitter,
+	}
+	return an, nil
+}
+
+// Start attaches the stream handlers to the host and starts tracking the
+// reachability of the host's addresses.
+func (an *AutoNAT) Start() error {
+	// Listen on event.EvtPeerProtocolsUpdated, event.EvtPeerConnectednessChanged
+	// event.EvtPeerIdentificationCompleted to maintain our set of autonat supporting peers.
+	sub, err := an.host.EventBus().Subscribe([]interface{}{
+		new(event.EvtPeerProtocolsUpdated),
+		new(event.EvtPeerConnectednessChanged),
+		new(event.EvtPeerIdentificationCompleted),
+		new(event.EvtLocalAddressesUpdated),
+	}, eventbus.Name("autonatv2"))
+	if err != nil {
+		return fmt.Errorf("event subscription failed: %w", err)
+	}
+	an.cli.Start()
+	an.srv.Start()
+
+	an.wg.Add(2)
+	go an.background(sub)
+	go an.probeLoop()
+	return nil
+}
+
+// Close stops the AutoNAT client and server.
+func (an *AutoNAT) Close() {
+	an.cancel()
+	an.wg.Wait()
+	an.srv.Close()
+	an.cli.Close()
+	an.emitter.Close()
+}
+
+func (an *AutoNAT) background(sub event.Subscription) {
+	defer an.wg.Done()
+	defer sub.Close()
+
+	for {
+		select {
+		case <-an.ctx.Done():
+			return
+		case e, ok := <-sub.Out():
+			if !ok {
+				return
+			}
+			switch evt := e.(type) {
+			case event.EvtPeerProtocolsUpdated:
+				an.updatePeer(evt.Peer)
+			case event.EvtPeerConnectednessChanged:
+				an.updatePeer(evt.Peer)
+			case event.EvtPeerIdentificationCompleted:
+				an.updatePeer(evt.Peer)
+			case event.EvtLocalAddressesUpdated:
+				an.triggerProbe()
+			}
+		}
+	}
+}
+
+// GetReachability makes a single dial request for checking reachability for requested addresses
+func (an *AutoNAT) GetReachability(ctx context.Context, reqs []Request) (Result, error) {
+	if !an.conf.allowPrivateAddrs {
+		for _, r := range reqs {
+			if !manet.IsPublicAddr(r.Addr) {
+				return Result{}, fmt.Errorf("private address cannot be verified by autonatv2: %s", r.Addr)
+			}
+		}
+	}
+	an.mx.Lock()
+	p := an.peers.GetRand()
+	an.mx.Unlock()
+	if p == "" {
+		return Result{}, ErrNoValidPeers
+	}
+
+	res, err := an.cli.GetReachability(ctx, p, reqs)
+	if err != nil {
+		log.Debugf("reachability check with %s failed, err: %s", p, err)
+		return Result{}, fmt.Errorf("reachability check with %s failed: %w", p, err)
+	}
+	log.Debugf("reachability check with %s successful", p)
+	return res, nil
+}
+
+func (an *AutoNAT) updatePeer(p peer.ID) {
+	an.mx.Lock()
+	defer an.mx.Unlock()
+
+	// There are no ordering gurantees between identify and swarm events. Check peerstore
+	// and swarm for the current state
+	protos, err := an.host.Peerstore().SupportsProtocols(p, DialProtocol)
+	connectedness := an.host.Network().Connectedness(p)
+	if err == nil && slices.Contains(protos, DialProtocol) && connectedness == network.Connected {
+		if an.peers.Put(p) {
+			// A new server might allow us to verify addresses that we couldn't verify so far.
+			an.triggerProbe()
+		}
+	} else {
+		an.peers.Delete(p)
+	}
+}
+
+func (an *AutoNAT) triggerProbe() {
+	select {
+	case an.probeTrigger <- struct{}{}:
+	default:
+	}
+}
+
+// probeLoop periodically verifies the reachability of the host's addresses
+// and emits event.EvtHostReachableAddrsChanged when it changes.
+func (an *AutoNAT) probeLoop() {
+	defer an.wg.Done()
+
+	timer := time.NewTimer(an.conf.bootDelay)
+	defer timer.Stop()
+	// don't probe on triggers before the boot delay has passed
+	booted := false
+	for {
+		select {
+		case <-an.ctx.Done():
+			return
+		case <-timer.C:
+			booted = true
+		case <-an.probeTrigger:
+			if !booted {
+				continue
+			}
+			if !timer.Stop() {
+				select {
+				case <-timer.C:
+				default:
+				}
+			}
+		}
+
+		an.probeAddrs()
+		now := an.conf.now()
+		timer.Reset(an.addrs.NextProbe(now, an.conf.retryInterval).Sub(now))
+	}
+}
+
+// probeAddrs verifies the reachability of all addresses that are due for a probe.
+func (an *AutoNAT) probeAddrs() {
+	changed := an.addrs.SetAddrs(an.trackedAddrs(), an.conf.now())
+	for _, a := range an.addrs.DueForProbe(an.conf.now()) {
+		ctx, cancel := context.WithTimeout(an.ctx, streamTimeout)
+		res, err := an.GetReachability(ctx, []Request{{Addr: a, SendDialData: true}})
+		cancel()
+		if an.ctx.Err() != nil {
+			return
+		}
+		reachability := network.ReachabilityUnknown
+		if err == nil {
+			reachability = res.Reachability
+		} else if errors.Is(err, ErrNoValidPeers) {
+			// No point in trying the remaining addresses. We'll be triggered
+			// again once we find a new server.
+			break
+		}
+		if an.addrs.Record(a, reachability, an.conf.now(), an.conf.retryInterval, an.conf.refreshInterval) {
+			changed = true
+		}
+	}
+	if changed {
+		reachable, unreachable, unknown := an.addrs.Addrs()
+		if err := an.emitter.Emit(event.EvtHostReachableAddrsChanged{
+			Reachable:   reachable,
+			Unreachable: unreachable,
+			Unknown:     unknown,
+		}); err != nil {
+			log.Debugf("failed to emit reachable addrs event: %s", err)
+		}
+	}
+}
+
+// trackedAddrs returns the host's addresses that AutoNAT v2 can verify.
+func (an *AutoNAT) trackedAddrs() []ma.Multiaddr {
+	addrs := an.conf.addrsFunc()
+	res := make([]ma.Multiaddr, 0, len(addrs))
+	for _, a := range addrs {
+		if _, err := a.ValueForProtocol(ma.P_CIRCUIT); err == nil {
+			continue
+		}
+		if !an.conf.allowPrivateAddrs && !manet.IsPublicAddr(a) {
+			continue
+		}
+		res = append(res, a)
+	}
+	return res
+}
+
+// peersMap provides random access to a set of peers. This is useful when the map iteration order is
+// not sufficiently random.
+type peersMap struct {
+	peerIdx map[peer.ID]int
+	peers   []peer.ID
+}
+
+func newPeersMap() *peersMap {
+	return &peersMap{
+		peerIdx: make(map[peer.ID]int),
+		peers:   make([]peer.ID, 0),
+	}
+}
+
+func (p *peersMap) GetRand() peer.ID {
+	if len(p.peers) == 0 {
+		return ""
+	}
+	return p.peers[rand.Intn(len(p.peers))]
+}
+
+// Put adds pid to the set. It returns true if pid wasn't in the set before.
+func (p *peersMap) Put(pid peer.ID) bool {
+	if _, ok := p.peerIdx[pid]; ok {
+		return false
+	}
+	p.peers = append(p.peers, pid)
+	p.peerIdx[pid] = len(p.peers) - 1
+	return true
+}
+
+func (p *peersMap) Delete(pid peer.ID) {
+	idx, ok := p.peerIdx[pid]
+	if !ok {
+		return
+	}
+	p.peers[idx] = p.peers[len(p.peers)-1]
+	p.peerIdx[p.peers[idx]] = idx
+	p.peers = p.peers[:len(p.peers)-1]
+	delete(p.peerIdx, pid)
+}
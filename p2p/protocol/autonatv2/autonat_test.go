@@ -0,0 +1,326 @@
+package autonatv2
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/event"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	bhost "github.com/libp2p/go-libp2p/p2p/host/blank"
+	swarmt "github.com/libp2p/go-libp2p/p2p/net/swarm/testing"
+	"github.com/libp2p/go-libp2p/p2p/protocol/autonatv2/pb"
+
+	"github.com/libp2p/go-msgio/pbio"
+	ma "github.com/multiformats/go-multiaddr"
+	"github.com/stretchr/testify/require"
+)
+
+func newAutoNAT(t *testing.T, dialer host.Host, opts ...Option) *AutoNAT {
+	t.Helper()
+	h := bhost.NewBlankHost(swarmt.GenSwarm(t, swarmt.OptDisableQUIC))
+	if dialer == nil {
+		dialer = bhost.NewBlankHost(swarmt.GenSwarm(t, swarmt.OptDisableQUIC, swarmt.OptDialOnly))
+	}
+	opts = append([]Option{allowPrivateAddrs, withAmplificationAttackPreventionDialWait(0)}, opts...)
+	an, err := New(h, dialer, opts...)
+	require.NoError(t, err)
+	require.NoError(t, an.Start())
+	t.Cleanup(func() {
+		an.Close()
+		an.host.Close()
+	})
+	return an
+}
+
+// connect connects cli to srv and registers srv as an AutoNAT v2 server.
+// Blank hosts don't run identify, so we do this manually.
+func connect(t *testing.T, cli, srv *AutoNAT) {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	require.NoError(t, cli.host.Connect(ctx, peer.AddrInfo{ID: srv.host.ID(), Addrs: srv.host.Addrs()}))
+	require.NoError(t, cli.host.Peerstore().AddProtocols(srv.host.ID(), DialProtocol))
+	cli.updatePeer(srv.host.ID())
+}
+
+func TestAutoNATPrivateAddr(t *testing.T) {
+	h := bhost.NewBlankHost(swarmt.GenSwarm(t, swarmt.OptDisableQUIC))
+	an, err := New(h, bhost.NewBlankHost(swarmt.GenSwarm(t, swarmt.OptDialOnly)))
+	require.NoError(t, err)
+	defer an.Close()
+	defer h.Close()
+
+	res, err := an.GetReachability(context.Background(), []Request{{Addr: ma.StringCast("/ip4/192.168.0.1/udp/10/quic-v1")}})
+	require.Equal(t, res, Result{})
+	require.Contains(t, err.Error(), "private address cannot be verified by autonatv2")
+}
+
+func TestClientRequest(t *testing.T) {
+	an := newAutoNAT(t, nil)
+	_, err := an.GetReachability(context.Background(), []Request{{Addr: ma.StringCast("/ip4/1.2.3.4/tcp/1")}})
+	require.ErrorIs(t, err, ErrNoValidPeers)
+}
+
+func TestClientServerDialBack(t *testing.T) {
+	srv := newAutoNAT(t, nil)
+	cli := newAutoNAT(t, nil)
+	connect(t, cli, srv)
+
+	addr := cli.host.Addrs()[0]
+	res, err := cli.GetReachability(context.Background(), []Request{{Addr: addr}})
+	require.NoError(t, err)
+	require.Equal(t, Result{
+		Addr:         addr,
+		Reachability: network.ReachabilityPublic,
+		Status:       pb.DialStatus_OK,
+	}, res)
+}
+
+func TestClientServerDialError(t *testing.T) {
+	srv := newAutoNAT(t, nil)
+	cli := newAutoNAT(t, nil)
+	connect(t, cli, srv)
+
+	// nobody is listening on this port
+	addr := ma.StringCast("/ip4/127.0.0.1/tcp/1")
+	res, err := cli.GetReachability(context.Background(), []Request{{Addr: addr}})
+	require.NoError(t, err)
+	require.Equal(t, Result{
+		Addr:         addr,
+		Reachability: network.ReachabilityPrivate,
+		Status:       pb.DialStatus_E_DIAL_ERROR,
+	}, res)
+}
+
+func TestClientServerDialRefused(t *testing.T) {
+	srv := newAutoNAT(t, nil)
+	cli := newAutoNAT(t, nil)
+	connect(t, cli, srv)
+
+	// the server's dialer doesn't support QUIC
+	_, err := cli.GetReachability(context.Background(), []Request{{Addr: ma.StringCast("/ip4/127.0.0.1/udp/1/quic-v1")}})
+	require.ErrorIs(t, err, ErrDialRefused)
+}
+
+func TestClientServerSkipsUndialableAddrs(t *testing.T) {
+	srv := newAutoNAT(t, nil)
+	cli := newAutoNAT(t, nil)
+	connect(t, cli, srv)
+
+	addr := cli.host.Addrs()[0]
+	res, err := cli.GetReachability(context.Background(), []Request{
+		{Addr: ma.StringCast("/ip4/127.0.0.1/udp/1/quic-v1")},
+		{Addr: addr},
+	})
+	require.NoError(t, err)
+	require.Equal(t, addr, res.Addr)
+	require.Equal(t, network.ReachabilityPublic, res.Reachability)
+}
+
+func TestClientServerDialData(t *testing.T) {
+	srv := newAutoNAT(t, nil, withDataRequestPolicy(func(network.Stream, ma.Multiaddr) bool { return true }))
+	cli := newAutoNAT(t, nil)
+	connect(t, cli, srv)
+
+	addr := cli.host.Addrs()[0]
+
+	t.Run("send dial data", func(t *testing.T) {
+		res, err := cli.GetReachability(context.Background(), []Request{{Addr: addr, SendDialData: true}})
+		require.NoError(t, err)
+		require.Equal(t, network.ReachabilityPublic, res.Reachability)
+	})
+
+	t.Run("refuse dial data", func(t *testing.T) {
+		_, err := cli.GetReachability(context.Background(), []Request{{Addr: addr}})
+		require.ErrorContains(t, err, "invalid dial data request")
+	})
+}
+
+func TestClientDialBackInvalidNonce(t *testing.T) {
+	cli := newAutoNAT(t, nil)
+	dialer := bhost.NewBlankHost(swarmt.GenSwarm(t, swarmt.OptDisableQUIC, swarmt.OptDialOnly))
+	defer dialer.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	require.NoError(t, dialer.Connect(ctx, peer.AddrInfo{ID: cli.host.ID(), Addrs: cli.host.Addrs()}))
+	s, err := dialer.NewStream(ctx, cli.host.ID(), DialBackProtocol)
+	require.NoError(t, err)
+	defer s.Close()
+
+	require.NoError(t, pbio.NewDelimitedWriter(s).WriteMsg(&pb.DialBack{Nonce: 42}))
+
+	// the client resets the stream as it didn't request a dial back with this nonce
+	s.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, err = s.Read(make([]byte, 1))
+	require.ErrorIs(t, err, network.ErrReset)
+}
+
+func TestAreAddrsConsistent(t *testing.T) {
+	c := newClient(nil)
+	for _, tc := range []struct {
+		name       string
+		localAddr  ma.Multiaddr
+		dialAddr   ma.Multiaddr
+		consistent bool
+	}{
+		{
+			name:       "simple match",
+			localAddr:  ma.StringCast("/ip4/192.168.0.1/tcp/12345"),
+			dialAddr:   ma.StringCast("/ip4/1.1.1.1/tcp/23232"),
+			consistent: true,
+		},
+		{
+			name:       "nat64",
+			localAddr:  ma.StringCast("/ip6/1::1/tcp/12345"),
+			dialAddr:   ma.StringCast("/ip4/1.1.1.1/tcp/23232"),
+			consistent: false,
+		},
+		{
+			name:       "simple mismatch",
+			localAddr:  ma.StringCast("/ip4/192.168.0.1/tcp/12345"),
+			dialAddr:   ma.StringCast("/ip4/1.1.1.1/udp/23232/quic-v1"),
+			consistent: false,
+		},
+		{
+			name:       "quic vs webtransport",
+			localAddr:  ma.StringCast("/ip4/192.168.0.1/udp/12345/quic-v1"),
+			dialAddr:   ma.StringCast("/ip4/1.1.1.1/udp/123/quic-v1/webtransport"),
+			consistent: false,
+		},
+		{
+			name:       "dns",
+			localAddr:  ma.StringCast("/ip6/1::1/tcp/12345"),
+			dialAddr:   ma.StringCast("/dns/lib.p2p/tcp/12345"),
+			consistent: true,
+		},
+		{
+			name:       "dns4 with ip6",
+			localAddr:  ma.StringCast("/ip6/1::1/tcp/12345"),
+			dialAddr:   ma.StringCast("/dns4/lib.p2p/tcp/12345"),
+			consistent: false,
+		},
+		{
+			name:       "no dial back",
+			localAddr:  nil,
+			dialAddr:   ma.StringCast("/ip4/1.1.1.1/tcp/23232"),
+			consistent: false,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			require.Equal(t, tc.consistent, c.areAddrsConsistent(tc.localAddr, tc.dialAddr))
+		})
+	}
+}
+
+func TestPeersMap(t *testing.T) {
+	pm := newPeersMap()
+	require.Equal(t, peer.ID(""), pm.GetRand())
+
+	require.True(t, pm.Put("a"))
+	require.False(t, pm.Put("a"))
+	require.True(t, pm.Put("b"))
+	for i := 0; i < 10; i++ {
+		require.Contains(t, []peer.ID{"a", "b"}, pm.GetRand())
+	}
+
+	pm.Delete("a")
+	for i := 0; i < 10; i++ {
+		require.Equal(t, peer.ID("b"), pm.GetRand())
+	}
+	pm.Delete("b")
+	require.Equal(t, peer.ID(""), pm.GetRand())
+}
+
+func TestAddrsReachability(t *testing.T) {
+	a1 := ma.StringCast("/ip4/1.1.1.1/tcp/1")
+	a2 := ma.StringCast("/ip4/1.1.1.1/udp/1/quic-v1")
+	now := time.Now()
+	retry, refresh := time.Minute, time.Hour
+
+	r := newAddrsReachability()
+	require.True(t, r.SetAddrs([]ma.Multiaddr{a1, a2}, now))
+	require.False(t, r.SetAddrs([]ma.Multiaddr{a1, a2}, now))
+	require.Equal(t, []ma.Multiaddr{a1, a2}, r.DueForProbe(now))
+
+	// the first conclusive result flips the reachability
+	require.True(t, r.Record(a1, network.ReachabilityPublic, now, retry, refresh))
+	require.False(t, r.Record(a2, network.ReachabilityUnknown, now, retry, refresh))
+	reachable, unreachable, unknown := r.Addrs()
+	require.Equal(t, []ma.Multiaddr{a1}, reachable)
+	require.Empty(t, unreachable)
+	require.Equal(t, []ma.Multiaddr{a2}, unknown)
+	require.Empty(t, r.DueForProbe(now))
+	require.Equal(t, now.Add(retry), r.NextProbe(now, refresh))
+
+	// build up confidence
+	for i := 0; i < maxConfidence; i++ {
+		require.False(t, r.Record(a1, network.ReachabilityPublic, now, retry, refresh))
+	}
+	// a confident result is only refreshed after refresh interval
+	now = now.Add(retry)
+	require.Equal(t, []ma.Multiaddr{a2}, r.DueForProbe(now))
+
+	// conflicting results reduce confidence before flipping
+	for i := 0; i < maxConfidence; i++ {
+		require.False(t, r.Record(a1, network.ReachabilityPrivate, now, retry, refresh))
+	}
+	require.True(t, r.Record(a1, network.ReachabilityPrivate, now, retry, refresh))
+	_, unreachable, _ = r.Addrs()
+	require.Equal(t, []ma.Multiaddr{a1}, unreachable)
+
+	// removing an address is a change
+	require.True(t, r.SetAddrs([]ma.Multiaddr{a1}, now))
+	_, _, unknown = r.Addrs()
+	require.Empty(t, unknown)
+}
+
+func TestReachableAddrsEvent(t *testing.T) {
+	srv := newAutoNAT(t, nil)
+
+	h := bhost.NewBlankHost(swarmt.GenSwarm(t, swarmt.OptDisableQUIC))
+	defer h.Close()
+	unreachableAddr := ma.StringCast("/ip4/127.0.0.1/tcp/1")
+	addrs := append(h.Addrs(), unreachableAddr)
+	cli, err := New(h, bhost.NewBlankHost(swarmt.GenSwarm(t, swarmt.OptDialOnly)),
+		allowPrivateAddrs,
+		WithoutStartupDelay(),
+		WithSchedule(100*time.Millisecond, time.Hour),
+		UsingAddresses(func() []ma.Multiaddr { return addrs }),
+	)
+	require.NoError(t, err)
+	defer cli.Close()
+
+	sub, err := h.EventBus().Subscribe(new(event.EvtHostReachableAddrsChanged))
+	require.NoError(t, err)
+	defer sub.Close()
+
+	require.NoError(t, cli.Start())
+	connect(t, cli, srv)
+
+	timeout := time.After(10 * time.Second)
+	for {
+		select {
+		case e := <-sub.Out():
+			evt := e.(event.EvtHostReachableAddrsChanged)
+			if len(evt.Unknown) > 0 {
+				continue
+			}
+			require.ElementsMatch(t, h.Addrs(), evt.Reachable)
+			require.Equal(t, []ma.Multiaddr{unreachableAddr}, evt.Unreachable)
+			return
+		case <-timeout:
+			t.Fatal("expected reachable addrs event")
+		}
+	}
+}
+
+func TestScheduleOption(t *testing.T) {
+	for _, intervals := range [][2]time.Duration{{0, time.Hour}, {time.Minute, 0}, {-time.Minute, time.Hour}} {
+		require.Error(t, WithSchedule(intervals[0], intervals[1])(defaultConfig()), intervals)
+	}
+	require.NoError(t, WithSchedule(time.Minute, time.Hour)(defaultConfig()))
+}
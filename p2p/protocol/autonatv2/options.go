@@ -0,0 +1,123 @@
+package autonatv2
+
+import (
+	"errors"
+	"time"
+
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+// config holds configurable options for the AutoNAT v2 client and server.
+type config struct {
+	// server
+	serverRPM         int
+	serverPerPeerRPM  int
+	serverDialDataRPM int
+	dataRequestPolicy dataRequestPolicyFunc
+	// amplificationAttackPreventionDialWait is the maximum random delay before
+	// dialing back a peer that had to send dial data.
+	amplificationAttackPreventionDialWait time.Duration
+
+	// client
+	addrsFunc       func() []ma.Multiaddr
+	bootDelay       time.Duration
+	retryInterval   time.Duration
+	refreshInterval time.Duration
+
+	// allowPrivateAddrs enables using private and localhost addresses for
+	// reachability checks. This is only useful for testing.
+	allowPrivateAddrs bool
+
+	now func() time.Time
+}
+
+func defaultConfig() *config {
+	return &config{
+		serverRPM:                             60,
+		serverPerPeerRPM:                      12,
+		serverDialDataRPM:                     12,
+		dataRequestPolicy:                     amplificationAttackPrevention,
+		amplificationAttackPreventionDialWait: 3 * time.Second,
+
+		bootDelay:       15 * time.Second,
+		retryInterval:   90 * time.Second,
+		refreshInterval: 15 * time.Minute,
+
+		now: time.Now,
+	}
+}
+
+// Option configures AutoNAT v2.
+type Option func(*config) error
+
+// WithServerRateLimit sets the rate limits of the AutoNAT v2 server. rpm is the
+// global limit of requests per minute, perPeerRPM the limit of requests per
+// minute from a single peer, and dialDataRPM the limit of requests per minute
+// that require the client to send dial data.
+func WithServerRateLimit(rpm, perPeerRPM, dialDataRPM int) Option {
+	return func(c *config) error {
+		if rpm <= 0 || perPeerRPM <= 0 || dialDataRPM <= 0 {
+			return errors.New("rate limits must be positive")
+		}
+		c.serverRPM = rpm
+		c.serverPerPeerRPM = perPeerRPM
+		c.serverDialDataRPM = dialDataRPM
+		return nil
+	}
+}
+
+// UsingAddresses overrides the addresses whose reachability is tracked and
+// reported via event.EvtHostReachableAddrsChanged. By default, the host's
+// advertised addresses are used.
+func UsingAddresses(addrsFunc func() []ma.Multiaddr) Option {
+	return func(c *config) error {
+		if addrsFunc == nil {
+			return errors.New("invalid address function supplied")
+		}
+		c.addrsFunc = addrsFunc
+		return nil
+	}
+}
+
+// WithSchedule configures how often the reachability of the host's addresses
+// is checked. retryInterval is used for addresses whose reachability isn't
+// known with confidence yet, while refreshInterval is used to re-confirm the
+// reachability of addresses in a steady state. Both intervals must be positive.
+func WithSchedule(retryInterval, refreshInterval time.Duration) Option {
+	return func(c *config) error {
+		if retryInterval <= 0 || refreshInterval <= 0 {
+			return errors.New("schedule intervals must be positive")
+		}
+		c.retryInterval = retryInterval
+		c.refreshInterval = refreshInterval
+		return nil
+	}
+}
+
+// WithoutStartupDelay removes the initial delay before the reachability of the
+// host's addresses is checked for the first time.
+func WithoutStartupDelay() Option {
+	return func(c *config) error {
+		c.bootDelay = 0
+		return nil
+	}
+}
+
+func withDataRequestPolicy(drp dataRequestPolicyFunc) Option {
+	return func(c *config) error {
+		c.dataRequestPolicy = drp
+		return nil
+	}
+}
+
+func withAmplificationAttackPreventionDialWait(d time.Duration) Option {
+	return func(c *config) error {
+		c.amplificationAttackPreventionDialWait = d
+		return nil
+	}
+}
+
+func allowPrivateAddrs(c *config) error {
+	c.allowPrivateAddrs = true
+	return nil
+}
@@ -0,0 +1,181 @@
+package autonatv2
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/p2p/protocol/autonatv2/pb"
+
+	"github.com/libp2p/go-msgio/pbio"
+	ma "github.com/multiformats/go-multiaddr"
+	"github.com/stretchr/testify/require"
+)
+
+func TestServerRateLimit(t *testing.T) {
+	srv := newAutoNAT(t, nil, WithServerRateLimit(10, 1, 1))
+	cli := newAutoNAT(t, nil)
+	connect(t, cli, srv)
+
+	addr := cli.host.Addrs()[0]
+	_, err := cli.GetReachability(context.Background(), []Request{{Addr: addr}})
+	require.NoError(t, err)
+
+	_, err = cli.GetReachability(context.Background(), []Request{{Addr: addr}})
+	require.ErrorContains(t, err, "E_REQUEST_REJECTED")
+}
+
+func TestServerDialDataRateLimit(t *testing.T) {
+	srv := newAutoNAT(t, nil,
+		WithServerRateLimit(10, 10, 1),
+		withDataRequestPolicy(func(network.Stream, ma.Multiaddr) bool { return true }))
+	cli := newAutoNAT(t, nil)
+	connect(t, cli, srv)
+
+	addr := cli.host.Addrs()[0]
+	_, err := cli.GetReachability(context.Background(), []Request{{Addr: addr, SendDialData: true}})
+	require.NoError(t, err)
+
+	_, err = cli.GetReachability(context.Background(), []Request{{Addr: addr, SendDialData: true}})
+	require.ErrorContains(t, err, "E_REQUEST_REJECTED")
+}
+
+func TestServerInvalidMsgType(t *testing.T) {
+	srv := newAutoNAT(t, nil)
+	cli := newAutoNAT(t, nil)
+	connect(t, cli, srv)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	s, err := cli.host.NewStream(ctx, srv.host.ID(), DialProtocol)
+	require.NoError(t, err)
+	defer s.Close()
+
+	w := pbio.NewDelimitedWriter(s)
+	require.NoError(t, w.WriteMsg(&pb.Message{
+		Msg: &pb.Message_DialDataResponse{DialDataResponse: &pb.DialDataResponse{Data: []byte("hello")}},
+	}))
+	s.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, err = s.Read(make([]byte, 1))
+	require.ErrorIs(t, err, network.ErrReset)
+}
+
+func TestServerTooLittleDialData(t *testing.T) {
+	srv := newAutoNAT(t, nil, withDataRequestPolicy(func(network.Stream, ma.Multiaddr) bool { return true }))
+	cli := newAutoNAT(t, nil)
+	connect(t, cli, srv)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	s, err := cli.host.NewStream(ctx, srv.host.ID(), DialProtocol)
+	require.NoError(t, err)
+	defer s.Close()
+
+	msg := newDialRequest([]Request{{Addr: cli.host.Addrs()[0]}}, 1)
+	w := pbio.NewDelimitedWriter(s)
+	r := pbio.NewDelimitedReader(s, maxMsgSize)
+	require.NoError(t, w.WriteMsg(&msg))
+	require.NoError(t, r.ReadMsg(&msg))
+	require.NotNil(t, msg.GetDialDataRequest())
+
+	// send tiny chunks
+	require.NoError(t, sendDialData(make([]byte, 10), 100, w, &msg))
+	s.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, err = s.Read(make([]byte, 1))
+	require.ErrorIs(t, err, network.ErrReset)
+}
+
+func TestRateLimiter(t *testing.T) {
+	now := time.Now()
+	r := &rateLimiter{RPM: 3, PerPeerRPM: 2, DialDataRPM: 1, now: func() time.Time { return now }}
+
+	require.True(t, r.Accept("peer1"))
+	// only one concurrent request per peer
+	require.False(t, r.Accept("peer1"))
+	r.CompleteRequest("peer1")
+	require.True(t, r.Accept("peer1"))
+	r.CompleteRequest("peer1")
+	// per peer limit
+	require.False(t, r.Accept("peer1"))
+
+	require.True(t, r.Accept("peer2"))
+	r.CompleteRequest("peer2")
+	// global limit
+	require.False(t, r.Accept("peer3"))
+
+	require.True(t, r.AcceptDialDataRequest())
+	require.False(t, r.AcceptDialDataRequest())
+
+	now = now.Add(time.Minute)
+	require.True(t, r.Accept("peer3"))
+	require.True(t, r.Accept("peer1"))
+	require.True(t, r.AcceptDialDataRequest())
+	require.Len(t, r.peerReqs, 2)
+}
+
+func TestRateLimiterCleanup(t *testing.T) {
+	now := time.Now()
+	r := &rateLimiter{RPM: 100, PerPeerRPM: 100, DialDataRPM: 100, now: func() time.Time { return now }}
+	for i := 0; i < 50; i++ {
+		p := peer.ID(fmt.Sprintf("peer-%d", i))
+		require.True(t, r.Accept(p))
+		r.CompleteRequest(p)
+		require.True(t, r.AcceptDialDataRequest())
+		now = now.Add(time.Second)
+	}
+	now = now.Add(time.Minute)
+	r.cleanup(now)
+	require.Empty(t, r.reqs)
+	require.Empty(t, r.peerReqs)
+	require.Empty(t, r.dialDataReqs)
+}
+
+func TestAmplificationAttackPrevention(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		remote   ma.Multiaddr
+		dialAddr ma.Multiaddr
+		required bool
+	}{
+		{
+			name:     "same ip",
+			remote:   ma.StringCast("/ip4/1.2.3.4/tcp/1"),
+			dialAddr: ma.StringCast("/ip4/1.2.3.4/udp/2/quic-v1"),
+			required: false,
+		},
+		{
+			name:     "different ip",
+			remote:   ma.StringCast("/ip4/1.2.3.4/tcp/1"),
+			dialAddr: ma.StringCast("/ip4/1.2.3.5/tcp/1"),
+			required: true,
+		},
+		{
+			name:     "dns",
+			remote:   ma.StringCast("/ip4/1.2.3.4/tcp/1"),
+			dialAddr: ma.StringCast("/dns4/example.com/tcp/1"),
+			required: true,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			s := &mockStream{conn: &mockConn{remote: tc.remote}}
+			require.Equal(t, tc.required, amplificationAttackPrevention(s, tc.dialAddr))
+		})
+	}
+}
+
+type mockConn struct {
+	network.Conn
+	remote ma.Multiaddr
+}
+
+func (c *mockConn) RemoteMultiaddr() ma.Multiaddr { return c.remote }
+
+type mockStream struct {
+	network.Stream
+	conn network.Conn
+}
+
+func (s *mockStream) Conn() network.Conn { return s.conn }
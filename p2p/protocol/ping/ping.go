@@ -149,6 +149,11 @@ func Ping(ctx context.Context, h host.Host, p peer.ID) <-chan Result {
 			// No error, record the RTT.
 			if res.Error == nil {
 				h.Peerstore().RecordLatency(p, res.RTT)
+				// The swarm uses per connection RTTs to balance streams over
+				// parallel connections.
+				if c, ok := s.Conn().(interface{ RecordLatency(time.Duration) }); ok {
+					c.RecordLatency(res.RTT)
+				}
 			}
 
 			select {
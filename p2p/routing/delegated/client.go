@@ -0,0 +1,473 @@
+// Package delegated implements a client for the delegated routing HTTP API, see
+// https://specs.ipfs.tech/routing/http-routing-v1/.
+//
+// The client implements routing.Routing, so it can be used with
+// libp2p.Routing and routed.Wrap by hosts that don't want to run a DHT.
+package delegated
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/routing"
+
+	lru "github.com/hashicorp/golang-lru/v2"
+	"github.com/ipfs/go-cid"
+	logging "github.com/ipfs/go-log/v2"
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+var log = logging.Logger("routing/delegated")
+
+const (
+	mediaTypeJSON       = "application/json"
+	mediaTypeNDJSON     = "application/x-ndjson"
+	mediaTypeIPNSRecord = "application/vnd.ipfs.ipns-record"
+
+	// maxIPNSRecordSize is the maximum size of an IPNS record, as defined by
+	// the IPNS spec.
+	maxIPNSRecordSize = 10 << 10
+	// maxResponseSize bounds non-streaming JSON responses.
+	maxResponseSize = 4 << 20
+
+	ipnsPrefix = "/ipns/"
+)
+
+const (
+	DefaultCacheSize = 1024
+	DefaultCacheTTL  = 5 * time.Minute
+)
+
+type cacheEntry struct {
+	addrs  []peer.AddrInfo
+	value  []byte
+	expiry time.Time
+}
+
+// Client is a delegated routing HTTP API client.
+//
+// Provide isn't supported, since the API doesn't allow announcing providers.
+// IPNS records returned by GetValue aren't validated, callers must validate
+// them, as they would for values returned by any other router.
+type Client struct {
+	baseURL    string
+	httpClient *http.Client
+	userAgent  string
+
+	cache    *lru.Cache[string, cacheEntry]
+	cacheTTL time.Duration
+
+	// for tests
+	now func() time.Time
+}
+
+var _ routing.Routing = &Client{}
+
+// New creates a client for the delegated routing server at baseURL, e.g.
+// https://delegated-ipfs.dev.
+func New(baseURL string, opts ...Option) (*Client, error) {
+	u, err := url.Parse(baseURL)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("unsupported scheme: %s", u.Scheme)
+	}
+
+	cfg := &config{
+		httpClient: http.DefaultClient,
+		cacheSize:  DefaultCacheSize,
+		cacheTTL:   DefaultCacheTTL,
+	}
+	for _, o := range opts {
+		if err := o(cfg); err != nil {
+			return nil, err
+		}
+	}
+
+	c := &Client{
+		baseURL:    strings.TrimSuffix(u.String(), "/"),
+		httpClient: cfg.httpClient,
+		userAgent:  cfg.userAgent,
+		cacheTTL:   cfg.cacheTTL,
+		now:        time.Now,
+	}
+	if cfg.cacheSize > 0 {
+		c.cache, err = lru.New[string, cacheEntry](cfg.cacheSize)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+func (c *Client) cached(path string) (cacheEntry, bool) {
+	if c.cache == nil {
+		return cacheEntry{}, false
+	}
+	e, ok := c.cache.Get(path)
+	if !ok {
+		return cacheEntry{}, false
+	}
+	if !c.now().Before(e.expiry) {
+		c.cache.Remove(path)
+		return cacheEntry{}, false
+	}
+	return e, true
+}
+
+func (c *Client) addToCache(path string, e cacheEntry) {
+	if c.cache == nil {
+		return
+	}
+	e.expiry = c.now().Add(c.cacheTTL)
+	c.cache.Add(path, e)
+}
+
+func (c *Client) newRequest(ctx context.Context, method, path, accept string, body io.Reader) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, method, c.baseURL+path, body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", accept)
+	if c.userAgent != "" {
+		req.Header.Set("User-Agent", c.userAgent)
+	}
+	return req, nil
+}
+
+// do sends the request, and returns the response if the status is 200.
+// routing.ErrNotFound is returned for 404.
+func (c *Client) do(ctx context.Context, req *http.Request) (*http.Response, error) {
+	routing.PublishQueryEvent(ctx, &routing.QueryEvent{
+		Type:  routing.SendingQuery,
+		Extra: req.Method + " " + req.URL.String(),
+	})
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		routing.PublishQueryEvent(ctx, &routing.QueryEvent{Type: routing.QueryError, Extra: err.Error()})
+		return nil, err
+	}
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return resp, nil
+	case http.StatusNotFound:
+		resp.Body.Close()
+		return nil, routing.ErrNotFound
+	default:
+		b, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		resp.Body.Close()
+		err := fmt.Errorf("delegated routing request failed: %s: %s", resp.Status, bytes.TrimSpace(b))
+		routing.PublishQueryEvent(ctx, &routing.QueryEvent{Type: routing.QueryError, Extra: err.Error()})
+		return nil, err
+	}
+}
+
+// record is a record of the peer schema. Records of unknown schemas are
+// ignored.
+type record struct {
+	Schema    string
+	ID        string
+	Addrs     []string
+	Protocols []string
+}
+
+func (r *record) addrInfo() (peer.AddrInfo, bool) {
+	if r.Schema != "peer" {
+		return peer.AddrInfo{}, false
+	}
+	id, err := peer.Decode(r.ID)
+	if err != nil {
+		log.Debugw("invalid peer ID in record", "id", r.ID, "error", err)
+		return peer.AddrInfo{}, false
+	}
+	ai := peer.AddrInfo{ID: id, Addrs: make([]ma.Multiaddr, 0, len(r.Addrs))}
+	for _, s := range r.Addrs {
+		a, err := ma.NewMultiaddr(s)
+		if err != nil {
+			log.Debugw("invalid address in record", "addr", s, "error", err)
+			continue
+		}
+		ai.Addrs = append(ai.Addrs, a)
+	}
+	return ai, true
+}
+
+// getRecords requests the peer records at path, and calls f for every record
+// as soon as it's received. Responses are streamed if the server supports
+// NDJSON.
+func (c *Client) getRecords(ctx context.Context, path, field string, f func(peer.AddrInfo) bool) error {
+	req, err := c.newRequest(ctx, http.MethodGet, path, mediaTypeNDJSON+", "+mediaTypeJSON, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := c.do(ctx, req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	emit := func(r *record) bool {
+		ai, ok := r.addrInfo()
+		if !ok {
+			return true
+		}
+		return f(ai)
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	switch mediaType {
+	case mediaTypeNDJSON:
+		s := bufio.NewScanner(resp.Body)
+		s.Buffer(make([]byte, 0, 4096), maxResponseSize)
+		for s.Scan() {
+			if len(bytes.TrimSpace(s.Bytes())) == 0 {
+				continue
+			}
+			var r record
+			if err := json.Unmarshal(s.Bytes(), &r); err != nil {
+				return fmt.Errorf("invalid record: %w", err)
+			}
+			if !emit(&r) {
+				return nil
+			}
+		}
+		return s.Err()
+	case mediaTypeJSON, "":
+		var body struct {
+			Providers []record
+			Peers     []record
+		}
+		if err := json.NewDecoder(io.LimitReader(resp.Body, maxResponseSize)).Decode(&body); err != nil {
+			return fmt.Errorf("invalid response: %w", err)
+		}
+		records := body.Providers
+		if field == "Peers" {
+			records = body.Peers
+		}
+		for i := range records {
+			if !emit(&records[i]) {
+				return nil
+			}
+		}
+		return nil
+	default:
+		return fmt.Errorf("unexpected content type: %s", mediaType)
+	}
+}
+
+// Provide isn't supported by the delegated routing HTTP API.
+func (c *Client) Provide(context.Context, cid.Cid, bool) error {
+	return routing.ErrNotSupported
+}
+
+// FindProvidersAsync returns the providers of the CID. If count is 0, all
+// providers returned by the server are returned.
+func (c *Client) FindProvidersAsync(ctx context.Context, key cid.Cid, count int) <-chan peer.AddrInfo {
+	path := "/routing/v1/providers/" + key.String()
+	if e, ok := c.cached(path); ok {
+		addrs := e.addrs
+		if count > 0 && len(addrs) > count {
+			addrs = addrs[:count]
+		}
+		ch := make(chan peer.AddrInfo, len(addrs))
+		for _, ai := range addrs {
+			ch <- ai
+		}
+		close(ch)
+		return ch
+	}
+
+	ch := make(chan peer.AddrInfo)
+	go func() {
+		defer close(ch)
+
+		var found []peer.AddrInfo
+		err := c.getRecords(ctx, path, "Providers", func(ai peer.AddrInfo) bool {
+			found = append(found, ai)
+			routing.PublishQueryEvent(ctx, &routing.QueryEvent{
+				Type:      routing.Provider,
+				ID:        ai.ID,
+				Responses: []*peer.AddrInfo{&ai},
+			})
+			select {
+			case ch <- ai:
+			case <-ctx.Done():
+				return false
+			}
+			return count == 0 || len(found) < count
+		})
+		switch {
+		case errors.Is(err, routing.ErrNotFound):
+		case err != nil:
+			log.Debugw("failed to find providers", "cid", key, "error", err)
+			return
+		}
+		// Only cache complete responses.
+		if ctx.Err() == nil && (count == 0 || len(found) < count) {
+			c.addToCache(path, cacheEntry{addrs: found})
+		}
+	}()
+	return ch
+}
+
+// FindPeer returns the addresses of the peer.
+func (c *Client) FindPeer(ctx context.Context, p peer.ID) (peer.AddrInfo, error) {
+	path := "/routing/v1/peers/" + peer.ToCid(p).String()
+	if e, ok := c.cached(path); ok {
+		if len(e.addrs) == 0 {
+			return peer.AddrInfo{}, routing.ErrNotFound
+		}
+		return e.addrs[0], nil
+	}
+
+	var res peer.AddrInfo
+	var found bool
+	err := c.getRecords(ctx, path, "Peers", func(ai peer.AddrInfo) bool {
+		if ai.ID != p {
+			return true
+		}
+		routing.PublishQueryEvent(ctx, &routing.QueryEvent{
+			Type:      routing.PeerResponse,
+			ID:        ai.ID,
+			Responses: []*peer.AddrInfo{&ai},
+		})
+		if !found {
+			res, found = ai, true
+		} else {
+			res.Addrs = ma.Unique(append(res.Addrs, ai.Addrs...))
+		}
+		return true
+	})
+	if err != nil {
+		return peer.AddrInfo{}, err
+	}
+	if !found {
+		return peer.AddrInfo{}, routing.ErrNotFound
+	}
+	c.addToCache(path, cacheEntry{addrs: []peer.AddrInfo{res}})
+	return res, nil
+}
+
+// ipnsPath returns the API path of an /ipns/ key.
+func ipnsPath(key string) (string, error) {
+	if !strings.HasPrefix(key, ipnsPrefix) {
+		return "", routing.ErrNotSupported
+	}
+	p, err := peer.IDFromBytes([]byte(key[len(ipnsPrefix):]))
+	if err != nil {
+		return "", fmt.Errorf("invalid IPNS name: %w", err)
+	}
+	return "/routing/v1/ipns/" + peer.ToCid(p).String(), nil
+}
+
+// PutValue publishes an IPNS record. Only /ipns/ keys are supported.
+func (c *Client) PutValue(ctx context.Context, key string, value []byte, opts ...routing.Option) error {
+	var options routing.Options
+	if err := options.Apply(opts...); err != nil {
+		return err
+	}
+	if options.Offline {
+		return routing.ErrNotSupported
+	}
+	path, err := ipnsPath(key)
+	if err != nil {
+		return err
+	}
+	if len(value) > maxIPNSRecordSize {
+		return errors.New("IPNS record too large")
+	}
+
+	req, err := c.newRequest(ctx, http.MethodPut, path, mediaTypeJSON, bytes.NewReader(value))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", mediaTypeIPNSRecord)
+	resp, err := c.do(ctx, req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if c.cache != nil {
+		c.cache.Remove(path)
+	}
+	return nil
+}
+
+// GetValue returns the IPNS record of an /ipns/ key.
+func (c *Client) GetValue(ctx context.Context, key string, opts ...routing.Option) ([]byte, error) {
+	var options routing.Options
+	if err := options.Apply(opts...); err != nil {
+		return nil, err
+	}
+	path, err := ipnsPath(key)
+	if err != nil {
+		return nil, err
+	}
+	if e, ok := c.cached(path); ok {
+		return e.value, nil
+	}
+	if options.Offline {
+		return nil, routing.ErrNotFound
+	}
+
+	req, err := c.newRequest(ctx, http.MethodGet, path, mediaTypeIPNSRecord, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.do(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	value, err := io.ReadAll(io.LimitReader(resp.Body, maxIPNSRecordSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(value) > maxIPNSRecordSize {
+		return nil, errors.New("IPNS record too large")
+	}
+	if len(value) == 0 {
+		return nil, routing.ErrNotFound
+	}
+	routing.PublishQueryEvent(ctx, &routing.QueryEvent{Type: routing.Value, Extra: key})
+	c.addToCache(path, cacheEntry{value: value})
+	return value, nil
+}
+
+// SearchValue returns the IPNS record of an /ipns/ key. Since there's only a
+// single server, at most one value is returned.
+func (c *Client) SearchValue(ctx context.Context, key string, opts ...routing.Option) (<-chan []byte, error) {
+	if _, err := ipnsPath(key); err != nil {
+		return nil, err
+	}
+	ch := make(chan []byte, 1)
+	go func() {
+		defer close(ch)
+		v, err := c.GetValue(ctx, key, opts...)
+		if err != nil {
+			if !errors.Is(err, routing.ErrNotFound) {
+				log.Debugw("failed to get value", "key", key, "error", err)
+			}
+			return
+		}
+		ch <- v
+	}()
+	return ch, nil
+}
+
+// Bootstrap is a no-op, the client doesn't need to be bootstrapped.
+func (c *Client) Bootstrap(context.Context) error {
+	return nil
+}
@@ -0,0 +1,250 @@
+package delegated
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/routing"
+	"github.com/libp2p/go-libp2p/core/test"
+
+	"github.com/ipfs/go-cid"
+	ma "github.com/multiformats/go-multiaddr"
+	mh "github.com/multiformats/go-multihash"
+	"github.com/stretchr/testify/require"
+)
+
+// mockServer is a minimal stand-in for a delegated routing server.
+type mockServer struct {
+	mx        sync.Mutex
+	providers map[string][]peer.AddrInfo
+	peers     map[peer.ID]peer.AddrInfo
+	ipns      map[string][]byte
+	ndjson    bool
+
+	requests atomic.Int32
+}
+
+func toRecord(ai peer.AddrInfo) map[string]any {
+	addrs := make([]string, 0, len(ai.Addrs))
+	for _, a := range ai.Addrs {
+		addrs = append(addrs, a.String())
+	}
+	return map[string]any{"Schema": "peer", "ID": ai.ID.String(), "Addrs": addrs, "Protocols": []string{"transport-bitswap"}}
+}
+
+func (s *mockServer) writeRecords(w http.ResponseWriter, r *http.Request, field string, ais []peer.AddrInfo) {
+	if len(ais) == 0 {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	// include a record of an unknown schema, it must be skipped
+	records := []any{map[string]any{"Schema": "unknown", "Foo": "bar"}}
+	for _, ai := range ais {
+		records = append(records, toRecord(ai))
+	}
+	if s.ndjson && strings.Contains(r.Header.Get("Accept"), mediaTypeNDJSON) {
+		w.Header().Set("Content-Type", mediaTypeNDJSON)
+		enc := json.NewEncoder(w)
+		for _, rec := range records {
+			enc.Encode(rec)
+		}
+		return
+	}
+	w.Header().Set("Content-Type", mediaTypeJSON)
+	json.NewEncoder(w).Encode(map[string]any{field: records})
+}
+
+func (s *mockServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.requests.Add(1)
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	switch {
+	case strings.HasPrefix(r.URL.Path, "/routing/v1/providers/"):
+		s.writeRecords(w, r, "Providers", s.providers[strings.TrimPrefix(r.URL.Path, "/routing/v1/providers/")])
+	case strings.HasPrefix(r.URL.Path, "/routing/v1/peers/"):
+		p, err := peer.Decode(strings.TrimPrefix(r.URL.Path, "/routing/v1/peers/"))
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		var ais []peer.AddrInfo
+		if ai, ok := s.peers[p]; ok {
+			ais = append(ais, ai)
+		}
+		s.writeRecords(w, r, "Peers", ais)
+	case strings.HasPrefix(r.URL.Path, "/routing/v1/ipns/"):
+		name := strings.TrimPrefix(r.URL.Path, "/routing/v1/ipns/")
+		switch r.Method {
+		case http.MethodGet:
+			v, ok := s.ipns[name]
+			if !ok {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			w.Header().Set("Content-Type", mediaTypeIPNSRecord)
+			w.Write(v)
+		case http.MethodPut:
+			if r.Header.Get("Content-Type") != mediaTypeIPNSRecord {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			b, _ := io.ReadAll(r.Body)
+			s.ipns[name] = b
+		}
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func newMockServer(t *testing.T) (*mockServer, string) {
+	s := &mockServer{
+		providers: make(map[string][]peer.AddrInfo),
+		peers:     make(map[peer.ID]peer.AddrInfo),
+		ipns:      make(map[string][]byte),
+	}
+	srv := httptest.NewServer(s)
+	t.Cleanup(srv.Close)
+	return s, srv.URL
+}
+
+func newCid(t *testing.T, s string) cid.Cid {
+	h, err := mh.Sum([]byte(s), mh.SHA2_256, -1)
+	require.NoError(t, err)
+	return cid.NewCidV1(cid.Raw, h)
+}
+
+func newAddrInfo(t *testing.T, i int) peer.AddrInfo {
+	p, err := test.RandPeerID()
+	require.NoError(t, err)
+	return peer.AddrInfo{ID: p, Addrs: []ma.Multiaddr{ma.StringCast(fmt.Sprintf("/ip4/1.2.3.%d/tcp/1234", i))}}
+}
+
+func collect(ch <-chan peer.AddrInfo) []peer.AddrInfo {
+	var res []peer.AddrInfo
+	for ai := range ch {
+		res = append(res, ai)
+	}
+	return res
+}
+
+func TestFindProviders(t *testing.T) {
+	for _, ndjson := range []bool{false, true} {
+		t.Run(fmt.Sprintf("ndjson=%t", ndjson), func(t *testing.T) {
+			srv, url := newMockServer(t)
+			srv.ndjson = ndjson
+			c, err := New(url)
+			require.NoError(t, err)
+
+			key := newCid(t, "foo")
+			providers := []peer.AddrInfo{newAddrInfo(t, 1), newAddrInfo(t, 2), newAddrInfo(t, 3)}
+			srv.providers[key.String()] = providers
+
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			require.Len(t, collect(c.FindProvidersAsync(ctx, key, 2)), 2)
+			require.Equal(t, providers, collect(c.FindProvidersAsync(ctx, key, 0)))
+			require.EqualValues(t, 2, srv.requests.Load())
+
+			// the complete response was cached
+			require.Equal(t, providers, collect(c.FindProvidersAsync(ctx, key, 0)))
+			require.Len(t, collect(c.FindProvidersAsync(ctx, key, 1)), 1)
+			require.EqualValues(t, 2, srv.requests.Load())
+
+			require.Empty(t, collect(c.FindProvidersAsync(ctx, newCid(t, "bar"), 0)))
+		})
+	}
+}
+
+func TestFindPeer(t *testing.T) {
+	srv, url := newMockServer(t)
+	c, err := New(url)
+	require.NoError(t, err)
+	now := time.Now()
+	c.now = func() time.Time { return now }
+
+	ai := newAddrInfo(t, 1)
+	srv.peers[ai.ID] = ai
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	res, err := c.FindPeer(ctx, ai.ID)
+	require.NoError(t, err)
+	require.Equal(t, ai, res)
+	_, err = c.FindPeer(ctx, newAddrInfo(t, 2).ID)
+	require.ErrorIs(t, err, routing.ErrNotFound)
+	require.EqualValues(t, 2, srv.requests.Load())
+
+	_, err = c.FindPeer(ctx, ai.ID)
+	require.NoError(t, err)
+	require.EqualValues(t, 2, srv.requests.Load())
+
+	// cache entries expire
+	now = now.Add(DefaultCacheTTL)
+	_, err = c.FindPeer(ctx, ai.ID)
+	require.NoError(t, err)
+	require.EqualValues(t, 3, srv.requests.Load())
+}
+
+func TestIPNS(t *testing.T) {
+	_, url := newMockServer(t)
+	c, err := New(url, WithCache(0, 0))
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	p := newAddrInfo(t, 1).ID
+	key := "/ipns/" + string(p)
+
+	_, err = c.GetValue(ctx, key)
+	require.ErrorIs(t, err, routing.ErrNotFound)
+
+	require.NoError(t, c.PutValue(ctx, key, []byte("record")))
+	v, err := c.GetValue(ctx, key)
+	require.NoError(t, err)
+	require.Equal(t, "record", string(v))
+
+	ch, err := c.SearchValue(ctx, key)
+	require.NoError(t, err)
+	require.Equal(t, "record", string(<-ch))
+
+	_, err = c.GetValue(ctx, "/pk/"+string(p))
+	require.ErrorIs(t, err, routing.ErrNotSupported)
+	require.ErrorIs(t, c.Provide(ctx, newCid(t, "foo"), true), routing.ErrNotSupported)
+}
+
+func TestQueryEvents(t *testing.T) {
+	srv, url := newMockServer(t)
+	c, err := New(url)
+	require.NoError(t, err)
+	ai := newAddrInfo(t, 1)
+	srv.peers[ai.ID] = ai
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	ctx, events := routing.RegisterForQueryEvents(ctx)
+	var types []routing.QueryEventType
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for e := range events {
+			types = append(types, e.Type)
+		}
+	}()
+
+	_, err = c.FindPeer(ctx, ai.ID)
+	require.NoError(t, err)
+	cancel()
+	<-done
+	require.Equal(t, []routing.QueryEventType{routing.SendingQuery, routing.PeerResponse}, types)
+}
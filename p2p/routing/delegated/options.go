@@ -0,0 +1,49 @@
+package delegated
+
+import (
+	"errors"
+	"net/http"
+	"time"
+)
+
+type config struct {
+	httpClient *http.Client
+	userAgent  string
+	cacheSize  int
+	cacheTTL   time.Duration
+}
+
+// Option configures the delegated routing client.
+type Option func(*config) error
+
+// WithHTTPClient sets the HTTP client used to send the requests.
+func WithHTTPClient(c *http.Client) Option {
+	return func(cfg *config) error {
+		if c == nil {
+			return errors.New("nil http client")
+		}
+		cfg.httpClient = c
+		return nil
+	}
+}
+
+// WithUserAgent sets the User-Agent header of the requests.
+func WithUserAgent(ua string) Option {
+	return func(cfg *config) error {
+		cfg.userAgent = ua
+		return nil
+	}
+}
+
+// WithCache configures the response cache. Successful responses are cached
+// for ttl, for up to size different requests. A size of 0 disables caching.
+func WithCache(size int, ttl time.Duration) Option {
+	return func(cfg *config) error {
+		if size < 0 || (size > 0 && ttl <= 0) {
+			return errors.New("invalid cache configuration")
+		}
+		cfg.cacheSize = size
+		cfg.cacheTTL = ttl
+		return nil
+	}
+}
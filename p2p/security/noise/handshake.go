@@ -5,6 +5,7 @@ import (
 	"crypto/rand"
 	"crypto/sha256"
 	"encoding/binary"
+	"errors"
 	"fmt"
 	"hash"
 	"os"
@@ -39,6 +40,12 @@ var cipherSuite = noise.NewCipherSuite(noise.DH25519, noise.CipherChaChaPoly, sh
 
 // runHandshake exchanges handshake messages with the remote peer to establish
 // a noise-libp2p session. It blocks until the handshake completes or fails.
+//
+// By default, the XX handshake pattern is used. If we know the static Noise
+// key of the remote peer from an earlier handshake, the initiator uses the IK
+// pattern instead, which completes in one round trip. If the responder
+// doesn't use this key anymore, it falls back to the XXfallback pattern,
+// reusing the ephemeral key of the initiator's first message.
 func (s *secureSession) runHandshake(ctx context.Context) (err error) {
 	defer func() {
 		if rerr := recover(); rerr != nil {
@@ -47,22 +54,12 @@ func (s *secureSession) runHandshake(ctx context.Context) (err error) {
 		}
 	}()
 
-	kp, err := noise.DH25519.GenerateKeypair(rand.Reader)
-	if err != nil {
-		return fmt.Errorf("error generating static keypair: %w", err)
-	}
-
-	cfg := noise.Config{
-		CipherSuite:   cipherSuite,
-		Pattern:       noise.HandshakeXX,
-		Initiator:     s.initiator,
-		StaticKeypair: kp,
-		Prologue:      s.prologue,
-	}
-
-	hs, err := noise.NewHandshakeState(cfg)
-	if err != nil {
-		return fmt.Errorf("error initializing handshake state: %w", err)
+	kp := s.staticKey
+	if kp.Private == nil {
+		kp, err = noise.DH25519.GenerateKeypair(rand.Reader)
+		if err != nil {
+			return fmt.Errorf("error generating static keypair: %w", err)
+		}
 	}
 
 	// set a deadline to complete the handshake, if one has been supplied.
@@ -78,79 +75,333 @@ func (s *secureSession) runHandshake(ctx context.Context) (err error) {
 	hbuf := pool.Get(2 << 10)
 	defer pool.Put(hbuf)
 
+	if s.hybrid {
+		if s.initiator {
+			return s.runInitiatorHybrid(ctx, kp, hbuf)
+		}
+		return s.runResponderHybrid(ctx, kp, hbuf)
+	}
 	if s.initiator {
-		// stage 0 //
-		// Handshake Msg Len = len(DH ephemeral key)
-		if err := s.sendHandshakeMessage(hs, nil, hbuf); err != nil {
-			return fmt.Errorf("error sending handshake message: %w", err)
+		if s.remoteStatic != nil {
+			return s.runInitiatorIK(ctx, kp, hbuf)
 		}
+		return s.runInitiatorXX(ctx, kp, hbuf)
+	}
+	return s.runResponder(ctx, kp, hbuf)
+}
 
-		// stage 1 //
-		plaintext, err := s.readHandshakeMessage(hs)
-		if err != nil {
-			return fmt.Errorf("error reading handshake message: %w", err)
+func (s *secureSession) newHandshakeState(cfg noise.Config) (*noise.HandshakeState, error) {
+	cfg.CipherSuite = cipherSuite
+	cfg.Prologue = s.prologue
+	hs, err := noise.NewHandshakeState(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("error initializing handshake state: %w", err)
+	}
+	s.handshakePattern = cfg.Pattern.Name
+	if cfg.PresharedKeyPlacement != 0 {
+		s.handshakePattern += fmt.Sprintf("psk%d", cfg.PresharedKeyPlacement)
+	}
+	s.noiseInitiator = cfg.Initiator
+	return hs, nil
+}
+
+func (s *secureSession) runInitiatorXX(ctx context.Context, kp noise.DHKey, hbuf []byte) error {
+	hs, err := s.newHandshakeState(noise.Config{
+		Pattern:       noise.HandshakeXX,
+		Initiator:     true,
+		StaticKeypair: kp,
+	})
+	if err != nil {
+		return err
+	}
+
+	// stage 0 //
+	// Handshake Msg Len = len(DH ephemeral key)
+	if err := s.sendHandshakeMessage(hs, nil, hbuf); err != nil {
+		return fmt.Errorf("error sending handshake message: %w", err)
+	}
+
+	// stage 1 //
+	plaintext, err := s.readHandshakeMessage(hs)
+	if err != nil {
+		return fmt.Errorf("error reading handshake message: %w", err)
+	}
+	return s.finishInitiator(ctx, hs, kp, plaintext, hbuf)
+}
+
+// finishInitiator handles the second message of the XX or XXfallback
+// handshake, and sends the third one.
+func (s *secureSession) finishInitiator(ctx context.Context, hs *noise.HandshakeState, kp noise.DHKey, plaintext []byte, hbuf []byte) error {
+	rcvdEd, err := s.handleRemoteHandshakePayload(plaintext, hs.PeerStatic())
+	if err != nil {
+		return err
+	}
+	if s.initiatorEarlyDataHandler != nil {
+		if err := s.initiatorEarlyDataHandler.Received(ctx, s.insecureConn, rcvdEd); err != nil {
+			return err
 		}
+	}
+
+	// stage 2 //
+	// Handshake Msg Len = len(DHT static key) +  MAC(static key is encrypted) + len(Payload) + MAC(payload is encrypted)
+	var ed *pb.NoiseExtensions
+	if s.initiatorEarlyDataHandler != nil {
+		ed = s.initiatorEarlyDataHandler.Send(ctx, s.insecureConn, s.remoteID)
+	}
+	payload, err := s.generateHandshakePayload(kp, ed)
+	if err != nil {
+		return err
+	}
+	if err := s.sendHandshakeMessage(hs, payload, hbuf); err != nil {
+		return fmt.Errorf("error sending handshake message: %w", err)
+	}
+	return nil
+}
+
+func (s *secureSession) runInitiatorIK(ctx context.Context, kp noise.DHKey, hbuf []byte) error {
+	hs, err := s.newHandshakeState(noise.Config{
+		Pattern:       noise.HandshakeIK,
+		Initiator:     true,
+		StaticKeypair: kp,
+		PeerStatic:    s.remoteStatic,
+	})
+	if err != nil {
+		return err
+	}
+
+	// stage 0 //
+	// Handshake Msg Len = len(DH ephemeral key) + len(DHT static key) +  MAC(static key is encrypted) + len(Payload) +
+	// MAC(payload is encrypted)
+	var ed *pb.NoiseExtensions
+	if s.initiatorEarlyDataHandler != nil {
+		ed = s.initiatorEarlyDataHandler.Send(ctx, s.insecureConn, s.remoteID)
+	}
+	payload, err := s.generateHandshakePayload(kp, ed)
+	if err != nil {
+		return err
+	}
+	if err := s.sendHandshakeMessage(hs, payload, hbuf); err != nil {
+		return fmt.Errorf("error sending handshake message: %w", err)
+	}
+
+	// stage 1 //
+	msg, err := s.readRawHandshakeMessage()
+	if err != nil {
+		return fmt.Errorf("error reading handshake message: %w", err)
+	}
+	defer pool.Put(msg)
+	if plaintext, err := s.processHandshakeMessage(hs, msg); err == nil {
 		rcvdEd, err := s.handleRemoteHandshakePayload(plaintext, hs.PeerStatic())
 		if err != nil {
 			return err
 		}
 		if s.initiatorEarlyDataHandler != nil {
-			if err := s.initiatorEarlyDataHandler.Received(ctx, s.insecureConn, rcvdEd); err != nil {
-				return err
-			}
+			return s.initiatorEarlyDataHandler.Received(ctx, s.insecureConn, rcvdEd)
 		}
+		return nil
+	}
 
-		// stage 2 //
-		// Handshake Msg Len = len(DHT static key) +  MAC(static key is encrypted) + len(Payload) + MAC(payload is encrypted)
-		var ed *pb.NoiseExtensions
-		if s.initiatorEarlyDataHandler != nil {
-			ed = s.initiatorEarlyDataHandler.Send(ctx, s.insecureConn, s.remoteID)
-		}
-		payload, err := s.generateHandshakePayload(kp, ed)
+	// The responder couldn't decrypt our first message, and answered with the
+	// first message of the XXfallback pattern.
+	hs, err = s.newHandshakeState(noise.Config{
+		Pattern:          noise.HandshakeXXfallback,
+		Initiator:        false,
+		StaticKeypair:    kp,
+		EphemeralKeypair: hs.LocalEphemeral(),
+	})
+	if err != nil {
+		return err
+	}
+	plaintext, err := s.processHandshakeMessage(hs, msg)
+	if err != nil {
+		return fmt.Errorf("error reading handshake message: %w", err)
+	}
+	return s.finishInitiator(ctx, hs, kp, plaintext, hbuf)
+}
+
+func (s *secureSession) runResponder(ctx context.Context, kp noise.DHKey, hbuf []byte) error {
+	// stage 0 //
+	msg, err := s.readRawHandshakeMessage()
+	if err != nil {
+		return fmt.Errorf("error reading handshake message: %w", err)
+	}
+	defer pool.Put(msg)
+
+	var hs *noise.HandshakeState
+	if len(msg) > noise.DH25519.DHLen() {
+		// The first message of the XX pattern only contains the ephemeral key of
+		// the initiator. This is the first message of the IK pattern.
+		hs, err = s.newHandshakeState(noise.Config{
+			Pattern:       noise.HandshakeIK,
+			Initiator:     false,
+			StaticKeypair: kp,
+		})
 		if err != nil {
 			return err
 		}
-		if err := s.sendHandshakeMessage(hs, payload, hbuf); err != nil {
-			return fmt.Errorf("error sending handshake message: %w", err)
-		}
-		return nil
-	} else {
-		// stage 0 //
-		if _, err := s.readHandshakeMessage(hs); err != nil {
-			return fmt.Errorf("error reading handshake message: %w", err)
+		plaintext, err := s.processHandshakeMessage(hs, msg)
+		if err == nil {
+			rcvdEd, err := s.handleRemoteHandshakePayload(plaintext, hs.PeerStatic())
+			if err != nil {
+				return err
+			}
+			if s.responderEarlyDataHandler != nil {
+				if err := s.responderEarlyDataHandler.Received(ctx, s.insecureConn, rcvdEd); err != nil {
+					return err
+				}
+			}
+			// stage 1 //
+			return s.sendResponderPayload(ctx, hs, kp, nil, hbuf)
 		}
 
-		// stage 1 //
-		// Handshake Msg Len = len(DH ephemeral key) + len(DHT static key) +  MAC(static key is encrypted) + len(Payload) +
-		// MAC(payload is encrypted)
-		var ed *pb.NoiseExtensions
-		if s.responderEarlyDataHandler != nil {
-			ed = s.responderEarlyDataHandler.Send(ctx, s.insecureConn, s.remoteID)
-		}
-		payload, err := s.generateHandshakePayload(kp, ed)
+		// The initiator encrypted its first message with a static key we don't
+		// use (anymore). Continue with the XXfallback pattern, taking over its
+		// ephemeral key.
+		hs, err = s.newHandshakeState(noise.Config{
+			Pattern:       noise.HandshakeXXfallback,
+			Initiator:     true,
+			StaticKeypair: kp,
+			PeerEphemeral: msg[:noise.DH25519.DHLen()],
+		})
 		if err != nil {
 			return err
 		}
-		if err := s.sendHandshakeMessage(hs, payload, hbuf); err != nil {
-			return fmt.Errorf("error sending handshake message: %w", err)
-		}
-
-		// stage 2 //
-		plaintext, err := s.readHandshakeMessage(hs)
+	} else {
+		hs, err = s.newHandshakeState(noise.Config{
+			Pattern:       noise.HandshakeXX,
+			Initiator:     false,
+			StaticKeypair: kp,
+		})
 		if err != nil {
+			return err
+		}
+		if _, err := s.processHandshakeMessage(hs, msg); err != nil {
 			return fmt.Errorf("error reading handshake message: %w", err)
 		}
-		rcvdEd, err := s.handleRemoteHandshakePayload(plaintext, hs.PeerStatic())
-		if err != nil {
+	}
+
+	// stage 1 //
+	// Handshake Msg Len = len(DH ephemeral key) + len(DHT static key) +  MAC(static key is encrypted) + len(Payload) +
+	// MAC(payload is encrypted)
+	if err := s.sendResponderPayload(ctx, hs, kp, nil, hbuf); err != nil {
+		return err
+	}
+	return s.finishResponder(ctx, hs)
+}
+
+// finishResponder handles the third message of the XX or XXfallback
+// handshake.
+func (s *secureSession) finishResponder(ctx context.Context, hs *noise.HandshakeState) error {
+	// stage 2 //
+	plaintext, err := s.readHandshakeMessage(hs)
+	if err != nil {
+		return fmt.Errorf("error reading handshake message: %w", err)
+	}
+	rcvdEd, err := s.handleRemoteHandshakePayload(plaintext, hs.PeerStatic())
+	if err != nil {
+		return err
+	}
+	if s.responderEarlyDataHandler != nil {
+		if err := s.responderEarlyDataHandler.Received(ctx, s.insecureConn, rcvdEd); err != nil {
 			return err
 		}
-		if s.responderEarlyDataHandler != nil {
-			if err := s.responderEarlyDataHandler.Received(ctx, s.insecureConn, rcvdEd); err != nil {
-				return err
-			}
-		}
-		return nil
 	}
+	return nil
+}
+
+// sendResponderPayload sends the handshake message of the responder that
+// carries its payload, prefixed with prefix.
+func (s *secureSession) sendResponderPayload(ctx context.Context, hs *noise.HandshakeState, kp noise.DHKey, prefix []byte, hbuf []byte) error {
+	var ed *pb.NoiseExtensions
+	if s.responderEarlyDataHandler != nil {
+		ed = s.responderEarlyDataHandler.Send(ctx, s.insecureConn, s.remoteID)
+	}
+	payload, err := s.generateHandshakePayload(kp, ed)
+	if err != nil {
+		return err
+	}
+	if prefix != nil {
+		payload = append(prefix, payload...)
+	}
+	if err := s.sendHandshakeMessage(hs, payload, hbuf); err != nil {
+		return fmt.Errorf("error sending handshake message: %w", err)
+	}
+	return nil
+}
+
+func (s *secureSession) runInitiatorHybrid(ctx context.Context, kp noise.DHKey, hbuf []byte) error {
+	hs, err := s.newHandshakeState(noise.Config{
+		Pattern:               noise.HandshakeXX,
+		Initiator:             true,
+		StaticKeypair:         kp,
+		PresharedKeyPlacement: 3,
+	})
+	if err != nil {
+		return err
+	}
+	dk, ek, err := generateKEMKey()
+	if err != nil {
+		return fmt.Errorf("error generating KEM key: %w", err)
+	}
+
+	// stage 0 //
+	// Handshake Msg Len = len(DH ephemeral key) + len(KEM encapsulation key)
+	if err := s.sendHandshakeMessage(hs, ek, hbuf); err != nil {
+		return fmt.Errorf("error sending handshake message: %w", err)
+	}
+
+	// stage 1 //
+	// The payload is prefixed with the KEM ciphertext.
+	plaintext, err := s.readHandshakeMessage(hs)
+	if err != nil {
+		return fmt.Errorf("error reading handshake message: %w", err)
+	}
+	if len(plaintext) < kemCiphertextSize {
+		return errors.New("handshake message too short for KEM ciphertext")
+	}
+	sharedKey, err := dk.Decapsulate(plaintext[:kemCiphertextSize])
+	if err != nil {
+		return fmt.Errorf("error decapsulating KEM shared key: %w", err)
+	}
+	if err := hs.SetPresharedKey(sharedKey); err != nil {
+		return err
+	}
+	return s.finishInitiator(ctx, hs, kp, plaintext[kemCiphertextSize:], hbuf)
+}
+
+func (s *secureSession) runResponderHybrid(ctx context.Context, kp noise.DHKey, hbuf []byte) error {
+	hs, err := s.newHandshakeState(noise.Config{
+		Pattern:               noise.HandshakeXX,
+		Initiator:             false,
+		StaticKeypair:         kp,
+		PresharedKeyPlacement: 3,
+	})
+	if err != nil {
+		return err
+	}
+
+	// stage 0 //
+	ek, err := s.readHandshakeMessage(hs)
+	if err != nil {
+		return fmt.Errorf("error reading handshake message: %w", err)
+	}
+	if len(ek) != kemEncapsulationKeySize {
+		return fmt.Errorf("invalid KEM encapsulation key length: %d", len(ek))
+	}
+	sharedKey, ciphertext, err := kemEncapsulate(ek)
+	if err != nil {
+		return fmt.Errorf("error encapsulating KEM shared key: %w", err)
+	}
+	// The shared key is only used at the end of the third message.
+	if err := hs.SetPresharedKey(sharedKey); err != nil {
+		return err
+	}
+
+	// stage 1 //
+	if err := s.sendResponderPayload(ctx, hs, kp, ciphertext, hbuf); err != nil {
+		return err
+	}
+	return s.finishResponder(ctx, hs)
 }
 
 // setCipherStates sets the initial cipher states that will be used to protect
@@ -159,7 +410,9 @@ func (s *secureSession) runHandshake(ctx context.Context) (err error) {
 // It is called when the final handshake message is processed by
 // either sendHandshakeMessage or readHandshakeMessage.
 func (s *secureSession) setCipherStates(cs1, cs2 *noise.CipherState) {
-	if s.initiator {
+	// cs1 protects the traffic sent by the initiator of the handshake pattern,
+	// which isn't us when we are the initiator of an XXfallback handshake.
+	if s.noiseInitiator {
 		s.enc = cs1
 		s.dec = cs2
 	} else {
@@ -203,26 +456,41 @@ func (s *secureSession) sendHandshakeMessage(hs *noise.HandshakeState, payload [
 // If this is the final message in the sequence, it calls setCipherStates
 // to initialize cipher states.
 func (s *secureSession) readHandshakeMessage(hs *noise.HandshakeState) ([]byte, error) {
+	buf, err := s.readRawHandshakeMessage()
+	if err != nil {
+		return nil, err
+	}
+	defer pool.Put(buf)
+	return s.processHandshakeMessage(hs, buf)
+}
+
+// readRawHandshakeMessage reads a message from the insecure conn, without
+// processing it. The returned buffer should be returned to the pool.
+func (s *secureSession) readRawHandshakeMessage() ([]byte, error) {
 	l, err := s.readNextInsecureMsgLen()
 	if err != nil {
 		return nil, err
 	}
 
 	buf := pool.Get(l)
-	defer pool.Put(buf)
-
 	if err := s.readNextMsgInsecure(buf); err != nil {
+		pool.Put(buf)
 		return nil, err
 	}
+	return buf, nil
+}
 
-	msg, cs1, cs2, err := hs.ReadMessage(nil, buf)
+// processHandshakeMessage processes msg as the expected next message in the
+// handshake sequence. See readHandshakeMessage.
+func (s *secureSession) processHandshakeMessage(hs *noise.HandshakeState, msg []byte) ([]byte, error) {
+	plaintext, cs1, cs2, err := hs.ReadMessage(nil, msg)
 	if err != nil {
 		return nil, err
 	}
 	if cs1 != nil && cs2 != nil {
 		s.setCipherStates(cs1, cs2)
 	}
-	return msg, nil
+	return plaintext, nil
 }
 
 // generateHandshakePayload creates a libp2p handshake payload with a
@@ -242,6 +510,16 @@ func (s *secureSession) generateHandshakePayload(localStatic noise.DHKey, ext *p
 		return nil, fmt.Errorf("error sigining handshake payload: %w", err)
 	}
 
+	// Peers can only use IK if our static key outlives this handshake.
+	if s.staticKey.Private != nil && !s.hybrid {
+		if ext == nil {
+			ext = &pb.NoiseExtensions{}
+		} else {
+			ext = proto.Clone(ext).(*pb.NoiseExtensions)
+		}
+		ext.IkSupported = proto.Bool(true)
+	}
+
 	// create payload
 	payloadEnc, err := proto.Marshal(&pb.NoiseHandshakePayload{
 		IdentityKey: localKeyRaw,
@@ -293,5 +571,7 @@ func (s *secureSession) handleRemoteHandshakePayload(payload []byte, remoteStati
 	// set remote peer key and id
 	s.remoteID = id
 	s.remoteKey = remotePubKey
+	s.remoteStatic = append([]byte(nil), remoteStatic...)
+	s.remoteIKSupported = nhp.GetExtensions().GetIkSupported()
 	return nhp.Extensions, nil
 }
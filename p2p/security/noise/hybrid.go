@@ -0,0 +1,57 @@
+package noise
+
+import (
+	"errors"
+
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/protocol"
+	tptu "github.com/libp2p/go-libp2p/p2p/net/upgrader"
+)
+
+// PQID is the protocol ID of the Noise transport using a hybrid post-quantum
+// key exchange.
+const PQID = "/noise-pq"
+
+var errHybridUnsupported = errors.New("hybrid post-quantum key exchange requires Go 1.24 or later")
+
+// Sizes of the ML-KEM-768 encapsulation key and ciphertext.
+const (
+	kemEncapsulationKeySize = 1184
+	kemCiphertextSize       = 1088
+)
+
+// A kemDecapsulationKey is the private key of an ML-KEM key pair.
+type kemDecapsulationKey interface {
+	Decapsulate(ciphertext []byte) (sharedKey []byte, err error)
+}
+
+// NewPQ creates a new Noise transport that combines the X25519 key exchange
+// with ML-KEM-768 (Kyber). The session keys remain secure as long as either of
+// them is unbroken. Peers are still authenticated with their libp2p identity
+// keys.
+//
+// The initiator sends an ephemeral ML-KEM encapsulation key in the first
+// message of the XX handshake, and the responder encapsulates a shared secret
+// to it in the second message. The shared secret is mixed into the session
+// keys as a pre-shared key, at the end of the third message (XXpsk3). The IK
+// pattern isn't supported.
+//
+// This transport can't handshake with the transport returned by New: it
+// should be registered under PQID, alongside the classical transport, so that
+// peers only use it if both of them support it:
+//
+//	libp2p.ChainOptions(
+//		libp2p.Security(noise.PQID, noise.NewPQ),
+//		libp2p.Security(noise.ID, noise.NewWithPeerstore),
+//	)
+func NewPQ(id protocol.ID, privkey crypto.PrivKey, muxers []tptu.StreamMuxer) (*Transport, error) {
+	if !hybridSupported {
+		return nil, errHybridUnsupported
+	}
+	t, err := New(id, privkey, muxers)
+	if err != nil {
+		return nil, err
+	}
+	t.hybrid = true
+	return t, nil
+}
@@ -0,0 +1,24 @@
+//go:build go1.24
+
+package noise
+
+import "crypto/mlkem"
+
+const hybridSupported = true
+
+func generateKEMKey() (kemDecapsulationKey, []byte, error) {
+	dk, err := mlkem.GenerateKey768()
+	if err != nil {
+		return nil, nil, err
+	}
+	return dk, dk.EncapsulationKey().Bytes(), nil
+}
+
+func kemEncapsulate(encapsulationKey []byte) (sharedKey, ciphertext []byte, err error) {
+	ek, err := mlkem.NewEncapsulationKey768(encapsulationKey)
+	if err != nil {
+		return nil, nil, err
+	}
+	sharedKey, ciphertext = ek.Encapsulate()
+	return sharedKey, ciphertext, nil
+}
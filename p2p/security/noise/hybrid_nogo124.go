@@ -0,0 +1,13 @@
+//go:build !go1.24
+
+package noise
+
+const hybridSupported = false
+
+func generateKEMKey() (kemDecapsulationKey, []byte, error) {
+	return nil, nil, errHybridUnsupported
+}
+
+func kemEncapsulate([]byte) (sharedKey, ciphertext []byte, err error) {
+	return nil, nil, errHybridUnsupported
+}
@@ -0,0 +1,103 @@
+//go:build go1.24
+
+package noise
+
+import (
+	"context"
+	"io"
+	"testing"
+
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/protocol"
+	"github.com/libp2p/go-libp2p/p2p/host/peerstore/pstoremem"
+
+	"github.com/stretchr/testify/require"
+)
+
+func newTestHybridTransport(t *testing.T, muxers []protocol.ID) *Transport {
+	t.Helper()
+	priv, _, err := crypto.GenerateKeyPair(crypto.Ed25519, 256)
+	require.NoError(t, err)
+	tpt, err := NewPQ(PQID, priv, nil)
+	require.NoError(t, err)
+	tpt.muxers = muxers
+	return tpt
+}
+
+func TestHybridHandshake(t *testing.T) {
+	initTransport := newTestHybridTransport(t, []protocol.ID{"muxer1", "muxer2"})
+	respTransport := newTestHybridTransport(t, []protocol.ID{"muxer2"})
+
+	initConn, respConn := connect(t, initTransport, respTransport)
+	defer initConn.Close()
+	defer respConn.Close()
+
+	require.Equal(t, "XXpsk3", initConn.handshakePattern)
+	require.Equal(t, "XXpsk3", respConn.handshakePattern)
+	require.Equal(t, respTransport.localID, initConn.RemotePeer())
+	require.Equal(t, initTransport.localID, respConn.RemotePeer())
+	require.Equal(t, protocol.ID("muxer2"), initConn.connectionState.StreamMultiplexer)
+	require.Equal(t, protocol.ID("muxer2"), respConn.connectionState.StreamMultiplexer)
+
+	before := []byte("hello world")
+	_, err := initConn.Write(before)
+	require.NoError(t, err)
+	after := make([]byte, len(before))
+	_, err = io.ReadFull(respConn, after)
+	require.NoError(t, err)
+	require.Equal(t, before, after)
+	_, err = respConn.Write(before)
+	require.NoError(t, err)
+	_, err = io.ReadFull(initConn, after)
+	require.NoError(t, err)
+	require.Equal(t, before, after)
+}
+
+func TestHybridClassicalInterop(t *testing.T) {
+	handshake := func(t *testing.T, initTransport, respTransport *Transport) (initErr, respErr error) {
+		init, resp := newConnPair(t)
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			_, initErr = initTransport.SecureOutbound(context.Background(), init, respTransport.localID)
+			// unblock the responder if it is still waiting for a message
+			init.Close()
+		}()
+		_, respErr = respTransport.SecureInbound(context.Background(), resp, "")
+		resp.Close()
+		<-done
+		return initErr, respErr
+	}
+
+	t.Run("hybrid initiator, classical responder", func(t *testing.T) {
+		initErr, respErr := handshake(t, newTestHybridTransport(t, nil), newTestTransport(t, crypto.Ed25519, 2048))
+		require.Error(t, initErr)
+		require.Error(t, respErr)
+	})
+	t.Run("classical initiator, hybrid responder", func(t *testing.T) {
+		initErr, respErr := handshake(t, newTestTransport(t, crypto.Ed25519, 2048), newTestHybridTransport(t, nil))
+		require.Error(t, initErr)
+		require.Error(t, respErr)
+	})
+}
+
+func TestHybridIgnoresStaticKeyCache(t *testing.T) {
+	initCache := pstoremem.NewPeerMetadata()
+	initTransport, err := newTestHybridTransport(t, nil).WithSessionOptions(StaticKeyCache(initCache))
+	require.NoError(t, err)
+	resp := newTestHybridTransport(t, nil)
+	respTransport, err := resp.WithSessionOptions(StaticKeyCache(pstoremem.NewPeerMetadata()))
+	require.NoError(t, err)
+
+	// A cached key must neither trigger an IK handshake, nor be updated.
+	staleKey := make([]byte, 32)
+	require.NoError(t, initCache.Put(resp.localID, staticKeyMetadataKey, staleKey))
+
+	initConn, respConn := connectSessions(t, initTransport, respTransport, resp.localID)
+	defer initConn.Close()
+	defer respConn.Close()
+	require.Equal(t, "XXpsk3", initConn.handshakePattern)
+	v, err := initCache.Get(resp.localID, staticKeyMetadataKey)
+	require.NoError(t, err)
+	require.Equal(t, staleKey, v)
+}
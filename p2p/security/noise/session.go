@@ -12,6 +12,7 @@ import (
 	"github.com/libp2p/go-libp2p/core/crypto"
 	"github.com/libp2p/go-libp2p/core/network"
 	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/peerstore"
 	"github.com/libp2p/go-libp2p/core/protocol"
 )
 
@@ -24,6 +25,23 @@ type secureSession struct {
 	remoteID  peer.ID
 	remoteKey crypto.PubKey
 
+	// staticKey is the static Noise key of the transport, if it has one
+	staticKey noise.DHKey
+	// remoteStatic is the static Noise key of the remote peer. Before the
+	// handshake, it is set if the key was cached, and the IK pattern is used.
+	remoteStatic      []byte
+	remoteIKSupported bool
+	keyCache          peerstore.PeerMetadata
+
+	// hybrid is set if the handshake combines X25519 with ML-KEM
+	hybrid bool
+
+	// handshakePattern is the name of the Noise handshake pattern used
+	handshakePattern string
+	// noiseInitiator is true if we are the initiator of the handshake pattern.
+	// This differs from initiator when falling back from IK to XXfallback.
+	noiseInitiator bool
+
 	readLock  sync.Mutex
 	writeLock sync.Mutex
 
@@ -49,7 +67,7 @@ type secureSession struct {
 
 // newSecureSession creates a Noise session over the given insecureConn Conn, using
 // the libp2p identity keypair from the given Transport.
-func newSecureSession(tpt *Transport, ctx context.Context, insecure net.Conn, remote peer.ID, prologue []byte, initiatorEDH, responderEDH EarlyDataHandler, initiator, checkPeerID bool) (*secureSession, error) {
+func newSecureSession(tpt *Transport, ctx context.Context, insecure net.Conn, remote peer.ID, prologue []byte, initiatorEDH, responderEDH EarlyDataHandler, keyCache peerstore.PeerMetadata, initiator, checkPeerID bool) (*secureSession, error) {
 	s := &secureSession{
 		insecureConn:              insecure,
 		insecureReader:            bufio.NewReader(insecure),
@@ -61,6 +79,12 @@ func newSecureSession(tpt *Transport, ctx context.Context, insecure net.Conn, re
 		initiatorEarlyDataHandler: initiatorEDH,
 		responderEarlyDataHandler: responderEDH,
 		checkPeerID:               checkPeerID,
+		staticKey:                 tpt.staticKey,
+		keyCache:                  keyCache,
+		hybrid:                    tpt.hybrid,
+	}
+	if initiator && remote != "" && !s.hybrid {
+		s.remoteStatic = cachedStaticKey(keyCache, remote)
 	}
 
 	// the go-routine we create to run the handshake will
@@ -75,6 +99,7 @@ func newSecureSession(tpt *Transport, ctx context.Context, insecure net.Conn, re
 		if err != nil {
 			_ = s.insecureConn.Close()
 		}
+		s.updateKeyCache(err)
 		return s, err
 
 	case <-ctx.Done():
@@ -82,11 +107,52 @@ func newSecureSession(tpt *Transport, ctx context.Context, insecure net.Conn, re
 		// We then wait for the handshake to return because of the first error it encounters
 		// so we don't return without cleaning up the go-routine.
 		_ = s.insecureConn.Close()
-		<-respCh
+		s.updateKeyCache(<-respCh)
 		return nil, ctx.Err()
 	}
 }
 
+// staticKeyMetadataKey is the peerstore metadata key the static Noise keys of
+// the peers are cached under.
+const staticKeyMetadataKey = "NoiseStaticKey"
+
+func cachedStaticKey(keyCache peerstore.PeerMetadata, p peer.ID) []byte {
+	if keyCache == nil {
+		return nil
+	}
+	v, err := keyCache.Get(p, staticKeyMetadataKey)
+	if err != nil {
+		return nil
+	}
+	key, ok := v.([]byte)
+	if !ok || len(key) != noise.DH25519.DHLen() {
+		return nil
+	}
+	return key
+}
+
+// updateKeyCache caches the static key of the remote peer after a successful
+// handshake, if the remote peer accepts the IK pattern. If the handshake
+// failed after we tried IK, the cached key is discarded, so that the next
+// handshake uses XX.
+func (s *secureSession) updateKeyCache(handshakeErr error) {
+	if s.keyCache == nil || s.remoteID == "" || s.hybrid {
+		return
+	}
+	key := []byte{}
+	switch {
+	case handshakeErr != nil:
+		if !s.initiator || s.handshakePattern != noise.HandshakeIK.Name {
+			return
+		}
+	case s.remoteIKSupported:
+		key = s.remoteStatic
+	case cachedStaticKey(s.keyCache, s.remoteID) == nil:
+		return
+	}
+	_ = s.keyCache.Put(s.remoteID, staticKeyMetadataKey, key)
+}
+
 func (s *secureSession) LocalAddr() net.Addr {
 	return s.insecureConn.LocalAddr()
 }
@@ -6,6 +6,7 @@ import (
 
 	"github.com/libp2p/go-libp2p/core/canonicallog"
 	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/peerstore"
 	"github.com/libp2p/go-libp2p/core/protocol"
 	"github.com/libp2p/go-libp2p/core/sec"
 	"github.com/libp2p/go-libp2p/p2p/security/noise/pb"
@@ -62,6 +63,24 @@ func DisablePeerIDCheck() SessionOption {
 	}
 }
 
+// StaticKeyCache caches the static Noise keys of the remote peers in the
+// metadata of the peerstore.
+//
+// When we know the static key of the peer we're dialing from an earlier
+// handshake, the IK handshake pattern is used instead of XX: the handshake
+// completes in one round trip instead of one and a half. If the peer's static
+// key has changed, for example because it restarted, the handshake falls back
+// to the XXfallback pattern, and the cached key is updated. Only the keys of
+// peers that advertise IK support are cached.
+//
+// Inbound IK handshakes are always accepted, regardless of this option.
+func StaticKeyCache(m peerstore.PeerMetadata) SessionOption {
+	return func(s *SessionTransport) error {
+		s.keyCache = m
+		return nil
+	}
+}
+
 var _ sec.SecureTransport = &SessionTransport{}
 
 // SessionTransport can be used
@@ -71,6 +90,7 @@ type SessionTransport struct {
 	// options
 	prologue           []byte
 	disablePeerIDCheck bool
+	keyCache           peerstore.PeerMetadata
 
 	protocolID protocol.ID
 
@@ -81,7 +101,7 @@ type SessionTransport struct {
 // If p is empty, connections from any peer are accepted.
 func (i *SessionTransport) SecureInbound(ctx context.Context, insecure net.Conn, p peer.ID) (sec.SecureConn, error) {
 	checkPeerID := !i.disablePeerIDCheck && p != ""
-	c, err := newSecureSession(i.t, ctx, insecure, p, i.prologue, i.initiatorEarlyDataHandler, i.responderEarlyDataHandler, false, checkPeerID)
+	c, err := newSecureSession(i.t, ctx, insecure, p, i.prologue, i.initiatorEarlyDataHandler, i.responderEarlyDataHandler, i.keyCache, false, checkPeerID)
 	if err != nil {
 		addr, maErr := manet.FromNetAddr(insecure.RemoteAddr())
 		if maErr == nil {
@@ -93,7 +113,7 @@ func (i *SessionTransport) SecureInbound(ctx context.Context, insecure net.Conn,
 
 // SecureOutbound runs the Noise handshake as the initiator.
 func (i *SessionTransport) SecureOutbound(ctx context.Context, insecure net.Conn, p peer.ID) (sec.SecureConn, error) {
-	return newSecureSession(i.t, ctx, insecure, p, i.prologue, i.initiatorEarlyDataHandler, i.responderEarlyDataHandler, true, !i.disablePeerIDCheck)
+	return newSecureSession(i.t, ctx, insecure, p, i.prologue, i.initiatorEarlyDataHandler, i.responderEarlyDataHandler, i.keyCache, true, !i.disablePeerIDCheck)
 }
 
 func (i *SessionTransport) ID() protocol.ID {
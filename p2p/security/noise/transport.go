@@ -2,16 +2,19 @@ package noise
 
 import (
 	"context"
+	"crypto/rand"
 	"net"
 
 	"github.com/libp2p/go-libp2p/core/canonicallog"
 	"github.com/libp2p/go-libp2p/core/crypto"
 	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/peerstore"
 	"github.com/libp2p/go-libp2p/core/protocol"
 	"github.com/libp2p/go-libp2p/core/sec"
 	tptu "github.com/libp2p/go-libp2p/p2p/net/upgrader"
 	"github.com/libp2p/go-libp2p/p2p/security/noise/pb"
 
+	"github.com/flynn/noise"
 	manet "github.com/multiformats/go-multiaddr/net"
 )
 
@@ -24,18 +27,33 @@ type Transport struct {
 	localID    peer.ID
 	privateKey crypto.PrivKey
 	muxers     []protocol.ID
+	// staticKey is the static Noise key used for all handshakes, so that
+	// peers can cache it and use the IK pattern
+	staticKey noise.DHKey
+	// hybrid is set if the handshake combines X25519 with ML-KEM
+	hybrid bool
+	// keyCache caches the static keys of the remote peers, see StaticKeyCache
+	keyCache peerstore.PeerMetadata
 }
 
 var _ sec.SecureTransport = &Transport{}
 
 // New creates a new Noise transport using the given private key as its
 // libp2p identity key.
+//
+// The transport doesn't cache the static keys of the remote peers, so outbound
+// handshakes always use the XX pattern. Use NewWithPeerstore to enable IK.
 func New(id protocol.ID, privkey crypto.PrivKey, muxers []tptu.StreamMuxer) (*Transport, error) {
 	localID, err := peer.IDFromPrivateKey(privkey)
 	if err != nil {
 		return nil, err
 	}
 
+	staticKey, err := noise.DH25519.GenerateKeypair(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+
 	muxerIDs := make([]protocol.ID, 0, len(muxers))
 	for _, m := range muxers {
 		muxerIDs = append(muxerIDs, m.ID)
@@ -46,14 +64,31 @@ func New(id protocol.ID, privkey crypto.PrivKey, muxers []tptu.StreamMuxer) (*Tr
 		localID:    localID,
 		privateKey: privkey,
 		muxers:     muxerIDs,
+		staticKey:  staticKey,
 	}, nil
 }
 
+// NewWithPeerstore creates a new Noise transport like New, which caches the
+// static keys of the remote peers in ps, so that reconnections to known peers
+// use the IK pattern. See StaticKeyCache.
+//
+// It can be passed to libp2p.Security, which provides the host's peerstore:
+//
+//	libp2p.Security(noise.ID, noise.NewWithPeerstore)
+func NewWithPeerstore(id protocol.ID, privkey crypto.PrivKey, muxers []tptu.StreamMuxer, ps peerstore.Peerstore) (*Transport, error) {
+	t, err := New(id, privkey, muxers)
+	if err != nil {
+		return nil, err
+	}
+	t.keyCache = ps
+	return t, nil
+}
+
 // SecureInbound runs the Noise handshake as the responder.
 // If p is empty, connections from any peer are accepted.
 func (t *Transport) SecureInbound(ctx context.Context, insecure net.Conn, p peer.ID) (sec.SecureConn, error) {
 	responderEDH := newTransportEDH(t)
-	c, err := newSecureSession(t, ctx, insecure, p, nil, nil, responderEDH, false, p != "")
+	c, err := newSecureSession(t, ctx, insecure, p, nil, nil, responderEDH, t.keyCache, false, p != "")
 	if err != nil {
 		addr, maErr := manet.FromNetAddr(insecure.RemoteAddr())
 		if maErr == nil {
@@ -66,7 +101,7 @@ func (t *Transport) SecureInbound(ctx context.Context, insecure net.Conn, p peer
 // SecureOutbound runs the Noise handshake as the initiator.
 func (t *Transport) SecureOutbound(ctx context.Context, insecure net.Conn, p peer.ID) (sec.SecureConn, error) {
 	initiatorEDH := newTransportEDH(t)
-	c, err := newSecureSession(t, ctx, insecure, p, nil, initiatorEDH, nil, true, true)
+	c, err := newSecureSession(t, ctx, insecure, p, nil, initiatorEDH, nil, t.keyCache, true, true)
 	if err != nil {
 		return c, err
 	}
@@ -74,7 +109,7 @@ func (t *Transport) SecureOutbound(ctx context.Context, insecure net.Conn, p pee
 }
 
 func (t *Transport) WithSessionOptions(opts ...SessionOption) (*SessionTransport, error) {
-	st := &SessionTransport{t: t, protocolID: t.protocolID}
+	st := &SessionTransport{t: t, protocolID: t.protocolID, keyCache: t.keyCache}
 	for _, opt := range opts {
 		if err := opt(st); err != nil {
 			return nil, err
@@ -3,6 +3,7 @@ package noise
 import (
 	"bytes"
 	"context"
+	crand "crypto/rand"
 	"encoding/binary"
 	"errors"
 	"io"
@@ -15,10 +16,14 @@ import (
 
 	"github.com/libp2p/go-libp2p/core/crypto"
 	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/peerstore"
 	"github.com/libp2p/go-libp2p/core/protocol"
 	"github.com/libp2p/go-libp2p/core/sec"
+	"github.com/libp2p/go-libp2p/core/test"
+	"github.com/libp2p/go-libp2p/p2p/host/peerstore/pstoremem"
 	"github.com/libp2p/go-libp2p/p2p/security/noise/pb"
 
+	"github.com/flynn/noise"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -713,3 +718,171 @@ func TestHandshakeWithTransportEarlyData(t *testing.T) {
 		})
 	}
 }
+
+func newTestIKTransport(t *testing.T, keyCache peerstore.PeerMetadata, opts ...SessionOption) (*SessionTransport, peer.ID) {
+	t.Helper()
+	priv, _, err := crypto.GenerateKeyPair(crypto.Ed25519, 256)
+	require.NoError(t, err)
+	tpt, err := New(ID, priv, nil)
+	require.NoError(t, err)
+	st, err := tpt.WithSessionOptions(append([]SessionOption{StaticKeyCache(keyCache)}, opts...)...)
+	require.NoError(t, err)
+	return st, tpt.localID
+}
+
+func connectSessions(t *testing.T, initTransport, respTransport *SessionTransport, respID peer.ID) (*secureSession, *secureSession) {
+	t.Helper()
+	init, resp := newConnPair(t)
+
+	var initConn sec.SecureConn
+	var initErr error
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		initConn, initErr = initTransport.SecureOutbound(context.Background(), init, respID)
+	}()
+
+	respConn, respErr := respTransport.SecureInbound(context.Background(), resp, "")
+	<-done
+	require.NoError(t, initErr)
+	require.NoError(t, respErr)
+
+	// check that the session keys match
+	before := []byte("hello world")
+	_, err := initConn.Write(before)
+	require.NoError(t, err)
+	after := make([]byte, len(before))
+	_, err = io.ReadFull(respConn, after)
+	require.NoError(t, err)
+	require.Equal(t, before, after)
+	_, err = respConn.Write(before)
+	require.NoError(t, err)
+	_, err = io.ReadFull(initConn, after)
+	require.NoError(t, err)
+	require.Equal(t, before, after)
+
+	return initConn.(*secureSession), respConn.(*secureSession)
+}
+
+func TestHandshakeIK(t *testing.T) {
+	initCache := pstoremem.NewPeerMetadata()
+	initTransport, initID := newTestIKTransport(t, initCache)
+	respTransport, respID := newTestIKTransport(t, pstoremem.NewPeerMetadata())
+
+	// The first handshake uses XX, and caches the static key of the responder.
+	initConn, respConn := connectSessions(t, initTransport, respTransport, respID)
+	require.Equal(t, "XX", initConn.handshakePattern)
+	require.Equal(t, "XX", respConn.handshakePattern)
+	require.Equal(t, respTransport.t.staticKey.Public, cachedStaticKey(initCache, respID))
+	initConn.Close()
+	respConn.Close()
+
+	// The next handshakes use IK.
+	for i := 0; i < 2; i++ {
+		initConn, respConn = connectSessions(t, initTransport, respTransport, respID)
+		require.Equal(t, "IK", initConn.handshakePattern)
+		require.Equal(t, "IK", respConn.handshakePattern)
+		require.Equal(t, respID, initConn.RemotePeer())
+		require.Equal(t, initID, respConn.RemotePeer())
+		initConn.Close()
+		respConn.Close()
+	}
+}
+
+func TestHandshakeIKFallback(t *testing.T) {
+	initCache := pstoremem.NewPeerMetadata()
+	initTransport, initID := newTestIKTransport(t, initCache)
+	respTransport, respID := newTestIKTransport(t, nil)
+
+	// The responder doesn't use the key we cached, for example because it
+	// restarted.
+	staleKey, err := noise.DH25519.GenerateKeypair(crand.Reader)
+	require.NoError(t, err)
+	require.NoError(t, initCache.Put(respID, staticKeyMetadataKey, staleKey.Public))
+
+	initConn, respConn := connectSessions(t, initTransport, respTransport, respID)
+	require.Equal(t, "XXfallback", initConn.handshakePattern)
+	require.Equal(t, "XXfallback", respConn.handshakePattern)
+	require.Equal(t, respID, initConn.RemotePeer())
+	require.Equal(t, initID, respConn.RemotePeer())
+	require.Equal(t, respTransport.t.staticKey.Public, cachedStaticKey(initCache, respID))
+	initConn.Close()
+	respConn.Close()
+
+	initConn, respConn = connectSessions(t, initTransport, respTransport, respID)
+	require.Equal(t, "IK", initConn.handshakePattern)
+	initConn.Close()
+	respConn.Close()
+}
+
+func TestHandshakeIKPeerIDMismatch(t *testing.T) {
+	initCache := pstoremem.NewPeerMetadata()
+	initTransport, _ := newTestIKTransport(t, initCache)
+	respTransport, respID := newTestIKTransport(t, nil)
+	initConn, respConn := connectSessions(t, initTransport, respTransport, respID)
+	initConn.Close()
+	respConn.Close()
+
+	// Another peer uses the cached static key.
+	otherID, err := test.RandPeerID()
+	require.NoError(t, err)
+	require.NoError(t, initCache.Put(otherID, staticKeyMetadataKey, cachedStaticKey(initCache, respID)))
+
+	init, resp := newConnPair(t)
+	go respTransport.SecureInbound(context.Background(), resp, "")
+	_, err = initTransport.SecureOutbound(context.Background(), init, otherID)
+	require.ErrorAs(t, err, &sec.ErrPeerIDMismatch{})
+	// The cached key is discarded.
+	require.Nil(t, cachedStaticKey(initCache, otherID))
+}
+
+func TestHandshakeIKNotCachedWithoutSupport(t *testing.T) {
+	initCache := pstoremem.NewPeerMetadata()
+	initTransport, _ := newTestIKTransport(t, initCache)
+	// This transport uses a new static key for every handshake.
+	tpt := newTestTransport(t, crypto.Ed25519, 2048)
+	respTransport, err := tpt.WithSessionOptions()
+	require.NoError(t, err)
+
+	for i := 0; i < 2; i++ {
+		initConn, respConn := connectSessions(t, initTransport, respTransport, tpt.localID)
+		require.Equal(t, "XX", initConn.handshakePattern)
+		initConn.Close()
+		respConn.Close()
+	}
+	require.Nil(t, cachedStaticKey(initCache, tpt.localID))
+}
+
+func TestHandshakeIKEarlyData(t *testing.T) {
+	var clientReceived, serverReceived *pb.NoiseExtensions
+	clientEDH := &earlyDataHandler{
+		send: func(context.Context, net.Conn, peer.ID) *pb.NoiseExtensions {
+			return &pb.NoiseExtensions{StreamMuxers: []string{"client"}}
+		},
+		received: func(_ context.Context, _ net.Conn, ext *pb.NoiseExtensions) error {
+			clientReceived = ext
+			return nil
+		},
+	}
+	serverEDH := &earlyDataHandler{
+		send: func(context.Context, net.Conn, peer.ID) *pb.NoiseExtensions {
+			return &pb.NoiseExtensions{StreamMuxers: []string{"server"}}
+		},
+		received: func(_ context.Context, _ net.Conn, ext *pb.NoiseExtensions) error {
+			serverReceived = ext
+			return nil
+		},
+	}
+	initTransport, _ := newTestIKTransport(t, pstoremem.NewPeerMetadata(), EarlyData(clientEDH, nil))
+	respTransport, respID := newTestIKTransport(t, nil, EarlyData(nil, serverEDH))
+
+	for _, pattern := range []string{"XX", "IK"} {
+		clientReceived, serverReceived = nil, nil
+		initConn, respConn := connectSessions(t, initTransport, respTransport, respID)
+		require.Equal(t, pattern, initConn.handshakePattern)
+		require.Equal(t, []string{"server"}, clientReceived.GetStreamMuxers())
+		require.Equal(t, []string{"client"}, serverReceived.GetStreamMuxers())
+		initConn.Close()
+		respConn.Close()
+	}
+}
@@ -0,0 +1,46 @@
+package libp2ptls
+
+import (
+	"crypto/tls"
+	"errors"
+
+	ci "github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/protocol"
+	tptu "github.com/libp2p/go-libp2p/p2p/net/upgrader"
+)
+
+// PQID is the protocol ID of the TLS transport using a hybrid post-quantum key
+// exchange (used when negotiating with multistream).
+const PQID = "/tls-pq/1.0.0"
+
+var errHybridUnsupported = errors.New("hybrid post-quantum key exchange requires Go 1.24 or later")
+
+// classicalCurves are the key exchanges used by the transport returned by New.
+var classicalCurves = []tls.CurveID{tls.X25519, tls.CurveP256, tls.CurveP384, tls.CurveP521}
+
+// NewPQ creates a TLS encrypted transport that only uses the hybrid
+// X25519MLKEM768 key exchange, combining X25519 with ML-KEM-768 (Kyber). The
+// session keys remain secure as long as either of them is unbroken. Peers are
+// still authenticated with their libp2p identity keys.
+//
+// The transport returned by New only uses classical key exchanges, whatever
+// the Go version and GODEBUG settings, so this transport can't handshake with
+// it, and the negotiated security protocol tells whether the hybrid key
+// exchange is used. It should be registered under PQID, alongside the
+// classical transport, so that peers only use it if both of them support it:
+//
+//	libp2p.ChainOptions(
+//		libp2p.Security(libp2ptls.PQID, libp2ptls.NewPQ),
+//		libp2p.Security(libp2ptls.ID, libp2ptls.New),
+//	)
+func NewPQ(id protocol.ID, key ci.PrivKey, muxers []tptu.StreamMuxer) (*Transport, error) {
+	if hybridCurves == nil {
+		return nil, errHybridUnsupported
+	}
+	t, err := New(id, key, muxers)
+	if err != nil {
+		return nil, err
+	}
+	t.curvePreferences = hybridCurves
+	return t, nil
+}
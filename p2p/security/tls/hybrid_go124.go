@@ -0,0 +1,7 @@
+//go:build go1.24
+
+package libp2ptls
+
+import "crypto/tls"
+
+var hybridCurves = []tls.CurveID{tls.X25519MLKEM768}
@@ -0,0 +1,120 @@
+//go:build go1.25
+
+// Enable the hybrid key exchange by default, as if go.mod required Go 1.24 or
+// later, to check that the classical transport doesn't use it anyway.
+//go:debug tlsmlkem=1
+
+package libp2ptls
+
+import (
+	"context"
+	"crypto/tls"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/sec"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestHybridKeyExchange(t *testing.T) {
+	clientID, clientKey := createPeer(t)
+	serverID, serverKey := createPeer(t)
+
+	handshake := func(t *testing.T, clientTransport, serverTransport *Transport) (clientConn, serverConn *conn) {
+		t.Helper()
+		clientInsecureConn, serverInsecureConn := connect(t)
+		serverConnChan := make(chan sec.SecureConn, 1)
+		go func() {
+			serverConn, err := serverTransport.SecureInbound(context.Background(), serverInsecureConn, "")
+			require.NoError(t, err)
+			serverConnChan <- serverConn
+		}()
+		c, err := clientTransport.SecureOutbound(context.Background(), clientInsecureConn, serverID)
+		require.NoError(t, err)
+		t.Cleanup(func() { c.Close() })
+		var s sec.SecureConn
+		select {
+		case s = <-serverConnChan:
+		case <-time.After(time.Second):
+			t.Fatal("expected the server to accept a connection")
+		}
+		t.Cleanup(func() { s.Close() })
+		require.Equal(t, serverID, c.RemotePeer())
+		require.Equal(t, clientID, s.RemotePeer())
+		return c.(*conn), s.(*conn)
+	}
+
+	t.Run("hybrid", func(t *testing.T) {
+		clientTransport, err := NewPQ(PQID, clientKey, nil)
+		require.NoError(t, err)
+		serverTransport, err := NewPQ(PQID, serverKey, nil)
+		require.NoError(t, err)
+		clientConn, serverConn := handshake(t, clientTransport, serverTransport)
+		require.Equal(t, tls.X25519MLKEM768, clientConn.ConnectionState().CurveID)
+		require.Equal(t, tls.X25519MLKEM768, serverConn.ConnectionState().CurveID)
+
+		_, err = serverConn.Write([]byte("foobar"))
+		require.NoError(t, err)
+		b := make([]byte, 6)
+		_, err = clientConn.Read(b)
+		require.NoError(t, err)
+		require.Equal(t, "foobar", string(b))
+	})
+
+	t.Run("classical", func(t *testing.T) {
+		clientTransport, err := New(ID, clientKey, nil)
+		require.NoError(t, err)
+		serverTransport, err := New(ID, serverKey, nil)
+		require.NoError(t, err)
+		clientConn, serverConn := handshake(t, clientTransport, serverTransport)
+		require.Equal(t, tls.X25519, clientConn.ConnectionState().CurveID)
+		require.Equal(t, tls.X25519, serverConn.ConnectionState().CurveID)
+	})
+}
+
+func TestHybridClassicalInterop(t *testing.T) {
+	_, clientKey := createPeer(t)
+	serverID, serverKey := createPeer(t)
+
+	// The transports don't have a key exchange in common.
+	handshake := func(t *testing.T, clientTransport, serverTransport *Transport, serverID peer.ID) (clientErr, serverErr error) {
+		clientInsecureConn, serverInsecureConn := connect(t)
+		serverErrChan := make(chan error, 1)
+		go func() {
+			conn, err := serverTransport.SecureInbound(context.Background(), serverInsecureConn, "")
+			if err == nil {
+				conn.Close()
+			}
+			serverErrChan <- err
+		}()
+		conn, err := clientTransport.SecureOutbound(context.Background(), clientInsecureConn, serverID)
+		if err == nil {
+			// TLS 1.3 clients only notice that the server rejected the handshake on Read.
+			_, err = conn.Read([]byte{0})
+			conn.Close()
+		}
+		return err, <-serverErrChan
+	}
+
+	pqClient, err := NewPQ(PQID, clientKey, nil)
+	require.NoError(t, err)
+	pqServer, err := NewPQ(PQID, serverKey, nil)
+	require.NoError(t, err)
+	classicalClient, err := New(ID, clientKey, nil)
+	require.NoError(t, err)
+	classicalServer, err := New(ID, serverKey, nil)
+	require.NoError(t, err)
+
+	t.Run("hybrid client, classical server", func(t *testing.T) {
+		clientErr, serverErr := handshake(t, pqClient, classicalServer, serverID)
+		require.Error(t, clientErr)
+		require.Error(t, serverErr)
+	})
+	t.Run("classical client, hybrid server", func(t *testing.T) {
+		clientErr, serverErr := handshake(t, classicalClient, pqServer, serverID)
+		require.Error(t, clientErr)
+		require.Error(t, serverErr)
+	})
+}
@@ -31,6 +31,9 @@ type Transport struct {
 	privKey    ci.PrivKey
 	muxers     []protocol.ID
 	protocolID protocol.ID
+
+	// curvePreferences are the key exchange mechanisms the transport uses
+	curvePreferences []tls.CurveID
 }
 
 var _ sec.SecureTransport = &Transport{}
@@ -50,6 +53,10 @@ func New(id protocol.ID, key ci.PrivKey, muxers []tptu.StreamMuxer) (*Transport,
 		localPeer:  localPeer,
 		privKey:    key,
 		muxers:     muxerIDs,
+		// Pin the classical key exchanges, so that the hybrid key exchange is
+		// only used by the transport returned by NewPQ, whatever the Go
+		// version and GODEBUG settings.
+		curvePreferences: classicalCurves,
 	}
 
 	identity, err := NewIdentity(key)
@@ -63,7 +70,7 @@ func New(id protocol.ID, key ci.PrivKey, muxers []tptu.StreamMuxer) (*Transport,
 // SecureInbound runs the TLS handshake as a server.
 // If p is empty, connections from any peer are accepted.
 func (t *Transport) SecureInbound(ctx context.Context, insecure net.Conn, p peer.ID) (sec.SecureConn, error) {
-	config, keyCh := t.identity.ConfigForPeer(p)
+	config, keyCh := t.configForPeer(p)
 	muxers := make([]string, 0, len(t.muxers))
 	for _, muxer := range t.muxers {
 		muxers = append(muxers, string(muxer))
@@ -108,7 +115,7 @@ func (t *Transport) SecureInbound(ctx context.Context, insecure net.Conn, p peer
 // If the handshake fails, the server will close the connection. The client will
 // notice this after 1 RTT when calling Read.
 func (t *Transport) SecureOutbound(ctx context.Context, insecure net.Conn, p peer.ID) (sec.SecureConn, error) {
-	config, keyCh := t.identity.ConfigForPeer(p)
+	config, keyCh := t.configForPeer(p)
 	muxers := make([]string, 0, len(t.muxers))
 	for _, muxer := range t.muxers {
 		muxers = append(muxers, (string)(muxer))
@@ -122,6 +129,12 @@ func (t *Transport) SecureOutbound(ctx context.Context, insecure net.Conn, p pee
 	return cs, err
 }
 
+func (t *Transport) configForPeer(p peer.ID) (*tls.Config, <-chan ci.PubKey) {
+	config, keyCh := t.identity.ConfigForPeer(p)
+	config.CurvePreferences = t.curvePreferences
+	return config, keyCh
+}
+
 func (t *Transport) handshake(ctx context.Context, tlsConn *tls.Conn, keyCh <-chan ci.PubKey) (_sconn sec.SecureConn, err error) {
 	defer func() {
 		if rerr := recover(); rerr != nil {
@@ -595,6 +595,88 @@ func TestDialerStreamResets(t *testing.T) {
 	}
 }
 
+func TestStreamErrorCode(t *testing.T) {
+	for _, tc := range transportsToTest {
+		t.Run(tc.Name, func(t *testing.T) {
+			h1 := tc.HostGenerator(t, TransportTestCaseOpts{})
+			h2 := tc.HostGenerator(t, TransportTestCaseOpts{NoListen: true})
+			defer h1.Close()
+			defer h2.Close()
+
+			require.NoError(t, h2.Connect(context.Background(), peer.AddrInfo{
+				ID:    h1.ID(),
+				Addrs: h1.Addrs(),
+			}))
+
+			errCh := make(chan error, 1)
+			h1.SetStreamHandler("reset", func(s network.Stream) {
+				s.ResetWithError(42)
+			})
+			h1.SetStreamHandler("echo", func(s network.Stream) {
+				_, err := io.Copy(s, s)
+				errCh <- err
+			})
+
+			// the listener resets the stream
+			// Depending on the transport, the reset is reported when negotiating the
+			// protocol, or when reading from the stream.
+			s, err := h2.NewStream(context.Background(), h1.ID(), "reset")
+			if err == nil {
+				_, err = s.Read([]byte{0})
+			}
+			require.ErrorIs(t, err, &network.StreamError{ErrorCode: 42, Remote: true})
+			require.ErrorIs(t, err, network.ErrReset)
+
+			// the dialer resets the stream
+			s, err = h2.NewStream(context.Background(), h1.ID(), "echo")
+			require.NoError(t, err)
+			_, err = s.Write([]byte("hello"))
+			require.NoError(t, err)
+			_, err = io.ReadFull(s, make([]byte, 5))
+			require.NoError(t, err)
+			require.NoError(t, s.ResetWithError(43))
+			_, err = s.Read([]byte{0})
+			require.ErrorIs(t, err, &network.StreamError{ErrorCode: 43, Remote: false})
+			select {
+			case err := <-errCh:
+				require.ErrorIs(t, err, &network.StreamError{ErrorCode: 43, Remote: true})
+			case <-time.After(5 * time.Second):
+				t.Fatal("expected the stream to be reset")
+			}
+		})
+	}
+}
+
+func TestConnErrorCode(t *testing.T) {
+	for _, tc := range transportsToTest {
+		t.Run(tc.Name, func(t *testing.T) {
+			if strings.Contains(tc.Name, "WebRTC") {
+				t.Skip("WebRTC can't send an error code when closing the connection")
+			}
+			h1 := tc.HostGenerator(t, TransportTestCaseOpts{})
+			h2 := tc.HostGenerator(t, TransportTestCaseOpts{NoListen: true})
+			defer h1.Close()
+			defer h2.Close()
+
+			require.NoError(t, h2.Connect(context.Background(), peer.AddrInfo{
+				ID:    h1.ID(),
+				Addrs: h1.Addrs(),
+			}))
+
+			h1.SetStreamHandler("close", func(s network.Stream) {
+				s.Conn().CloseWithError(42)
+			})
+
+			s, err := h2.NewStream(context.Background(), h1.ID(), "close")
+			if err == nil {
+				_, err = s.Read([]byte{0})
+			}
+			require.ErrorIs(t, err, &network.ConnError{ErrorCode: 42, Remote: true})
+			require.ErrorIs(t, err, network.ErrReset)
+		})
+	}
+}
+
 func TestStreamReadDeadline(t *testing.T) {
 	for _, tc := range transportsToTest {
 		t.Run(tc.Name, func(t *testing.T) {
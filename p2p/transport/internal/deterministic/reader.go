@@ -0,0 +1,54 @@
+// Package deterministic derives the certificate keys of the WebTransport and
+// WebRTC transports from the libp2p identity key.
+package deterministic
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"io"
+	"time"
+
+	ic "github.com/libp2p/go-libp2p/core/crypto"
+
+	"golang.org/x/crypto/hkdf"
+)
+
+// reader is a hack. It counter-acts the Go library's attempt at making ECDSA
+// key generation and signatures non-deterministic. Go adds non-determinism by
+// randomly dropping a single byte from the reader stream. This counteracts this
+// by detecting when a read is a single byte and using a different reader
+// instead.
+type reader struct {
+	reader           io.Reader
+	singleByteReader io.Reader
+}
+
+// NewReader returns a reader producing a stream of bytes derived from seed,
+// salt and info, using HKDF. ECDSA keys and signatures generated with it are
+// deterministic.
+func NewReader(seed []byte, salt []byte, info string) io.Reader {
+	return &reader{
+		reader:           hkdf.New(sha256.New, seed, salt, []byte(info)),
+		singleByteReader: hkdf.New(sha256.New, seed, salt, []byte(info+" single byte")),
+	}
+}
+
+func (r *reader) Read(p []byte) (n int, err error) {
+	if len(p) == 1 {
+		return r.singleByteReader.Read(p)
+	}
+	return r.reader.Read(p)
+}
+
+// NewCertReader returns a reader to generate the certificate valid from start
+// with, derived from key. info separates the certificates of different
+// transports.
+func NewCertReader(key ic.PrivKey, start time.Time, info string) (io.Reader, error) {
+	keyBytes, err := key.Raw()
+	if err != nil {
+		return nil, err
+	}
+	salt := make([]byte, 8)
+	binary.LittleEndian.PutUint64(salt, uint64(start.UnixNano()))
+	return NewReader(keyBytes, salt, info), nil
+}
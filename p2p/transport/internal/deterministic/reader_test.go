@@ -0,0 +1,52 @@
+package deterministic
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/sha256"
+	"crypto/x509"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// TestDeterministicSig tests that our hack around making ECDSA signatures
+// deterministic works. If this fails, this means we need to try another
+// strategy to make deterministic signatures or try something else entirely.
+// See reader for more context.
+func TestDeterministicSig(t *testing.T) {
+	// Run this test 1000 times since we want to make sure the signatures are deterministic
+	runs := 1000
+	for i := 0; i < runs; i++ {
+		zeroSeed := [32]byte{}
+		deterministicHKDFReader := NewReader(zeroSeed[:], nil, "determinisitic cert")
+		b := [1024]byte{}
+		io.ReadFull(deterministicHKDFReader, b[:])
+		caPrivateKey, err := ecdsa.GenerateKey(elliptic.P256(), deterministicHKDFReader)
+		require.NoError(t, err)
+
+		digest := sha256.Sum256(b[:])
+		sig, err := caPrivateKey.Sign(deterministicHKDFReader, digest[:], crypto.SHA256)
+		require.NoError(t, err)
+
+		deterministicHKDFReader = NewReader(zeroSeed[:], nil, "determinisitic cert")
+		b2 := [1024]byte{}
+		io.ReadFull(deterministicHKDFReader, b2[:])
+		caPrivateKey2, err := ecdsa.GenerateKey(elliptic.P256(), deterministicHKDFReader)
+		require.NoError(t, err)
+
+		digest2 := sha256.Sum256(b2[:])
+		sig2, err := caPrivateKey2.Sign(deterministicHKDFReader, digest2[:], crypto.SHA256)
+		require.NoError(t, err)
+
+		keyBytes, err := x509.MarshalECPrivateKey(caPrivateKey)
+		require.NoError(t, err)
+		keyBytes2, err := x509.MarshalECPrivateKey(caPrivateKey2)
+		require.NoError(t, err)
+
+		require.Equal(t, sig, sig2)
+		require.Equal(t, keyBytes, keyBytes2)
+	}
+}
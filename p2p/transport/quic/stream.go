@@ -2,6 +2,7 @@ package libp2pquic
 
 import (
 	"errors"
+	"math"
 
 	"github.com/libp2p/go-libp2p/core/network"
 
@@ -18,20 +19,45 @@ type stream struct {
 
 var _ network.MuxedStream = &stream{}
 
+func parseStreamError(err error) error {
+	if err == nil {
+		return err
+	}
+	se := &quic.StreamError{}
+	if errors.As(err, &se) {
+		code := network.StreamCodeOutOfRange
+		if se.ErrorCode <= math.MaxUint32 {
+			code = network.StreamErrorCode(se.ErrorCode)
+		}
+		return &network.StreamError{
+			ErrorCode:      code,
+			Remote:         se.Remote,
+			TransportError: se,
+		}
+	}
+	ae := &quic.ApplicationError{}
+	if errors.As(err, &ae) {
+		code := network.ConnCodeOutOfRange
+		if ae.ErrorCode <= math.MaxUint32 {
+			code = network.ConnErrorCode(ae.ErrorCode)
+		}
+		return &network.ConnError{
+			ErrorCode:      code,
+			Remote:         ae.Remote,
+			TransportError: ae,
+		}
+	}
+	return err
+}
+
 func (s *stream) Read(b []byte) (n int, err error) {
 	n, err = s.Stream.Read(b)
-	if err != nil && errors.Is(err, &quic.StreamError{}) {
-		err = network.ErrReset
-	}
-	return n, err
+	return n, parseStreamError(err)
 }
 
 func (s *stream) Write(b []byte) (n int, err error) {
 	n, err = s.Stream.Write(b)
-	if err != nil && errors.Is(err, &quic.StreamError{}) {
-		err = network.ErrReset
-	}
-	return n, err
+	return n, parseStreamError(err)
 }
 
 func (s *stream) Reset() error {
@@ -40,6 +66,12 @@ func (s *stream) Reset() error {
 	return nil
 }
 
+func (s *stream) ResetWithError(errCode network.StreamErrorCode) error {
+	s.Stream.CancelRead(quic.StreamErrorCode(errCode))
+	s.Stream.CancelWrite(quic.StreamErrorCode(errCode))
+	return nil
+}
+
 func (s *stream) Close() error {
 	s.Stream.CancelRead(reset)
 	return s.Stream.Close()
@@ -154,6 +154,26 @@ func (c *ConnManager) transportForListen(network string, laddr *net.UDPAddr) (re
 	}, nil
 }
 
+// SharedNonQUICPacketConn returns a net.PacketConn listening on laddr, that
+// shares its UDP socket with the QUIC listener on the same address. QUIC
+// packets are handled by QUIC, and all the other packets (those with the two
+// most significant bits of their first byte unset, such as STUN and DTLS
+// packets) are read from the returned conn. The QUIC listener can be started
+// before or after the returned conn.
+//
+// This allows running WebRTC-direct on the port used by QUIC and
+// WebTransport. It requires port reuse to be enabled.
+func (c *ConnManager) SharedNonQUICPacketConn(network string, laddr *net.UDPAddr) (net.PacketConn, error) {
+	if !c.enableReuseport {
+		return nil, errors.New("sharing the QUIC socket requires port reuse")
+	}
+	tr, err := c.transportForListen(network, laddr)
+	if err != nil {
+		return nil, err
+	}
+	return newNonQUICPacketConn(tr), nil
+}
+
 func (c *ConnManager) DialQUIC(ctx context.Context, raddr ma.Multiaddr, tlsConf *tls.Config, allowWindowIncrease func(conn quic.Connection, delta uint64) bool) (quic.Connection, error) {
 	naddr, v, err := FromQuicMultiaddr(raddr)
 	if err != nil {
@@ -259,3 +259,63 @@ func testListener(t *testing.T, enableReuseport bool) {
 
 	checkClosed(t, cm)
 }
+
+func TestSharedNonQUICPacketConn(t *testing.T) {
+	cm, err := NewConnManager(quic.StatelessResetKey{}, quic.TokenGeneratorKey{})
+	require.NoError(t, err)
+	defer checkClosed(t, cm)
+	defer cm.Close()
+
+	conn, err := cm.SharedNonQUICPacketConn("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	require.NoError(t, err)
+	port := conn.LocalAddr().(*net.UDPAddr).Port
+
+	// QUIC listens on the same port
+	ln, err := cm.ListenQUIC(ma.StringCast(fmt.Sprintf("/ip4/127.0.0.1/udp/%d/quic-v1", port)), &tls.Config{NextProtos: []string{"proto"}}, nil)
+	require.NoError(t, err)
+	require.Equal(t, port, ln.Addr().(*net.UDPAddr).Port)
+
+	peer, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	require.NoError(t, err)
+	defer peer.Close()
+
+	// The first two bits of a STUN packet are 0.
+	stun := []byte{0x00, 0x01, 0x00, 0x00, 0x21, 0x12, 0xa4, 0x42}
+	_, err = peer.WriteTo(stun, conn.LocalAddr())
+	require.NoError(t, err)
+	buf := make([]byte, 1500)
+	require.NoError(t, conn.SetReadDeadline(time.Now().Add(5*time.Second)))
+	n, addr, err := conn.ReadFrom(buf)
+	require.NoError(t, err)
+	require.Equal(t, stun, buf[:n])
+	require.Equal(t, peer.LocalAddr().String(), addr.String())
+
+	// Packets are sent from the shared socket.
+	_, err = conn.WriteTo([]byte("foobar"), peer.LocalAddr())
+	require.NoError(t, err)
+	require.NoError(t, peer.SetReadDeadline(time.Now().Add(5*time.Second)))
+	n, addr, err = peer.ReadFrom(buf)
+	require.NoError(t, err)
+	require.Equal(t, "foobar", string(buf[:n]))
+	require.Equal(t, port, addr.(*net.UDPAddr).Port)
+
+	// Read deadlines are respected.
+	require.NoError(t, conn.SetReadDeadline(time.Now().Add(10*time.Millisecond)))
+	_, _, err = conn.ReadFrom(buf)
+	var nerr net.Error
+	require.ErrorAs(t, err, &nerr)
+	require.True(t, nerr.Timeout())
+
+	require.NoError(t, ln.Close())
+	require.NoError(t, conn.Close())
+	_, _, err = conn.ReadFrom(buf)
+	require.ErrorIs(t, err, net.ErrClosed)
+}
+
+func TestSharedNonQUICPacketConnRequiresReuseport(t *testing.T) {
+	cm, err := NewConnManager(quic.StatelessResetKey{}, quic.TokenGeneratorKey{}, DisableReuseport())
+	require.NoError(t, err)
+	defer cm.Close()
+	_, err = cm.SharedNonQUICPacketConn("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	require.Error(t, err)
+}
@@ -0,0 +1,109 @@
+package quicreuse
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"time"
+)
+
+// nonQUICPacketConn is a net.PacketConn that reads the non-QUIC packets
+// received on a QUIC transport, and writes packets around QUIC on the same
+// socket. It lets other protocols, like the STUN and DTLS packets of
+// WebRTC-direct, share the UDP port used by QUIC.
+type nonQUICPacketConn struct {
+	tr refCountedQuicTransport
+
+	ctx       context.Context
+	cancel    context.CancelFunc
+	closeOnce sync.Once
+
+	mx           sync.Mutex
+	readDeadline time.Time
+}
+
+var _ net.PacketConn = &nonQUICPacketConn{}
+
+func newNonQUICPacketConn(tr refCountedQuicTransport) *nonQUICPacketConn {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &nonQUICPacketConn{tr: tr, ctx: ctx, cancel: cancel}
+}
+
+// ReadFrom implements net.PacketConn.
+func (c *nonQUICPacketConn) ReadFrom(p []byte) (int, net.Addr, error) {
+	ctx := c.ctx
+	c.mx.Lock()
+	deadline := c.readDeadline
+	c.mx.Unlock()
+	if !deadline.IsZero() {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithDeadline(ctx, deadline)
+		defer cancel()
+	}
+
+	n, addr, err := c.tr.ReadNonQUICPacket(ctx, p)
+	if err != nil {
+		if c.ctx.Err() != nil {
+			return 0, nil, net.ErrClosed
+		}
+		if errors.Is(err, context.DeadlineExceeded) {
+			return 0, nil, errTimeout{}
+		}
+		// the transport was closed
+		return 0, nil, net.ErrClosed
+	}
+	return n, addr, nil
+}
+
+// WriteTo implements net.PacketConn.
+func (c *nonQUICPacketConn) WriteTo(p []byte, addr net.Addr) (int, error) {
+	if c.ctx.Err() != nil {
+		return 0, net.ErrClosed
+	}
+	return c.tr.WriteTo(p, addr)
+}
+
+// Close implements net.PacketConn. It releases the QUIC transport, which is
+// closed once it's used by neither a QUIC listener nor a QUIC connection.
+func (c *nonQUICPacketConn) Close() error {
+	c.closeOnce.Do(func() {
+		c.cancel()
+		c.tr.DecreaseCount()
+	})
+	return nil
+}
+
+// LocalAddr implements net.PacketConn.
+func (c *nonQUICPacketConn) LocalAddr() net.Addr {
+	return c.tr.LocalAddr()
+}
+
+// SetDeadline implements net.PacketConn. Only the read deadline is supported:
+// writes never block.
+func (c *nonQUICPacketConn) SetDeadline(t time.Time) error {
+	return c.SetReadDeadline(t)
+}
+
+// SetReadDeadline implements net.PacketConn. The deadline applies to the calls
+// to ReadFrom made after it is set.
+func (c *nonQUICPacketConn) SetReadDeadline(t time.Time) error {
+	c.mx.Lock()
+	c.readDeadline = t
+	c.mx.Unlock()
+	return nil
+}
+
+// SetWriteDeadline implements net.PacketConn. It is a no-op.
+func (c *nonQUICPacketConn) SetWriteDeadline(t time.Time) error {
+	return nil
+}
+
+// errTimeout is returned by ReadFrom when the read deadline is exceeded.
+type errTimeout struct{}
+
+func (errTimeout) Error() string   { return "i/o timeout" }
+func (errTimeout) Timeout() bool   { return true }
+func (errTimeout) Temporary() bool { return true }
+
+var _ net.Error = errTimeout{}
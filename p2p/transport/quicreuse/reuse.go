@@ -24,6 +24,10 @@ type refCountedQuicTransport interface {
 	DecreaseCount()
 	IncreaseCount()
 
+	// Used to read the non-QUIC packets received on the socket, see
+	// nonQUICPacketConn.
+	ReadNonQUICPacket(ctx context.Context, b []byte) (int, net.Addr, error)
+
 	Dial(ctx context.Context, addr net.Addr, tlsConf *tls.Config, conf *quic.Config) (quic.Connection, error)
 	Listen(tlsConf *tls.Config, conf *quic.Config) (*quic.Listener, error)
 }
@@ -310,6 +314,22 @@ func (r *reuse) TransportForListen(network string, laddr *net.UDPAddr) (*refcoun
 		}
 	}
 
+	// Reuse a transport that is already listening on the requested address.
+	// This happens when the socket is shared between QUIC and another
+	// protocol, see ConnManager.SharedNonQUICPacketConn.
+	if laddr.Port != 0 {
+		var tr *refcountedTransport
+		if laddr.IP.IsUnspecified() {
+			tr = r.globalListeners[laddr.Port]
+		} else if trs, ok := r.unicast[laddr.IP.String()]; ok {
+			tr = trs[laddr.Port]
+		}
+		if tr != nil {
+			tr.IncreaseCount()
+			return tr, nil
+		}
+	}
+
 	conn, err := net.ListenUDP(network, laddr)
 	if err != nil {
 		return nil, err
@@ -238,3 +238,22 @@ func TestReuseGarbageCollect(t *testing.T) {
 	}
 	require.Eventually(t, func() bool { return numGlobals() == 0 }, 4*garbageCollectInterval, 10*time.Millisecond)
 }
+
+func TestReuseListenOnSameAddr(t *testing.T) {
+	reuse := newReuse(nil, nil)
+	cleanup(t, reuse)
+
+	tr1, err := reuse.TransportForListen("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	require.NoError(t, err)
+	tr2, err := reuse.TransportForListen("udp4", tr1.LocalAddr().(*net.UDPAddr))
+	require.NoError(t, err)
+	require.Equal(t, tr1, tr2)
+	require.Equal(t, 2, tr1.GetCount())
+
+	tr3, err := reuse.TransportForListen("udp4", &net.UDPAddr{IP: net.IPv4zero})
+	require.NoError(t, err)
+	tr4, err := reuse.TransportForListen("udp4", tr3.LocalAddr().(*net.UDPAddr))
+	require.NoError(t, err)
+	require.Equal(t, tr3, tr4)
+	require.Equal(t, 2, tr3.GetCount())
+}
@@ -0,0 +1,418 @@
+package libp2pwebrtc
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"strconv"
+	"sync"
+	"time"
+
+	ic "github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/p2p/transport/internal/deterministic"
+
+	ds "github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-datastore/namespace"
+	"github.com/ipfs/go-datastore/query"
+	ma "github.com/multiformats/go-multiaddr"
+	"github.com/pion/webrtc/v3"
+)
+
+const (
+	// certRotationMargin is how long before its expiry we stop using a
+	// certificate. pion refuses to create peer connections with an expired
+	// certificate, and we allow for a bit of clock skew.
+	certRotationMargin = time.Hour
+	// certRetryInterval is how long the current certificate keeps being used
+	// after the certificate provider failed, before we ask it again.
+	certRetryInterval = time.Minute
+)
+
+// certNeverExpires is the expiry time of the certificates that are not
+// rotated.
+var certNeverExpires = time.Date(9999, 12, 31, 23, 59, 59, 0, time.UTC)
+
+// A CertificateProvider provides the DTLS certificates of the transport. The
+// webrtc-direct listen addresses carry the hash of the certificate, so
+// changing it changes the addresses of the node.
+//
+// A rotated certificate is replaced one hour before it expires. While the
+// replacement is known, the listen addresses contain a second certhash
+// component with its hash, after the hash of the certificate in use: dialers
+// holding these addresses keep working once the replacement is in use.
+type CertificateProvider interface {
+	// Certificates returns the certificate in use at now, which must not
+	// expire within the next hour, and its replacement, or nil if the
+	// certificate is never replaced. It must keep returning the same
+	// certificates until the one in use is replaced, otherwise the listen
+	// addresses change.
+	Certificates(now time.Time) (current, next *webrtc.Certificate, err error)
+}
+
+// WithCertificateProvider configures the transport to use the certificates
+// provided by p. By default, a new certificate is generated every time the
+// transport is constructed, so the certificate hashes in the listen addresses
+// change on every restart.
+func WithCertificateProvider(p CertificateProvider) Option {
+	return func(t *WebRTCTransport) error {
+		if p == nil {
+			return errors.New("certificate provider must not be nil")
+		}
+		t.certProvider = p
+		return nil
+	}
+}
+
+// WithCertificate configures the transport to always use the certificate c.
+// The certificate isn't rotated, it is up to the caller to provide a
+// certificate that doesn't expire while the transport is in use.
+func WithCertificate(c webrtc.Certificate) Option {
+	return WithCertificateProvider(&staticCertProvider{cert: c})
+}
+
+type staticCertProvider struct {
+	cert webrtc.Certificate
+}
+
+func (p *staticCertProvider) Certificates(time.Time) (current, next *webrtc.Certificate, err error) {
+	return &p.cert, nil, nil
+}
+
+// certPeriodStart returns the start of the rotation period now is in. The
+// periods start at offset after the unix epoch, and last period.
+func certPeriodStart(now time.Time, period, offset time.Duration) time.Time {
+	n := (now.UnixMilli() - offset.Milliseconds()) / period.Milliseconds()
+	if now.UnixMilli() < offset.Milliseconds() {
+		n--
+	}
+	return time.UnixMilli(offset.Milliseconds() + n*period.Milliseconds())
+}
+
+// certValidity returns the validity of the certificate used during the
+// rotation period starting at start. If period is zero, the certificate isn't
+// rotated.
+func certValidity(start time.Time, period time.Duration) (notBefore, notAfter time.Time) {
+	if period == 0 {
+		return time.Unix(0, 0), certNeverExpires
+	}
+	// The certificate is used until certRotationMargin before its expiry.
+	return start.Add(-period), start.Add(period + certRotationMargin)
+}
+
+// certificatesForPeriods returns the certificates for the rotation period now
+// is in and the next one, using get to get the certificate of a period.
+func certificatesForPeriods(now time.Time, period, offset time.Duration, get func(start time.Time) (*webrtc.Certificate, error)) (current, next *webrtc.Certificate, err error) {
+	if period == 0 {
+		current, err = get(time.Time{})
+		return current, nil, err
+	}
+	start := certPeriodStart(now, period, offset)
+	current, err = get(start)
+	if err != nil {
+		return nil, nil, err
+	}
+	next, err = get(start.Add(period))
+	if err != nil {
+		return nil, nil, err
+	}
+	return current, next, nil
+}
+
+func checkRotationPeriod(period time.Duration) error {
+	if period != 0 && period < 2*certRotationMargin {
+		return fmt.Errorf("certificate rotation period must be zero or at least %s", 2*certRotationMargin)
+	}
+	return nil
+}
+
+type deterministicCertProvider struct {
+	key    ic.PrivKey
+	period time.Duration
+	offset time.Duration
+
+	mx    sync.Mutex
+	certs map[int64]*webrtc.Certificate // by period start
+}
+
+// NewDeterministicCertificateProvider returns a provider that derives the
+// certificates from key, so the listen addresses survive restarts and are
+// shared by all the nodes using key, with no state to store.
+//
+// If rotationPeriod is zero, the certificate is never rotated, and the
+// certificate hash in the listen addresses never changes. Otherwise, a new
+// certificate is used every rotationPeriod. Each key starts its periods at a
+// different offset, to spread the rotations of the nodes of the network.
+func NewDeterministicCertificateProvider(key ic.PrivKey, rotationPeriod time.Duration) (CertificateProvider, error) {
+	if err := checkRotationPeriod(rotationPeriod); err != nil {
+		return nil, err
+	}
+	p := &deterministicCertProvider{
+		key:    key,
+		period: rotationPeriod,
+		certs:  make(map[int64]*webrtc.Certificate),
+	}
+	if rotationPeriod != 0 {
+		pubkeyBytes, err := key.GetPublic().Raw()
+		if err != nil {
+			return nil, err
+		}
+		p.offset = time.Duration(binary.LittleEndian.Uint16(pubkeyBytes)) * time.Minute % rotationPeriod
+	}
+	return p, nil
+}
+
+func (p *deterministicCertProvider) Certificates(now time.Time) (current, next *webrtc.Certificate, err error) {
+	p.mx.Lock()
+	defer p.mx.Unlock()
+	current, next, err = certificatesForPeriods(now, p.period, p.offset, p.certificate)
+	if err != nil {
+		return nil, nil, err
+	}
+	// Forget the certificates of the periods that are over.
+	if p.period != 0 {
+		start := certPeriodStart(now, p.period, p.offset).UnixNano()
+		for k := range p.certs {
+			if k < start {
+				delete(p.certs, k)
+			}
+		}
+	}
+	return current, next, nil
+}
+
+func (p *deterministicCertProvider) certificate(start time.Time) (*webrtc.Certificate, error) {
+	if c, ok := p.certs[start.UnixNano()]; ok {
+		return c, nil
+	}
+	r, err := deterministic.NewCertReader(p.key, start, "webrtc-direct certificate")
+	if err != nil {
+		return nil, err
+	}
+	notBefore, notAfter := certValidity(start, p.period)
+	c, err := generateCertificate(r, notBefore, notAfter)
+	if err != nil {
+		return nil, err
+	}
+	p.certs[start.UnixNano()] = c
+	return c, nil
+}
+
+const certDatastoreNamespace = "/libp2p/transport/webrtc/certs"
+
+type datastoreCertProvider struct {
+	ds     ds.Datastore
+	period time.Duration
+
+	mx sync.Mutex
+}
+
+// NewDatastoreCertificateProvider returns a provider that generates
+// certificates with random keys, and persists them in d, so that they survive
+// restarts.
+//
+// If rotationPeriod is zero, the certificate is never rotated, and the
+// certificate hash in the listen addresses never changes. Otherwise, a new
+// certificate is used every rotationPeriod.
+//
+// Replicas sharing the same identity can share the datastore to use the same
+// certificates. The certificates are generated by the first replica that
+// needs them: replicas shouldn't be started at the same time as the very first
+// one, nor be stopped for longer than a rotation period.
+func NewDatastoreCertificateProvider(d ds.Datastore, rotationPeriod time.Duration) (CertificateProvider, error) {
+	if d == nil {
+		return nil, errors.New("certificate datastore must not be nil")
+	}
+	if err := checkRotationPeriod(rotationPeriod); err != nil {
+		return nil, err
+	}
+	return &datastoreCertProvider{
+		ds:     namespace.Wrap(d, ds.NewKey(certDatastoreNamespace)),
+		period: rotationPeriod,
+	}, nil
+}
+
+func certDatastoreKey(start time.Time) ds.Key {
+	if start.IsZero() {
+		return ds.NewKey("static")
+	}
+	return ds.NewKey(strconv.FormatInt(start.Unix(), 10))
+}
+
+func (p *datastoreCertProvider) Certificates(now time.Time) (current, next *webrtc.Certificate, err error) {
+	p.mx.Lock()
+	defer p.mx.Unlock()
+
+	ctx := context.Background()
+	current, next, err = certificatesForPeriods(now, p.period, 0, func(start time.Time) (*webrtc.Certificate, error) {
+		return p.certificate(ctx, start)
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	if p.period != 0 {
+		p.deleteBefore(ctx, certPeriodStart(now, p.period, 0))
+	}
+	return current, next, nil
+}
+
+// certificate returns the certificate of the period starting at start,
+// generating it if needed.
+func (p *datastoreCertProvider) certificate(ctx context.Context, start time.Time) (*webrtc.Certificate, error) {
+	key := certDatastoreKey(start)
+	b, err := p.ds.Get(ctx, key)
+	if err == nil {
+		return webrtc.CertificateFromPEM(string(b))
+	}
+	if !errors.Is(err, ds.ErrNotFound) {
+		return nil, err
+	}
+
+	notBefore, notAfter := certValidity(start, p.period)
+	c, err := generateCertificate(rand.Reader, notBefore, notAfter)
+	if err != nil {
+		return nil, err
+	}
+	pem, err := c.PEM()
+	if err != nil {
+		return nil, err
+	}
+	if err := p.ds.Put(ctx, key, []byte(pem)); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// deleteBefore deletes the certificates of the periods that started before
+// start.
+func (p *datastoreCertProvider) deleteBefore(ctx context.Context, start time.Time) {
+	res, err := p.ds.Query(ctx, query.Query{KeysOnly: true})
+	if err != nil {
+		log.Debugw("failed to query certificates", "error", err)
+		return
+	}
+	defer res.Close()
+	for r := range res.Next() {
+		if r.Error != nil {
+			log.Debugw("failed to query certificates", "error", r.Error)
+			return
+		}
+		k := ds.RawKey(r.Key)
+		s, err := strconv.ParseInt(k.Name(), 10, 64)
+		if err != nil || s >= start.Unix() {
+			continue
+		}
+		if err := p.ds.Delete(ctx, k); err != nil {
+			log.Debugw("failed to delete expired certificate", "key", k, "error", err)
+		}
+	}
+}
+
+// generateCertificate generates a self-signed ECDSA P-256 certificate, using
+// rand as the source of randomness.
+func generateCertificate(rand io.Reader, notBefore, notAfter time.Time) (*webrtc.Certificate, error) {
+	// We use elliptic P-256 since it is widely supported by browsers.
+	// See newCertificate for details.
+	serial := make([]byte, 16)
+	if _, err := io.ReadFull(rand, serial); err != nil {
+		return nil, err
+	}
+	pk, err := ecdsa.GenerateKey(elliptic.P256(), rand)
+	if err != nil {
+		return nil, fmt.Errorf("generate key for cert: %w", err)
+	}
+	tpl := &x509.Certificate{
+		SerialNumber:       new(big.Int).SetBytes(serial),
+		Issuer:             pkix.Name{CommonName: "libp2p"},
+		Subject:            pkix.Name{CommonName: "libp2p"},
+		NotBefore:          notBefore,
+		NotAfter:           notAfter,
+		SignatureAlgorithm: x509.ECDSAWithSHA256,
+	}
+	der, err := x509.CreateCertificate(rand, tpl, tpl, pk.Public(), pk)
+	if err != nil {
+		return nil, fmt.Errorf("generate certificate: %w", err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, err
+	}
+	c := webrtc.CertificateFromX509(pk, cert)
+	return &c, nil
+}
+
+// certState holds the certificates the transport is using.
+type certState struct {
+	mx          sync.Mutex
+	current     *webrtc.Certificate
+	next        *webrtc.Certificate
+	certHashes  ma.Multiaddr
+	refreshTime time.Time // when to get the certificates again
+}
+
+// certificate returns the certificate to use for new connections, and the
+// certhash components to add to the listen addresses. The certificates are
+// refreshed if the current one is about to expire.
+func (t *WebRTCTransport) certificate() (webrtc.Certificate, ma.Multiaddr, error) {
+	s := &t.certs
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	now := time.Now()
+	if s.current == nil || !now.Before(s.refreshTime) {
+		if err := t.refreshCertificates(now); err != nil {
+			if s.current == nil || !now.Before(s.current.Expires()) {
+				return webrtc.Certificate{}, nil, err
+			}
+			log.Errorw("failed to rotate certificate", "error", err)
+			s.refreshTime = now.Add(certRetryInterval)
+		}
+	}
+	return *s.current, s.certHashes, nil
+}
+
+func (t *WebRTCTransport) refreshCertificates(now time.Time) error {
+	s := &t.certs
+	current, next, err := t.certProvider.Certificates(now)
+	if err != nil {
+		return err
+	}
+	refresh := current.Expires().Add(-certRotationMargin)
+	if !now.Before(refresh) {
+		return fmt.Errorf("current certificate expires too soon (%s)", current.Expires())
+	}
+	certHashes, err := certHashComponent(current)
+	if err != nil {
+		return err
+	}
+	if next != nil {
+		comp, err := certHashComponent(next)
+		if err != nil {
+			return err
+		}
+		certHashes = certHashes.Encapsulate(comp)
+	}
+	s.current, s.next, s.certHashes, s.refreshTime = current, next, certHashes, refresh
+	return nil
+}
+
+// certHashComponent returns the certhash component of the address of a
+// listener using c.
+func certHashComponent(c *webrtc.Certificate) (ma.Multiaddr, error) {
+	fps, err := c.GetFingerprints()
+	if err != nil {
+		return nil, err
+	}
+	encoded, err := encodeDTLSFingerprint(fps[0])
+	if err != nil {
+		return nil, err
+	}
+	return ma.NewComponent(ma.ProtocolWithCode(ma.P_CERTHASH).Name, encoded)
+}
@@ -0,0 +1,217 @@
+package libp2pwebrtc
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/network"
+	tpt "github.com/libp2p/go-libp2p/core/transport"
+
+	ds "github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-datastore/query"
+	ma "github.com/multiformats/go-multiaddr"
+	"github.com/pion/webrtc/v3"
+	"github.com/stretchr/testify/require"
+)
+
+func certHashes(t *testing.T, addr ma.Multiaddr) []string {
+	t.Helper()
+	var hashes []string
+	ma.ForEach(addr, func(c ma.Component) bool {
+		if c.Protocol().Code == ma.P_CERTHASH {
+			hashes = append(hashes, c.Value())
+		}
+		return true
+	})
+	return hashes
+}
+
+func certHashOf(t *testing.T, c *webrtc.Certificate) string {
+	t.Helper()
+	comp, err := certHashComponent(c)
+	require.NoError(t, err)
+	return certHashes(t, comp)[0]
+}
+
+func TestDeterministicCertificateStable(t *testing.T) {
+	privKey, _, err := crypto.GenerateKeyPair(crypto.Ed25519, -1)
+	require.NoError(t, err)
+
+	var addrs []ma.Multiaddr
+	for i := 0; i < 2; i++ {
+		p, err := NewDeterministicCertificateProvider(privKey, 0)
+		require.NoError(t, err)
+		tr, err := New(privKey, nil, nil, &network.NullResourceManager{}, WithCertificateProvider(p))
+		require.NoError(t, err)
+		ln, err := tr.Listen(ma.StringCast("/ip4/127.0.0.1/udp/0/webrtc-direct"))
+		require.NoError(t, err)
+		addrs = append(addrs, ln.Multiaddr())
+		ln.Close()
+	}
+	require.Len(t, certHashes(t, addrs[0]), 1)
+	require.Equal(t, certHashes(t, addrs[0]), certHashes(t, addrs[1]))
+
+	// a different key results in a different certificate
+	otherKey, _, err := crypto.GenerateKeyPair(crypto.Ed25519, -1)
+	require.NoError(t, err)
+	p, err := NewDeterministicCertificateProvider(otherKey, 0)
+	require.NoError(t, err)
+	c, _, err := p.Certificates(time.Now())
+	require.NoError(t, err)
+	require.NotEqual(t, certHashes(t, addrs[0])[0], certHashOf(t, c))
+}
+
+func TestDeterministicCertificateRotation(t *testing.T) {
+	privKey, _, err := crypto.GenerateKeyPair(crypto.Ed25519, -1)
+	require.NoError(t, err)
+	const period = 24 * time.Hour
+	p, err := NewDeterministicCertificateProvider(privKey, period)
+	require.NoError(t, err)
+
+	now := time.Now()
+	current, next, err := p.Certificates(now)
+	require.NoError(t, err)
+	require.NotNil(t, next)
+	require.NotEqual(t, certHashOf(t, current), certHashOf(t, next))
+	require.Greater(t, current.Expires().Sub(now), certRotationMargin)
+	require.Greater(t, next.Expires(), current.Expires())
+
+	// Once the current certificate is phased out, the next one is used.
+	switchover := current.Expires().Add(-certRotationMargin)
+	c, _, err := p.Certificates(switchover.Add(-time.Second))
+	require.NoError(t, err)
+	require.Equal(t, certHashOf(t, current), certHashOf(t, c))
+	c, _, err = p.Certificates(switchover)
+	require.NoError(t, err)
+	require.Equal(t, certHashOf(t, next), certHashOf(t, c))
+
+	// Another provider derives the same certificates.
+	p2, err := NewDeterministicCertificateProvider(privKey, period)
+	require.NoError(t, err)
+	current2, next2, err := p2.Certificates(now)
+	require.NoError(t, err)
+	require.Equal(t, certHashOf(t, current), certHashOf(t, current2))
+	require.Equal(t, certHashOf(t, next), certHashOf(t, next2))
+
+	_, err = NewDeterministicCertificateProvider(privKey, time.Hour)
+	require.Error(t, err)
+}
+
+func TestDatastoreCertificatePersistence(t *testing.T) {
+	d := ds.NewMapDatastore()
+	const period = 24 * time.Hour
+	p, err := NewDatastoreCertificateProvider(d, period)
+	require.NoError(t, err)
+
+	now := time.Now()
+	current, next, err := p.Certificates(now)
+	require.NoError(t, err)
+	require.NotNil(t, next)
+	require.NotEqual(t, certHashOf(t, current), certHashOf(t, next))
+
+	// A restarted node uses the same certificates.
+	p2, err := NewDatastoreCertificateProvider(d, period)
+	require.NoError(t, err)
+	current2, next2, err := p2.Certificates(now)
+	require.NoError(t, err)
+	require.Equal(t, certHashOf(t, current), certHashOf(t, current2))
+	require.Equal(t, certHashOf(t, next), certHashOf(t, next2))
+
+	// After the rotation, the old certificate is deleted.
+	current3, _, err := p2.Certificates(current.Expires().Add(-certRotationMargin))
+	require.NoError(t, err)
+	require.Equal(t, certHashOf(t, next), certHashOf(t, current3))
+	res, err := d.Query(context.Background(), query.Query{KeysOnly: true})
+	require.NoError(t, err)
+	entries, err := res.Rest()
+	require.NoError(t, err)
+	require.Len(t, entries, 2)
+
+	// A certificate that is never rotated is persisted as well.
+	p3, err := NewDatastoreCertificateProvider(d, 0)
+	require.NoError(t, err)
+	static, next, err := p3.Certificates(now)
+	require.NoError(t, err)
+	require.Nil(t, next)
+	static2, _, err := p3.Certificates(now.Add(365 * 24 * time.Hour))
+	require.NoError(t, err)
+	require.Equal(t, certHashOf(t, static), certHashOf(t, static2))
+}
+
+// rotatingCertProvider switches from its first to its second certificate
+// once the first one is phased out.
+type rotatingCertProvider struct {
+	first, second *webrtc.Certificate
+}
+
+func (p *rotatingCertProvider) Certificates(now time.Time) (current, next *webrtc.Certificate, err error) {
+	if now.Before(p.first.Expires().Add(-certRotationMargin)) {
+		return p.first, p.second, nil
+	}
+	return p.second, nil, nil
+}
+
+func TestTransportCertificateRotation(t *testing.T) {
+	now := time.Now()
+	first, err := generateCertificate(rand.Reader, now.Add(-time.Hour), now.Add(certRotationMargin+2*time.Second))
+	require.NoError(t, err)
+	second, err := generateCertificate(rand.Reader, now.Add(-time.Hour), now.Add(24*time.Hour))
+	require.NoError(t, err)
+	p := &rotatingCertProvider{first: first, second: second}
+
+	tr, listeningPeer := getTransport(t, WithCertificateProvider(p))
+	tr1, _ := getTransport(t)
+	ln, err := tr.Listen(ma.StringCast("/ip4/127.0.0.1/udp/0/webrtc-direct"))
+	require.NoError(t, err)
+	defer ln.Close()
+
+	// During the transition, both certificate hashes are advertised.
+	addr := ln.Multiaddr()
+	require.Equal(t, []string{certHashOf(t, first), certHashOf(t, second)}, certHashes(t, addr))
+	require.True(t, tr1.CanDial(addr))
+
+	dial := func(addr ma.Multiaddr) error {
+		accepted := make(chan tpt.CapableConn, 1)
+		go func() {
+			conn, err := ln.Accept()
+			if err == nil {
+				accepted <- conn
+			}
+		}()
+		conn, err := tr1.Dial(context.Background(), addr, listeningPeer)
+		if err != nil {
+			return err
+		}
+		defer conn.Close()
+		select {
+		case c := <-accepted:
+			c.Close()
+			return nil
+		case <-time.After(10 * time.Second):
+			return errors.New("accept timed out")
+		}
+	}
+	require.NoError(t, dial(addr))
+
+	require.Eventually(t, func() bool {
+		return len(certHashes(t, ln.Multiaddr())) == 1
+	}, 5*time.Second, 50*time.Millisecond)
+	require.Equal(t, []string{certHashOf(t, second)}, certHashes(t, ln.Multiaddr()))
+
+	// Clients that learnt the address before the rotation can still connect.
+	require.NoError(t, dial(addr))
+	require.NoError(t, dial(ln.Multiaddr()))
+}
+
+func TestCanDialMultipleCerthashes(t *testing.T) {
+	tr, _ := getTransport(t)
+	const hash = "uEiDDq4_xNyDorZBH3TlGazyJdOWSwvo4PUo5YHFMrvDE8g"
+	require.True(t, tr.CanDial(ma.StringCast("/ip4/1.2.3.4/udp/1234/webrtc-direct/certhash/"+hash)))
+	require.True(t, tr.CanDial(ma.StringCast("/ip4/1.2.3.4/udp/1234/webrtc-direct/certhash/"+hash+"/certhash/"+hash)))
+	require.False(t, tr.CanDial(ma.StringCast("/ip4/1.2.3.4/udp/1234/webrtc-direct")))
+	require.False(t, tr.CanDial(ma.StringCast("/ip4/1.2.3.4/udp/1234/certhash/"+hash+"/certhash/"+hash)))
+}
@@ -38,7 +38,7 @@ type dataChannel struct {
 
 type connection struct {
 	pc        *webrtc.PeerConnection
-	transport *WebRTCTransport
+	transport tpt.Transport
 	scope     network.ConnManagementScope
 
 	closeOnce sync.Once
@@ -62,9 +62,9 @@ type connection struct {
 }
 
 func newConnection(
-	direction network.Direction,
+	role webrtc.DTLSRole,
 	pc *webrtc.PeerConnection,
-	transport *WebRTCTransport,
+	transport tpt.Transport,
 	scope network.ConnManagementScope,
 
 	localPeer peer.ID,
@@ -93,11 +93,13 @@ func newConnection(
 
 		acceptQueue: incomingDataChannels,
 	}
-	switch direction {
-	case network.DirInbound:
+	// Following RFC 8832, the DTLS client uses even stream IDs and the DTLS server
+	// uses odd stream IDs.
+	switch role {
+	case webrtc.DTLSRoleServer:
 		c.nextStreamID.Store(1)
-	case network.DirOutbound:
-		// stream ID 0 is used for the Noise handshake stream
+	case webrtc.DTLSRoleClient:
+		// stream ID 0 is used for the handshake stream
 		c.nextStreamID.Store(2)
 	}
 
@@ -107,7 +109,7 @@ func newConnection(
 
 // ConnState implements transport.CapableConn
 func (c *connection) ConnState() network.ConnectionState {
-	return network.ConnectionState{Transport: "webrtc-direct"}
+	return network.ConnectionState{Transport: ma.ProtocolWithCode(c.transport.Protocols()[0]).Name}
 }
 
 // Close closes the underlying peerconnection.
@@ -116,6 +118,13 @@ func (c *connection) Close() error {
 	return nil
 }
 
+// CloseWithError closes the connection ignoring the error code. As there's no way to signal
+// the remote peer on closing the underlying peerconnection, we ignore the error code.
+func (c *connection) CloseWithError(errCode network.ConnErrorCode) error {
+	c.closeOnce.Do(func() { c.closeWithError(&network.ConnError{ErrorCode: errCode}) })
+	return nil
+}
+
 // closeWithError is used to Close the connection when the underlying DTLS connection fails
 func (c *connection) closeWithError(err error) {
 	c.closeErr = err
@@ -1,6 +1,7 @@
 package libp2pwebrtc
 
 import (
+	"bytes"
 	"crypto"
 	"crypto/x509"
 	"errors"
@@ -28,16 +29,73 @@ func parseFingerprint(cert *x509.Certificate, algo crypto.Hash) ([]byte, error)
 	return h.Sum(nil), nil
 }
 
-func decodeRemoteFingerprint(maddr ma.Multiaddr) (*mh.DecodedMultihash, error) {
-	remoteFingerprintMultibase, err := maddr.ValueForProtocol(ma.P_CERTHASH)
+// decodeRemoteFingerprints decodes the certhashes of maddr. Listeners
+// advertise several certhashes while their certificate is being rotated.
+func decodeRemoteFingerprints(maddr ma.Multiaddr) ([]mh.DecodedMultihash, error) {
+	var fps []mh.DecodedMultihash
+	var err error
+	ma.ForEach(maddr, func(c ma.Component) bool {
+		if c.Protocol().Code != ma.P_CERTHASH {
+			return true
+		}
+		var data []byte
+		_, data, err = multibase.Decode(c.Value())
+		if err != nil {
+			return false
+		}
+		var fp *mh.DecodedMultihash
+		fp, err = mh.Decode(data)
+		if err != nil {
+			return false
+		}
+		fps = append(fps, *fp)
+		return true
+	})
 	if err != nil {
 		return nil, err
 	}
-	_, data, err := multibase.Decode(remoteFingerprintMultibase)
+	if len(fps) == 0 {
+		return nil, errors.New("no certhash in multiaddr")
+	}
+	return fps, nil
+}
+
+// verifyRemoteCertificate checks that the certificate of the remote end of pc
+// matches one of the fingerprints.
+func verifyRemoteCertificate(pc *webrtc.PeerConnection, fps []mh.DecodedMultihash) error {
+	cert, err := x509.ParseCertificate(pc.SCTP().Transport().GetRemoteCertificate())
 	if err != nil {
-		return nil, err
+		return err
+	}
+	for _, fp := range fps {
+		hash, ok := getSupportedSDPHash(fp.Code)
+		if !ok {
+			continue
+		}
+		digest, err := parseFingerprint(cert, hash)
+		if err != nil {
+			continue
+		}
+		if bytes.Equal(digest, fp.Digest) {
+			return nil
+		}
+	}
+	return errors.New("remote certificate doesn't match any certhash")
+}
+
+// localCertificateFingerprint returns the SHA-256 fingerprint of the
+// certificate pc uses.
+func localCertificateFingerprint(pc *webrtc.PeerConnection) (webrtc.DTLSFingerprint, error) {
+	params, err := pc.SCTP().Transport().GetLocalParameters()
+	if err != nil {
+		return webrtc.DTLSFingerprint{}, err
+	}
+	for _, fp := range params.Fingerprints {
+		if fp.Algorithm == "sha-256" {
+			return fp, nil
+		}
 	}
-	return mh.Decode(data)
+	return webrtc.DTLSFingerprint{}, errors.New("no sha-256 fingerprint for the local certificate")
 }
 
 func encodeDTLSFingerprint(fp webrtc.DTLSFingerprint) (string, error) {
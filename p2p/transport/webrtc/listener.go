@@ -3,11 +3,9 @@ package libp2pwebrtc
 import (
 	"context"
 	"crypto"
-	"encoding/hex"
 	"errors"
 	"fmt"
 	"net"
-	"strings"
 	"sync"
 	"time"
 
@@ -18,8 +16,6 @@ import (
 
 	ma "github.com/multiformats/go-multiaddr"
 	manet "github.com/multiformats/go-multiaddr/net"
-	"github.com/multiformats/go-multibase"
-	"github.com/multiformats/go-multihash"
 	pionlogger "github.com/pion/logging"
 	"github.com/pion/webrtc/v3"
 	"go.uber.org/zap/zapcore"
@@ -44,11 +40,8 @@ type listener struct {
 
 	mux *udpmux.UDPMux
 
-	config                    webrtc.Configuration
-	localFingerprint          webrtc.DTLSFingerprint
-	localFingerprintMultibase string
-
-	localAddr      net.Addr
+	localAddr net.Addr
+	// localMultiaddr is the address of the listener, without the certhashes
 	localMultiaddr ma.Multiaddr
 
 	// buffered incoming connections
@@ -62,33 +55,12 @@ type listener struct {
 
 var _ tpt.Listener = &listener{}
 
-func newListener(transport *WebRTCTransport, laddr ma.Multiaddr, socket net.PacketConn, config webrtc.Configuration) (*listener, error) {
-	localFingerprints, err := config.Certificates[0].GetFingerprints()
-	if err != nil {
-		return nil, err
-	}
-
-	localMh, err := hex.DecodeString(strings.ReplaceAll(localFingerprints[0].Value, ":", ""))
-	if err != nil {
-		return nil, err
-	}
-	localMhBuf, err := multihash.Encode(localMh, multihash.SHA2_256)
-	if err != nil {
-		return nil, err
-	}
-	localFpMultibase, err := multibase.Encode(multibase.Base64url, localMhBuf)
-	if err != nil {
-		return nil, err
-	}
-
+func newListener(transport *WebRTCTransport, laddr ma.Multiaddr, socket net.PacketConn) (*listener, error) {
 	l := &listener{
-		transport:                 transport,
-		config:                    config,
-		localFingerprint:          localFingerprints[0],
-		localFingerprintMultibase: localFpMultibase,
-		localMultiaddr:            laddr,
-		localAddr:                 socket.LocalAddr(),
-		acceptQueue:               make(chan tpt.CapableConn),
+		transport:      transport,
+		localMultiaddr: laddr,
+		localAddr:      socket.LocalAddr(),
+		acceptQueue:    make(chan tpt.CapableConn),
 	}
 
 	l.ctx, l.cancel = context.WithCancel(context.Background())
@@ -101,7 +73,7 @@ func newListener(transport *WebRTCTransport, laddr ma.Multiaddr, socket net.Pack
 		l.listen()
 	}()
 
-	return l, err
+	return l, nil
 }
 
 func (l *listener) listen() {
@@ -155,8 +127,7 @@ func (l *listener) handleCandidate(ctx context.Context, candidate udpmux.Candida
 		return nil, err
 	}
 	if l.transport.gater != nil {
-		localAddr, _ := ma.SplitFunc(l.localMultiaddr, func(c ma.Component) bool { return c.Protocol().Code == ma.P_CERTHASH })
-		if !l.transport.gater.InterceptAccept(&connMultiaddrs{local: localAddr, remote: remoteMultiaddr}) {
+		if !l.transport.gater.InterceptAccept(&connMultiaddrs{local: l.localMultiaddr, remote: remoteMultiaddr}) {
 			// The connection attempt is rejected before we can send the client an error.
 			// This means that the connection attempt will time out.
 			return nil, errors.New("connection gated")
@@ -222,7 +193,11 @@ func (l *listener) setupConnection(
 	)
 	settingEngine.DetachDataChannels()
 
-	w, err = newWebRTCConnection(settingEngine, l.config)
+	config, err := l.transport.connectionConfig()
+	if err != nil {
+		return nil, err
+	}
+	w, err = newWebRTCConnection(settingEngine, config)
 	if err != nil {
 		return nil, fmt.Errorf("instantiating peer connection failed: %w", err)
 	}
@@ -272,14 +247,13 @@ func (l *listener) setupConnection(
 		return nil, err
 	}
 
-	localMultiaddrWithoutCerthash, _ := ma.SplitFunc(l.localMultiaddr, func(c ma.Component) bool { return c.Protocol().Code == ma.P_CERTHASH })
 	conn, err := newConnection(
-		network.DirInbound,
+		webrtc.DTLSRoleServer,
 		w.PeerConnection,
 		l.transport,
 		scope,
 		l.transport.localPeerId,
-		localMultiaddrWithoutCerthash,
+		l.localMultiaddr,
 		remotePeer,
 		remotePubKey,
 		remoteMultiaddr,
@@ -325,8 +299,17 @@ func (l *listener) Addr() net.Addr {
 	return l.localAddr
 }
 
+// Multiaddr returns the address of the listener, with the hashes of the
+// certificates the transport is using. While the certificate is being rotated,
+// the address contains the hashes of both the current and the next
+// certificate.
 func (l *listener) Multiaddr() ma.Multiaddr {
-	return l.localMultiaddr
+	_, certHashes, err := l.transport.certificate()
+	if err != nil {
+		log.Errorw("failed to get certificate", "error", err)
+		return l.localMultiaddr
+	}
+	return l.localMultiaddr.Encapsulate(certHashes)
 }
 
 // addOnConnectionStateChangeCallback adds the OnConnectionStateChange to the PeerConnection.
@@ -0,0 +1,116 @@
+package libp2pwebrtc
+
+import (
+	"context"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/network"
+	tpt "github.com/libp2p/go-libp2p/core/transport"
+
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+// privateConnectionSetupTimeout bounds the signaling and the ICE connectivity
+// checks of an incoming private-to-private connection.
+const privateConnectionSetupTimeout = 30 * time.Second
+
+// privateListener accepts private-to-private WebRTC connections. Connections
+// are initiated by the remote peer opening a signaling stream to us, so the
+// listener doesn't own any socket.
+type privateListener struct {
+	transport *PrivateTransport
+
+	// limits the number of concurrent connection attempts
+	inFlight chan struct{}
+
+	acceptQueue chan tpt.CapableConn
+
+	ctx       context.Context
+	cancel    context.CancelFunc
+	closeOnce sync.Once
+}
+
+var _ tpt.Listener = &privateListener{}
+
+func newPrivateListener(t *PrivateTransport) *privateListener {
+	l := &privateListener{
+		transport:   t,
+		inFlight:    make(chan struct{}, t.maxInFlightConnections),
+		acceptQueue: make(chan tpt.CapableConn),
+	}
+	l.ctx, l.cancel = context.WithCancel(context.Background())
+	return l
+}
+
+func (l *privateListener) handleSignalingStream(s network.Stream) {
+	if err := s.Scope().SetService(SignalingServiceName); err != nil {
+		log.Debugw("error attaching signaling stream to service", "error", err)
+		s.Reset()
+		return
+	}
+	if err := s.Scope().ReserveMemory(maxSignalingMsgSize, network.ReservationPriorityAlways); err != nil {
+		log.Debugw("error reserving memory for signaling stream", "error", err)
+		s.Reset()
+		return
+	}
+	defer s.Scope().ReleaseMemory(maxSignalingMsgSize)
+
+	select {
+	case l.inFlight <- struct{}{}:
+	default:
+		log.Debugw("too many in-flight connections, rejecting signaling stream", "peer", s.Conn().RemotePeer())
+		s.ResetWithError(network.StreamRateLimited)
+		return
+	}
+	// The slot is freed once the connection was accepted, or on error.
+	defer func() { <-l.inFlight }()
+
+	ctx, cancel := context.WithTimeout(l.ctx, privateConnectionSetupTimeout)
+	defer cancel()
+	conn, err := l.transport.accept(ctx, s)
+	s.Close()
+	if err != nil {
+		log.Debugw("failed to accept connection", "peer", s.Conn().RemotePeer(), "error", err)
+		return
+	}
+
+	select {
+	case l.acceptQueue <- conn:
+	case <-l.ctx.Done():
+		conn.Close()
+	}
+}
+
+func (l *privateListener) Accept() (tpt.CapableConn, error) {
+	select {
+	case c := <-l.acceptQueue:
+		return c, nil
+	case <-l.ctx.Done():
+		return nil, tpt.ErrListenerClosed
+	}
+}
+
+func (l *privateListener) Close() error {
+	l.closeOnce.Do(func() {
+		l.transport.removeListener(l)
+		l.cancel()
+	})
+	return nil
+}
+
+func (l *privateListener) Addr() net.Addr {
+	return privateAddr{}
+}
+
+func (l *privateListener) Multiaddr() ma.Multiaddr {
+	return webrtcPrivateComponent
+}
+
+// privateAddr is the net.Addr of the private listener. It doesn't correspond
+// to any socket.
+type privateAddr struct{}
+
+func (privateAddr) Network() string { return "webrtc" }
+func (privateAddr) String() string  { return "/webrtc" }
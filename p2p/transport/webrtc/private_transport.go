@@ -0,0 +1,429 @@
+package libp2pwebrtc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+
+	"github.com/libp2p/go-libp2p/core/connmgr"
+	ic "github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/pnet"
+	tpt "github.com/libp2p/go-libp2p/core/transport"
+
+	ma "github.com/multiformats/go-multiaddr"
+	manet "github.com/multiformats/go-multiaddr/net"
+	msmux "github.com/multiformats/go-multistream"
+	pionlogger "github.com/pion/logging"
+	"github.com/pion/webrtc/v3"
+)
+
+var webrtcPrivateComponent *ma.Component
+
+func init() {
+	var err error
+	webrtcPrivateComponent, err = ma.NewComponent(ma.ProtocolWithCode(ma.P_WEBRTC).Name, "")
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+// PrivateTransport is the private-to-private WebRTC transport (/webrtc).
+//
+// Both peers are expected to be reachable through a circuit v2 relay. The
+// dialer opens a /webrtc-signaling/0.0.1 stream over the relayed connection
+// and uses it to exchange the SDP offer, answer and ICE candidates. Once the
+// peer connection is established, the signaling stream is closed and the
+// resulting connection is handed to the swarm as a direct connection.
+//
+// Since the SDP, including the DTLS fingerprints, is exchanged over an
+// authenticated stream, no additional security handshake is run on the
+// WebRTC connection.
+type PrivateTransport struct {
+	host         host.Host
+	rcmgr        network.ResourceManager
+	gater        connmgr.ConnectionGater
+	webrtcConfig webrtc.Configuration
+
+	peerConnectionTimeouts iceTimeouts
+	maxInFlightConnections uint32
+
+	mx       sync.Mutex
+	listener *privateListener
+}
+
+var _ tpt.Transport = &PrivateTransport{}
+
+type PrivateOption func(*PrivateTransport) error
+
+// WithSTUNServers configures the STUN servers used to gather server reflexive
+// ICE candidates. Without any STUN servers, only host candidates are
+// exchanged, which doesn't work for peers behind different NATs.
+func WithSTUNServers(urls ...string) PrivateOption {
+	return func(t *PrivateTransport) error {
+		t.webrtcConfig.ICEServers = append(t.webrtcConfig.ICEServers, webrtc.ICEServer{URLs: urls})
+		return nil
+	}
+}
+
+// NewPrivateTransport creates the private-to-private WebRTC transport. It's
+// meant to be used with libp2p.Transport, together with the relay transport.
+func NewPrivateTransport(h host.Host, psk pnet.PSK, gater connmgr.ConnectionGater, rcmgr network.ResourceManager, opts ...PrivateOption) (*PrivateTransport, error) {
+	if psk != nil {
+		log.Error("WebRTC doesn't support private networks yet.")
+		return nil, fmt.Errorf("WebRTC doesn't support private networks yet")
+	}
+	cert, err := newCertificate()
+	if err != nil {
+		return nil, err
+	}
+	t := &PrivateTransport{
+		host:         h,
+		rcmgr:        rcmgr,
+		gater:        gater,
+		webrtcConfig: webrtc.Configuration{Certificates: []webrtc.Certificate{*cert}},
+
+		peerConnectionTimeouts: iceTimeouts{
+			Disconnect: DefaultDisconnectedTimeout,
+			Failed:     DefaultFailedTimeout,
+			Keepalive:  DefaultKeepaliveTimeout,
+		},
+		maxInFlightConnections: DefaultMaxInFlightConnections,
+	}
+	for _, opt := range opts {
+		if err := opt(t); err != nil {
+			return nil, err
+		}
+	}
+	return t, nil
+}
+
+func (t *PrivateTransport) Protocols() []int {
+	return []int{ma.P_WEBRTC}
+}
+
+func (t *PrivateTransport) Proxy() bool {
+	return false
+}
+
+func (t *PrivateTransport) CanDial(addr ma.Multiaddr) bool {
+	_, ok := splitPrivateAddr(addr)
+	return ok
+}
+
+// splitPrivateAddr returns the relayed address used for signaling from a
+// /p2p-circuit/webrtc address.
+func splitPrivateAddr(addr ma.Multiaddr) (ma.Multiaddr, bool) {
+	rest, last := ma.SplitLast(addr)
+	if last != nil && last.Protocol().Code == ma.P_P2P && rest != nil {
+		rest, last = ma.SplitLast(rest)
+	}
+	if last == nil || !last.Equal(webrtcPrivateComponent) || rest == nil {
+		return nil, false
+	}
+	if _, circuit := ma.SplitLast(rest); circuit == nil || circuit.Protocol().Code != ma.P_CIRCUIT {
+		return nil, false
+	}
+	return rest, true
+}
+
+// Listen starts accepting incoming connections on the signaling protocol. addr
+// must be /webrtc.
+func (t *PrivateTransport) Listen(addr ma.Multiaddr) (tpt.Listener, error) {
+	if !addr.Equal(webrtcPrivateComponent) {
+		return nil, fmt.Errorf("must listen on %s", webrtcPrivateComponent)
+	}
+
+	t.mx.Lock()
+	defer t.mx.Unlock()
+	if t.listener != nil {
+		return nil, errors.New("already listening on webrtc")
+	}
+	t.listener = newPrivateListener(t)
+	t.host.SetStreamHandler(SignalingProtocol, t.listener.handleSignalingStream)
+	return t.listener, nil
+}
+
+func (t *PrivateTransport) removeListener(l *privateListener) {
+	t.mx.Lock()
+	defer t.mx.Unlock()
+	if t.listener == l {
+		t.host.RemoveStreamHandler(SignalingProtocol)
+		t.listener = nil
+	}
+}
+
+func (t *PrivateTransport) Dial(ctx context.Context, remoteMultiaddr ma.Multiaddr, p peer.ID) (tpt.CapableConn, error) {
+	scope, err := t.rcmgr.OpenConnection(network.DirOutbound, false, remoteMultiaddr)
+	if err != nil {
+		return nil, err
+	}
+	if err := scope.SetPeer(p); err != nil {
+		scope.Done()
+		return nil, err
+	}
+	conn, err := t.dial(ctx, scope, remoteMultiaddr, p)
+	if err != nil {
+		scope.Done()
+		return nil, err
+	}
+	return conn, nil
+}
+
+func (t *PrivateTransport) dial(ctx context.Context, scope network.ConnManagementScope, remoteMultiaddr ma.Multiaddr, p peer.ID) (tConn tpt.CapableConn, err error) {
+	circuitAddr, ok := splitPrivateAddr(remoteMultiaddr)
+	if !ok {
+		return nil, fmt.Errorf("invalid webrtc address: %s", remoteMultiaddr)
+	}
+	str, remoteKey, release, err := t.openSignalingStream(ctx, circuitAddr, p)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open signaling stream: %w", err)
+	}
+	defer release()
+	defer str.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		str.SetDeadline(deadline)
+	}
+
+	var w webRTCConnection
+	defer func() {
+		if err != nil {
+			if w.PeerConnection != nil {
+				_ = w.PeerConnection.Close()
+			}
+			if tConn != nil {
+				_ = tConn.Close()
+			}
+		}
+	}()
+
+	w, err = newWebRTCConnection(t.settingEngine(), t.webrtcConfig)
+	if err != nil {
+		return nil, fmt.Errorf("instantiating peer connection failed: %w", err)
+	}
+	ss := newSignalingStream(str)
+	w.PeerConnection.OnICECandidate(ss.sendCandidate)
+	errC := addOnConnectionStateChangeCallback(w.PeerConnection)
+
+	offer, err := w.PeerConnection.CreateOffer(nil)
+	if err != nil {
+		return nil, fmt.Errorf("create offer: %w", err)
+	}
+	if err := w.PeerConnection.SetLocalDescription(offer); err != nil {
+		return nil, fmt.Errorf("set local description: %w", err)
+	}
+	if err := ss.sendDescription(offer); err != nil {
+		return nil, fmt.Errorf("send offer: %w", err)
+	}
+	answer, err := ss.readDescription(webrtc.SDPTypeAnswer)
+	if err != nil {
+		return nil, fmt.Errorf("read answer: %w", err)
+	}
+	if err := w.PeerConnection.SetRemoteDescription(answer); err != nil {
+		return nil, fmt.Errorf("set remote description: %w", err)
+	}
+	go ss.readCandidates(w.PeerConnection)
+
+	select {
+	case err := <-errC:
+		if err != nil {
+			return nil, err
+		}
+	case <-ctx.Done():
+		return nil, errors.New("peerconnection opening timed out")
+	}
+
+	// The answerer is always the DTLS client, see settingEngine.
+	conn, err := t.newConnection(webrtc.DTLSRoleServer, w, scope, p, remoteKey)
+	if err != nil {
+		return nil, err
+	}
+	if t.gater != nil && !t.gater.InterceptSecured(network.DirOutbound, p, conn) {
+		return conn, fmt.Errorf("secured connection gated")
+	}
+	return conn, nil
+}
+
+// openSignalingStream opens a signaling stream to p. It reuses an existing
+// connection to p if there is one, otherwise it dials the relayed address
+// directly using the relay transport. The connection is not added to the
+// swarm in this case, it's closed by calling release.
+func (t *PrivateTransport) openSignalingStream(ctx context.Context, circuitAddr ma.Multiaddr, p peer.ID) (str network.MuxedStream, remoteKey ic.PubKey, release func(), err error) {
+	if len(t.host.Network().ConnsToPeer(p)) > 0 {
+		ctx := network.WithNoDial(network.WithUseTransient(ctx, "webrtc signaling"), "webrtc signaling")
+		s, err := t.host.NewStream(ctx, p, SignalingProtocol)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		if err := s.Scope().SetService(SignalingServiceName); err != nil {
+			s.Reset()
+			return nil, nil, nil, err
+		}
+		if err := s.Scope().ReserveMemory(maxSignalingMsgSize, network.ReservationPriorityAlways); err != nil {
+			s.Reset()
+			return nil, nil, nil, err
+		}
+		return s, s.Conn().RemotePublicKey(), func() { s.Scope().ReleaseMemory(maxSignalingMsgSize) }, nil
+	}
+
+	n, ok := t.host.Network().(interface {
+		TransportForDialing(ma.Multiaddr) tpt.Transport
+	})
+	if !ok {
+		return nil, nil, nil, errors.New("network doesn't support dialing relayed addresses")
+	}
+	rt := n.TransportForDialing(circuitAddr)
+	if rt == nil || !rt.Proxy() {
+		return nil, nil, nil, fmt.Errorf("no relay transport for %s", circuitAddr)
+	}
+	c, err := rt.Dial(ctx, circuitAddr, p)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	s, err := c.OpenStream(ctx)
+	if err != nil {
+		c.Close()
+		return nil, nil, nil, err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		s.SetDeadline(deadline)
+	}
+	if err := msmux.SelectProtoOrFail(SignalingProtocol, s); err != nil {
+		c.Close()
+		return nil, nil, nil, err
+	}
+	return s, c.RemotePublicKey(), func() { c.Close() }, nil
+}
+
+// accept handles an incoming signaling stream and returns the established
+// connection.
+func (t *PrivateTransport) accept(ctx context.Context, s network.Stream) (tConn tpt.CapableConn, err error) {
+	p := s.Conn().RemotePeer()
+	scope, err := t.rcmgr.OpenConnection(network.DirInbound, false, s.Conn().RemoteMultiaddr().Encapsulate(webrtcPrivateComponent))
+	if err != nil {
+		return nil, err
+	}
+	var w webRTCConnection
+	defer func() {
+		if err != nil {
+			if w.PeerConnection != nil {
+				_ = w.PeerConnection.Close()
+			}
+			if tConn != nil {
+				_ = tConn.Close()
+			} else {
+				scope.Done()
+			}
+		}
+	}()
+	if err := scope.SetPeer(p); err != nil {
+		return nil, err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		s.SetDeadline(deadline)
+	}
+
+	ss := newSignalingStream(s)
+	offer, err := ss.readDescription(webrtc.SDPTypeOffer)
+	if err != nil {
+		return nil, fmt.Errorf("read offer: %w", err)
+	}
+
+	w, err = newWebRTCConnection(t.settingEngine(), t.webrtcConfig)
+	if err != nil {
+		return nil, fmt.Errorf("instantiating peer connection failed: %w", err)
+	}
+	w.PeerConnection.OnICECandidate(ss.sendCandidate)
+	errC := addOnConnectionStateChangeCallback(w.PeerConnection)
+
+	if err := w.PeerConnection.SetRemoteDescription(offer); err != nil {
+		return nil, fmt.Errorf("set remote description: %w", err)
+	}
+	answer, err := w.PeerConnection.CreateAnswer(nil)
+	if err != nil {
+		return nil, fmt.Errorf("create answer: %w", err)
+	}
+	if err := w.PeerConnection.SetLocalDescription(answer); err != nil {
+		return nil, fmt.Errorf("set local description: %w", err)
+	}
+	if err := ss.sendDescription(answer); err != nil {
+		return nil, fmt.Errorf("send answer: %w", err)
+	}
+	go ss.readCandidates(w.PeerConnection)
+
+	select {
+	case err := <-errC:
+		if err != nil {
+			return nil, err
+		}
+	case <-ctx.Done():
+		return nil, errors.New("peerconnection opening timed out")
+	}
+
+	conn, err := t.newConnection(webrtc.DTLSRoleClient, w, scope, p, s.Conn().RemotePublicKey())
+	if err != nil {
+		return nil, err
+	}
+	if t.gater != nil && !t.gater.InterceptSecured(network.DirInbound, p, conn) {
+		return conn, fmt.Errorf("secured connection gated")
+	}
+	return conn, nil
+}
+
+func (t *PrivateTransport) newConnection(role webrtc.DTLSRole, w webRTCConnection, scope network.ConnManagementScope, p peer.ID, remoteKey ic.PubKey) (*connection, error) {
+	cp, err := w.PeerConnection.SCTP().Transport().ICETransport().GetSelectedCandidatePair()
+	if cp == nil {
+		return nil, errors.New("ice connection did not have selected candidate pair: nil result")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("ice connection did not have selected candidate pair: error: %w", err)
+	}
+	localAddr, err := manet.FromNetAddr(&net.UDPAddr{IP: net.ParseIP(cp.Local.Address), Port: int(cp.Local.Port)})
+	if err != nil {
+		return nil, err
+	}
+	remoteAddr, err := manet.FromNetAddr(&net.UDPAddr{IP: net.ParseIP(cp.Remote.Address), Port: int(cp.Remote.Port)})
+	if err != nil {
+		return nil, err
+	}
+	// The handshake channel is only used to get the SCTP association
+	// negotiated in the SDP.
+	w.HandshakeDataChannel.Close()
+
+	return newConnection(
+		role,
+		w.PeerConnection,
+		t,
+		scope,
+		t.host.ID(),
+		localAddr.Encapsulate(webrtcPrivateComponent),
+		p,
+		remoteKey,
+		remoteAddr.Encapsulate(webrtcPrivateComponent),
+		w.IncomingDataChannels,
+	)
+}
+
+func (t *PrivateTransport) settingEngine() webrtc.SettingEngine {
+	settingEngine := webrtc.SettingEngine{}
+	// suppress pion logs
+	loggerFactory := pionlogger.NewDefaultLoggerFactory()
+	loggerFactory.DefaultLogLevel = pionlogger.LogLevelDisabled
+	settingEngine.LoggerFactory = loggerFactory
+
+	// The answerer is always the DTLS client. This way both sides know which
+	// stream IDs to use without having to inspect the negotiated DTLS role.
+	settingEngine.SetAnsweringDTLSRole(webrtc.DTLSRoleClient)
+	settingEngine.DetachDataChannels()
+	settingEngine.SetICETimeouts(
+		t.peerConnectionTimeouts.Disconnect,
+		t.peerConnectionTimeouts.Failed,
+		t.peerConnectionTimeouts.Keepalive,
+	)
+	settingEngine.SetIncludeLoopbackCandidate(true)
+	return settingEngine
+}
@@ -0,0 +1,95 @@
+package libp2pwebrtc
+
+import (
+	"context"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/p2p/protocol/circuitv2/client"
+	"github.com/libp2p/go-libp2p/p2p/transport/tcp"
+
+	ma "github.com/multiformats/go-multiaddr"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPrivateTransportCanDial(t *testing.T) {
+	tr, err := NewPrivateTransport(nil, nil, nil, &network.NullResourceManager{})
+	require.NoError(t, err)
+
+	valid := []string{
+		"/ip4/1.2.3.4/tcp/1234/p2p/QmcgpsyWgH8Y8ajJz1Cu72KnS5uo2Aa2LpzU7kinSupNKC/p2p-circuit/webrtc",
+		"/ip4/1.2.3.4/tcp/1234/p2p/QmcgpsyWgH8Y8ajJz1Cu72KnS5uo2Aa2LpzU7kinSupNKC/p2p-circuit/webrtc/p2p/QmcgpsyWgH8Y8ajJz1Cu72KnS5uo2Aa2LpzU7kinSupNKD",
+	}
+	invalid := []string{
+		"/webrtc",
+		"/p2p-circuit",
+		"/ip4/1.2.3.4/udp/1234/webrtc",
+		"/ip4/1.2.3.4/tcp/1234/p2p/QmcgpsyWgH8Y8ajJz1Cu72KnS5uo2Aa2LpzU7kinSupNKC/p2p-circuit",
+		"/ip4/1.2.3.4/udp/1234/webrtc-direct/certhash/uEiAsGPzpiPGQzSlVHRXrUCT5EkTV7YFrV4VZ3hpEKTd_zg",
+	}
+	for _, a := range valid {
+		require.True(t, tr.CanDial(ma.StringCast(a)), a)
+	}
+	for _, a := range invalid {
+		require.False(t, tr.CanDial(ma.StringCast(a)), a)
+	}
+}
+
+func newPrivateHost(t *testing.T, opts ...libp2p.Option) host.Host {
+	t.Helper()
+	h, err := libp2p.New(append([]libp2p.Option{
+		libp2p.Transport(tcp.NewTCPTransport),
+		libp2p.ListenAddrStrings("/ip4/127.0.0.1/tcp/0"),
+		libp2p.ResourceManager(&network.NullResourceManager{}),
+	}, opts...)...)
+	require.NoError(t, err)
+	t.Cleanup(func() { h.Close() })
+	return h
+}
+
+func TestPrivateTransportDial(t *testing.T) {
+	relay := newPrivateHost(t, libp2p.EnableRelayService(), libp2p.ForceReachabilityPublic())
+	webrtcOpts := []libp2p.Option{
+		libp2p.Transport(NewPrivateTransport),
+		libp2p.ListenAddrStrings("/ip4/127.0.0.1/tcp/0", "/webrtc"),
+	}
+	listener := newPrivateHost(t, webrtcOpts...)
+	dialer := newPrivateHost(t, webrtcOpts...)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+	defer cancel()
+	relayInfo := peer.AddrInfo{ID: relay.ID(), Addrs: relay.Addrs()}
+	require.NoError(t, listener.Connect(ctx, relayInfo))
+	_, err := client.Reserve(ctx, listener, relayInfo)
+	require.NoError(t, err)
+	require.NoError(t, dialer.Connect(ctx, relayInfo))
+
+	listener.SetStreamHandler("/echo", func(s network.Stream) {
+		defer s.Close()
+		io.Copy(s, s)
+	})
+
+	addr := relay.Addrs()[0].Encapsulate(ma.StringCast("/p2p/" + relay.ID().String() + "/p2p-circuit/webrtc"))
+	require.NoError(t, dialer.Connect(ctx, peer.AddrInfo{ID: listener.ID(), Addrs: []ma.Multiaddr{addr}}))
+
+	conns := dialer.Network().ConnsToPeer(listener.ID())
+	require.Len(t, conns, 1)
+	require.False(t, conns[0].Stat().Transient)
+	_, err = conns[0].RemoteMultiaddr().ValueForProtocol(ma.P_WEBRTC)
+	require.NoError(t, err)
+	require.Equal(t, "webrtc", conns[0].ConnState().Transport)
+
+	s, err := dialer.NewStream(ctx, listener.ID(), "/echo")
+	require.NoError(t, err)
+	_, err = s.Write([]byte("foobar"))
+	require.NoError(t, err)
+	require.NoError(t, s.CloseWrite())
+	b, err := io.ReadAll(s)
+	require.NoError(t, err)
+	require.Equal(t, "foobar", string(b))
+}
@@ -0,0 +1,142 @@
+package libp2pwebrtc
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+
+	"github.com/libp2p/go-libp2p/core/protocol"
+	"github.com/libp2p/go-libp2p/p2p/transport/webrtc/pb"
+
+	"github.com/libp2p/go-msgio/pbio"
+	"github.com/pion/webrtc/v3"
+)
+
+const (
+	// SignalingProtocol is the protocol used to exchange the SDP and the ICE
+	// candidates for private-to-private WebRTC connections.
+	SignalingProtocol protocol.ID = "/webrtc-signaling/0.0.1"
+	// SignalingServiceName is the resource manager service of the signaling
+	// streams.
+	SignalingServiceName = "libp2p.webrtc.signaling"
+
+	maxSignalingMsgSize = 4096
+)
+
+// signalingStream exchanges the signaling messages over a stream.
+//
+// ICE candidates are gathered as soon as the local description is set, but
+// must only be sent after the offer (or answer) was sent. Candidates gathered
+// before that are buffered.
+type signalingStream struct {
+	r pbio.Reader
+
+	mx      sync.Mutex
+	w       pbio.Writer
+	sent    bool
+	pending []webrtc.ICECandidateInit
+}
+
+func newSignalingStream(rw interface {
+	Read([]byte) (int, error)
+	Write([]byte) (int, error)
+}) *signalingStream {
+	return &signalingStream{
+		r: pbio.NewDelimitedReader(rw, maxSignalingMsgSize),
+		w: pbio.NewDelimitedWriter(rw),
+	}
+}
+
+func (s *signalingStream) sendDescription(desc webrtc.SessionDescription) error {
+	var typ pb.SignalingMessage_Type
+	switch desc.Type {
+	case webrtc.SDPTypeOffer:
+		typ = pb.SignalingMessage_SDP_OFFER
+	case webrtc.SDPTypeAnswer:
+		typ = pb.SignalingMessage_SDP_ANSWER
+	default:
+		return fmt.Errorf("unexpected session description type: %s", desc.Type)
+	}
+
+	s.mx.Lock()
+	defer s.mx.Unlock()
+	if err := s.w.WriteMsg(&pb.SignalingMessage{Type: typ.Enum(), Data: &desc.SDP}); err != nil {
+		return err
+	}
+	s.sent = true
+	for _, c := range s.pending {
+		if err := s.writeCandidate(c); err != nil {
+			return err
+		}
+	}
+	s.pending = nil
+	return nil
+}
+
+// sendCandidate is the OnICECandidate callback of the peer connection.
+func (s *signalingStream) sendCandidate(c *webrtc.ICECandidate) {
+	// nil signals the end of the candidate gathering
+	if c == nil {
+		return
+	}
+	s.mx.Lock()
+	defer s.mx.Unlock()
+	if !s.sent {
+		s.pending = append(s.pending, c.ToJSON())
+		return
+	}
+	if err := s.writeCandidate(c.ToJSON()); err != nil {
+		log.Debugw("failed to send ICE candidate", "error", err)
+	}
+}
+
+func (s *signalingStream) writeCandidate(c webrtc.ICECandidateInit) error {
+	b, err := json.Marshal(c)
+	if err != nil {
+		return err
+	}
+	data := string(b)
+	return s.w.WriteMsg(&pb.SignalingMessage{Type: pb.SignalingMessage_ICE_CANDIDATE.Enum(), Data: &data})
+}
+
+func (s *signalingStream) readDescription(typ webrtc.SDPType) (webrtc.SessionDescription, error) {
+	expected := pb.SignalingMessage_SDP_OFFER
+	if typ == webrtc.SDPTypeAnswer {
+		expected = pb.SignalingMessage_SDP_ANSWER
+	}
+	var msg pb.SignalingMessage
+	if err := s.r.ReadMsg(&msg); err != nil {
+		return webrtc.SessionDescription{}, err
+	}
+	if msg.GetType() != expected {
+		return webrtc.SessionDescription{}, fmt.Errorf("expected %s, got %s", expected, msg.GetType())
+	}
+	return webrtc.SessionDescription{Type: typ, SDP: msg.GetData()}, nil
+}
+
+// readCandidates adds the remote ICE candidates to the peer connection until
+// the stream is closed.
+func (s *signalingStream) readCandidates(pc *webrtc.PeerConnection) {
+	for {
+		var msg pb.SignalingMessage
+		if err := s.r.ReadMsg(&msg); err != nil {
+			return
+		}
+		if msg.GetType() != pb.SignalingMessage_ICE_CANDIDATE {
+			log.Debugw("unexpected signaling message", "type", msg.GetType())
+			return
+		}
+		// An empty candidate signals the end of the remote candidates.
+		if msg.GetData() == "" || msg.GetData() == "null" {
+			continue
+		}
+		var c webrtc.ICECandidateInit
+		if err := json.Unmarshal([]byte(msg.GetData()), &c); err != nil {
+			log.Debugw("failed to unmarshal ICE candidate", "error", err)
+			return
+		}
+		if err := pc.AddICECandidate(c); err != nil {
+			log.Debugw("failed to add ICE candidate", "error", err)
+		}
+	}
+}
@@ -262,6 +262,31 @@ func TestStreamResets(t *testing.T) {
 	}, time.Second, 50*time.Millisecond)
 }
 
+func TestStreamResetWithError(t *testing.T) {
+	client, server := getDetachedDataChannels(t)
+
+	clientStr := newStream(client.dc, client.rwc, func() {})
+	serverStr := newStream(server.dc, server.rwc, func() {})
+
+	_, err := clientStr.Write([]byte("foobar"))
+	require.NoError(t, err)
+	require.NoError(t, clientStr.ResetWithError(42))
+
+	_, err = clientStr.Write([]byte("foobar"))
+	require.ErrorIs(t, err, &network.StreamError{ErrorCode: 42, Remote: false})
+	_, err = clientStr.Read(make([]byte, 1))
+	require.ErrorIs(t, err, &network.StreamError{ErrorCode: 42, Remote: false})
+
+	b, err := io.ReadAll(serverStr)
+	require.Equal(t, []byte("foobar"), b)
+	require.ErrorIs(t, err, &network.StreamError{ErrorCode: 42, Remote: true})
+	require.ErrorIs(t, err, network.ErrReset)
+	require.Eventually(t, func() bool {
+		_, err := serverStr.Write([]byte("foobar"))
+		return errors.Is(err, &network.StreamError{ErrorCode: 42, Remote: true})
+	}, time.Second, 50*time.Millisecond)
+}
+
 func TestStreamReadDeadlineAsync(t *testing.T) {
 	client, server := getDetachedDataChannels(t)
 
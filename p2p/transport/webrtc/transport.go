@@ -54,6 +54,22 @@ var log = logging.Logger("webrtc-transport")
 
 var dialMatcher = mafmt.And(mafmt.UDP, mafmt.Base(ma.P_WEBRTC_DIRECT), mafmt.Base(ma.P_CERTHASH))
 
+// stripExtraCerthashes removes all but the first certhash component at the end
+// of addr. Listeners advertise several certhashes while their certificate is
+// being rotated.
+func stripExtraCerthashes(addr ma.Multiaddr) ma.Multiaddr {
+	for {
+		rest, last := ma.SplitLast(addr)
+		if last == nil || last.Protocol().Code != ma.P_CERTHASH || rest == nil {
+			return addr
+		}
+		if _, prev := ma.SplitLast(rest); prev == nil || prev.Protocol().Code != ma.P_CERTHASH {
+			return addr
+		}
+		addr = rest
+	}
+}
+
 var webrtcComponent *ma.Component
 
 func init() {
@@ -87,6 +103,8 @@ const (
 
 type WebRTCTransport struct {
 	webrtcConfig webrtc.Configuration
+	certProvider CertificateProvider
+	certs        certState
 	rcmgr        network.ResourceManager
 	gater        connmgr.ConnectionGater
 	privKey      ic.PrivKey
@@ -98,12 +116,44 @@ type WebRTCTransport struct {
 
 	// in-flight connections
 	maxInFlightConnections uint32
+
+	// listenUDP opens the UDP sockets listeners run on
+	listenUDP ListenUDPFn
 }
 
 var _ tpt.Transport = &WebRTCTransport{}
 
 type Option func(*WebRTCTransport) error
 
+// ListenUDPFn opens the UDP socket a listener runs on.
+type ListenUDPFn func(network string, laddr *net.UDPAddr) (net.PacketConn, error)
+
+// WithListenUDP configures the function used to open the UDP sockets the
+// transport listens on. By default, a new socket is opened for every listener.
+//
+// To share the UDP port used by QUIC and WebTransport, pass the
+// SharedNonQUICPacketConn method of the QUIC connection manager:
+//
+//	libp2p.Transport(func(key ic.PrivKey, psk pnet.PSK, gater connmgr.ConnectionGater, rcmgr network.ResourceManager, cm *quicreuse.ConnManager) (*libp2pwebrtc.WebRTCTransport, error) {
+//		return libp2pwebrtc.New(key, psk, gater, rcmgr, libp2pwebrtc.WithListenUDP(cm.SharedNonQUICPacketConn))
+//	})
+//
+// Then /udp/4001/quic-v1, /udp/4001/quic-v1/webtransport and
+// /udp/4001/webrtc-direct can all be listened on.
+func WithListenUDP(fn ListenUDPFn) Option {
+	return func(t *WebRTCTransport) error {
+		if fn == nil {
+			return errors.New("listen function must not be nil")
+		}
+		t.listenUDP = fn
+		return nil
+	}
+}
+
+func listenUDP(network string, laddr *net.UDPAddr) (net.PacketConn, error) {
+	return net.ListenUDP(network, laddr)
+}
+
 type iceTimeouts struct {
 	Disconnect time.Duration
 	Failed     time.Duration
@@ -119,39 +169,16 @@ func New(privKey ic.PrivKey, psk pnet.PSK, gater connmgr.ConnectionGater, rcmgr
 	if err != nil {
 		return nil, fmt.Errorf("get local peer ID: %w", err)
 	}
-	// We use elliptic P-256 since it is widely supported by browsers.
-	//
-	// Implementation note: Testing with the browser,
-	// it seems like Chromium only supports ECDSA P-256 or RSA key signatures in the webrtc TLS certificate.
-	// We tried using P-228 and P-384 which caused the DTLS handshake to fail with Illegal Parameter
-	//
-	// Please refer to this is a list of suggested algorithms for the WebCrypto API.
-	// The algorithm for generating a certificate for an RTCPeerConnection
-	// must adhere to the WebCrpyto API. From my observation,
-	// RSA and ECDSA P-256 is supported on almost all browsers.
-	// Ed25519 is not present on the list.
-	pk, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
-	if err != nil {
-		return nil, fmt.Errorf("generate key for cert: %w", err)
-	}
-	cert, err := webrtc.GenerateCertificate(pk)
-	if err != nil {
-		return nil, fmt.Errorf("generate certificate: %w", err)
-	}
-	config := webrtc.Configuration{
-		Certificates: []webrtc.Certificate{*cert},
-	}
 	noiseTpt, err := noise.New(noise.ID, privKey, nil)
 	if err != nil {
 		return nil, fmt.Errorf("unable to create noise transport: %w", err)
 	}
 	transport := &WebRTCTransport{
-		rcmgr:        rcmgr,
-		gater:        gater,
-		webrtcConfig: config,
-		privKey:      privKey,
-		noiseTpt:     noiseTpt,
-		localPeerId:  localPeerID,
+		rcmgr:       rcmgr,
+		gater:       gater,
+		privKey:     privKey,
+		noiseTpt:    noiseTpt,
+		localPeerId: localPeerID,
 
 		peerConnectionTimeouts: iceTimeouts{
 			Disconnect: DefaultDisconnectedTimeout,
@@ -160,15 +187,63 @@ func New(privKey ic.PrivKey, psk pnet.PSK, gater connmgr.ConnectionGater, rcmgr
 		},
 
 		maxInFlightConnections: DefaultMaxInFlightConnections,
+
+		listenUDP: listenUDP,
 	}
 	for _, opt := range opts {
 		if err := opt(transport); err != nil {
 			return nil, err
 		}
 	}
+	if transport.certProvider == nil {
+		cert, err := newCertificate()
+		if err != nil {
+			return nil, err
+		}
+		transport.certProvider = &staticCertProvider{cert: *cert}
+	}
+	if _, _, err := transport.certificate(); err != nil {
+		return nil, fmt.Errorf("get certificate: %w", err)
+	}
 	return transport, nil
 }
 
+// connectionConfig returns the configuration of a new peer connection, using
+// the current certificate.
+func (t *WebRTCTransport) connectionConfig() (webrtc.Configuration, error) {
+	cert, _, err := t.certificate()
+	if err != nil {
+		return webrtc.Configuration{}, err
+	}
+	config := t.webrtcConfig
+	config.Certificates = []webrtc.Certificate{cert}
+	return config, nil
+}
+
+// newCertificate generates the certificate used for the DTLS handshake.
+func newCertificate() (*webrtc.Certificate, error) {
+	// We use elliptic P-256 since it is widely supported by browsers.
+	//
+	// Implementation note: Testing with the browser,
+	// it seems like Chromium only supports ECDSA P-256 or RSA key signatures in the webrtc TLS certificate.
+	// We tried using P-228 and P-384 which caused the DTLS handshake to fail with Illegal Parameter
+	//
+	// Please refer to this is a list of suggested algorithms for the WebCrypto API.
+	// The algorithm for generating a certificate for an RTCPeerConnection
+	// must adhere to the WebCrpyto API. From my observation,
+	// RSA and ECDSA P-256 is supported on almost all browsers.
+	// Ed25519 is not present on the list.
+	pk, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("generate key for cert: %w", err)
+	}
+	cert, err := webrtc.GenerateCertificate(pk)
+	if err != nil {
+		return nil, fmt.Errorf("generate certificate: %w", err)
+	}
+	return cert, nil
+}
+
 func (t *WebRTCTransport) Protocols() []int {
 	return []int{ma.P_WEBRTC_DIRECT}
 }
@@ -178,14 +253,13 @@ func (t *WebRTCTransport) Proxy() bool {
 }
 
 func (t *WebRTCTransport) CanDial(addr ma.Multiaddr) bool {
-	return dialMatcher.Matches(addr)
+	return dialMatcher.Matches(stripExtraCerthashes(addr))
 }
 
 // Listen returns a listener for addr.
 //
-// The IP, Port combination for addr must be exclusive to this listener as a WebRTC listener cannot
-// be multiplexed on the same port as other UDP based transports like QUIC and WebTransport.
-// See https://github.com/libp2p/go-libp2p/issues/2446 for details.
+// By default, the IP, Port combination for addr must be exclusive to this listener. Use
+// WithListenUDP to share the port with other UDP based transports like QUIC and WebTransport.
 func (t *WebRTCTransport) Listen(addr ma.Multiaddr) (tpt.Listener, error) {
 	addr, wrtcComponent := ma.SplitLast(addr)
 	isWebrtc := wrtcComponent.Equal(webrtcComponent)
@@ -201,7 +275,7 @@ func (t *WebRTCTransport) Listen(addr ma.Multiaddr) (tpt.Listener, error) {
 		return nil, fmt.Errorf("listener could not resolve udp address: %w", err)
 	}
 
-	socket, err := net.ListenUDP(nw, udpAddr)
+	socket, err := t.listenUDP(nw, udpAddr)
 	if err != nil {
 		return nil, fmt.Errorf("listen on udp: %w", err)
 	}
@@ -214,33 +288,17 @@ func (t *WebRTCTransport) Listen(addr ma.Multiaddr) (tpt.Listener, error) {
 	return listener, nil
 }
 
-func (t *WebRTCTransport) listenSocket(socket *net.UDPConn) (tpt.Listener, error) {
+func (t *WebRTCTransport) listenSocket(socket net.PacketConn) (tpt.Listener, error) {
 	listenerMultiaddr, err := manet.FromNetAddr(socket.LocalAddr())
 	if err != nil {
 		return nil, err
 	}
-
-	listenerFingerprint, err := t.getCertificateFingerprint()
-	if err != nil {
-		return nil, err
-	}
-
-	encodedLocalFingerprint, err := encodeDTLSFingerprint(listenerFingerprint)
-	if err != nil {
-		return nil, err
-	}
-
-	certComp, err := ma.NewComponent(ma.ProtocolWithCode(ma.P_CERTHASH).Name, encodedLocalFingerprint)
-	if err != nil {
-		return nil, err
-	}
-	listenerMultiaddr = listenerMultiaddr.Encapsulate(webrtcComponent).Encapsulate(certComp)
+	listenerMultiaddr = listenerMultiaddr.Encapsulate(webrtcComponent)
 
 	return newListener(
 		t,
 		listenerMultiaddr,
 		socket,
-		t.webrtcConfig,
 	)
 }
 
@@ -274,10 +332,11 @@ func (t *WebRTCTransport) dial(ctx context.Context, scope network.ConnManagement
 		}
 	}()
 
-	remoteMultihash, err := decodeRemoteFingerprint(remoteMultiaddr)
+	remoteMultihashes, err := decodeRemoteFingerprints(remoteMultiaddr)
 	if err != nil {
 		return nil, fmt.Errorf("decode fingerprint: %w", err)
 	}
+	remoteMultihash := remoteMultihashes[0]
 	remoteHashFunction, ok := getSupportedSDPHash(remoteMultihash.Code)
 	if !ok {
 		return nil, fmt.Errorf("unsupported hash function: %w", nil)
@@ -321,8 +380,18 @@ func (t *WebRTCTransport) dial(ctx context.Context, scope network.ConnManagement
 	// If you run pion on a system with only the loopback interface UP,
 	// it will not connect to anything.
 	settingEngine.SetIncludeLoopbackCandidate(true)
+	if len(remoteMultihashes) > 1 {
+		// The listener may serve any of the certificates in its address while
+		// rotating its certificate, but the SDP only allows for one fingerprint.
+		// We check the certificate against all of them once connected.
+		settingEngine.DisableCertificateFingerprintVerification(true)
+	}
 
-	w, err = newWebRTCConnection(settingEngine, t.webrtcConfig)
+	config, err := t.connectionConfig()
+	if err != nil {
+		return nil, err
+	}
+	w, err = newWebRTCConnection(settingEngine, config)
 	if err != nil {
 		return nil, fmt.Errorf("instantiating peer connection failed: %w", err)
 	}
@@ -340,7 +409,7 @@ func (t *WebRTCTransport) dial(ctx context.Context, scope network.ConnManagement
 		return nil, fmt.Errorf("set local description: %w", err)
 	}
 
-	answerSDPString, err := createServerSDP(raddr, ufrag, *remoteMultihash)
+	answerSDPString, err := createServerSDP(raddr, ufrag, remoteMultihash)
 	if err != nil {
 		return nil, fmt.Errorf("render server SDP: %w", err)
 	}
@@ -360,6 +429,9 @@ func (t *WebRTCTransport) dial(ctx context.Context, scope network.ConnManagement
 	case <-ctx.Done():
 		return nil, errors.New("peerconnection opening timed out")
 	}
+	if err := verifyRemoteCertificate(w.PeerConnection, remoteMultihashes); err != nil {
+		return nil, err
+	}
 
 	// We are connected, run the noise handshake
 	detached, err := detachHandshakeDataChannel(ctx, w.HandshakeDataChannel)
@@ -389,7 +461,7 @@ func (t *WebRTCTransport) dial(ctx context.Context, scope network.ConnManagement
 	remoteMultiaddrWithoutCerthash, _ := ma.SplitFunc(remoteMultiaddr, func(c ma.Component) bool { return c.Protocol().Code == ma.P_CERTHASH })
 
 	conn, err := newConnection(
-		network.DirOutbound,
+		webrtc.DTLSRoleClient,
 		w.PeerConnection,
 		t,
 		scope,
@@ -429,14 +501,6 @@ func genUfrag() string {
 	return string(b)
 }
 
-func (t *WebRTCTransport) getCertificateFingerprint() (webrtc.DTLSFingerprint, error) {
-	fps, err := t.webrtcConfig.Certificates[0].GetFingerprints()
-	if err != nil {
-		return webrtc.DTLSFingerprint{}, err
-	}
-	return fps[0], nil
-}
-
 func (t *WebRTCTransport) generateNoisePrologue(pc *webrtc.PeerConnection, hash crypto.Hash, inbound bool) ([]byte, error) {
 	raw := pc.SCTP().Transport().GetRemoteCertificate()
 	cert, err := x509.ParseCertificate(raw)
@@ -446,7 +510,7 @@ func (t *WebRTCTransport) generateNoisePrologue(pc *webrtc.PeerConnection, hash
 
 	// NOTE: should we want we can fork the cert code as well to avoid
 	// all the extra allocations due to unneeded string interspersing (hex)
-	localFp, err := t.getCertificateFingerprint()
+	localFp, err := localCertificateFingerprint(pc)
 	if err != nil {
 		return nil, err
 	}
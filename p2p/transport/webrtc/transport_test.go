@@ -3,6 +3,7 @@ package libp2pwebrtc
 import (
 	"context"
 	"crypto/rand"
+	"crypto/tls"
 	"encoding/hex"
 	"fmt"
 	"io"
@@ -17,10 +18,12 @@ import (
 	"github.com/libp2p/go-libp2p/core/crypto"
 	"github.com/libp2p/go-libp2p/core/network"
 	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/p2p/transport/quicreuse"
 	ma "github.com/multiformats/go-multiaddr"
 	manet "github.com/multiformats/go-multiaddr/net"
 	"github.com/multiformats/go-multibase"
 	"github.com/multiformats/go-multihash"
+	"github.com/quic-go/quic-go"
 	quicproxy "github.com/quic-go/quic-go/integrationtests/tools/proxy"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -99,6 +102,45 @@ func TestTransportWebRTC_DialFailsOnUnsupportedHashFunction(t *testing.T) {
 	require.ErrorContains(t, err, "unsupported hash function")
 }
 
+func TestTransportWebRTC_ShareQUICPort(t *testing.T) {
+	cm, err := quicreuse.NewConnManager(quic.StatelessResetKey{}, quic.TokenGeneratorKey{})
+	require.NoError(t, err)
+	defer cm.Close()
+
+	tr, listeningPeer := getTransport(t, WithListenUDP(cm.SharedNonQUICPacketConn))
+	tr1, connectingPeer := getTransport(t)
+
+	listener, err := tr.Listen(ma.StringCast("/ip4/127.0.0.1/udp/0/webrtc-direct"))
+	require.NoError(t, err)
+	defer listener.Close()
+	port := listener.Addr().(*net.UDPAddr).Port
+
+	// QUIC listens on the same port
+	qln, err := cm.ListenQUIC(ma.StringCast(fmt.Sprintf("/ip4/127.0.0.1/udp/%d/quic-v1", port)), &tls.Config{NextProtos: []string{"proto"}}, nil)
+	require.NoError(t, err)
+	defer qln.Close()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		conn, err := tr1.Dial(context.Background(), listener.Multiaddr(), listeningPeer)
+		if !assert.NoError(t, err) {
+			return
+		}
+		conn.Close()
+	}()
+
+	conn, err := listener.Accept()
+	require.NoError(t, err)
+	defer conn.Close()
+	require.Equal(t, connectingPeer, conn.RemotePeer())
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatal("dial timed out")
+	}
+}
+
 func TestTransportWebRTC_CanListenSingle(t *testing.T) {
 	tr, listeningPeer := getTransport(t)
 	tr1, connectingPeer := getTransport(t)
@@ -4,15 +4,14 @@ import (
 	"context"
 	"crypto/sha256"
 	"crypto/tls"
-	"encoding/binary"
 	"fmt"
 	"sync"
 	"time"
 
 	"github.com/benbjohnson/clock"
-	ic "github.com/libp2p/go-libp2p/core/crypto"
 	ma "github.com/multiformats/go-multiaddr"
 	"github.com/multiformats/go-multihash"
+	"github.com/quic-go/quic-go/http3"
 )
 
 // Allow for a bit of clock skew.
@@ -29,45 +28,60 @@ type certConfig struct {
 func (c *certConfig) Start() time.Time { return c.tlsConf.Certificates[0].Leaf.NotBefore }
 func (c *certConfig) End() time.Time   { return c.tlsConf.Certificates[0].Leaf.NotAfter }
 
-func newCertConfig(key ic.PrivKey, start, end time.Time) (*certConfig, error) {
-	conf, err := getTLSConf(key, start, end)
-	if err != nil {
+func newCertConfig(cert *tls.Certificate) (*certConfig, error) {
+	if err := checkCertificate(cert); err != nil {
 		return nil, err
 	}
 	return &certConfig{
-		tlsConf: conf,
-		sha256:  sha256.Sum256(conf.Certificates[0].Leaf.Raw),
+		tlsConf: &tls.Config{
+			Certificates: []tls.Certificate{*cert},
+			NextProtos:   []string{http3.NextProtoH3},
+		},
+		sha256: sha256.Sum256(cert.Leaf.Raw),
 	}, nil
 }
 
+// certRetryInterval is the interval at which the certificates are requested
+// again when the certificate provider fails.
+const certRetryInterval = time.Minute
+
 // Certificate renewal logic:
-//  1. On startup, we generate one cert that is valid from now (-1h, to allow for clock skew), and another
-//     cert that is valid from the expiry date of the first certificate (again, with allowance for clock skew).
-//  2. Once we reach 1h before expiry of the first certificate, we switch over to the second certificate.
-//     At the same time, we stop advertising the certhash of the first cert and generate the next cert.
+//  1. On startup, we get the current certificate from the certificate provider, and the certificate
+//     that will replace it. By default, the current cert is valid from now (-1h, to allow for clock skew),
+//     and the next cert is valid from the expiry date of the first certificate (again, with allowance for
+//     clock skew).
+//  2. Once we reach 1h before expiry of the current certificate, we switch over to the next certificate.
+//     At the same time, we stop advertising the certhash of the first cert and get the next cert.
+//  3. If the certificate provider can change its certificates at any time, we switch over to the new
+//     certificates when it notifies us.
 type certManager struct {
 	clock     clock.Clock
 	ctx       context.Context
 	ctxCancel context.CancelFunc
 	refCount  sync.WaitGroup
 
+	provider CertificateProvider
+	// onAddrChange is called when the multiaddr component returned by
+	// AddrComponent changes.
+	onAddrChange func()
+
 	mx            sync.RWMutex
 	lastConfig    *certConfig // initially nil
 	currentConfig *certConfig
-	nextConfig    *certConfig // nil until we have passed half the certValidity of the current config
+	nextConfig    *certConfig // nil if the provider doesn't know the next certificate yet
 	addrComp      ma.Multiaddr
 
 	serializedCertHashes [][]byte
 }
 
-func newCertManager(hostKey ic.PrivKey, clock clock.Clock) (*certManager, error) {
-	m := &certManager{clock: clock}
+func newCertManager(provider CertificateProvider, clock clock.Clock, onAddrChange func()) (*certManager, error) {
+	m := &certManager{clock: clock, provider: provider, onAddrChange: onAddrChange}
 	m.ctx, m.ctxCancel = context.WithCancel(context.Background())
-	if err := m.init(hostKey); err != nil {
+	if _, err := m.refresh(m.clock.Now()); err != nil {
 		return nil, err
 	}
 
-	m.background(hostKey)
+	m.background()
 	return m, nil
 }
 
@@ -85,47 +99,54 @@ func getCurrentBucketStartTime(now time.Time, offset time.Duration) time.Time {
 	return time.UnixMilli(offset.Milliseconds() + currentBucket*validityMinusTwoSkew.Milliseconds())
 }
 
-func (m *certManager) init(hostKey ic.PrivKey) error {
-	start := m.clock.Now()
-	pubkeyBytes, err := hostKey.GetPublic().Raw()
+// refresh gets the certificates from the provider, and reports whether the
+// address component changed.
+func (m *certManager) refresh(now time.Time) (bool, error) {
+	current, next, err := m.provider.Certificates(now)
 	if err != nil {
-		return err
+		return false, err
 	}
-
-	// We want to add a random offset to each start time so that not all certs
-	// rotate at the same time across the network. The offset represents moving
-	// the bucket start time some `offset` earlier.
-	offset := (time.Duration(binary.LittleEndian.Uint16(pubkeyBytes)) * time.Minute) % certValidity
-
-	// We want the certificate have been valid for at least one clockSkewAllowance
-	start = start.Add(-clockSkewAllowance)
-	startTime := getCurrentBucketStartTime(start, offset)
-	m.nextConfig, err = newCertConfig(hostKey, startTime, startTime.Add(certValidity))
+	currentConfig, err := newCertConfig(current)
 	if err != nil {
-		return err
+		return false, fmt.Errorf("invalid current certificate: %w", err)
+	}
+	if !now.Before(currentConfig.End().Add(-clockSkewAllowance)) {
+		return false, fmt.Errorf("current certificate expires too soon (NotAfter: %s)", currentConfig.End())
+	}
+	var nextConfig *certConfig
+	if next != nil {
+		nextConfig, err = newCertConfig(next)
+		if err != nil {
+			return false, fmt.Errorf("invalid next certificate: %w", err)
+		}
 	}
-	return m.rollConfig(hostKey)
-}
 
-func (m *certManager) rollConfig(hostKey ic.PrivKey) error {
-	// We stop using the current certificate clockSkewAllowance before its expiry time.
-	// At this point, the next certificate needs to be valid for one clockSkewAllowance.
-	nextStart := m.nextConfig.End().Add(-2 * clockSkewAllowance)
-	c, err := newCertConfig(hostKey, nextStart, nextStart.Add(certValidity))
-	if err != nil {
-		return err
+	m.mx.Lock()
+	defer m.mx.Unlock()
+	if m.currentConfig == nil || m.currentConfig.sha256 != currentConfig.sha256 {
+		m.lastConfig = m.currentConfig
+		m.currentConfig = currentConfig
 	}
-	m.lastConfig = m.currentConfig
-	m.currentConfig = m.nextConfig
-	m.nextConfig = c
+	m.nextConfig = nextConfig
 	if err := m.cacheSerializedCertHashes(); err != nil {
-		return err
+		return false, err
 	}
-	return m.cacheAddrComponent()
+	prev := m.addrComp
+	if err := m.cacheAddrComponent(); err != nil {
+		return false, err
+	}
+	return prev != nil && !prev.Equal(m.addrComp), nil
 }
 
-func (m *certManager) background(hostKey ic.PrivKey) {
+func (m *certManager) background() {
+	var changed <-chan struct{}
+	if n, ok := m.provider.(CertificateNotifier); ok {
+		changed = n.CertificatesChanged()
+	}
+
+	m.mx.RLock()
 	d := m.currentConfig.End().Add(-clockSkewAllowance).Sub(m.clock.Now())
+	m.mx.RUnlock()
 	log.Debugw("setting timer", "duration", d.String())
 	t := m.clock.Timer(d)
 	m.refCount.Add(1)
@@ -138,16 +159,30 @@ func (m *certManager) background(hostKey ic.PrivKey) {
 			select {
 			case <-m.ctx.Done():
 				return
+			case <-changed:
+				log.Debug("certificates changed")
 			case <-t.C:
-				now := m.clock.Now()
-				m.mx.Lock()
-				if err := m.rollConfig(hostKey); err != nil {
-					log.Errorw("rolling config failed", "error", err)
-				}
-				d := m.currentConfig.End().Add(-clockSkewAllowance).Sub(now)
+			}
+			now := m.clock.Now()
+			addrChanged, err := m.refresh(now)
+			d := certRetryInterval
+			if err != nil {
+				log.Errorw("rolling config failed", "error", err)
+			} else {
+				m.mx.RLock()
+				d = m.currentConfig.End().Add(-clockSkewAllowance).Sub(now)
+				m.mx.RUnlock()
 				log.Debugw("rolling certificates", "next", d.String())
-				t.Reset(d)
-				m.mx.Unlock()
+			}
+			if !t.Stop() {
+				select {
+				case <-t.C:
+				default:
+				}
+			}
+			t.Reset(d)
+			if addrChanged && m.onAddrChange != nil {
+				m.onAddrChange()
 			}
 		}
 	}()
@@ -166,6 +201,8 @@ func (m *certManager) AddrComponent() ma.Multiaddr {
 }
 
 func (m *certManager) SerializedCertHashes() [][]byte {
+	m.mx.RLock()
+	defer m.mx.RUnlock()
 	return m.serializedCertHashes
 }
 
@@ -179,7 +216,8 @@ func (m *certManager) cacheSerializedCertHashes() error {
 		hashes = append(hashes, m.nextConfig.sha256)
 	}
 
-	m.serializedCertHashes = m.serializedCertHashes[:0]
+	// Don't modify the slice in place, it might still be in use.
+	m.serializedCertHashes = make([][]byte, 0, len(hashes))
 	for _, certHash := range hashes {
 		h, err := multihash.Encode(certHash[:], multihash.SHA2_256)
 		if err != nil {
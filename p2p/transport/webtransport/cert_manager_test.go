@@ -41,12 +41,19 @@ func certHashFromComponent(t *testing.T, comp ma.Component) []byte {
 	return mh.Digest
 }
 
+func newTestCertProvider(t *testing.T, key crypto.PrivKey) CertificateProvider {
+	t.Helper()
+	p, err := NewDeterministicCertificateProvider(key)
+	require.NoError(t, err)
+	return p
+}
+
 func TestInitialCert(t *testing.T) {
 	cl := clock.NewMock()
 	cl.Add(1234567 * time.Hour)
 	priv, _, err := test.RandTestKeyPair(crypto.Ed25519, 256)
 	require.NoError(t, err)
-	m, err := newCertManager(priv, cl)
+	m, err := newCertManager(newTestCertProvider(t, priv), cl, nil)
 	require.NoError(t, err)
 	defer m.Close()
 
@@ -70,7 +77,7 @@ func TestCertRenewal(t *testing.T) {
 	cl.Add(time.Hour * 24 * 365)
 	priv, _, err := test.SeededTestKeyPair(crypto.Ed25519, 256, 0)
 	require.NoError(t, err)
-	m, err := newCertManager(priv, cl)
+	m, err := newCertManager(newTestCertProvider(t, priv), cl, nil)
 	require.NoError(t, err)
 	defer m.Close()
 
@@ -120,7 +127,7 @@ func TestDeterministicCertsAcrossReboots(t *testing.T) {
 			cl := clock.NewMock()
 			priv, _, err := test.SeededTestKeyPair(crypto.Ed25519, 256, 0)
 			require.NoError(t, err)
-			m, err := newCertManager(priv, cl)
+			m, err := newCertManager(newTestCertProvider(t, priv), cl, nil)
 			require.NoError(t, err)
 			defer m.Close()
 
@@ -132,7 +139,7 @@ func TestDeterministicCertsAcrossReboots(t *testing.T) {
 
 			cl.Add(time.Hour)
 			// reboot
-			m, err = newCertManager(priv, cl)
+			m, err = newCertManager(newTestCertProvider(t, priv), cl, nil)
 			require.NoError(t, err)
 			defer m.Close()
 
@@ -0,0 +1,306 @@
+package libp2pwebtransport
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/binary"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+
+	ic "github.com/libp2p/go-libp2p/core/crypto"
+)
+
+// A CertificateProvider provides the certificates the transport listens with.
+//
+// The transport serves the current certificate, and advertises the hashes of
+// both the current and the next certificate, so that clients that learnt our
+// addresses before a rotation can still connect. It asks the provider for new
+// certificates one hour (to allow for clock skew) before the current one
+// expires.
+type CertificateProvider interface {
+	// Certificates returns the certificate to serve at now, and the
+	// certificate that will replace it, or nil if it's not known yet.
+	// The certificates must be self-signed ECDSA certificates valid for at
+	// most 14 days, and the current certificate must remain valid for more
+	// than an hour after now. Calls made while the current certificate is
+	// valid should return the same certificates, for the advertised
+	// certificate hashes to remain stable.
+	Certificates(now time.Time) (current, next *tls.Certificate, err error)
+}
+
+// A CertificateNotifier is a CertificateProvider whose certificates can change
+// outside of the rotation schedule of the transport, for example because they
+// are managed externally.
+type CertificateNotifier interface {
+	CertificateProvider
+	// CertificatesChanged returns a channel that is signaled when the
+	// certificates returned by Certificates change.
+	CertificatesChanged() <-chan struct{}
+}
+
+// WithCertificateProvider configures the transport to listen with the
+// certificates provided by p. By default, the certificates are derived from
+// the host key, see NewDeterministicCertificateProvider.
+func WithCertificateProvider(p CertificateProvider) Option {
+	return func(t *transport) error {
+		if p == nil {
+			return errors.New("certificate provider must not be nil")
+		}
+		t.certProvider = p
+		return nil
+	}
+}
+
+type deterministicCertProvider struct {
+	key    ic.PrivKey
+	offset time.Duration
+
+	mx    sync.Mutex
+	certs map[int64]*tls.Certificate // by start time
+}
+
+var _ CertificateProvider = &deterministicCertProvider{}
+
+// NewDeterministicCertificateProvider returns a provider that derives the
+// certificates from key, for fixed time periods. Restarted nodes and replicas
+// sharing the same key use the same certificates, without persisting any state.
+//
+// The time periods are offset by a duration derived from the public key, so
+// that not all nodes of the network rotate their certificates at the same time.
+func NewDeterministicCertificateProvider(key ic.PrivKey) (CertificateProvider, error) {
+	pubkeyBytes, err := key.GetPublic().Raw()
+	if err != nil {
+		return nil, err
+	}
+	return &deterministicCertProvider{
+		key: key,
+		// We want to add a random offset to each start time so that not all certs
+		// rotate at the same time across the network. The offset represents moving
+		// the bucket start time some `offset` earlier.
+		offset: (time.Duration(binary.LittleEndian.Uint16(pubkeyBytes)) * time.Minute) % certValidity,
+		certs:  make(map[int64]*tls.Certificate),
+	}, nil
+}
+
+func (p *deterministicCertProvider) Certificates(now time.Time) (current, next *tls.Certificate, err error) {
+	p.mx.Lock()
+	defer p.mx.Unlock()
+
+	// We want the certificate have been valid for at least one clockSkewAllowance
+	start := getCurrentBucketStartTime(now.Add(-clockSkewAllowance), p.offset)
+	current, err = p.certificate(start)
+	if err != nil {
+		return nil, nil, err
+	}
+	// We stop using the current certificate clockSkewAllowance before its expiry time.
+	// At this point, the next certificate needs to be valid for one clockSkewAllowance.
+	next, err = p.certificate(current.Leaf.NotAfter.Add(-2 * clockSkewAllowance))
+	if err != nil {
+		return nil, nil, err
+	}
+	// Only keep the certificates that may be returned again.
+	for k := range p.certs {
+		if k < start.UnixNano() {
+			delete(p.certs, k)
+		}
+	}
+	return current, next, nil
+}
+
+func (p *deterministicCertProvider) certificate(start time.Time) (*tls.Certificate, error) {
+	if c, ok := p.certs[start.UnixNano()]; ok {
+		return c, nil
+	}
+	cert, priv, err := generateCert(p.key, start, start.Add(certValidity))
+	if err != nil {
+		return nil, err
+	}
+	c := &tls.Certificate{
+		Certificate: [][]byte{cert.Raw},
+		PrivateKey:  priv,
+		Leaf:        cert,
+	}
+	p.certs[start.UnixNano()] = c
+	return c, nil
+}
+
+// newRandomCertificate generates a certificate with a random key, valid for
+// certValidity from start.
+func newRandomCertificate(start time.Time) (*tls.Certificate, error) {
+	cert, priv, err := generateCertWithRand(rand.Reader, start, start.Add(certValidity))
+	if err != nil {
+		return nil, err
+	}
+	return &tls.Certificate{
+		Certificate: [][]byte{cert.Raw},
+		PrivateKey:  priv,
+		Leaf:        cert,
+	}, nil
+}
+
+// selectCertificates selects the current and the next certificates among
+// certs, which must have their Leaf set. The current certificate is the one
+// valid at now that was valid first, and the next certificate the one valid
+// once the current one is phased out that was valid first. Ties are broken by
+// certificate hash, so that the selection doesn't depend on the order of certs.
+func selectCertificates(certs []*tls.Certificate, now time.Time) (current, next *tls.Certificate) {
+	sorted := slices.Clone(certs)
+	slices.SortFunc(sorted, func(a, b *tls.Certificate) int {
+		if c := a.Leaf.NotBefore.Compare(b.Leaf.NotBefore); c != 0 {
+			return c
+		}
+		ha, hb := sha256.Sum256(a.Leaf.Raw), sha256.Sum256(b.Leaf.Raw)
+		return bytes.Compare(ha[:], hb[:])
+	})
+	for _, c := range sorted {
+		if !c.Leaf.NotBefore.After(now) && now.Before(c.Leaf.NotAfter.Add(-clockSkewAllowance)) {
+			current = c
+			break
+		}
+	}
+	if current == nil {
+		return nil, nil
+	}
+	switchover := current.Leaf.NotAfter.Add(-clockSkewAllowance)
+	for _, c := range sorted {
+		if c.Leaf.NotBefore.After(current.Leaf.NotBefore) && !c.Leaf.NotBefore.After(switchover) && c.Leaf.NotAfter.After(current.Leaf.NotAfter) {
+			next = c
+			break
+		}
+	}
+	return current, next
+}
+
+// marshalCertificate encodes c and its private key as PEM.
+func marshalCertificate(c *tls.Certificate) ([]byte, error) {
+	key, err := x509.MarshalPKCS8PrivateKey(c.PrivateKey)
+	if err != nil {
+		return nil, err
+	}
+	var b bytes.Buffer
+	for _, der := range c.Certificate {
+		if err := pem.Encode(&b, &pem.Block{Type: "CERTIFICATE", Bytes: der}); err != nil {
+			return nil, err
+		}
+	}
+	if err := pem.Encode(&b, &pem.Block{Type: "PRIVATE KEY", Bytes: key}); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+
+// unmarshalCertificate decodes a certificate and its private key from PEM.
+func unmarshalCertificate(b []byte) (*tls.Certificate, error) {
+	c, err := tls.X509KeyPair(b, b)
+	if err != nil {
+		return nil, err
+	}
+	if c.Leaf == nil {
+		c.Leaf, err = x509.ParseCertificate(c.Certificate[0])
+		if err != nil {
+			return nil, err
+		}
+	}
+	return &c, nil
+}
+
+// checkCertificate checks that c can be used by the transport, and sets its
+// Leaf if needed.
+func checkCertificate(c *tls.Certificate) error {
+	if len(c.Certificate) == 0 {
+		return errors.New("no certificate")
+	}
+	if c.Leaf == nil {
+		leaf, err := x509.ParseCertificate(c.Certificate[0])
+		if err != nil {
+			return err
+		}
+		c.Leaf = leaf
+	}
+	if _, ok := c.Leaf.PublicKey.(*ecdsa.PublicKey); !ok {
+		return fmt.Errorf("certificate must use an ECDSA key, got %T", c.Leaf.PublicKey)
+	}
+	if l := c.Leaf.NotAfter.Sub(c.Leaf.NotBefore); l > certValidity {
+		return fmt.Errorf("certificate must not be valid for longer than 14 days (NotBefore: %s, NotAfter: %s)", c.Leaf.NotBefore, c.Leaf.NotAfter)
+	}
+	return nil
+}
+
+// certPollInterval is the interval at which externally managed certificates
+// are checked for changes.
+var certPollInterval = time.Minute
+
+// certWatcher signals when the certificates of a provider change. It
+// periodically polls the certificates, and compares them to the certificates
+// the provider returned last.
+type certWatcher struct {
+	changed chan struct{}
+
+	mx            sync.Mutex
+	current, next [32]byte // hashes of the certificates returned last
+
+	closeOnce sync.Once
+	closing   chan struct{}
+	done      chan struct{}
+}
+
+func newCertWatcher() *certWatcher {
+	return &certWatcher{
+		changed: make(chan struct{}, 1),
+		closing: make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+}
+
+func certHash(c *tls.Certificate) [32]byte {
+	if c == nil {
+		return [32]byte{}
+	}
+	return sha256.Sum256(c.Certificate[0])
+}
+
+// start starts polling the certificates with poll.
+func (w *certWatcher) start(poll func(now time.Time) (current, next *tls.Certificate)) {
+	go func() {
+		defer close(w.done)
+		t := time.NewTicker(certPollInterval)
+		defer t.Stop()
+		for {
+			select {
+			case <-w.closing:
+				return
+			case now := <-t.C:
+				current, next := poll(now)
+				w.mx.Lock()
+				changed := certHash(current) != w.current || certHash(next) != w.next
+				w.mx.Unlock()
+				if changed {
+					select {
+					case w.changed <- struct{}{}:
+					default:
+					}
+				}
+			}
+		}
+	}()
+}
+
+// returned records the certificates returned by the provider.
+func (w *certWatcher) returned(current, next *tls.Certificate) {
+	w.mx.Lock()
+	w.current, w.next = certHash(current), certHash(next)
+	w.mx.Unlock()
+}
+
+func (w *certWatcher) close() {
+	w.closeOnce.Do(func() { close(w.closing) })
+	<-w.done
+}
@@ -0,0 +1,153 @@
+package libp2pwebtransport
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/hex"
+	"errors"
+	"sync"
+	"time"
+
+	ds "github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-datastore/namespace"
+	"github.com/ipfs/go-datastore/query"
+)
+
+const certDatastoreNamespace = "/libp2p/transport/webtransport/certs"
+
+// DatastoreCertificateProvider is a CertificateProvider that generates
+// certificates with random keys, and persists them in a datastore.
+type DatastoreCertificateProvider struct {
+	ds      ds.Datastore
+	watcher *certWatcher
+
+	mx sync.Mutex
+}
+
+var _ CertificateNotifier = &DatastoreCertificateProvider{}
+
+// NewDatastoreCertificateProvider returns a provider that persists the
+// certificates in d, so that they survive restarts. Replicas sharing the same
+// identity and datastore use the same certificates: when several replicas
+// generate certificates at the same time, they all end up using the first
+// one, according to the ordering of selectCertificates.
+//
+// The datastore is polled for certificates stored by other replicas. Close
+// stops polling.
+func NewDatastoreCertificateProvider(d ds.Datastore) (*DatastoreCertificateProvider, error) {
+	if d == nil {
+		return nil, errors.New("certificate datastore must not be nil")
+	}
+	p := &DatastoreCertificateProvider{
+		ds:      namespace.Wrap(d, ds.NewKey(certDatastoreNamespace)),
+		watcher: newCertWatcher(),
+	}
+	p.watcher.start(p.poll)
+	return p, nil
+}
+
+// Certificates implements CertificateProvider. The certificates are generated
+// if none of the persisted certificates can be used.
+func (p *DatastoreCertificateProvider) Certificates(now time.Time) (current, next *tls.Certificate, err error) {
+	p.mx.Lock()
+	defer p.mx.Unlock()
+
+	ctx := context.Background()
+	certs, err := p.load(ctx, now)
+	if err != nil {
+		return nil, nil, err
+	}
+	current, next = selectCertificates(certs, now)
+	if current == nil || next == nil {
+		if current == nil {
+			// Round the start time, so that replicas that generate
+			// certificates concurrently generate certificates that can be
+			// ordered by hash.
+			current, err = newRandomCertificate(now.Add(-clockSkewAllowance).Truncate(time.Hour))
+			if err != nil {
+				return nil, nil, err
+			}
+			if err := p.store(ctx, current); err != nil {
+				return nil, nil, err
+			}
+		}
+		next, err = newRandomCertificate(current.Leaf.NotAfter.Add(-2 * clockSkewAllowance))
+		if err != nil {
+			return nil, nil, err
+		}
+		if err := p.store(ctx, next); err != nil {
+			return nil, nil, err
+		}
+		// Another replica might have stored certificates in the meantime.
+		certs, err = p.load(ctx, now)
+		if err != nil {
+			return nil, nil, err
+		}
+		current, next = selectCertificates(certs, now)
+		if current == nil {
+			return nil, nil, errors.New("generated certificate not found in the datastore")
+		}
+	}
+	p.watcher.returned(current, next)
+	return current, next, nil
+}
+
+// CertificatesChanged implements CertificateNotifier.
+func (p *DatastoreCertificateProvider) CertificatesChanged() <-chan struct{} {
+	return p.watcher.changed
+}
+
+// Close stops polling the datastore.
+func (p *DatastoreCertificateProvider) Close() error {
+	p.watcher.close()
+	return nil
+}
+
+func (p *DatastoreCertificateProvider) poll(now time.Time) (current, next *tls.Certificate) {
+	p.mx.Lock()
+	defer p.mx.Unlock()
+	certs, err := p.load(context.Background(), now)
+	if err != nil {
+		log.Debugw("failed to load certificates", "error", err)
+		return nil, nil
+	}
+	return selectCertificates(certs, now)
+}
+
+// load loads the persisted certificates, and deletes the expired ones.
+func (p *DatastoreCertificateProvider) load(ctx context.Context, now time.Time) ([]*tls.Certificate, error) {
+	res, err := p.ds.Query(ctx, query.Query{})
+	if err != nil {
+		return nil, err
+	}
+	defer res.Close()
+
+	var certs []*tls.Certificate
+	for r := range res.Next() {
+		if r.Error != nil {
+			return nil, r.Error
+		}
+		c, err := unmarshalCertificate(r.Value)
+		if err != nil {
+			log.Errorw("failed to parse persisted certificate", "key", r.Key, "error", err)
+			continue
+		}
+		if now.After(c.Leaf.NotAfter) {
+			if err := p.ds.Delete(ctx, ds.NewKey(r.Key)); err != nil {
+				log.Debugw("failed to delete expired certificate", "key", r.Key, "error", err)
+			}
+			continue
+		}
+		certs = append(certs, c)
+	}
+	return certs, nil
+}
+
+func (p *DatastoreCertificateProvider) store(ctx context.Context, c *tls.Certificate) error {
+	b, err := marshalCertificate(c)
+	if err != nil {
+		return err
+	}
+	h := certHash(c)
+	return p.ds.Put(ctx, ds.NewKey(hex.EncodeToString(h[:])), b)
+}
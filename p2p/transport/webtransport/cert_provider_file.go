@@ -0,0 +1,120 @@
+package libp2pwebtransport
+
+import (
+	"crypto/tls"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// FileCertificateProvider is a CertificateProvider that reads the
+// certificates from files, allowing them to be managed by an external process.
+type FileCertificateProvider struct {
+	dir     string
+	watcher *certWatcher
+
+	mx      sync.Mutex
+	certs   []*tls.Certificate
+	version string // identifies the files the certificates were read from
+}
+
+var _ CertificateNotifier = &FileCertificateProvider{}
+
+// NewFileCertificateProvider returns a provider that reads the certificates
+// from the .pem files in dir. Each file must contain a certificate and its
+// private key, PEM encoded. The current and next certificates are selected
+// among the certificates that are valid, preferring the ones that became
+// valid first.
+//
+// The directory is polled for changes, so that certificates can be added and
+// removed while the transport is running. The addresses advertised by the
+// transport are updated when the selected certificates change. Close stops
+// polling.
+func NewFileCertificateProvider(dir string) (*FileCertificateProvider, error) {
+	p := &FileCertificateProvider{
+		dir:     dir,
+		watcher: newCertWatcher(),
+	}
+	if err := p.reload(); err != nil {
+		return nil, err
+	}
+	p.watcher.start(p.poll)
+	return p, nil
+}
+
+// Certificates implements CertificateProvider.
+func (p *FileCertificateProvider) Certificates(now time.Time) (current, next *tls.Certificate, err error) {
+	p.mx.Lock()
+	defer p.mx.Unlock()
+	if err := p.reload(); err != nil {
+		log.Errorw("failed to read certificates", "dir", p.dir, "error", err)
+	}
+	current, next = selectCertificates(p.certs, now)
+	if current == nil {
+		return nil, nil, fmt.Errorf("no valid certificate in %s", p.dir)
+	}
+	p.watcher.returned(current, next)
+	return current, next, nil
+}
+
+// CertificatesChanged implements CertificateNotifier.
+func (p *FileCertificateProvider) CertificatesChanged() <-chan struct{} {
+	return p.watcher.changed
+}
+
+// Close stops polling the directory.
+func (p *FileCertificateProvider) Close() error {
+	p.watcher.close()
+	return nil
+}
+
+func (p *FileCertificateProvider) poll(now time.Time) (current, next *tls.Certificate) {
+	p.mx.Lock()
+	defer p.mx.Unlock()
+	if err := p.reload(); err != nil {
+		log.Errorw("failed to read certificates", "dir", p.dir, "error", err)
+	}
+	return selectCertificates(p.certs, now)
+}
+
+// reload reads the certificates again if the files changed. If reading
+// fails, the certificates read previously are kept.
+func (p *FileCertificateProvider) reload() error {
+	files, err := filepath.Glob(filepath.Join(p.dir, "*.pem"))
+	if err != nil {
+		return err
+	}
+	var version strings.Builder
+	for _, f := range files {
+		fi, err := os.Stat(f)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(&version, "%s:%d:%d\n", f, fi.Size(), fi.ModTime().UnixNano())
+	}
+	if p.certs != nil && version.String() == p.version {
+		return nil
+	}
+
+	certs := make([]*tls.Certificate, 0, len(files))
+	for _, f := range files {
+		b, err := os.ReadFile(f)
+		if err != nil {
+			return err
+		}
+		c, err := unmarshalCertificate(b)
+		if err != nil {
+			return fmt.Errorf("failed to parse %s: %w", f, err)
+		}
+		if err := checkCertificate(c); err != nil {
+			return fmt.Errorf("invalid certificate in %s: %w", f, err)
+		}
+		certs = append(certs, c)
+	}
+	p.certs = certs
+	p.version = version.String()
+	return nil
+}
@@ -0,0 +1,329 @@
+package libp2pwebtransport
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/test"
+	"github.com/libp2p/go-libp2p/p2p/transport/quicreuse"
+
+	"github.com/benbjohnson/clock"
+	ds "github.com/ipfs/go-datastore"
+	dssync "github.com/ipfs/go-datastore/sync"
+	ic "github.com/libp2p/go-libp2p/core/crypto"
+	ma "github.com/multiformats/go-multiaddr"
+	"github.com/quic-go/quic-go"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestCertificate(t *testing.T, start time.Time) *tls.Certificate {
+	t.Helper()
+	c, err := newRandomCertificate(start)
+	require.NoError(t, err)
+	return c
+}
+
+func writeCertificate(t *testing.T, path string, c *tls.Certificate) {
+	t.Helper()
+	b, err := marshalCertificate(c)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(path, b, 0o600))
+}
+
+func setCertPollInterval(t *testing.T, d time.Duration) {
+	orig := certPollInterval
+	certPollInterval = d
+	t.Cleanup(func() { certPollInterval = orig })
+}
+
+func TestSelectCertificates(t *testing.T) {
+	now := time.Now().Truncate(time.Second)
+	expiring := newTestCertificate(t, now.Add(-certValidity+clockSkewAllowance/2))
+	current := newTestCertificate(t, now.Add(-2*clockSkewAllowance))
+	later := newTestCertificate(t, now.Add(-clockSkewAllowance))
+	next := newTestCertificate(t, current.Leaf.NotAfter.Add(-2*clockSkewAllowance))
+	future := newTestCertificate(t, now.Add(certValidity))
+
+	cur, nxt := selectCertificates(nil, now)
+	require.Nil(t, cur)
+	require.Nil(t, nxt)
+
+	cur, nxt = selectCertificates([]*tls.Certificate{future, next, later, current, expiring}, now)
+	require.Equal(t, current, cur)
+	require.Equal(t, later, nxt)
+
+	cur, nxt = selectCertificates([]*tls.Certificate{future, next, current}, now)
+	require.Equal(t, current, cur)
+	require.Equal(t, next, nxt)
+
+	// no certificate valid at now
+	cur, nxt = selectCertificates([]*tls.Certificate{future, next, expiring}, now)
+	require.Nil(t, cur)
+	require.Nil(t, nxt)
+
+	// ties are broken by hash, whatever the order
+	other := newTestCertificate(t, current.Leaf.NotBefore)
+	cur1, _ := selectCertificates([]*tls.Certificate{current, other}, now)
+	cur2, _ := selectCertificates([]*tls.Certificate{other, current}, now)
+	require.Equal(t, cur1, cur2)
+}
+
+func TestMarshalCertificate(t *testing.T) {
+	c := newTestCertificate(t, time.Now())
+	b, err := marshalCertificate(c)
+	require.NoError(t, err)
+	c2, err := unmarshalCertificate(b)
+	require.NoError(t, err)
+	require.Equal(t, c.Certificate, c2.Certificate)
+	require.Equal(t, c.PrivateKey, c2.PrivateKey)
+	require.Equal(t, c.Leaf.Raw, c2.Leaf.Raw)
+}
+
+func TestDeterministicCertificateProvider(t *testing.T) {
+	priv, _, err := test.SeededTestKeyPair(ic.Ed25519, 256, 0)
+	require.NoError(t, err)
+	p1, err := NewDeterministicCertificateProvider(priv)
+	require.NoError(t, err)
+	p2, err := NewDeterministicCertificateProvider(priv)
+	require.NoError(t, err)
+
+	now := time.Now()
+	current1, next1, err := p1.Certificates(now)
+	require.NoError(t, err)
+	current2, next2, err := p2.Certificates(now.Add(time.Hour))
+	require.NoError(t, err)
+	require.Equal(t, current1.Certificate, current2.Certificate)
+	require.Equal(t, next1.Certificate, next2.Certificate)
+
+	// after the switchover, the next certificate becomes the current one
+	current, next, err := p1.Certificates(current1.Leaf.NotAfter.Add(-clockSkewAllowance))
+	require.NoError(t, err)
+	require.Equal(t, next1.Certificate, current.Certificate)
+	require.NotEqual(t, next1.Certificate, next.Certificate)
+}
+
+func TestDatastoreCertificateProvider(t *testing.T) {
+	d := dssync.MutexWrap(ds.NewMapDatastore())
+	p1, err := NewDatastoreCertificateProvider(d)
+	require.NoError(t, err)
+	defer p1.Close()
+
+	now := time.Now()
+	current, next, err := p1.Certificates(now)
+	require.NoError(t, err)
+	require.NotNil(t, next)
+	require.True(t, current.Leaf.NotAfter.After(now.Add(clockSkewAllowance)))
+	require.NoError(t, checkCertificate(current))
+	require.NoError(t, checkCertificate(next))
+
+	// a replica sharing the datastore, or a restarted node, use the same certificates
+	p2, err := NewDatastoreCertificateProvider(d)
+	require.NoError(t, err)
+	defer p2.Close()
+	current2, next2, err := p2.Certificates(now.Add(time.Minute))
+	require.NoError(t, err)
+	require.Equal(t, current.Certificate, current2.Certificate)
+	require.Equal(t, next.Certificate, next2.Certificate)
+
+	// after the switchover, the next certificate becomes the current one,
+	// and a new next certificate is generated
+	later := current.Leaf.NotAfter.Add(-clockSkewAllowance)
+	current3, next3, err := p2.Certificates(later)
+	require.NoError(t, err)
+	require.Equal(t, next.Certificate, current3.Certificate)
+	require.NotNil(t, next3)
+	current4, next4, err := p1.Certificates(later)
+	require.NoError(t, err)
+	require.Equal(t, current3.Certificate, current4.Certificate)
+	require.Equal(t, next3.Certificate, next4.Certificate)
+
+	// expired certificates are deleted
+	_, _, err = p1.Certificates(current.Leaf.NotAfter.Add(time.Second))
+	require.NoError(t, err)
+	certs, err := p1.load(context.Background(), current.Leaf.NotAfter.Add(time.Second))
+	require.NoError(t, err)
+	require.Len(t, certs, 2)
+}
+
+func TestDatastoreCertificateProviderPolling(t *testing.T) {
+	setCertPollInterval(t, 10*time.Millisecond)
+	d := dssync.MutexWrap(ds.NewMapDatastore())
+	p, err := NewDatastoreCertificateProvider(d)
+	require.NoError(t, err)
+	defer p.Close()
+
+	now := time.Now()
+	current, _, err := p.Certificates(now)
+	require.NoError(t, err)
+
+	// another replica stored a certificate that takes precedence
+	replica, err := NewDatastoreCertificateProvider(d)
+	require.NoError(t, err)
+	defer replica.Close()
+	earlier := newTestCertificate(t, current.Leaf.NotBefore.Add(-time.Minute))
+	require.NoError(t, replica.store(context.Background(), earlier))
+
+	select {
+	case <-p.CertificatesChanged():
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the certificates to change")
+	}
+	current, _, err = p.Certificates(now)
+	require.NoError(t, err)
+	require.Equal(t, earlier.Certificate, current.Certificate)
+}
+
+func TestFileCertificateProvider(t *testing.T) {
+	setCertPollInterval(t, 10*time.Millisecond)
+	dir := t.TempDir()
+	now := time.Now()
+	first := newTestCertificate(t, now.Add(-clockSkewAllowance))
+	writeCertificate(t, filepath.Join(dir, "first.pem"), first)
+
+	p, err := NewFileCertificateProvider(dir)
+	require.NoError(t, err)
+	defer p.Close()
+
+	current, next, err := p.Certificates(now)
+	require.NoError(t, err)
+	require.Equal(t, first.Certificate, current.Certificate)
+	require.Nil(t, next)
+
+	// adding the next certificate
+	second := newTestCertificate(t, first.Leaf.NotAfter.Add(-2*clockSkewAllowance))
+	writeCertificate(t, filepath.Join(dir, "second.pem"), second)
+	select {
+	case <-p.CertificatesChanged():
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the certificates to change")
+	}
+	current, next, err = p.Certificates(now)
+	require.NoError(t, err)
+	require.Equal(t, first.Certificate, current.Certificate)
+	require.Equal(t, second.Certificate, next.Certificate)
+
+	// invalid files are ignored, and the certificates read before are kept
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "invalid.pem"), []byte("foobar"), 0o600))
+	current, _, err = p.Certificates(now)
+	require.NoError(t, err)
+	require.Equal(t, first.Certificate, current.Certificate)
+	require.NoError(t, os.Remove(filepath.Join(dir, "invalid.pem")))
+
+	// removing all the certificates valid now
+	require.NoError(t, os.Remove(filepath.Join(dir, "first.pem")))
+	_, _, err = p.Certificates(now)
+	require.Error(t, err)
+
+	// the initial certificates must be valid
+	invalidDir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(invalidDir, "invalid.pem"), []byte("foobar"), 0o600))
+	_, err = NewFileCertificateProvider(invalidDir)
+	require.Error(t, err)
+}
+
+func TestCertManagerNotification(t *testing.T) {
+	setCertPollInterval(t, 10*time.Millisecond)
+	dir := t.TempDir()
+	now := time.Now()
+	first := newTestCertificate(t, now.Add(-clockSkewAllowance))
+	writeCertificate(t, filepath.Join(dir, "cert.pem"), first)
+	p, err := NewFileCertificateProvider(dir)
+	require.NoError(t, err)
+	defer p.Close()
+
+	changed := make(chan struct{}, 1)
+	m, err := newCertManager(p, clock.New(), func() { changed <- struct{}{} })
+	require.NoError(t, err)
+	defer m.Close()
+	require.Len(t, m.SerializedCertHashes(), 1)
+	firstAddr := splitMultiaddr(m.AddrComponent())
+	require.Len(t, firstAddr, 1)
+
+	// replace the certificate
+	second := newTestCertificate(t, now.Add(-2*clockSkewAllowance))
+	writeCertificate(t, filepath.Join(dir, "cert.pem"), second)
+	select {
+	case <-changed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the address to change")
+	}
+	require.Equal(t, certificateHashFromTLSConfig(m.GetConfig()), certHash(second))
+	secondAddr := splitMultiaddr(m.AddrComponent())
+	require.Len(t, secondAddr, 1)
+	require.NotEqual(t, firstAddr[0].Value(), secondAddr[0].Value())
+	// the hash of the replaced certificate is still sent to clients
+	require.Len(t, m.SerializedCertHashes(), 2)
+}
+
+func TestCertManagerRejectsInvalidCertificates(t *testing.T) {
+	dir := t.TempDir()
+	writeCertificate(t, filepath.Join(dir, "cert.pem"), newTestCertificate(t, time.Now().Add(-clockSkewAllowance)))
+	p, err := NewFileCertificateProvider(dir)
+	require.NoError(t, err)
+	defer p.Close()
+
+	// the certificate expires within the clock skew allowance
+	cl := clock.NewMock()
+	cl.Set(time.Now().Add(certValidity - 2*clockSkewAllowance + time.Minute))
+	_, err = newCertManager(staticCertProvider{p}, cl, nil)
+	require.Error(t, err)
+}
+
+// staticCertProvider returns the certificates of the wrapped provider at
+// time.Now, whatever time it's asked for.
+type staticCertProvider struct{ CertificateProvider }
+
+func (p staticCertProvider) Certificates(time.Time) (current, next *tls.Certificate, err error) {
+	return p.CertificateProvider.Certificates(time.Now())
+}
+
+func TestTransportCertificateProvider(t *testing.T) {
+	setCertPollInterval(t, 10*time.Millisecond)
+	dir := t.TempDir()
+	now := time.Now()
+	first := newTestCertificate(t, now.Add(-clockSkewAllowance))
+	writeCertificate(t, filepath.Join(dir, "cert.pem"), first)
+	p, err := NewFileCertificateProvider(dir)
+	require.NoError(t, err)
+	defer p.Close()
+
+	key, _, err := ic.GenerateEd25519Key(rand.Reader)
+	require.NoError(t, err)
+	cm, err := quicreuse.NewConnManager(quic.StatelessResetKey{}, quic.TokenGeneratorKey{})
+	require.NoError(t, err)
+	defer cm.Close()
+	tpt, err := New(key, nil, cm, nil, &network.NullResourceManager{}, WithCertificateProvider(p))
+	require.NoError(t, err)
+	tr := tpt.(*transport)
+	defer tr.Close()
+
+	ln, err := tr.Listen(ma.StringCast("/ip4/127.0.0.1/udp/0/quic-v1/webtransport"))
+	require.NoError(t, err)
+	defer ln.Close()
+	addr, ok := tr.AddCertHashes(ma.StringCast("/ip4/1.2.3.4/udp/1234/quic-v1/webtransport"))
+	require.True(t, ok)
+	hash := certHash(first)
+	comp, err := addrComponentForCert(hash[:])
+	require.NoError(t, err)
+	require.True(t, addr.Equal(ma.StringCast("/ip4/1.2.3.4/udp/1234/quic-v1/webtransport").Encapsulate(comp)))
+
+	second := newTestCertificate(t, now.Add(-2*clockSkewAllowance))
+	writeCertificate(t, filepath.Join(dir, "cert.pem"), second)
+	select {
+	case <-tr.CertHashesChanged():
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the certificate hashes to change")
+	}
+	hash = certHash(second)
+	comp, err = addrComponentForCert(hash[:])
+	require.NoError(t, err)
+	addr, ok = tr.AddCertHashes(ma.StringCast("/ip4/1.2.3.4/udp/1234/quic-v1/webtransport"))
+	require.True(t, ok)
+	require.True(t, addr.Equal(ma.StringCast("/ip4/1.2.3.4/udp/1234/quic-v1/webtransport").Encapsulate(comp)))
+}
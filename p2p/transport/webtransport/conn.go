@@ -2,6 +2,7 @@ package libp2pwebtransport
 
 import (
 	"context"
+	"errors"
 
 	"github.com/libp2p/go-libp2p/core/network"
 	tpt "github.com/libp2p/go-libp2p/core/transport"
@@ -47,17 +48,17 @@ func newConn(tr *transport, sess *webtransport.Session, sconn *connSecurityMulti
 func (c *conn) OpenStream(ctx context.Context) (network.MuxedStream, error) {
 	str, err := c.session.OpenStreamSync(ctx)
 	if err != nil {
-		return nil, err
+		return nil, parseSessionError(err)
 	}
-	return &stream{str}, nil
+	return &stream{Stream: str}, nil
 }
 
 func (c *conn) AcceptStream() (network.MuxedStream, error) {
 	str, err := c.session.AcceptStream(context.Background())
 	if err != nil {
-		return nil, err
+		return nil, parseSessionError(err)
 	}
-	return &stream{str}, nil
+	return &stream{Stream: str}, nil
 }
 
 func (c *conn) allowWindowIncrease(size uint64) bool {
@@ -68,9 +69,27 @@ func (c *conn) allowWindowIncrease(size uint64) bool {
 // It must be called even if the peer closed the connection in order for
 // garbage collection to properly work in this package.
 func (c *conn) Close() error {
+	return c.CloseWithError(network.ConnNoError)
+}
+
+// CloseWithError closes the connection, sending the error code to the remote
+// peer as the WebTransport session error code.
+func (c *conn) CloseWithError(errCode network.ConnErrorCode) error {
 	c.scope.Done()
 	c.transport.removeConn(c.session)
-	return c.session.CloseWithError(0, "")
+	return c.session.CloseWithError(webtransport.SessionErrorCode(errCode), "")
+}
+
+func parseSessionError(err error) error {
+	ce := &webtransport.ConnectionError{}
+	if errors.As(err, &ce) {
+		return &network.ConnError{
+			ErrorCode:      network.ConnErrorCode(ce.ErrorCode),
+			Remote:         ce.Remote,
+			TransportError: ce,
+		}
+	}
+	return err
 }
 
 func (c *conn) IsClosed() bool           { return c.session.Context().Err() != nil }
@@ -5,7 +5,6 @@ import (
 	"crypto/ecdsa"
 	"crypto/elliptic"
 	"crypto/sha256"
-	"crypto/tls"
 	"crypto/x509"
 	"crypto/x509/pkix"
 	"encoding/binary"
@@ -15,45 +14,29 @@ import (
 	"math/big"
 	"time"
 
-	"golang.org/x/crypto/hkdf"
-
 	ic "github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/p2p/transport/internal/deterministic"
 
 	"github.com/multiformats/go-multihash"
-	"github.com/quic-go/quic-go/http3"
 )
 
 const deterministicCertInfo = "determinisitic cert"
 
-func getTLSConf(key ic.PrivKey, start, end time.Time) (*tls.Config, error) {
-	cert, priv, err := generateCert(key, start, end)
-	if err != nil {
-		return nil, err
-	}
-	return &tls.Config{
-		Certificates: []tls.Certificate{{
-			Certificate: [][]byte{cert.Raw},
-			PrivateKey:  priv,
-			Leaf:        cert,
-		}},
-		NextProtos: []string{http3.NextProtoH3},
-	}, nil
-}
-
 // generateCert generates certs deterministically based on the `key` and start
 // time passed in. Uses `golang.org/x/crypto/hkdf`.
 func generateCert(key ic.PrivKey, start, end time.Time) (*x509.Certificate, *ecdsa.PrivateKey, error) {
-	keyBytes, err := key.Raw()
+	r, err := deterministic.NewCertReader(key, start, deterministicCertInfo)
 	if err != nil {
 		return nil, nil, err
 	}
+	return generateCertWithRand(r, start, end)
+}
 
-	startTimeSalt := make([]byte, 8)
-	binary.LittleEndian.PutUint64(startTimeSalt, uint64(start.UnixNano()))
-	deterministicHKDFReader := newDeterministicReader(keyBytes, startTimeSalt, deterministicCertInfo)
-
+// generateCertWithRand generates a self-signed ECDSA certificate valid from
+// start until end, using rand as the source of randomness.
+func generateCertWithRand(rand io.Reader, start, end time.Time) (*x509.Certificate, *ecdsa.PrivateKey, error) {
 	b := make([]byte, 8)
-	if _, err := deterministicHKDFReader.Read(b); err != nil {
+	if _, err := io.ReadFull(rand, b); err != nil {
 		return nil, nil, err
 	}
 	serial := int64(binary.BigEndian.Uint64(b))
@@ -71,11 +54,11 @@ func generateCert(key ic.PrivKey, start, end time.Time) (*x509.Certificate, *ecd
 		BasicConstraintsValid: true,
 	}
 
-	caPrivateKey, err := ecdsa.GenerateKey(elliptic.P256(), deterministicHKDFReader)
+	caPrivateKey, err := ecdsa.GenerateKey(elliptic.P256(), rand)
 	if err != nil {
 		return nil, nil, err
 	}
-	caBytes, err := x509.CreateCertificate(deterministicHKDFReader, certTempl, certTempl, caPrivateKey.Public(), caPrivateKey)
+	caBytes, err := x509.CreateCertificate(rand, certTempl, certTempl, caPrivateKey.Public(), caPrivateKey)
 	if err != nil {
 		return nil, nil, err
 	}
@@ -126,30 +109,3 @@ func verifyRawCerts(rawCerts [][]byte, certHashes []multihash.DecodedMultihash)
 	}
 	return nil
 }
-
-// deterministicReader is a hack. It counter-acts the Go library's attempt at
-// making ECDSA signatures non-deterministic. Go adds non-determinism by
-// randomly dropping a singly byte from the reader stream. This counteracts this
-// by detecting when a read is a single byte and using a different reader
-// instead.
-type deterministicReader struct {
-	reader           io.Reader
-	singleByteReader io.Reader
-}
-
-func newDeterministicReader(seed []byte, salt []byte, info string) io.Reader {
-	reader := hkdf.New(sha256.New, seed, salt, []byte(info))
-	singleByteReader := hkdf.New(sha256.New, seed, salt, []byte(info+" single byte"))
-
-	return &deterministicReader{
-		reader:           reader,
-		singleByteReader: singleByteReader,
-	}
-}
-
-func (r *deterministicReader) Read(p []byte) (n int, err error) {
-	if len(p) == 1 {
-		return r.singleByteReader.Read(p)
-	}
-	return r.reader.Read(p)
-}
@@ -11,7 +11,6 @@ import (
 	"crypto/x509"
 	"crypto/x509/pkix"
 	"fmt"
-	"io"
 	"math/big"
 	mrand "math/rand"
 	"testing"
@@ -157,40 +156,3 @@ func TestDeterministicCertHashes(t *testing.T) {
 		require.Equal(t, keyBytes, keyBytes2)
 	}
 }
-
-// TestDeterministicSig tests that our hack around making ECDSA signatures
-// deterministic works. If this fails, this means we need to try another
-// strategy to make deterministic signatures or try something else entirely.
-// See deterministicReader for more context.
-func TestDeterministicSig(t *testing.T) {
-	// Run this test 1000 times since we want to make sure the signatures are deterministic
-	runs := 1000
-	for i := 0; i < runs; i++ {
-		zeroSeed := [32]byte{}
-		deterministicHKDFReader := newDeterministicReader(zeroSeed[:], nil, deterministicCertInfo)
-		b := [1024]byte{}
-		io.ReadFull(deterministicHKDFReader, b[:])
-		caPrivateKey, err := ecdsa.GenerateKey(elliptic.P256(), deterministicHKDFReader)
-		require.NoError(t, err)
-
-		sig, err := caPrivateKey.Sign(deterministicHKDFReader, b[:], crypto.SHA256)
-		require.NoError(t, err)
-
-		deterministicHKDFReader = newDeterministicReader(zeroSeed[:], nil, deterministicCertInfo)
-		b2 := [1024]byte{}
-		io.ReadFull(deterministicHKDFReader, b2[:])
-		caPrivateKey2, err := ecdsa.GenerateKey(elliptic.P256(), deterministicHKDFReader)
-		require.NoError(t, err)
-
-		sig2, err := caPrivateKey2.Sign(deterministicHKDFReader, b2[:], crypto.SHA256)
-		require.NoError(t, err)
-
-		keyBytes, err := x509.MarshalECPrivateKey(caPrivateKey)
-		require.NoError(t, err)
-		keyBytes2, err := x509.MarshalECPrivateKey(caPrivateKey2)
-		require.NoError(t, err)
-
-		require.Equal(t, sig, sig2)
-		require.Equal(t, keyBytes, keyBytes2)
-	}
-}
@@ -3,6 +3,7 @@ package libp2pwebtransport
 import (
 	"errors"
 	"net"
+	"sync/atomic"
 
 	"github.com/libp2p/go-libp2p/core/network"
 
@@ -30,38 +31,58 @@ func (s *webtransportStream) RemoteAddr() net.Addr {
 
 type stream struct {
 	webtransport.Stream
+	// canceled is set once we canceled reading or writing on this stream.
+	// webtransport-go doesn't tell us which side reset the stream, so we use
+	// it to figure out if the error was caused by the remote peer.
+	canceled atomic.Bool
 }
 
 var _ network.MuxedStream = &stream{}
 
+func (s *stream) parseStreamError(err error) error {
+	if err == nil {
+		return err
+	}
+	se := &webtransport.StreamError{}
+	if errors.As(err, &se) {
+		return &network.StreamError{
+			ErrorCode:      network.StreamErrorCode(se.ErrorCode),
+			Remote:         !s.canceled.Load(),
+			TransportError: se,
+		}
+	}
+	return err
+}
+
 func (s *stream) Read(b []byte) (n int, err error) {
 	n, err = s.Stream.Read(b)
-	if err != nil && errors.Is(err, &webtransport.StreamError{}) {
-		err = network.ErrReset
-	}
-	return n, err
+	return n, s.parseStreamError(err)
 }
 
 func (s *stream) Write(b []byte) (n int, err error) {
 	n, err = s.Stream.Write(b)
-	if err != nil && errors.Is(err, &webtransport.StreamError{}) {
-		err = network.ErrReset
-	}
-	return n, err
+	return n, s.parseStreamError(err)
 }
 
 func (s *stream) Reset() error {
-	s.Stream.CancelRead(reset)
-	s.Stream.CancelWrite(reset)
+	return s.ResetWithError(network.StreamErrorCode(reset))
+}
+
+func (s *stream) ResetWithError(errCode network.StreamErrorCode) error {
+	s.canceled.Store(true)
+	s.Stream.CancelRead(webtransport.StreamErrorCode(errCode))
+	s.Stream.CancelWrite(webtransport.StreamErrorCode(errCode))
 	return nil
 }
 
 func (s *stream) Close() error {
+	s.canceled.Store(true)
 	s.Stream.CancelRead(reset)
 	return s.Stream.Close()
 }
 
 func (s *stream) CloseRead() error {
+	s.canceled.Store(true)
 	s.Stream.CancelRead(reset)
 	return nil
 }
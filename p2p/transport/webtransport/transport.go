@@ -75,6 +75,11 @@ type transport struct {
 	hasCertManager atomic.Bool // set to true once the certManager is initialized
 	staticTLSConf  *tls.Config
 	tlsClientConf  *tls.Config
+	certProvider   CertificateProvider
+
+	// certHashesChanged is signaled when the certificate hashes added by
+	// AddCertHashes change
+	certHashesChanged chan struct{}
 
 	noise *noise.Transport
 
@@ -106,6 +111,8 @@ func New(key ic.PrivKey, psk pnet.PSK, connManager *quicreuse.ConnManager, gater
 		clock:       clock.New(),
 		connManager: connManager,
 		conns:       map[uint64]*conn{},
+
+		certHashesChanged: make(chan struct{}, 1),
 	}
 	for _, opt := range opts {
 		if err := opt(t); err != nil {
@@ -117,6 +124,12 @@ func New(key ic.PrivKey, psk pnet.PSK, connManager *quicreuse.ConnManager, gater
 		return nil, err
 	}
 	t.noise = n
+	if t.certProvider == nil {
+		t.certProvider, err = NewDeterministicCertificateProvider(key)
+		if err != nil {
+			return nil, err
+		}
+	}
 	return t, nil
 }
 
@@ -304,7 +317,7 @@ func (t *transport) Listen(laddr ma.Multiaddr) (tpt.Listener, error) {
 	}
 	if t.staticTLSConf == nil {
 		t.listenOnce.Do(func() {
-			t.certManager, t.listenOnceErr = newCertManager(t.privKey, t.clock)
+			t.certManager, t.listenOnceErr = newCertManager(t.certProvider, t.clock, t.signalCertHashesChanged)
 			t.hasCertManager.Store(true)
 		})
 		if t.listenOnceErr != nil {
@@ -409,6 +422,20 @@ func (t *transport) Resolve(_ context.Context, maddr ma.Multiaddr) ([]ma.Multiad
 	return []ma.Multiaddr{beforeQuicMA.Encapsulate(quicComponent).Encapsulate(sniComponent).Encapsulate(afterQuicMA)}, nil
 }
 
+func (t *transport) signalCertHashesChanged() {
+	select {
+	case t.certHashesChanged <- struct{}{}:
+	default:
+	}
+}
+
+// CertHashesChanged returns a channel that is signaled when the certificate
+// hashes added by AddCertHashes change, and the addresses of the host need to be
+// updated. It is meant to be used by the host.
+func (t *transport) CertHashesChanged() <-chan struct{} {
+	return t.certHashesChanged
+}
+
 // AddCertHashes adds the current certificate hashes to a multiaddress.
 // If called before Listen, it's a no-op.
 func (t *transport) AddCertHashes(m ma.Multiaddr) (ma.Multiaddr, bool) {